@@ -5,12 +5,16 @@ import (
         "crypto/ecdsa"
         "crypto/elliptic"
         "crypto/rand"
+        "encoding/json"
         "fmt"
         "log"
         "math/big"
         "net/http"
         "os"
+        "os/signal"
         "strconv"
+        "strings"
+        "syscall"
         "time"
 
         "github.com/syndtr/goleveldb/leveldb"
@@ -133,7 +137,45 @@ func main() {
                 log.Fatalf("❌ Failed to create validator service: %v", err)
         }
 
+        // PoB ZK keys come from the setup ceremony (cmd/zksetup) so every node
+        // proves and verifies against the same keys. Aggregation proofs gate
+        // PoB rounds on-chain, so they are only used when the genesis config
+        // pins the verifying key hash; without a pin no node installs a proof
+        // system and rounds complete without proofs on every node alike.
+        zkKeysDir := "./zk_keys"
+        if dir := os.Getenv("RNR_ZK_KEYS_DIR"); dir != "" {
+                zkKeysDir = dir
+        }
+        pinnedVKHash := ""
+        if genesisConfig != nil {
+                pinnedVKHash = genesisConfig.ZKVerifyingKeyHash
+        }
+        if pinnedVKHash != "" {
+                zkSystem, err := consensus.LoadZKProofSystem(zkKeysDir, pinnedVKHash)
+                if err != nil {
+                        log.Fatalf("❌ Failed to load ZK ceremony keys: %v", err)
+                }
+                fmt.Printf("   ZK Verifying Key: %s\n", pinnedVKHash[:16])
+                validatorService.SetZKProofSystem(zkSystem)
+        } else {
+                log.Printf("⚠️  Genesis pins no ZK verifying key, PoB rounds run without aggregation proofs (DEV MODE)")
+        }
+
+        stakingMgr := validatorService.GetStakingManager()
+        if genesisConfig != nil {
+                for _, gv := range genesisConfig.InitialValidators {
+                        stake, ok := new(big.Int).SetString(gv.Stake, 10)
+                        if !ok || stake.Sign() <= 0 {
+                                continue
+                        }
+                        if err := stakingMgr.BondGenesisStake(gv.Address, stake); err != nil {
+                                log.Printf("⚠️  Failed to bond genesis stake for %s: %v", gv.Address, err)
+                        }
+                }
+        }
+
         ctx := context.Background()
+        checkpointMgr := consensus.NewCheckpointManager(db, 100)
 
         p2pPort := 6000
         if portStr := os.Getenv("RNR_P2P_PORT"); portStr != "" {
@@ -142,19 +184,139 @@ func main() {
                 }
         }
 
-        p2pNode, err := network.NewP2PNetwork(p2pPort)
+        // The node key and address book live next to the database, so the
+        // peer ID survives restarts. Peer lists are comma separated:
+        // multiaddrs with /p2p/ IDs for static and persistent peers, bare
+        // peer IDs for unconditional and private ones.
+        peerList := func(name string) []string {
+                return strings.FieldsFunc(os.Getenv(name), func(r rune) bool { return r == ',' || r == ' ' })
+        }
+        peerLimit := func(name string) int {
+                n, _ := strconv.Atoi(os.Getenv(name)) // Unset or invalid takes the default
+                return n
+        }
+        p2pNode, err := network.NewP2PNetwork(network.P2PConfig{
+                Port:                 p2pPort,
+                DataDir:              fmt.Sprintf("./data/rnr-p2p-%s", validatorWallet.Address[:12]),
+                KeyType:              os.Getenv("RNR_NODE_KEY_TYPE"), // ed25519 (default) or rsa
+                SwarmKeyFile:         os.Getenv("RNR_SWARM_KEY"),     // Private network key, see cmd/swarmkey
+                StaticPeers:          peerList("RNR_STATIC_PEERS"),
+                PersistentPeers:      peerList("RNR_PERSISTENT_PEERS"),
+                UnconditionalPeerIDs: peerList("RNR_UNCONDITIONAL_PEER_IDS"),
+                PrivatePeerIDs:       peerList("RNR_PRIVATE_PEER_IDS"),
+                Limits: network.ConnLimits{
+                        MaxInbound:     peerLimit("RNR_MAX_INBOUND_PEERS"),
+                        MaxOutbound:    peerLimit("RNR_MAX_OUTBOUND_PEERS"),
+                        ProtectedSlots: peerLimit("RNR_PROTECTED_PEER_SLOTS"),
+                        MaxPerSubnet24: peerLimit("RNR_MAX_PEERS_PER_SUBNET24"),
+                        MaxPerSubnet16: peerLimit("RNR_MAX_PEERS_PER_SUBNET16"),
+                        MaxPerASN:      peerLimit("RNR_MAX_PEERS_PER_ASN"),
+                },
+        })
         if err != nil {
                 log.Printf("⚠️  P2P initialization failed: %v", err)
+        } else {
+                log.Printf("🆔 Node peer ID: %s", p2pNode.Host.ID())
+                shutdownMgr.RegisterShutdownHook("p2p", func() error {
+                        log.Printf("📒 Saving address book...")
+                        return p2pNode.Close()
+                })
+        }
+
+        // Status handshake: peers of another chain or version are dropped
+        if p2pNode != nil {
+                chainID := genesis.DefaultGenesisConfig().ChainID
+                if genesisConfig != nil {
+                        chainID = genesisConfig.ChainID
+                }
+                genesisHash, err := chain.GenesisHash()
+                if err != nil {
+                        log.Fatalf("❌ Failed to hash genesis block: %v", err)
+                }
+
+                p2pNode.SetStatusProvider(func() *network.StatusMessage {
+                        status := &network.StatusMessage{
+                                ChainID:     chainID,
+                                GenesisHash: genesisHash,
+                        }
+                        head := chain.GetLatestBlock()
+                        status.HeadHeight = head.Header.Height
+                        status.HeadHash, _ = head.Hash()
+                        if checkpoint := checkpointMgr.GetLatestCheckpoint(); checkpoint != nil {
+                                status.FinalizedHeight = checkpoint.Height
+                                status.FinalizedHash = checkpoint.BlockHash
+                        }
+                        return status
+                })
         }
 
         var discovery *network.PeerDiscovery
         if p2pNode != nil {
-                discovery, err = network.NewPeerDiscovery(ctx, p2pNode.Host)
+                // Private networks bootstrap from their static and persistent peers
+                bootstrapPeers := network.BootstrapPeers
+                if p2pNode.IsPrivateNetwork() {
+                        bootstrapPeers = nil
+                }
+                discovery, err = network.NewPeerDiscovery(ctx, p2pNode.Host, bootstrapPeers)
                 if err != nil {
                         log.Printf("⚠️  Peer discovery failed: %v", err)
                 }
         }
 
+        // IP-to-ASN dataset (CSV/TSV, MRT or MaxMind DB) for peer diversity;
+        // without one, peers are grouped by subnet. Send SIGHUP to reload it
+        // after updating the file.
+        if asnPath := os.Getenv("RNR_ASN_DB"); asnPath != "" {
+                asnResolver, err := network.NewASNResolver(asnPath)
+                if err != nil {
+                        log.Printf("⚠️  ASN resolution disabled, grouping peers by subnet: %v", err)
+                } else {
+                        if p2pNode != nil {
+                                p2pNode.SetASNResolver(asnResolver)
+                        }
+
+                        reload := make(chan os.Signal, 1)
+                        signal.Notify(reload, syscall.SIGHUP)
+                        utils.SafeGoroutine("asn-reload", func() {
+                                for {
+                                        select {
+                                        case <-reload:
+                                                if err := asnResolver.Reload(); err != nil {
+                                                        log.Printf("⚠️  %v", err)
+                                                }
+                                        case <-shutdownMgr.Context().Done():
+                                                signal.Stop(reload)
+                                                return
+                                        }
+                                }
+                        })
+                }
+        }
+
+        // PoB rewards group validators by origin AS only with the dataset the
+        // genesis config pins, so every node splits rewards alike. It is read
+        // from RNR_ASN_REWARDS_DB, or RNR_ASN_DB, once and never reloaded.
+        pinnedASNHash := ""
+        if genesisConfig != nil {
+                pinnedASNHash = genesisConfig.ASNDatasetHash
+        }
+        if pinnedASNHash != "" {
+                rewardsASNPath := os.Getenv("RNR_ASN_REWARDS_DB")
+                if rewardsASNPath == "" {
+                        rewardsASNPath = os.Getenv("RNR_ASN_DB")
+                }
+                if rewardsASNPath == "" {
+                        log.Fatalf("❌ Genesis pins ASN dataset %s, set RNR_ASN_REWARDS_DB to it", pinnedASNHash[:16])
+                }
+                rewardsResolver, err := network.NewPinnedASNResolver(rewardsASNPath, pinnedASNHash)
+                if err != nil {
+                        log.Fatalf("❌ Failed to load the pinned ASN dataset: %v", err)
+                }
+                validatorService.SetASNResolver(rewardsResolver)
+        } else {
+                log.Printf("⚠️  Genesis pins no ASN dataset, PoB rewards group validators by subnet")
+        }
+
         // Start PoB Test Server for speed test measurements (Whitepaper Bab 3.1.2)
         pobPort := 8080
         if portStr := os.Getenv("RNR_POB_PORT"); portStr != "" {
@@ -164,6 +326,22 @@ func main() {
         }
         
         pobServer := consensus.NewPoBTestServer(pobPort)
+
+        // Network emulation for local testnets and CI, e.g.
+        // RNR_NETEM="upload=5MB,latency=80ms,jitter=10ms,dist=normal,loss=0.5,seed=1"
+        if spec := os.Getenv("RNR_NETEM"); spec != "" {
+                profile, err := network.ParseNetemProfile(spec)
+                if err != nil {
+                        log.Fatalf("❌ %v", err)
+                }
+                link := network.NewNetemLink(profile)
+                pobServer.SetNetworkEmulation(link)
+                if p2pNode != nil {
+                        p2pNode.SetNetworkEmulation(link)
+                }
+                log.Printf("🧪 Network emulation enabled: %s", spec)
+        }
+
         if err := pobServer.Start(); err != nil {
                 log.Fatalf("❌ %v", err) // Fatal: PoB test server is critical for mainnet
         }
@@ -174,14 +352,21 @@ func main() {
                 pobServer.Stop()
         }()
 
+        // Blocks from peers, gossiped or synced, are validated like our own
+        // proposals and reach the chain only once final
         forkResolver := blockchain.NewForkResolver(chain, db)
+        forkResolver.SetBlockProcessor(validatorService.ProcessBlock)
         syncManager := sync.NewSyncManager(chain)
+        syncManager.SetBlockProcessor(validatorService.ProcessBlock)
         mempoolSync := mempool.NewMempoolSync(mp)
         validatorRegistry := consensus.NewValidatorRegistry(state)
-        checkpointMgr := consensus.NewCheckpointManager(db, 100)
         partitionDetector := consensus.NewPartitionDetector(1)
         slashingMgr := consensus.NewSlashingManager(db, validatorRegistry)
-        gossipProtocol := network.NewGossipProtocol(3, 5)
+        slashingMgr.SetStakingManager(stakingMgr)
+        livenessTracker := consensus.NewLivenessTracker(db, state, slashingMgr, consensus.DefaultLivenessParams())
+        validatorService.SetLivenessTracker(livenessTracker)
+        byzantineDetector := consensus.NewByzantineDetector()
+        validatorService.SetByzantineDetector(byzantineDetector)
 
         // State Pruner: Database optimization and cleanup
         retentionBlocks := uint64(1000) // Keep last 1000 blocks AFTER finalized checkpoint
@@ -197,11 +382,103 @@ func main() {
                         if err := forkResolver.HandleCompetingBlock(block); err != nil {
                                 return err
                         }
+                        // Vote on validated blocks, so they can become final
+                        // and the next proposer's LastCommit carries our
+                        // signature
+                        if err := validatorService.AttestBlock(block); err != nil {
+                                log.Printf("⚠️  Failed to vote on block #%d: %v", block.Header.Height, err)
+                        }
                         return nil
                 })
 
+                // Verified votes go into the voting manager, which the next
+                // proposer reads its LastCommit from
                 p2pNode.SetVoteHandler(func(blockHash []byte, validatorID string, signature []byte) error {
-                        log.Printf("📥 Received vote from %s for block %x", validatorID[:12], blockHash[:8])
+                        return validatorService.HandleReceivedVote(blockHash, validatorID, signature)
+                })
+
+                // Sync: serve block ranges, and catch up with peers whose
+                // handshake shows a higher head
+                p2pNode.SetBlockRangeHandler(func(start, end uint64) ([]*core.Block, error) {
+                        resp, err := syncManager.HandleBlockRequest(&sync.BlockRequest{StartHeight: start, EndHeight: end})
+                        if err != nil {
+                                return nil, err
+                        }
+                        return resp.Blocks, nil
+                })
+
+                p2pNode.SetPeerStatusHandler(func(peerID string, status *network.StatusMessage) {
+                        if status.HeadHeight <= chain.GetLatestBlock().Header.Height || syncManager.IsSyncing() {
+                                return
+                        }
+                        utils.SafeGoroutine("block-sync", func() {
+                                if err := syncManager.StartSync(status.HeadHeight, peerID); err != nil {
+                                        return
+                                }
+                                for syncManager.IsSyncing() {
+                                        req := syncManager.CreateBlockRequest(network.MaxSyncBatch)
+                                        blocks, err := p2pNode.RequestBlocks(peerID, req.StartHeight, req.EndHeight)
+                                        if err == nil {
+                                                err = syncManager.ProcessBlockResponse(blocks)
+                                        }
+                                        if err != nil {
+                                                log.Printf("⚠️  Sync from %s failed: %v", peerID[:12], err)
+                                                syncManager.AbortSync()
+                                                return
+                                        }
+                                }
+                        })
+                })
+
+                // Gossip validation: only signed, verifiable messages are relayed.
+                // Stale or not yet verifiable messages are dropped without
+                // penalizing the peer; malformed or forged ones lower its score.
+                p2pNode.SetBlockValidator(func(block *core.Block) network.ValidationResult {
+                        if latest := chain.GetLatestBlock(); latest != nil && block.Header.Height+100 <= latest.Header.Height {
+                                return network.ValidationIgnore
+                        }
+                        if len(block.VRFProof) == 0 {
+                                return network.ValidationReject
+                        }
+                        return validatorService.ValidateGossipBlock(block)
+                })
+
+                p2pNode.SetVoteValidator(validatorService.ValidateGossipVote)
+
+                p2pNode.SetVRFProofValidator(validatorService.ValidateGossipVRFProof)
+
+                p2pNode.SetTransactionValidator(func(tx *core.Transaction) network.ValidationResult {
+                        if mp.Contains(tx.ID) {
+                                return network.ValidationIgnore
+                        }
+                        return network.ValidationAccept
+                })
+
+                // Misbehavior this node detects penalizes the validator's peer
+                // and is gossiped so every node learns of it. Gossiped evidence
+                // is relayed once its proof is verified; each node derives the
+                // same evidence from the chain, so it is only logged.
+                byzantineDetector.SetEvidenceHandler(func(evidence consensus.ByzantineEvidence) {
+                        p2pNode.ReportValidatorMisbehavior(evidence.ValidatorID, evidence.Severity,
+                                fmt.Sprintf("%s: %s", evidence.EvidenceType, evidence.Description))
+                        data, err := json.Marshal(evidence)
+                        if err != nil {
+                                return
+                        }
+                        if err := p2pNode.BroadcastEvidence(data); err != nil {
+                                log.Printf("⚠️  Failed to gossip evidence: %v", err)
+                        }
+                })
+
+                p2pNode.SetEvidenceValidator(validatorService.ValidateGossipEvidence)
+
+                p2pNode.SetEvidenceHandler(func(data []byte) error {
+                        var evidence consensus.ByzantineEvidence
+                        if err := json.Unmarshal(data, &evidence); err != nil {
+                                return fmt.Errorf("invalid evidence: %w", err)
+                        }
+                        log.Printf("📥 Received %s evidence against validator %s: %s",
+                                evidence.EvidenceType, evidence.ValidatorID, evidence.Description)
                         return nil
                 })
 
@@ -223,6 +500,20 @@ func main() {
                         return tx, nil
                 })
 
+                // Compact blocks are rebuilt from the mempool
+                p2pNode.SetMempoolHandler(mp.GetPendingTransactions)
+
+                // Large blocks this node proposes also go out as erasure coded
+                // shreds relayed through the validators
+                if os.Getenv("RNR_SHRED_BLOCKS") == "true" {
+                        p2pNode.SetShredRelay(true)
+                        log.Printf("🧩 Shred relay enabled for large blocks")
+                }
+                if os.Getenv("RNR_WIRE_COMPRESSION") == "true" {
+                        p2pNode.SetWireCompression(true)
+                        log.Printf("🗜️  Snappy compression enabled for P2P messages")
+                }
+
                 // Wire up mempool sync with P2P network
                 mempoolSync.SetBroadcastFunc(func(tx *core.Transaction) error {
                         return p2pNode.BroadcastTransaction(tx)
@@ -258,6 +549,28 @@ func main() {
                 })
 
                 log.Printf("✅ Mempool sync enabled with P2P network")
+
+                // PoB rounds: committee members challenge candidates over the
+                // speed test protocols and probe them over UDP
+                p2pNode.SetupSpeedTestHandlers()
+                if probes := pobServer.ProbeResponder(); probes != nil {
+                        p2pNode.SetUDPProbeResponder(probes)
+                }
+                validatorService.SetP2PNetwork(p2pNode)
+
+                // A candidate asks to be tested once it has had a block time to connect
+                if os.Getenv("RNR_REQUEST_POB") == "true" {
+                        utils.SafeGoroutine("pob-request", func() {
+                                select {
+                                case <-time.After(core.BlockTime):
+                                case <-shutdownMgr.Context().Done():
+                                        return
+                                }
+                                if _, err := validatorService.RequestPoBRound(); err != nil {
+                                        log.Printf("⚠️  PoB round request failed: %v", err)
+                                }
+                        })
+                }
         }
 
         utils.SafeGoroutine("validator-registry", func() {
@@ -322,6 +635,10 @@ func main() {
         }
 
         apiServer := api.NewAPIServer(chain, state, mp, apiPort)
+        apiServer.SetRewardLedger(validatorService.GetRewardLedger())
+        if p2pNode != nil {
+                apiServer.SetP2PNetwork(p2pNode)
+        }
         utils.SafeGoroutine("api-server", func() {
                 if err := apiServer.Start(); err != nil && err != http.ErrServerClosed {
                         log.Printf("⚠️  API server error: %v", err)
@@ -335,7 +652,6 @@ func main() {
         }()
 
         _ = syncManager
-        _ = gossipProtocol
         _ = discovery
 
         genesisAccount := &core.Account{
@@ -416,6 +732,59 @@ func main() {
 
         dbCircuitBreaker := utils.NewCircuitBreaker("database", 5, 30*time.Second)
         
+        // proposeBlock builds, broadcasts and votes on a block for the next height
+        proposeBlock := func() error {
+                startTime := time.Now()
+                var block *core.Block
+                proposeErr := dbCircuitBreaker.Call(func() error {
+                        var err error
+                        block, err = validatorService.ProposeBlock()
+                        return err
+                })
+                
+                if proposeErr != nil {
+                        return fmt.Errorf("failed to propose block: %w", proposeErr)
+                }
+
+                blockchainMetrics.BlockProductionTime.ObserveDuration(startTime)
+                blockchainMetrics.TotalTransactions.Add(int64(len(block.Transactions)))
+
+                fmt.Printf("   📝 Proposed block #%d with %d txs\n", block.Header.Height, len(block.Transactions))
+                
+                logger.InfoWithFields("Block proposed", map[string]interface{}{
+                        "height":   block.Header.Height,
+                        "txs":      len(block.Transactions),
+                        "proposer": validatorID[:12],
+                })
+
+                // SECURITY: Broadcast block to network
+                if p2pNode != nil {
+                        if err := p2pNode.BroadcastBlock(block); err != nil {
+                                log.Printf("⚠️  Failed to broadcast block: %v", err)
+                        } else {
+                                fmt.Printf("   📡 Block broadcast to network\n")
+                        }
+
+                        // SECURITY: Broadcast VRF proof to network
+                        if err := p2pNode.BroadcastVRFProof(block); err != nil {
+                                log.Printf("⚠️  Failed to broadcast VRF proof: %v", err)
+                        } else {
+                                fmt.Printf("   🔐 VRF proof broadcast to network\n")
+                        }
+                }
+
+                if err := validatorService.VoteOnBlock(block); err != nil {
+                        log.Printf("❌ Failed to vote: %v", err)
+                } else {
+                        fmt.Printf("   ✅ Voted on own block\n")
+                        if chain.GetLatestBlock().Header.Height == block.Header.Height {
+                                blockchainMetrics.FinalizedBlocks.Inc()
+                        }
+                }
+
+                return nil
+        }
+
         utils.SafeGoroutine("block-production", func() {
                 blockCount := 0
                 for {
@@ -427,69 +796,61 @@ func main() {
                                         latestBlock := chain.GetLatestBlock()
                                         nextHeight := latestBlock.Header.Height + 1
 
-                                        isProposer, proposerID, err := validatorService.IsProposer(nextHeight)
+                                        // A block at this height is still collecting votes
+                                        if validatorService.AwaitingFinality(nextHeight) {
+                                                fmt.Printf("\n⏰ Block Cycle #%d | Height: %d\n", blockCount, nextHeight)
+                                                fmt.Printf("   ⏳ Waiting for block #%d to be finalized...\n", nextHeight)
+                                                return nil
+                                        }
+
+                                        rank, ranking, err := validatorService.ProposerRank(nextHeight)
                                         if err != nil {
                                                 return fmt.Errorf("error checking proposer: %w", err)
                                         }
+                                        proposerID := ranking[0]
 
                                         fmt.Printf("\n⏰ Block Cycle #%d | Height: %d\n", blockCount, nextHeight)
                                         fmt.Printf("   Proposer: %s%s\n", proposerID[:12], func() string {
-                                                if isProposer {
+                                                if rank == 0 {
                                                         return " (ME)"
                                                 }
                                                 return ""
                                         }())
 
-                                        if isProposer {
-                                                startTime := time.Now()
-                                                var block *core.Block
-                                                proposeErr := dbCircuitBreaker.Call(func() error {
-                                                        var err error
-                                                        block, err = validatorService.ProposeBlock()
-                                                        return err
-                                                })
-                                                
-                                                if proposeErr != nil {
-                                                        return fmt.Errorf("failed to propose block: %w", proposeErr)
-                                                }
-
-                                                blockchainMetrics.BlockProductionTime.ObserveDuration(startTime)
-                                                blockchainMetrics.TotalTransactions.Add(int64(len(block.Transactions)))
-
-                                                fmt.Printf("   📝 Proposed block #%d with %d txs\n", block.Header.Height, len(block.Transactions))
-                                                
-                                                logger.InfoWithFields("Block proposed", map[string]interface{}{
-                                                        "height":   block.Header.Height,
-                                                        "txs":      len(block.Transactions),
-                                                        "proposer": validatorID[:12],
-                                                })
+                                        if rank == 0 {
+                                                return proposeBlock()
+                                        }
 
-                                                // SECURITY: Broadcast block to network
-                                                if p2pNode != nil {
-                                                        if err := p2pNode.BroadcastBlock(block); err != nil {
-                                                                log.Printf("⚠️  Failed to broadcast block: %v", err)
-                                                        } else {
-                                                                fmt.Printf("   📡 Block broadcast to network\n")
+                                        if rank > 0 {
+                                                // Backup proposer: step in if the height has not advanced,
+                                                // and no block at it is collecting votes, by the time our
+                                                // fallback slot starts
+                                                slotStart := consensus.ProposerSlotStart(latestBlock, rank)
+                                                fmt.Printf("   ⏳ Waiting for proposer's block (fallback rank %d, slot at %s)...\n",
+                                                        rank, slotStart.Format("15:04:05"))
+
+                                                parentHeight := latestBlock.Header.Height
+                                                utils.SafeGoroutine("fallback-proposer", func() {
+                                                        select {
+                                                        case <-time.After(time.Until(slotStart)):
+                                                        case <-shutdownMgr.Context().Done():
+                                                                return
                                                         }
 
-                                                        // SECURITY: Broadcast VRF proof to network
-                                                        if err := p2pNode.BroadcastVRFProof(block); err != nil {
-                                                                log.Printf("⚠️  Failed to broadcast VRF proof: %v", err)
-                                                        } else {
-                                                                fmt.Printf("   🔐 VRF proof broadcast to network\n")
+                                                        if chain.GetLatestBlock().Header.Height != parentHeight ||
+                                                                validatorService.AwaitingFinality(nextHeight) {
+                                                                return
                                                         }
-                                                }
 
-                                                if err := validatorService.VoteOnBlock(block); err != nil {
-                                                        log.Printf("❌ Failed to vote: %v", err)
-                                                } else {
-                                                        fmt.Printf("   ✅ Voted on own block\n")
-                                                        blockchainMetrics.FinalizedBlocks.Inc()
-                                                }
-                                        } else {
-                                                fmt.Printf("   ⏳ Waiting for proposer's block...\n")
+                                                        fmt.Printf("   🔁 Proposer missed slot for height %d, proposing as fallback #%d\n", nextHeight, rank)
+                                                        if err := proposeBlock(); err != nil {
+                                                                log.Printf("⚠️  Fallback proposal failed: %v", err)
+                                                        }
+                                                })
+                                                return nil
                                         }
-                                        
+
+                                        fmt.Printf("   ⏳ Waiting for proposer's block...\n")
                                         return nil
                                 }, "block-production")
                                 
@@ -0,0 +1,44 @@
+// swarmkey generates the pre-shared key of a private RNR network.
+//
+//	swarmkey -out swarm.key
+//	swarmkey -show swarm.key
+//
+// Copy the key file to every node of the network over a secure channel and
+// point RNR_SWARM_KEY at it. Nodes started without it, or with another key,
+// cannot connect to the network. -show prints the fingerprint of a key, for
+// checking that nodes share the same one.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"rnr-blockchain/pkg/network"
+)
+
+func main() {
+	out := flag.String("out", network.SwarmKeyFile, "Path to write the new swarm key to")
+	show := flag.String("show", "", "Print the fingerprint of an existing swarm key instead")
+	flag.Parse()
+
+	fmt.Println("🔒 RNR Private Network Key")
+	fmt.Println("-------------------------------------------------")
+
+	if *show != "" {
+		psk, err := network.LoadSwarmKey(*show)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("🔑 Fingerprint: %s\n", network.SwarmKeyFingerprint(psk))
+		return
+	}
+
+	psk, err := network.WriteSwarmKey(*out)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Printf("✅ Swarm key written to %s\n", *out)
+	fmt.Printf("🔑 Fingerprint: %s\n", network.SwarmKeyFingerprint(psk))
+	fmt.Println("➡️  Copy it to every node securely and set RNR_SWARM_KEY to its path")
+}
@@ -0,0 +1,134 @@
+// zksetup runs the multi-party trusted setup for the PoB ZK circuit.
+//
+// The coordinator initializes the ceremony and passes the ceremony directory
+// from participant to participant; each one runs `contribute` on it:
+//
+//	zksetup init -dir ceremony
+//	zksetup contribute -dir ceremony        (each participant, phase 1)
+//	zksetup phase2 -dir ceremony
+//	zksetup contribute -dir ceremony        (each participant, phase 2)
+//	zksetup finalize -dir ceremony -keys zk_keys -genesis genesis.json
+//
+// finalize verifies the whole transcript, writes the proving and verifying
+// keys of every PoB circuit, and pins the verifying key hash in the genesis
+// config. Anyone can run `verify` on the published transcript to check the
+// pinned hash.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"rnr-blockchain/pkg/consensus"
+	"rnr-blockchain/pkg/genesis"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	command := os.Args[1]
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	dir := fs.String("dir", "./zk_ceremony", "Ceremony transcript directory")
+	keysDir := fs.String("keys", "./zk_keys", "Output directory for the proving and verifying keys")
+	genesisFile := fs.String("genesis", "", "Genesis config to pin (finalize) or check (verify) the verifying key hash")
+	fs.Parse(os.Args[2:])
+
+	fmt.Println("🔐 RNR ZK Setup Ceremony")
+	fmt.Println("-------------------------------------------------")
+
+	switch command {
+	case "init":
+		contribution, err := consensus.InitZKCeremony(*dir)
+		if err != nil {
+			log.Fatalf("❌ Failed to initialize ceremony: %v", err)
+		}
+		printContribution(contribution)
+		fmt.Println("➡️  Pass the ceremony directory to the first participant (zksetup contribute)")
+
+	case "contribute":
+		contributions, err := consensus.ContributeZKCeremony(*dir)
+		if err != nil {
+			log.Fatalf("❌ Contribution failed: %v", err)
+		}
+		for _, contribution := range contributions {
+			printContribution(contribution)
+		}
+		fmt.Println("🔥 Your randomness was never written to disk. Publish the hashes above so others can check they are in the transcript.")
+
+	case "phase2":
+		contributions, err := consensus.StartZKCeremonyPhase2(*dir)
+		if err != nil {
+			log.Fatalf("❌ Failed to start phase 2: %v", err)
+		}
+		for _, contribution := range contributions {
+			printContribution(contribution)
+		}
+		fmt.Println("➡️  Phase 1 verified. Collect phase 2 contributions (zksetup contribute)")
+
+	case "finalize":
+		vkHash, err := consensus.FinalizeZKCeremony(*dir, *keysDir)
+		if err != nil {
+			log.Fatalf("❌ Failed to finalize ceremony: %v", err)
+		}
+		fmt.Printf("✅ Keys written to %s\n", *keysDir)
+		fmt.Printf("   Verifying key hash: %s\n", vkHash)
+
+		if *genesisFile != "" {
+			config, err := genesis.LoadGenesisConfig(*genesisFile)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			config.ZKVerifyingKeyHash = vkHash
+			if err := config.Save(*genesisFile); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			fmt.Printf("📜 Pinned verifying key hash in %s\n", *genesisFile)
+		}
+
+	case "verify":
+		tmpDir, err := os.MkdirTemp("", "rnr-zk-verify")
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		vkHash, err := consensus.FinalizeZKCeremony(*dir, tmpDir)
+		if err != nil {
+			log.Fatalf("❌ Transcript verification failed: %v", err)
+		}
+		fmt.Printf("✅ Transcript valid, verifying key hash: %s\n", vkHash)
+
+		if *genesisFile != "" {
+			config, err := genesis.LoadGenesisConfig(*genesisFile)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			if config.ZKVerifyingKeyHash != vkHash {
+				log.Fatalf("❌ Genesis pins %q, transcript yields %s", config.ZKVerifyingKeyHash, vkHash)
+			}
+			fmt.Printf("✅ Matches the hash pinned in %s\n", *genesisFile)
+		}
+
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+func printContribution(c *consensus.ZKContribution) {
+	if c.Circuit != "" {
+		fmt.Printf("✅ Phase %d (%s) entry #%d written to %s\n", c.Phase, c.Circuit, c.Index, c.Path)
+	} else {
+		fmt.Printf("✅ Phase %d entry #%d written to %s\n", c.Phase, c.Index, c.Path)
+	}
+	fmt.Printf("   Contribution hash: %s\n", c.Hash)
+}
+
+func usage() {
+	fmt.Println("Usage: zksetup <init|contribute|phase2|finalize|verify> [-dir ceremony] [-keys zk_keys] [-genesis genesis.json]")
+}
@@ -14,18 +14,23 @@ import (
         "strings"
         "time"
 
+        "github.com/libp2p/go-libp2p/core/peer"
         "rnr-blockchain/pkg/blockchain"
+        "rnr-blockchain/pkg/consensus"
         "rnr-blockchain/pkg/core"
+        "rnr-blockchain/pkg/network"
         "rnr-blockchain/pkg/wallet"
 )
 
 // APIServer provides JSON-RPC/REST endpoints for external applications
 type APIServer struct {
-        blockchain *blockchain.Blockchain
-        state      *blockchain.State
-        mempool    *blockchain.Mempool
-        port       int
-        server     *http.Server
+        blockchain   *blockchain.Blockchain
+        state        *blockchain.State
+        mempool      *blockchain.Mempool
+        rewardLedger *consensus.RewardLedger
+        p2p          *network.P2PNetwork
+        port         int
+        server       *http.Server
 }
 
 // Response types
@@ -85,6 +90,7 @@ type SubmitTxRequest struct {
         Timestamp int64  `json:"timestamp"` // Unix timestamp (seconds) - must match signed payload
         Signature string `json:"signature"`
         PublicKey string `json:"public_key"` // SECURITY: Required for signature verification
+        Data      string `json:"data,omitempty"` // Hex-encoded payload for staking/reward module transactions
 }
 
 type SubmitTxResponse struct {
@@ -94,6 +100,37 @@ type SubmitTxResponse struct {
         Message string `json:"message,omitempty"`
 }
 
+type FeeResponse struct {
+        NextHeight      uint64 `json:"next_height"`
+        BaseFeePerByte  string `json:"base_fee_per_byte"`
+        ParentBaseFee   string `json:"parent_base_fee,omitempty"`
+        ParentSizeUsed  uint64 `json:"parent_size_used"`
+        ParentSizeLimit uint64 `json:"parent_size_limit"`
+}
+
+type RewardBalanceResponse struct {
+        Address          string `json:"address"`
+        Accumulated      string `json:"accumulated"`
+        Withdrawn        string `json:"withdrawn"`
+        Claimable        string `json:"claimable"`
+        LastRewardHeight uint64 `json:"last_reward_height"`
+}
+
+type PeerResponse struct {
+        PeerID      string  `json:"peer_id"`
+        Score       float64 `json:"score"`
+        GossipScore float64 `json:"gossip_score"`
+        HeadHeight  uint64  `json:"head_height"`
+        Direction   string  `json:"direction"`
+        Protected   bool    `json:"protected"`
+}
+
+type PeersResponse struct {
+        Connected   []PeerResponse               `json:"connected"`
+        Banned      []*network.PeerScoreRecord   `json:"banned"`
+        Connections network.ConnStats            `json:"connections"`
+}
+
 type ErrorResponse struct {
         Error   string `json:"error"`
         Code    int    `json:"code"`
@@ -110,6 +147,16 @@ func NewAPIServer(blockchain *blockchain.Blockchain, state *blockchain.State, me
         }
 }
 
+// SetRewardLedger enables the reward accounting endpoints
+func (s *APIServer) SetRewardLedger(ledger *consensus.RewardLedger) {
+        s.rewardLedger = ledger
+}
+
+// SetP2PNetwork enables the peer endpoints
+func (s *APIServer) SetP2PNetwork(p2p *network.P2PNetwork) {
+        s.p2p = p2p
+}
+
 // Start begins serving API requests
 func (s *APIServer) Start() error {
         mux := http.NewServeMux()
@@ -122,6 +169,10 @@ func (s *APIServer) Start() error {
         mux.HandleFunc("/api/blocks/", s.handleBlock)
         mux.HandleFunc("/api/info", s.handleBlockchainInfo)
         mux.HandleFunc("/api/mempool", s.handleMempool)
+        mux.HandleFunc("/api/rewards/", s.handleRewards)
+        mux.HandleFunc("/api/fees", s.handleFees)
+        mux.HandleFunc("/api/peers", s.handlePeers)
+        mux.HandleFunc("/api/peers/", s.handlePeers)
         mux.HandleFunc("/health", s.handleHealth)
 
         // CORS middleware
@@ -144,6 +195,11 @@ func (s *APIServer) Start() error {
         log.Printf("   - GET  /api/blocks/:height")
         log.Printf("   - GET  /api/info")
         log.Printf("   - GET  /api/mempool")
+        log.Printf("   - GET  /api/rewards/:address")
+        log.Printf("   - GET  /api/fees")
+        log.Printf("   - GET  /api/rewards/block/:height")
+        log.Printf("   - GET  /api/peers")
+        log.Printf("   - GET  /api/peers/:peer_id")
         log.Printf("   - GET  /health")
 
         return s.server.ListenAndServe()
@@ -389,6 +445,15 @@ func (s *APIServer) handleSubmitTx(w http.ResponseWriter, r *http.Request) {
                 fee.SetInt64(1000000000000000) // Default 0.001 RNR
         }
 
+        var data []byte
+        if req.Data != "" {
+                data, err = hex.DecodeString(req.Data)
+                if err != nil {
+                        s.writeError(w, "Invalid data format (must be hex)", http.StatusBadRequest)
+                        return
+                }
+        }
+
         // Create transaction object with client-provided timestamp
         tx := &core.Transaction{
                 From:      req.From,
@@ -398,6 +463,7 @@ func (s *APIServer) handleSubmitTx(w http.ResponseWriter, r *http.Request) {
                 Nonce:     req.Nonce,
                 Timestamp: clientTime,
                 Signature: sigBytes,
+                Data:      data,
         }
 
         // Calculate transaction hash
@@ -473,6 +539,123 @@ func (s *APIServer) handleBlock(w http.ResponseWriter, r *http.Request) {
         s.respondBlock(w, block)
 }
 
+// handleFees returns the base fee the next block will charge, so wallets can
+// set Fee >= base_fee_per_byte × tx size plus a priority tip
+func (s *APIServer) handleFees(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                s.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+
+        parent := s.blockchain.GetLatestBlock().Header
+        response := FeeResponse{
+                NextHeight:      parent.Height + 1,
+                BaseFeePerByte:  consensus.CalculateNextBaseFee(parent).String(),
+                ParentSizeUsed:  parent.SizeUsed,
+                ParentSizeLimit: parent.SizeLimit,
+        }
+        if parent.BaseFee != nil {
+                response.ParentBaseFee = parent.BaseFee.String()
+        }
+
+        s.writeJSON(w, response, http.StatusOK)
+}
+
+// handleRewards returns an address's reward balance, or the reward breakdown
+// of a block for /api/rewards/block/:height
+func (s *APIServer) handleRewards(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                s.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+
+        if s.rewardLedger == nil {
+                s.writeError(w, "Reward ledger not available", http.StatusServiceUnavailable)
+                return
+        }
+
+        path := strings.TrimPrefix(r.URL.Path, "/api/rewards/")
+        if strings.HasPrefix(path, "block/") {
+                height, err := strconv.ParseUint(strings.TrimPrefix(path, "block/"), 10, 64)
+                if err != nil {
+                        s.writeError(w, "Invalid block height", http.StatusBadRequest)
+                        return
+                }
+
+                record, err := s.rewardLedger.GetBlockRewards(height)
+                if err != nil {
+                        s.writeError(w, "Reward record not found", http.StatusNotFound)
+                        return
+                }
+
+                s.writeJSON(w, record, http.StatusOK)
+                return
+        }
+
+        if path == "" {
+                s.writeError(w, "Address required", http.StatusBadRequest)
+                return
+        }
+
+        balance := s.rewardLedger.GetBalance(path)
+        response := RewardBalanceResponse{
+                Address:          balance.Address,
+                Accumulated:      balance.Accumulated.String(),
+                Withdrawn:        balance.Withdrawn.String(),
+                Claimable:        balance.Claimable().String(),
+                LastRewardHeight: balance.LastRewardHeight,
+        }
+
+        s.writeJSON(w, response, http.StatusOK)
+}
+
+// handlePeers lists connected peers with their scores and banned peers
+// with the reasons of their bans, or returns one peer's score history
+func (s *APIServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                s.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+
+        if s.p2p == nil {
+                s.writeError(w, "P2P network not available", http.StatusServiceUnavailable)
+                return
+        }
+
+        peerID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/peers"), "/")
+        if peerID != "" {
+                record := s.p2p.GetPeerScore(peerID)
+                if record == nil {
+                        s.writeError(w, "Peer has no score", http.StatusNotFound)
+                        return
+                }
+                s.writeJSON(w, record, http.StatusOK)
+                return
+        }
+
+        response := PeersResponse{
+                Connected:   []PeerResponse{},
+                Banned:      s.p2p.GetBannedPeerScores(),
+                Connections: s.p2p.GetConnStats(),
+        }
+        for _, id := range s.p2p.GetPeers() {
+                info := PeerResponse{PeerID: id, Direction: s.p2p.PeerDirection(id)}
+                if record := s.p2p.GetPeerScore(id); record != nil {
+                        info.Score = record.Score
+                }
+                if pid, err := peer.Decode(id); err == nil {
+                        info.GossipScore = s.p2p.PeerScore(pid)
+                        info.Protected = s.p2p.IsProtectedPeer(pid)
+                }
+                if status := s.p2p.GetPeerStatus(id); status != nil {
+                        info.HeadHeight = status.HeadHeight
+                }
+                response.Connected = append(response.Connected, info)
+        }
+
+        s.writeJSON(w, response, http.StatusOK)
+}
+
 // handleBlockchainInfo returns general blockchain information
 func (s *APIServer) handleBlockchainInfo(w http.ResponseWriter, r *http.Request) {
         if r.Method != http.MethodGet {
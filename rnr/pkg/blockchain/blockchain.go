@@ -3,6 +3,8 @@ package blockchain
 import (
         "bytes"
         "crypto/sha256"
+        "encoding/binary"
+        "encoding/hex"
         "encoding/json"
         "errors"
         "fmt"
@@ -28,24 +30,27 @@ func NewBlockchain(db *leveldb.DB) (*Blockchain, error) {
                 return nil, err
         }
 
+        bc := &Blockchain{
+                db:         db,
+                Difficulty: big.NewInt(1),
+        }
+
         currentBlockBytes, err := db.Get([]byte("current_block"), nil)
         if err != nil {
-                currentBlockBytes = genesisBytes
-                if err := db.Put([]byte("current_block"), currentBlockBytes, nil); err != nil {
+                // Genesis is stored like any block, so block #1 finds its parent
+                if err := bc.AddBlock(genesisBlock); err != nil {
                         return nil, err
                 }
+                currentBlockBytes = genesisBytes
         }
 
         var currentBlock core.Block
         if err := json.Unmarshal(currentBlockBytes, &currentBlock); err != nil {
                 return nil, err
         }
+        bc.currentBlock = &currentBlock
 
-        return &Blockchain{
-                db:           db,
-                currentBlock: &currentBlock,
-                Difficulty:   big.NewInt(1),
-        }, nil
+        return bc, nil
 }
 
 func createGenesisBlock() *core.Block {
@@ -68,6 +73,12 @@ func createGenesisBlock() *core.Block {
         }
 }
 
+// GenesisHash returns the hash of the genesis block, which identifies the
+// chain to peers
+func (bc *Blockchain) GenesisHash() ([]byte, error) {
+        return createGenesisBlock().Hash()
+}
+
 func (bc *Blockchain) GetLatestBlock() *core.Block {
         bc.mu.RLock()
         defer bc.mu.RUnlock()
@@ -83,12 +94,18 @@ func (bc *Blockchain) AddBlock(block *core.Block) error {
                 return err
         }
 
-        key := fmt.Sprintf("block_%d", block.Header.Height)
-        if err := bc.db.Put([]byte(key), blockBytes, nil); err != nil {
+        hash, err := block.Hash()
+        if err != nil {
                 return err
         }
-
-        if err := bc.db.Put([]byte("current_block"), blockBytes, nil); err != nil {
+        height := make([]byte, 8)
+        binary.BigEndian.PutUint64(height, block.Header.Height)
+
+        batch := new(leveldb.Batch)
+        batch.Put([]byte(fmt.Sprintf("block_%d", block.Header.Height)), blockBytes)
+        batch.Put([]byte("blockhash_"+hex.EncodeToString(hash)), height)
+        batch.Put([]byte("current_block"), blockBytes)
+        if err := bc.db.Write(batch, nil); err != nil {
                 return err
         }
 
@@ -114,6 +131,29 @@ func (bc *Blockchain) GetBlockByHeight(height uint64) (*core.Block, error) {
         return &block, nil
 }
 
+// GetBlockByHash returns the stored block with hash. A block replaced at its
+// height is no longer found.
+func (bc *Blockchain) GetBlockByHash(hash []byte) (*core.Block, error) {
+        genesis := createGenesisBlock()
+        if genesisHash, err := genesis.Hash(); err == nil && bytes.Equal(hash, genesisHash) {
+                return genesis, nil
+        }
+
+        height, err := bc.db.Get([]byte("blockhash_"+hex.EncodeToString(hash)), nil)
+        if err != nil || len(height) != 8 {
+                return nil, fmt.Errorf("block %x not found", hash)
+        }
+        block, err := bc.GetBlockByHeight(binary.BigEndian.Uint64(height))
+        if err != nil {
+                return nil, err
+        }
+        stored, err := block.Hash()
+        if err != nil || !bytes.Equal(stored, hash) {
+                return nil, fmt.Errorf("block %x was replaced at height %d", hash, block.Header.Height)
+        }
+        return block, nil
+}
+
 func (bc *Blockchain) VerifyBlock(block *core.Block) error {
         latestBlock := bc.GetLatestBlock()
 
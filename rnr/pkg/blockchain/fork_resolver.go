@@ -22,6 +22,7 @@ type ForkResolver struct {
         db              *leveldb.DB
         mainChain       *Blockchain
         candidateChains map[string]*ChainInfo
+        processBlock    func(*core.Block) error // Validates and applies blocks extending the main chain
         mu              sync.RWMutex
 }
 
@@ -33,10 +34,26 @@ func NewForkResolver(bc *Blockchain, db *leveldb.DB) *ForkResolver {
         }
 }
 
+// SetBlockProcessor sets the function that validates blocks and applies them
+// to the chain and the state once they are final. Competing blocks are then
+// settled by votes before any is applied, so every block goes to the
+// processor and the main chain is never reorganized. Without one, blocks
+// extending the main chain are added unchecked and forks are resolved by
+// cumulative PoB weight.
+func (fr *ForkResolver) SetBlockProcessor(process func(*core.Block) error) {
+        fr.mu.Lock()
+        defer fr.mu.Unlock()
+        fr.processBlock = process
+}
+
 func (fr *ForkResolver) HandleCompetingBlock(block *core.Block) error {
         fr.mu.Lock()
         defer fr.mu.Unlock()
 
+        if fr.processBlock != nil {
+                return fr.processBlock(block)
+        }
+
         mainTip := fr.mainChain.GetLatestBlock()
         
         if bytes.Equal(block.Header.PrevBlockHash, fr.hashBlock(mainTip)) {
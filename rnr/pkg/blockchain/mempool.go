@@ -82,6 +82,27 @@ func (m *Mempool) GetPendingTransactions() []*core.Transaction {
         return append(txs, m.priorityTx...)
 }
 
+// PendingNonce returns the nonce of address's next transaction: its
+// committed nonce advanced past the transactions from address already
+// waiting in the mempool with consecutive nonces.
+func (m *Mempool) PendingNonce(address string, committedNonce uint64) uint64 {
+        m.mu.RLock()
+        defer m.mu.RUnlock()
+
+        pending := make(map[uint64]bool)
+        for _, tx := range m.priorityTx {
+                if tx.From == address && tx.Nonce >= committedNonce {
+                        pending[tx.Nonce] = true
+                }
+        }
+
+        nonce := committedNonce
+        for pending[nonce] {
+                nonce++
+        }
+        return nonce
+}
+
 // GetTransactionsBySize returns transactions from mempool up to maxSizeBytes
 // This implements the whitepaper's Dynamic Block Capacity formula
 func (m *Mempool) GetTransactionsBySize(maxSizeBytes int) []*core.Transaction {
@@ -93,7 +114,7 @@ func (m *Mempool) GetTransactionsBySize(maxSizeBytes int) []*core.Transaction {
 
         for _, tx := range m.priorityTx {
                 // Calculate transaction size (approximate serialized size)
-                txSize := m.estimateTransactionSize(tx)
+                txSize := EstimateTransactionSize(tx)
                 
                 if totalSize+txSize > maxSizeBytes {
                         // Reached capacity limit
@@ -107,8 +128,9 @@ func (m *Mempool) GetTransactionsBySize(maxSizeBytes int) []*core.Transaction {
         return selected
 }
 
-// estimateTransactionSize estimates the serialized size of a transaction in bytes
-func (m *Mempool) estimateTransactionSize(tx *core.Transaction) int {
+// EstimateTransactionSize estimates the serialized size of a transaction in bytes.
+// Block capacity and the per-byte base fee are both measured with it.
+func EstimateTransactionSize(tx *core.Transaction) int {
         // Rough estimate based on transaction structure:
         // ID (64 bytes) + From (42 bytes) + To (42 bytes) + Amount (32 bytes) +
         // Timestamp (8 bytes) + Nonce (8 bytes) + Fee (32 bytes) +
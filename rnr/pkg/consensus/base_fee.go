@@ -0,0 +1,63 @@
+package consensus
+
+import (
+	"math/big"
+
+	"rnr-blockchain/pkg/blockchain"
+	"rnr-blockchain/pkg/core"
+)
+
+// CalculateNextBaseFee derives the base fee of the block following parent
+// (Whitepaper Bab 7.3: base fee burning, EIP-1559 style).
+// Blocks target 1/BaseFeeElasticityMultiplier of the proposer's dynamic
+// capacity; the base fee rises when the parent was fuller than the target
+// and falls when it was emptier, by at most 1/BaseFeeChangeDenominator.
+// Every node computes the same value from the parent header alone.
+func CalculateNextBaseFee(parent *core.BlockHeader) *big.Int {
+	if parent == nil || parent.BaseFee == nil || parent.SizeLimit == 0 {
+		return new(big.Int).Set(core.InitialBaseFee)
+	}
+
+	parentBaseFee := parent.BaseFee
+	target := parent.SizeLimit / core.BaseFeeElasticityMultiplier
+	if target == 0 || parent.SizeUsed == target {
+		return new(big.Int).Set(parentBaseFee)
+	}
+
+	if parent.SizeUsed > target {
+		delta := new(big.Int).Mul(parentBaseFee, new(big.Int).SetUint64(parent.SizeUsed-target))
+		delta.Div(delta, new(big.Int).SetUint64(target))
+		delta.Div(delta, big.NewInt(core.BaseFeeChangeDenominator))
+		if delta.Sign() == 0 {
+			delta.SetInt64(1)
+		}
+		return new(big.Int).Add(parentBaseFee, delta)
+	}
+
+	delta := new(big.Int).Mul(parentBaseFee, new(big.Int).SetUint64(target-parent.SizeUsed))
+	delta.Div(delta, new(big.Int).SetUint64(target))
+	delta.Div(delta, big.NewInt(core.BaseFeeChangeDenominator))
+
+	next := new(big.Int).Sub(parentBaseFee, delta)
+	if next.Cmp(core.MinBaseFee) < 0 {
+		next.Set(core.MinBaseFee)
+	}
+	return next
+}
+
+// TransactionBaseFee is the part of a transaction's fee that is burned:
+// the per-byte base fee times the transaction size.
+func TransactionBaseFee(baseFee *big.Int, tx *core.Transaction) *big.Int {
+	size := big.NewInt(int64(blockchain.EstimateTransactionSize(tx)))
+	return new(big.Int).Mul(baseFee, size)
+}
+
+// SplitTransactionFee splits tx.Fee into the burned base fee and the priority
+// tip paid to the proposer. tx.Fee acts as the sender's fee cap.
+func SplitTransactionFee(baseFee *big.Int, tx *core.Transaction) (burned *big.Int, tip *big.Int) {
+	burned = TransactionBaseFee(baseFee, tx)
+	if tx.Fee == nil || tx.Fee.Cmp(burned) < 0 {
+		return burned, big.NewInt(0)
+	}
+	return burned, new(big.Int).Sub(tx.Fee, burned)
+}
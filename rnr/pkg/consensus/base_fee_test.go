@@ -0,0 +1,63 @@
+package consensus
+
+import (
+	"math/big"
+	"testing"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// TestBaseFeeAdjustment tests that the base fee tracks block fullness with bounded steps
+func TestBaseFeeAdjustment(t *testing.T) {
+	parentFee := big.NewInt(1000000)
+	limit := uint64(1000000)
+
+	testCases := []struct {
+		sizeUsed    uint64
+		expected    int64
+		description string
+	}{
+		{limit / 2, 1000000, "at target: unchanged"},
+		{limit, 1125000, "full block: +12.5%"},
+		{0, 875000, "empty block: -12.5%"},
+		{limit * 3 / 4, 1062500, "halfway above target: +6.25%"},
+	}
+
+	for _, tc := range testCases {
+		parent := &core.BlockHeader{BaseFee: parentFee, SizeUsed: tc.sizeUsed, SizeLimit: limit}
+		next := CalculateNextBaseFee(parent)
+		if next.Cmp(big.NewInt(tc.expected)) != 0 {
+			t.Errorf("%s: expected %d, got %s", tc.description, tc.expected, next.String())
+		}
+	}
+
+	// Pre-fee-market parents (genesis) start from the initial base fee
+	if next := CalculateNextBaseFee(&core.BlockHeader{}); next.Cmp(core.InitialBaseFee) != 0 {
+		t.Errorf("Expected initial base fee %s, got %s", core.InitialBaseFee.String(), next.String())
+	}
+
+	// Base fee never drops below the floor
+	floor := &core.BlockHeader{BaseFee: core.MinBaseFee, SizeUsed: 0, SizeLimit: limit}
+	if next := CalculateNextBaseFee(floor); next.Cmp(core.MinBaseFee) != 0 {
+		t.Errorf("Expected base fee floor %s, got %s", core.MinBaseFee.String(), next.String())
+	}
+}
+
+// TestSplitTransactionFee tests the burned/tip split of a transaction fee
+func TestSplitTransactionFee(t *testing.T) {
+	tx := &core.Transaction{Amount: big.NewInt(0), Fee: big.NewInt(1000000)}
+	baseFee := big.NewInt(100)
+
+	burned, tip := SplitTransactionFee(baseFee, tx)
+	if new(big.Int).Add(burned, tip).Cmp(tx.Fee) != 0 {
+		t.Errorf("Burned %s + tip %s should equal fee %s", burned.String(), tip.String(), tx.Fee.String())
+	}
+	if burned.Cmp(TransactionBaseFee(baseFee, tx)) != 0 {
+		t.Errorf("Burned amount should equal the size-weighted base fee")
+	}
+
+	tx.Fee = big.NewInt(1)
+	if _, tip := SplitTransactionFee(baseFee, tx); tip.Sign() != 0 {
+		t.Errorf("Fee below base fee should yield no tip, got %s", tip.String())
+	}
+}
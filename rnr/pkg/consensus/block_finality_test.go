@@ -0,0 +1,131 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// TestBlocksApplyOnceFinal tests that a block is applied only once votes of
+// two thirds of the validators are seen for it, gossiped or in a child's
+// LastCommit, and that the block it competed with is dropped
+func TestBlocksApplyOnceFinal(t *testing.T) {
+	db := setupTestDB(t)
+	chain, _ := setupTestBlockchain(db)
+	state, _ := setupTestState(db)
+
+	ids := []string{"validator_00001", "validator_00002", "validator_00003"}
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range ids {
+		key, info := createTestValidator(id)
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+	proposers := make(map[string]*ValidatorService)
+	for _, id := range ids {
+		vs, err := NewValidatorService(id, keys[id], chain, state, setupTestMempool(), NewProofOfHistory())
+		if err != nil {
+			t.Fatalf("Failed to create validator service: %v", err)
+		}
+		proposers[id] = vs
+	}
+	observerKey, _ := createTestValidator("observer_00001")
+	node, err := NewValidatorService("observer_00001", observerKey, chain, state, setupTestMempool(), NewProofOfHistory())
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+
+	// A peer with its own chain, which only learns of votes from LastCommits
+	peerDB := setupTestDB(t)
+	peerChain, _ := setupTestBlockchain(peerDB)
+	peerState, _ := setupTestState(peerDB)
+	for _, id := range ids {
+		info, _ := state.GetValidator(id)
+		peerState.UpdateValidator(info)
+	}
+	peer, err := NewValidatorService("observer_00001", observerKey, peerChain, peerState, setupTestMempool(), NewProofOfHistory())
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+
+	vote := func(vs *ValidatorService, id string, block *core.Block) {
+		hash, _ := block.Hash()
+		signature, _ := SignVote(hash, keys[id])
+		if err := vs.HandleReceivedVote(hash, id, signature); err != nil {
+			t.Fatalf("Vote of %s rejected: %v", id, err)
+		}
+	}
+
+	// The primary and the first fallback proposer compete for block #1
+	_, ranking, err := node.ProposerRank(1)
+	if err != nil {
+		t.Fatalf("ProposerRank failed: %v", err)
+	}
+	primary, err := proposers[ranking[0]].ProposeBlock()
+	if err != nil {
+		t.Fatalf("ProposeBlock failed: %v", err)
+	}
+	fallback, err := proposers[ranking[1]].ProposeBlock()
+	if err != nil {
+		t.Fatalf("ProposeBlock failed: %v", err)
+	}
+	for _, block := range []*core.Block{primary, fallback} {
+		if err := node.ProcessBlock(block); err != nil {
+			t.Fatalf("ProcessBlock failed: %v", err)
+		}
+	}
+	if err := peer.ProcessBlock(primary); err != nil {
+		t.Fatalf("ProcessBlock failed: %v", err)
+	}
+	if chain.GetLatestBlock().Header.Height != 0 {
+		t.Fatalf("Block applied before it was final")
+	}
+	if !node.AwaitingFinality(1) {
+		t.Errorf("Block #1 should be awaiting finality")
+	}
+
+	vote(node, ranking[0], primary)
+	vote(node, ranking[1], fallback)
+	if chain.GetLatestBlock().Header.Height != 0 {
+		t.Fatalf("Block applied with a third of the votes")
+	}
+	vote(node, ranking[2], primary)
+
+	primaryHash, _ := primary.Hash()
+	if tipHash, _ := chain.GetLatestBlock().Hash(); !bytes.Equal(tipHash, primaryHash) {
+		t.Fatalf("Block with two thirds of the votes should be the tip")
+	}
+	if node.AwaitingFinality(1) {
+		t.Errorf("Finalized height should not await finality")
+	}
+	if err := node.ProcessBlock(fallback); err == nil {
+		t.Errorf("Block competing with a final block should be refused")
+	}
+	if err := node.ProcessBlock(primary); err != nil {
+		t.Errorf("Final block received again should be ignored, got %v", err)
+	}
+
+	// Block #2's LastCommit finalizes block #1 at the peer
+	_, ranking2, err := node.ProposerRank(2)
+	if err != nil {
+		t.Fatalf("ProposerRank failed: %v", err)
+	}
+	next := proposers[ranking2[0]]
+	vote(next, ranking[0], primary)
+	vote(next, ranking[2], primary)
+	child, err := next.ProposeBlock()
+	if err != nil {
+		t.Fatalf("ProposeBlock failed: %v", err)
+	}
+	if err := peer.ProcessBlock(child); err != nil {
+		t.Fatalf("ProcessBlock of the child failed: %v", err)
+	}
+	if tipHash, _ := peerChain.GetLatestBlock().Hash(); !bytes.Equal(tipHash, primaryHash) {
+		t.Errorf("Child's LastCommit should finalize its parent")
+	}
+	if !peer.AwaitingFinality(2) {
+		t.Errorf("Child should be awaiting finality")
+	}
+}
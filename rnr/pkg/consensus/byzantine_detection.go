@@ -13,7 +13,7 @@ import (
 
 type ByzantineEvidence struct {
 	ValidatorID   string
-	EvidenceType  string // "double_vote", "invalid_vrf", "conflicting_block", "censorship", "speedtest_cheat"
+	EvidenceType  string // "double_vote", "invalid_vrf", "conflicting_block", "censorship", "speedtest_cheat", "tester_collusion"
 	Timestamp     time.Time
 	Proof         []byte  // Cryptographic proof of misbehavior
 	Severity      int     // 1-10
@@ -29,6 +29,7 @@ type ValidatorBehavior struct {
 	VRFFailures          int
 	CensorshipAttempts   int
 	SpeedTestCheats      int
+	CollusionFlags       int
 	LastMisbehavior      time.Time
 	MisbehaviorCount     int
 	TrustScore           int // 0-100
@@ -47,11 +48,14 @@ type ByzantineDetector struct {
 	vrfFailureThreshold       int
 	censorshipThreshold       int
 	speedTestCheatThreshold   int
+	collusionThreshold        int
 	
 	// Trust score configuration
 	initialTrustScore         int
 	minimumTrustScore         int
 	banDuration              time.Duration
+	
+	onEvidence func(ByzantineEvidence) // Called for every evidence recorded, e.g. to gossip it
 }
 
 func NewByzantineDetector() *ByzantineDetector {
@@ -62,6 +66,7 @@ func NewByzantineDetector() *ByzantineDetector {
 		vrfFailureThreshold:       5,  // 5 invalid VRFs = ban
 		censorshipThreshold:       10, // 10 censorship attempts = ban
 		speedTestCheatThreshold:   2,  // 2 speedtest cheats = ban
+		collusionThreshold:        3,  // 3 collusion flags = ban
 		initialTrustScore:        100,
 		minimumTrustScore:        30,
 		banDuration:              7 * 24 * time.Hour, // 7 days
@@ -89,7 +94,7 @@ func (bd *ByzantineDetector) DetectDoubleVote(validatorID string, blockHeight ui
 		Verified:     true,
 	}
 	
-	bd.evidenceLog = append(bd.evidenceLog, evidence)
+	bd.record(evidence)
 	validator.DoubleVotes++
 	validator.MisbehaviorCount++
 	validator.LastMisbehavior = time.Now()
@@ -122,7 +127,7 @@ func (bd *ByzantineDetector) DetectInvalidVRF(validatorID string, vrfInput []byt
 		Verified:     true,
 	}
 	
-	bd.evidenceLog = append(bd.evidenceLog, evidence)
+	bd.record(evidence)
 	validator.VRFFailures++
 	validator.MisbehaviorCount++
 	validator.LastMisbehavior = time.Now()
@@ -156,7 +161,7 @@ func (bd *ByzantineDetector) DetectConflictingBlocks(validatorID string, height
 		Verified:     true,
 	}
 	
-	bd.evidenceLog = append(bd.evidenceLog, evidence)
+	bd.record(evidence)
 	validator.InvalidBlocks++
 	validator.MisbehaviorCount++
 	validator.LastMisbehavior = time.Now()
@@ -186,7 +191,7 @@ func (bd *ByzantineDetector) DetectSpeedTestCheat(validatorID string, cheatType
 		Verified:     true,
 	}
 	
-	bd.evidenceLog = append(bd.evidenceLog, byzEvidence)
+	bd.record(byzEvidence)
 	validator.SpeedTestCheats++
 	validator.MisbehaviorCount++
 	validator.LastMisbehavior = time.Now()
@@ -201,6 +206,40 @@ func (bd *ByzantineDetector) DetectSpeedTestCheat(validatorID string, cheatType
 	return fmt.Errorf("speed test cheat detected for validator %s", validatorID)
 }
 
+// ReportTesterCollusion records a PoB tester whose results statistically
+// favor candidates (see CollusionDetector). The evidence is statistical, not
+// cryptographic, so it weighs less than a proven speed test cheat.
+func (bd *ByzantineDetector) ReportTesterCollusion(validatorID string, pattern string, evidence []byte) error {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	
+	validator := bd.getOrCreateValidator(validatorID)
+	
+	byzEvidence := ByzantineEvidence{
+		ValidatorID:  validatorID,
+		EvidenceType: "tester_collusion",
+		Timestamp:    time.Now(),
+		Proof:        evidence,
+		Severity:     5,
+		Description:  fmt.Sprintf("PoB tester collusion: %s", pattern),
+		Verified:     false,
+	}
+	
+	bd.record(byzEvidence)
+	validator.CollusionFlags++
+	validator.MisbehaviorCount++
+	validator.LastMisbehavior = time.Now()
+	validator.TrustScore -= 10
+	
+	if validator.CollusionFlags >= bd.collusionThreshold || validator.TrustScore < bd.minimumTrustScore {
+		validator.IsBanned = true
+		validator.BanUntil = time.Now().Add(bd.banDuration)
+		return fmt.Errorf("validator %s BANNED for PoB tester collusion", validatorID)
+	}
+	
+	return fmt.Errorf("PoB tester collusion suspected for validator %s", validatorID)
+}
+
 // IsValidatorTrusted checks if validator is trusted
 func (bd *ByzantineDetector) IsValidatorTrusted(validatorID string) bool {
 	bd.mu.RLock()
@@ -251,6 +290,24 @@ func (bd *ByzantineDetector) RecordGoodBehavior(validatorID string) {
 	}
 }
 
+// SetEvidenceHandler sets a handler called with every evidence recorded.
+// It runs on its own goroutine, so it may gossip the evidence and have it
+// validated against the PoB rounds and collusion flags that reported it.
+func (bd *ByzantineDetector) SetEvidenceHandler(handler func(ByzantineEvidence)) {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	bd.onEvidence = handler
+}
+
+// record logs evidence and hands it to the evidence handler. Callers hold
+// the detector's lock and their own, so the handler runs outside them.
+func (bd *ByzantineDetector) record(evidence ByzantineEvidence) {
+	bd.evidenceLog = append(bd.evidenceLog, evidence)
+	if handler := bd.onEvidence; handler != nil {
+		go handler(evidence)
+	}
+}
+
 // getOrCreateValidator internal helper
 func (bd *ByzantineDetector) getOrCreateValidator(validatorID string) *ValidatorBehavior {
 	validator, exists := bd.validators[validatorID]
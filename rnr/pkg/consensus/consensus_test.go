@@ -8,12 +8,14 @@ import (
         "fmt"
         "math/big"
         "os"
+        "path/filepath"
         "testing"
         "time"
 
         "github.com/syndtr/goleveldb/leveldb"
         "rnr-blockchain/pkg/blockchain"
         "rnr-blockchain/pkg/core"
+        "rnr-blockchain/pkg/network"
 )
 
 func setupTestDB(t *testing.T) *leveldb.DB {
@@ -214,7 +216,7 @@ func TestNetworkGroupFairness(t *testing.T) {
                 "v5": {ID: "v5", PoBScore: 1.0, NetworkASN: "AS3", IPAddress: "30.0.0.1"},
         }
 
-        groups := GroupValidatorsByNetwork(validators)
+        groups := GroupValidatorsByNetwork(validators, nil)
 
         if len(groups) != 3 {
                 t.Errorf("Expected 3 network groups, got %d", len(groups))
@@ -245,6 +247,40 @@ func TestNetworkGroupFairness(t *testing.T) {
         t.Logf("✅ Network fairness: v1 (group of 3) gets %s, v4 (solo) gets %s", v1Reward.String(), v4Reward.String())
 }
 
+// TestNetworkGroupByASN tests that validators on one provider's many subnets share a reward group
+func TestNetworkGroupByASN(t *testing.T) {
+        dataset := filepath.Join(t.TempDir(), "ip2asn.csv")
+        rows := "network,asn\n203.0.113.0/24,64500\n198.51.100.0/24,64500\n2001:db8::/32,64500\n192.0.2.0/24,64501\n"
+        if err := os.WriteFile(dataset, []byte(rows), 0644); err != nil {
+                t.Fatalf("Failed to write ASN dataset: %v", err)
+        }
+        resolver, err := network.NewASNResolver(dataset)
+        if err != nil {
+                t.Fatalf("NewASNResolver failed: %v", err)
+        }
+
+        validators := map[string]*core.ValidatorInfo{
+                "v1": {ID: "v1", NetworkASN: "unknown", IPAddress: "203.0.113.10"},
+                "v2": {ID: "v2", NetworkASN: "unknown", IPAddress: "198.51.100.20"},
+                "v3": {ID: "v3", NetworkASN: "unknown", IPAddress: "2001:db8:1::1"},
+                "v4": {ID: "v4", NetworkASN: "unknown", IPAddress: "192.0.2.30"},
+                "v5": {ID: "v5", NetworkASN: "unknown", IPAddress: "100.64.1.1"},
+        }
+
+        groups := GroupValidatorsByNetwork(validators, resolver)
+        if len(groups["AS64500"].Validators) != 3 {
+                t.Errorf("Expected the three AS64500 validators in one group, got %v", groups)
+        }
+        if groups["AS64501"] == nil || groups["100.64.1.0/24"] == nil {
+                t.Errorf("Expected AS64501 and a subnet fallback group, got %v", groups)
+        }
+
+        // Without a dataset every subnet is its own group
+        if bySubnet := GroupValidatorsByNetwork(validators, nil); len(bySubnet) != 5 {
+                t.Errorf("Expected 5 subnet groups without a dataset, got %d", len(bySubnet))
+        }
+}
+
 func TestDoubleVotingPrevention(t *testing.T) {
         votingMgr := NewVotingManager(nil) // nil db for testing
 
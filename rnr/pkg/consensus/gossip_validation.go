@@ -0,0 +1,150 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"rnr-blockchain/pkg/core"
+	"rnr-blockchain/pkg/network"
+)
+
+// Gossip validation: blocks, votes, VRF proofs and evidence are checked against the
+// validators in state before the network relays them. A message this node
+// cannot check yet (unknown signer, round not seen) is ignored; one that
+// fails a check is rejected, which lowers the sending peer's score.
+
+// speedTestCheatEvidence is the proof of speedtest_cheat evidence: the
+// disputed record and the dispute upheld against it
+type speedTestCheatEvidence struct {
+	RoundID string           `json:"round_id"`
+	Record  *SpeedTestResult `json:"record"`
+	Dispute *PoBDispute      `json:"dispute"`
+}
+
+// verifyBlockSignature checks that block is signed by the holder of
+// publicKey
+func verifyBlockSignature(block *core.Block, publicKey []byte) error {
+	key, err := DecodeECDSAPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for %s: %w", shortValidatorID(block.ProposerID), err)
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		return fmt.Errorf("failed to hash block: %w", err)
+	}
+	if len(block.Signature) != 64 || !VerifyVote(&Vote{BlockHash: hash, Signature: block.Signature}, key) {
+		return fmt.Errorf("invalid block signature from %s", shortValidatorID(block.ProposerID))
+	}
+	return nil
+}
+
+// ValidateGossipBlock checks a gossiped block's proposer signature and VRF
+// proof. Where the block fits in the chain is checked when it is processed.
+func (vs *ValidatorService) ValidateGossipBlock(block *core.Block) network.ValidationResult {
+	info, err := vs.state.GetValidator(block.ProposerID)
+	if err != nil || info == nil || len(info.VRFPublicKey) == 0 {
+		return network.ValidationIgnore
+	}
+	if err := verifyBlockSignature(block, info.PublicKey); err != nil {
+		log.Printf("⚠️  Gossiped block #%d rejected: %v", block.Header.Height, err)
+		return network.ValidationReject
+	}
+	vrfInput := []byte(fmt.Sprintf("block_%d", block.Header.Height))
+	if err := VerifyBlockVRFProof(block, info.VRFPublicKey, vrfInput, block.Header.VRFOutput); err != nil {
+		log.Printf("⚠️  Gossiped block #%d rejected: %v", block.Header.Height, err)
+		return network.ValidationReject
+	}
+	return network.ValidationAccept
+}
+
+// ValidateGossipVRFProof checks a gossiped VRF proof against the proposer's
+// VRF key and the height it claims
+func (vs *ValidatorService) ValidateGossipVRFProof(msg *network.VRFProofMessage) network.ValidationResult {
+	info, err := vs.state.GetValidator(msg.ProposerID)
+	if err != nil || info == nil || len(info.VRFPublicKey) == 0 {
+		return network.ValidationIgnore
+	}
+	vrfInput := []byte(fmt.Sprintf("block_%d", msg.BlockHeight))
+	if len(msg.VRFOutput) == 0 || !VerifyVRFWithPublicKey(vrfInput, msg.VRFOutput, msg.VRFProof, info.VRFPublicKey) {
+		log.Printf("⚠️  Gossiped VRF proof for #%d from %s rejected", msg.BlockHeight, shortValidatorID(msg.ProposerID))
+		return network.ValidationReject
+	}
+	return network.ValidationAccept
+}
+
+// ValidateGossipVote checks a gossiped vote's signature against the
+// validator's key
+func (vs *ValidatorService) ValidateGossipVote(blockHash []byte, validatorID string, signature []byte) network.ValidationResult {
+	if len(blockHash) != 32 {
+		return network.ValidationReject
+	}
+	info, err := vs.state.GetValidator(validatorID)
+	if err != nil || info == nil {
+		return network.ValidationIgnore
+	}
+	publicKey, err := DecodeECDSAPublicKey(info.PublicKey)
+	if err != nil {
+		return network.ValidationIgnore
+	}
+	if !VerifyVote(&Vote{BlockHash: blockHash, ValidatorID: validatorID, Signature: signature}, publicKey) {
+		return network.ValidationReject
+	}
+	return network.ValidationAccept
+}
+
+// ValidateGossipEvidence verifies the proof of gossiped misbehavior
+// evidence. A speed test cheat must carry a record signed by the accused and
+// a dispute that holds against it; a tester collusion flag must match one
+// this node raised from the same rounds. Other evidence carries no proof
+// another node can check.
+func (vs *ValidatorService) ValidateGossipEvidence(data []byte) network.ValidationResult {
+	var evidence ByzantineEvidence
+	if err := json.Unmarshal(data, &evidence); err != nil || evidence.ValidatorID == "" {
+		return network.ValidationReject
+	}
+
+	switch evidence.EvidenceType {
+	case "speedtest_cheat":
+		var proof speedTestCheatEvidence
+		if err := json.Unmarshal(evidence.Proof, &proof); err != nil || proof.Record == nil || proof.Dispute == nil {
+			return network.ValidationReject
+		}
+		if proof.Record.TesterID != evidence.ValidatorID || proof.Dispute.Accused != evidence.ValidatorID ||
+			proof.Record.SessionID != proof.RoundID {
+			return network.ValidationReject
+		}
+		info, err := vs.state.GetValidator(evidence.ValidatorID)
+		if err != nil || info == nil {
+			return network.ValidationIgnore
+		}
+		publicKey, err := DecodeECDSAPublicKey(info.PublicKey)
+		if err != nil {
+			return network.ValidationIgnore
+		}
+		if !proof.Record.VerifySignature(publicKey) {
+			return network.ValidationReject
+		}
+		round := vs.pobRounds.GetRound(proof.RoundID)
+		if round == nil {
+			return network.ValidationIgnore
+		}
+		if err := CheckPoBDispute(round, proof.Record, proof.Dispute, info.PublicKey); err != nil {
+			log.Printf("⚠️  Gossiped evidence against %s rejected: %v", shortValidatorID(evidence.ValidatorID), err)
+			return network.ValidationReject
+		}
+		return network.ValidationAccept
+
+	case "tester_collusion":
+		var flag CollusionFlag
+		if err := json.Unmarshal(evidence.Proof, &flag); err != nil || flag.TesterID != evidence.ValidatorID {
+			return network.ValidationReject
+		}
+		if !vs.collusion.HasFlag(&flag) {
+			return network.ValidationIgnore
+		}
+		return network.ValidationAccept
+	}
+
+	return network.ValidationReject
+}
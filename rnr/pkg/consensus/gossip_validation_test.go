@@ -0,0 +1,222 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+	"rnr-blockchain/pkg/network"
+)
+
+// TestGossipValidation tests that gossiped blocks, votes, VRF proofs and evidence are relayed only when their signatures and proofs verify
+func TestGossipValidation(t *testing.T) {
+	db := setupTestDB(t)
+	chain, _ := setupTestBlockchain(db)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+	vs, err := NewValidatorService("1", keys["1"], chain, state, setupTestMempool(), NewProofOfHistory())
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+
+	// Blocks: the proposer's signature and the VRF proof of the height
+	signedBlock := func(height uint64, vrfHeight uint64, key *ecdsa.PrivateKey) *core.Block {
+		vrf, err := vs.vrfSystem.Generate([]byte(fmt.Sprintf("block_%d", vrfHeight)))
+		if err != nil {
+			t.Fatalf("VRF generation failed: %v", err)
+		}
+		block := &core.Block{
+			Header:     &core.BlockHeader{Height: height, Timestamp: time.Now(), VRFOutput: vrf.Value},
+			ProposerID: "1",
+			VRFProof:   vrf.Proof,
+		}
+		hash, _ := block.Hash()
+		block.Signature, _ = signDigest(hash, key)
+		return block
+	}
+	if result := vs.ValidateGossipBlock(signedBlock(5, 5, keys["1"])); result != network.ValidationAccept {
+		t.Errorf("Signed block with a valid VRF proof should be accepted, got %v", result)
+	}
+	if result := vs.ValidateGossipBlock(signedBlock(5, 5, keys["2"])); result != network.ValidationReject {
+		t.Errorf("Block signed by another key should be rejected, got %v", result)
+	}
+	if result := vs.ValidateGossipBlock(signedBlock(5, 6, keys["1"])); result != network.ValidationReject {
+		t.Errorf("Block with the VRF proof of another height should be rejected, got %v", result)
+	}
+	stranger := signedBlock(5, 5, keys["1"])
+	stranger.ProposerID = "unknown"
+	if result := vs.ValidateGossipBlock(stranger); result != network.ValidationIgnore {
+		t.Errorf("Block of an unknown proposer should be ignored, got %v", result)
+	}
+
+	// VRF proofs: the proposer's VRF key and the height proven
+	vrfProof := func(height uint64, vrfHeight uint64) *network.VRFProofMessage {
+		vrf, err := vs.vrfSystem.Generate([]byte(fmt.Sprintf("block_%d", vrfHeight)))
+		if err != nil {
+			t.Fatalf("VRF generation failed: %v", err)
+		}
+		return &network.VRFProofMessage{BlockHeight: height, ProposerID: "1", VRFProof: vrf.Proof, VRFOutput: vrf.Value}
+	}
+	if result := vs.ValidateGossipVRFProof(vrfProof(5, 5)); result != network.ValidationAccept {
+		t.Errorf("VRF proof of the proposer's key should be accepted, got %v", result)
+	}
+	if result := vs.ValidateGossipVRFProof(vrfProof(5, 6)); result != network.ValidationReject {
+		t.Errorf("VRF proof of another height should be rejected, got %v", result)
+	}
+	mismatched := vrfProof(5, 5)
+	mismatched.VRFOutput = []byte("forged-output")
+	if result := vs.ValidateGossipVRFProof(mismatched); result != network.ValidationReject {
+		t.Errorf("VRF proof of another output should be rejected, got %v", result)
+	}
+	impostor := vrfProof(5, 5)
+	impostor.ProposerID = "unknown"
+	if result := vs.ValidateGossipVRFProof(impostor); result != network.ValidationIgnore {
+		t.Errorf("VRF proof of an unknown proposer should be ignored, got %v", result)
+	}
+
+	// Votes: the validator's signature of the block hash
+	blockHash := sha256.Sum256([]byte("block"))
+	signature, _ := signDigest(blockHash[:], keys["2"])
+	if result := vs.ValidateGossipVote(blockHash[:], "2", signature); result != network.ValidationAccept {
+		t.Errorf("Signed vote should be accepted, got %v", result)
+	}
+	if result := vs.ValidateGossipVote(blockHash[:], "3", signature); result != network.ValidationReject {
+		t.Errorf("Vote signed by another validator should be rejected, got %v", result)
+	}
+	if result := vs.ValidateGossipVote(blockHash[:], "unknown", signature); result != network.ValidationIgnore {
+		t.Errorf("Vote of an unknown validator should be ignored, got %v", result)
+	}
+
+	// Evidence: a record signed by the accused that the dispute holds against
+	block := &core.Block{Header: &core.BlockHeader{Height: 10, VRFOutput: []byte("vrf-output-10"), Timestamp: time.Now()}}
+	round, err := vs.pobRounds.ApplyPoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxRequest}), block)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	evidence := func(accused string, upload float64, kind string) []byte {
+		record := timedResult(t, round, accused, keys[accused], upload)
+		proof, _ := json.Marshal(&speedTestCheatEvidence{RoundID: round.ID, Record: record,
+			Dispute: &PoBDispute{Accused: accused, Kind: DisputeInflatedBandwidth}})
+		data, _ := json.Marshal(ByzantineEvidence{ValidatorID: accused, EvidenceType: kind, Proof: proof})
+		return data
+	}
+	liar := round.Committee[0]
+	if result := vs.ValidateGossipEvidence(evidence(liar, 50.0, "speedtest_cheat")); result != network.ValidationAccept {
+		t.Errorf("Proven speed test cheat should be accepted, got %v", result)
+	}
+	if result := vs.ValidateGossipEvidence(evidence(liar, 5.0, "speedtest_cheat")); result != network.ValidationReject {
+		t.Errorf("Dispute that does not hold should be rejected, got %v", result)
+	}
+	if result := vs.ValidateGossipEvidence(evidence(liar, 50.0, "double_vote")); result != network.ValidationReject {
+		t.Errorf("Evidence without a checkable proof should be rejected, got %v", result)
+	}
+	forged, _ := json.Marshal(ByzantineEvidence{ValidatorID: round.Committee[1], EvidenceType: "speedtest_cheat",
+		Proof: []byte(`{"round_id":"` + round.ID + `"}`)})
+	if result := vs.ValidateGossipEvidence(forged); result != network.ValidationReject {
+		t.Errorf("Evidence without a record should be rejected, got %v", result)
+	}
+	flag, _ := json.Marshal(&CollusionFlag{TesterID: liar, Pattern: CollusionFavorsCandidate, Subject: "1", RoundID: round.ID})
+	collusion, _ := json.Marshal(ByzantineEvidence{ValidatorID: liar, EvidenceType: "tester_collusion", Proof: flag})
+	if result := vs.ValidateGossipEvidence(collusion); result != network.ValidationIgnore {
+		t.Errorf("Collusion flag this node did not raise should be ignored, got %v", result)
+	}
+}
+
+// TestUpheldDisputeGossipsEvidence tests that an upheld dispute reaches a real P2P node's evidence topic without deadlocking on the round lock
+func TestUpheldDisputeGossipsEvidence(t *testing.T) {
+	db := setupTestDB(t)
+	chain, _ := setupTestBlockchain(db)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+	vs, err := NewValidatorService("1", keys["1"], chain, state, setupTestMempool(), NewProofOfHistory())
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+	detector := NewByzantineDetector()
+	vs.SetByzantineDetector(detector)
+
+	node, err := network.NewP2PNetwork(network.P2PConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	defer node.Close()
+	node.SetEvidenceValidator(vs.ValidateGossipEvidence)
+
+	// As wired by the node: evidence is gossiped, and checked against the round
+	gossiped := make(chan network.ValidationResult, 1)
+	detector.SetEvidenceHandler(func(evidence ByzantineEvidence) {
+		data, _ := json.Marshal(evidence)
+		if err := node.BroadcastEvidence(data); err != nil {
+			t.Errorf("Failed to gossip evidence: %v", err)
+		}
+		gossiped <- vs.ValidateGossipEvidence(data)
+	})
+
+	block := &core.Block{Header: &core.BlockHeader{Height: 10, VRFOutput: []byte("vrf-output-10"), Timestamp: time.Now()}}
+	round, err := vs.pobRounds.ApplyPoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxRequest}), block)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	liar := round.Committee[0]
+	uploads := map[string]float64{liar: 50.0}
+	for i, upload := range []float64{5.0, 5.2, 4.9, 5.1, 5.3, 5.0, 5.2} {
+		uploads[round.Committee[i+1]] = upload
+	}
+	for _, tester := range round.Committee {
+		result := timedResult(t, round, tester, keys[tester], uploads[tester])
+		if _, err := vs.pobRounds.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}), block); err != nil {
+			t.Fatalf("Result from %s rejected: %v", tester, err)
+		}
+	}
+	testedAt := time.Now()
+	vs.pobRounds.ProcessRounds(11, testedAt, &PoBThresholds{MinUploadBandwidth: 4.0, TargetLatency: 100.0, TargetPacketLoss: 0.1})
+
+	challenger := ""
+	for id := range keys {
+		if id != "1" && !round.IsCommitteeMember(id) {
+			challenger = id
+		}
+	}
+	dispute := pobTx(t, challenger, &PoBTx{Type: PoBTxDispute, RoundID: round.ID,
+		Dispute: &PoBDispute{Accused: liar, Kind: DisputeInflatedBandwidth}})
+	applied := make(chan error, 1)
+	go func() {
+		_, err := vs.pobRounds.ApplyPoBTx(dispute, &core.Block{Header: &core.BlockHeader{Height: 12, Timestamp: testedAt.Add(time.Hour)}})
+		applied <- err
+	}()
+
+	select {
+	case err := <-applied:
+		if err != nil {
+			t.Fatalf("Inflated bandwidth dispute should be upheld: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upholding a dispute deadlocked on gossiping its evidence")
+	}
+	select {
+	case result := <-gossiped:
+		if result != network.ValidationAccept {
+			t.Errorf("Gossiped evidence of the upheld dispute should be accepted, got %v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Evidence of the upheld dispute was not gossiped")
+	}
+}
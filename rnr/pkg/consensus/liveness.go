@@ -0,0 +1,343 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"rnr-blockchain/pkg/blockchain"
+	"rnr-blockchain/pkg/core"
+)
+
+// Liveness tracking: every block carries the votes on its parent
+// (Block.LastCommit), so all nodes see the same signer set for each height.
+// Each active validator has a missed-block bitmap over a sliding window;
+// a validator that misses too much of the window is jailed and stays out of
+// the active set until it sends an unjail transaction after the cooldown.
+
+const SlashingTxUnjail = "unjail"
+
+// SlashingTx is the payload carried in core.Transaction.Data for transactions
+// sent to core.SlashingModuleAddress.
+type SlashingTx struct {
+	Type string `json:"type"`
+}
+
+func (p *SlashingTx) Marshal() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+type LivenessParams struct {
+	SignedBlocksWindow uint64
+	MinSignedPerWindow float64
+}
+
+func DefaultLivenessParams() LivenessParams {
+	return LivenessParams{
+		SignedBlocksWindow: core.SignedBlocksWindow,
+		MinSignedPerWindow: core.MinSignedPerWindow,
+	}
+}
+
+// MaxMissedBlocks is the number of misses in a full window that still keeps
+// a validator out of jail.
+func (p LivenessParams) MaxMissedBlocks() uint64 {
+	return p.SignedBlocksWindow - uint64(float64(p.SignedBlocksWindow)*p.MinSignedPerWindow)
+}
+
+type ValidatorSigningInfo struct {
+	ValidatorID         string `json:"validator_id"`
+	StartHeight         uint64 `json:"start_height"`
+	IndexOffset         uint64 `json:"index_offset"`
+	MissedBlocksCounter uint64 `json:"missed_blocks_counter"`
+	MissedBitmap        []byte `json:"missed_bitmap"`
+}
+
+func (si *ValidatorSigningInfo) missedAt(index uint64) bool {
+	return si.MissedBitmap[index/8]&(1<<(index%8)) != 0
+}
+
+func (si *ValidatorSigningInfo) setMissed(index uint64, missed bool) {
+	if missed {
+		si.MissedBitmap[index/8] |= 1 << (index % 8)
+	} else {
+		si.MissedBitmap[index/8] &^= 1 << (index % 8)
+	}
+}
+
+type LivenessTracker struct {
+	db           *leveldb.DB
+	state        *blockchain.State
+	slashingMgr  *SlashingManager
+	params       LivenessParams
+	signingInfos map[string]*ValidatorSigningInfo
+	mu           sync.RWMutex
+}
+
+func NewLivenessTracker(db *leveldb.DB, state *blockchain.State, slashingMgr *SlashingManager, params LivenessParams) *LivenessTracker {
+	lt := &LivenessTracker{
+		db:           db,
+		state:        state,
+		slashingMgr:  slashingMgr,
+		params:       params,
+		signingInfos: make(map[string]*ValidatorSigningInfo),
+	}
+
+	lt.loadSigningInfos()
+
+	return lt
+}
+
+func (lt *LivenessTracker) loadSigningInfos() {
+	iter := lt.db.NewIterator(util.BytesPrefix([]byte("signing_info_")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var si ValidatorSigningInfo
+		if err := json.Unmarshal(iter.Value(), &si); err == nil {
+			if uint64(len(si.MissedBitmap)) != (lt.params.SignedBlocksWindow+7)/8 {
+				// Window size changed: start a fresh window
+				continue
+			}
+			lt.signingInfos[si.ValidatorID] = &si
+		}
+	}
+
+	log.Printf("📡 Loaded liveness signing info for %d validators", len(lt.signingInfos))
+}
+
+// RecordCommit updates every active validator's missed-block bitmap with the
+// signer set of the block at height, and jails validators that exceed the
+// allowed number of misses, dated blockTime, the time of the block carrying
+// the commit. Returns the IDs of newly jailed validators.
+func (lt *LivenessTracker) RecordCommit(height uint64, blockTime time.Time, commit []*core.CommitSig) []string {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	signers := make(map[string]bool, len(commit))
+	for _, sig := range commit {
+		signers[sig.ValidatorID] = true
+	}
+
+	activeValidators := lt.state.GetActiveValidators()
+	sort.Strings(activeValidators)
+
+	window := lt.params.SignedBlocksWindow
+	maxMissed := lt.params.MaxMissedBlocks()
+	batch := new(leveldb.Batch)
+	jailed := make([]string, 0)
+
+	for _, validatorID := range activeValidators {
+		info, err := lt.state.GetValidator(validatorID)
+		if err != nil || info == nil || info.IsSuspended {
+			continue
+		}
+
+		si, ok := lt.signingInfos[validatorID]
+		if !ok {
+			si = &ValidatorSigningInfo{
+				ValidatorID:  validatorID,
+				StartHeight:  height,
+				MissedBitmap: make([]byte, (window+7)/8),
+			}
+			lt.signingInfos[validatorID] = si
+		}
+
+		index := si.IndexOffset % window
+		previouslyMissed := si.missedAt(index)
+		missed := !signers[validatorID]
+
+		switch {
+		case !previouslyMissed && missed:
+			si.setMissed(index, true)
+			si.MissedBlocksCounter++
+		case previouslyMissed && !missed:
+			si.setMissed(index, false)
+			si.MissedBlocksCounter--
+		}
+		si.IndexOffset++
+
+		if si.IndexOffset >= window && si.MissedBlocksCounter > maxMissed {
+			evidence, _ := json.Marshal(map[string]uint64{
+				"height":        height,
+				"missed_blocks": si.MissedBlocksCounter,
+				"window":        window,
+			})
+
+			if err := lt.slashingMgr.JailForDowntime(validatorID, height, blockTime, evidence); err != nil {
+				log.Printf("⚠️  Failed to jail validator %s: %v", shortValidatorID(validatorID), err)
+			} else {
+				log.Printf("📡 Validator %s missed %d of last %d blocks - jailed",
+					shortValidatorID(validatorID), si.MissedBlocksCounter, window)
+				jailed = append(jailed, validatorID)
+
+				// Start a fresh window once the validator is back
+				delete(lt.signingInfos, validatorID)
+				batch.Delete([]byte("signing_info_" + validatorID))
+				continue
+			}
+		}
+
+		data, err := json.Marshal(si)
+		if err != nil {
+			continue
+		}
+		batch.Put([]byte("signing_info_"+validatorID), data)
+	}
+
+	if err := lt.db.Write(batch, nil); err != nil {
+		log.Printf("⚠️  Failed to persist liveness signing info: %v", err)
+	}
+
+	return jailed
+}
+
+// IsUnjailTx reports whether tx targets the slashing module.
+func IsUnjailTx(tx *core.Transaction) bool {
+	return tx.To == core.SlashingModuleAddress
+}
+
+// ValidateUnjailTx checks that the sender is a jailed validator whose jail
+// period has passed by blockHeight.
+func (lt *LivenessTracker) ValidateUnjailTx(tx *core.Transaction, blockHeight uint64) error {
+	var payload SlashingTx
+	if err := json.Unmarshal(tx.Data, &payload); err != nil {
+		return fmt.Errorf("invalid slashing payload: %w", err)
+	}
+	if payload.Type != SlashingTxUnjail {
+		return fmt.Errorf("unknown slashing tx type: %s", payload.Type)
+	}
+	if tx.Amount != nil && tx.Amount.Sign() != 0 {
+		return fmt.Errorf("unjail tx must not transfer funds")
+	}
+
+	info, err := lt.state.GetValidator(tx.From)
+	if err != nil || info == nil {
+		return fmt.Errorf("unknown validator: %s", tx.From)
+	}
+	if !info.IsJailed {
+		return fmt.Errorf("validator %s is not jailed", shortValidatorID(tx.From))
+	}
+	if blockHeight < info.JailedUntilHeight {
+		return fmt.Errorf("validator jailed until block #%d", info.JailedUntilHeight)
+	}
+
+	return nil
+}
+
+func (lt *LivenessTracker) ApplyUnjailTx(tx *core.Transaction, blockHeight uint64) error {
+	if err := lt.ValidateUnjailTx(tx, blockHeight); err != nil {
+		return err
+	}
+
+	if err := lt.slashingMgr.UnjailValidator(tx.From); err != nil {
+		return err
+	}
+
+	lt.mu.Lock()
+	delete(lt.signingInfos, tx.From)
+	lt.mu.Unlock()
+	if err := lt.db.Delete([]byte("signing_info_"+tx.From), nil); err != nil {
+		log.Printf("⚠️  Failed to reset signing info: %v", err)
+	}
+
+	log.Printf("🔓 Validator unjailed: %s at block #%d", shortValidatorID(tx.From), blockHeight)
+	return nil
+}
+
+// GetSigningInfo returns a copy of a validator's liveness record, or nil.
+func (lt *LivenessTracker) GetSigningInfo(validatorID string) *ValidatorSigningInfo {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+
+	si, ok := lt.signingInfos[validatorID]
+	if !ok {
+		return nil
+	}
+	siCopy := *si
+	siCopy.MissedBitmap = append([]byte(nil), si.MissedBitmap...)
+	return &siCopy
+}
+
+// VerifyLastCommit checks that every commit signature is a valid vote on
+// parentHash by a known validator, with no validator listed twice.
+func VerifyLastCommit(state *blockchain.State, parentHash []byte, commit []*core.CommitSig) error {
+	seen := make(map[string]bool, len(commit))
+	for _, sig := range commit {
+		if seen[sig.ValidatorID] {
+			return fmt.Errorf("duplicate commit signature from %s", shortValidatorID(sig.ValidatorID))
+		}
+		seen[sig.ValidatorID] = true
+
+		info, err := state.GetValidator(sig.ValidatorID)
+		if err != nil || info == nil {
+			return fmt.Errorf("commit signature from unknown validator %s", shortValidatorID(sig.ValidatorID))
+		}
+		publicKey, err := DecodeECDSAPublicKey(info.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid public key for %s: %w", shortValidatorID(sig.ValidatorID), err)
+		}
+
+		vote := &Vote{BlockHash: parentHash, ValidatorID: sig.ValidatorID, Signature: sig.Signature}
+		if !VerifyVote(vote, publicKey) {
+			return fmt.Errorf("invalid commit signature from %s", shortValidatorID(sig.ValidatorID))
+		}
+	}
+
+	// Commits are canonical: sorted by validator ID
+	if !sort.SliceIsSorted(commit, func(i, j int) bool {
+		return commit[i].ValidatorID < commit[j].ValidatorID
+	}) {
+		return fmt.Errorf("commit signatures not sorted by validator ID")
+	}
+
+	return nil
+}
+
+// VerifyCommitQuorum checks that the signers of a commit hold at least two
+// thirds of the active validators' bonded stake. Signatures are checked by
+// VerifyLastCommit; signers outside the active set carry no weight. When no
+// stake is bonded at all (e.g. before any staking tx) each active validator
+// weighs one.
+func VerifyCommitQuorum(state *blockchain.State, staking *StakingManager, commit []*core.CommitSig) error {
+	active := state.GetActiveValidators()
+	if len(active) == 0 {
+		return nil
+	}
+
+	power := make(map[string]*big.Int, len(active))
+	total := big.NewInt(0)
+	for _, id := range active {
+		weight := big.NewInt(0)
+		if staking != nil {
+			weight = staking.GetTotalBonded(id)
+		}
+		power[id] = weight
+		total.Add(total, weight)
+	}
+	if total.Sign() == 0 {
+		for _, id := range active {
+			power[id] = big.NewInt(1)
+		}
+		total.SetInt64(int64(len(active)))
+	}
+
+	signed := big.NewInt(0)
+	for _, sig := range commit {
+		if weight, ok := power[sig.ValidatorID]; ok {
+			signed.Add(signed, weight)
+		}
+	}
+
+	// signed/total >= 2/3
+	if new(big.Int).Mul(signed, big.NewInt(3)).Cmp(new(big.Int).Mul(total, big.NewInt(2))) < 0 {
+		return fmt.Errorf("commit has %s of %s bonded stake, need two thirds", signed, total)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package consensus
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// TestLivenessJailAndUnjail tests jailing after too many missed blocks and the unjail cooldown
+func TestLivenessJailAndUnjail(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+	registry := NewValidatorRegistry(state)
+	slashingMgr := NewSlashingManager(db, registry)
+
+	_, online := createTestValidator("1")
+	_, offline := createTestValidator("2")
+	state.UpdateValidator(online)
+	state.UpdateValidator(offline)
+
+	params := LivenessParams{SignedBlocksWindow: 10, MinSignedPerWindow: 0.5}
+	tracker := NewLivenessTracker(db, state, slashingMgr, params)
+
+	commit := []*core.CommitSig{{ValidatorID: online.ID}}
+	blockTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The window must fill before anyone can be jailed
+	for height := uint64(1); height < 10; height++ {
+		if jailed := tracker.RecordCommit(height, blockTime, commit); len(jailed) != 0 {
+			t.Fatalf("Validator jailed at height %d before the window filled", height)
+		}
+	}
+
+	jailed := tracker.RecordCommit(10, blockTime, commit)
+	if len(jailed) != 1 || jailed[0] != offline.ID {
+		t.Fatalf("Expected offline validator to be jailed, got %v", jailed)
+	}
+	if history := slashingMgr.GetSlashingHistory(offline.ID); len(history) != 1 || !history[0].Timestamp.Equal(blockTime) {
+		t.Errorf("Expected one slashing event dated by the block, got %+v", history)
+	}
+
+	info, _ := state.GetValidator(offline.ID)
+	if !info.IsJailed || !info.IsSuspended {
+		t.Errorf("Jailed validator should be suspended and marked jailed")
+	}
+	if info.JailedUntilHeight != 10+core.DowntimeJailBlocks {
+		t.Errorf("Expected jail until %d, got %d", 10+core.DowntimeJailBlocks, info.JailedUntilHeight)
+	}
+
+	// Jail does not expire on its own
+	if cleared := slashingMgr.CheckAndClearExpiredSuspensions(); cleared != 0 {
+		t.Errorf("Jailed validator must not be auto-released, cleared %d", cleared)
+	}
+
+	if si := tracker.GetSigningInfo(online.ID); si == nil || si.MissedBlocksCounter != 0 {
+		t.Errorf("Online validator should have no missed blocks, got %+v", si)
+	}
+
+	payload, _ := (&SlashingTx{Type: SlashingTxUnjail}).Marshal()
+	unjail := &core.Transaction{
+		ID:     "unjail_1",
+		From:   offline.ID,
+		To:     core.SlashingModuleAddress,
+		Amount: big.NewInt(0),
+		Fee:    big.NewInt(0),
+		Data:   payload,
+	}
+
+	if err := tracker.ValidateUnjailTx(unjail, 11); err == nil {
+		t.Errorf("Unjail during cooldown should be rejected")
+	}
+	if err := tracker.ApplyUnjailTx(unjail, 10+core.DowntimeJailBlocks); err != nil {
+		t.Fatalf("Unjail after cooldown failed: %v", err)
+	}
+
+	info, _ = state.GetValidator(offline.ID)
+	if info.IsJailed || info.IsSuspended {
+		t.Errorf("Validator should be active again after unjail")
+	}
+}
+
+// TestJailForDowntimeFailedSlash tests that a validator whose delegated stake cannot be slashed is left unjailed
+func TestJailForDowntimeFailedSlash(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	_, validator := createTestValidator("1")
+	state.UpdateValidator(validator)
+	state.UpdateAccount(&core.Account{Address: "delegator_1", Balance: big.NewInt(1000000)})
+	sm := NewStakingManager(db, state)
+	bondViaTx(t, state, sm, newStakingTx(t, "delegator_1", 1000000, &StakingTx{Type: StakingTxDelegate, ValidatorID: validator.ID}), 1)
+
+	slashingMgr := NewSlashingManager(db, NewValidatorRegistry(state))
+	slashingMgr.SetStakingManager(sm)
+	blockTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	module, _ := state.GetAccount(core.StakingModuleAddress)
+	funded := module.Balance
+	module.Balance = big.NewInt(10)
+	state.UpdateAccount(module)
+	if err := slashingMgr.JailForDowntime(validator.ID, 10, blockTime, nil); err == nil {
+		t.Errorf("Jail should fail when the module account cannot cover the slash")
+	}
+	if info, _ := state.GetValidator(validator.ID); info.IsJailed {
+		t.Errorf("Validator should not be jailed after a failed slash")
+	}
+
+	module.Balance = funded
+	state.UpdateAccount(module)
+	if err := slashingMgr.JailForDowntime(validator.ID, 11, blockTime, nil); err != nil {
+		t.Fatalf("JailForDowntime failed: %v", err)
+	}
+	if info, _ := state.GetValidator(validator.ID); !info.IsJailed {
+		t.Errorf("Validator should be jailed once its stake is slashed")
+	}
+	if bonded := sm.GetTotalBonded(validator.ID); bonded.Cmp(big.NewInt(999900)) != 0 {
+		t.Errorf("Expected 999900 bonded after the downtime slash, got %s", bonded.String())
+	}
+}
+
+// TestVerifyCommitQuorum tests that a commit needs two thirds of bonded stake
+func TestVerifyCommitQuorum(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+	sm := NewStakingManager(db, state)
+
+	stakes := map[string]int64{"1": 600, "2": 300, "3": 100}
+	for id, stake := range stakes {
+		_, v := createTestValidator(id)
+		v.ID = id
+		state.UpdateValidator(v)
+		if err := sm.BondGenesisStake(id, big.NewInt(stake)); err != nil {
+			t.Fatalf("BondGenesisStake failed: %v", err)
+		}
+	}
+
+	sigs := func(ids ...string) []*core.CommitSig {
+		commit := make([]*core.CommitSig, 0, len(ids))
+		for _, id := range ids {
+			commit = append(commit, &core.CommitSig{ValidatorID: id})
+		}
+		return commit
+	}
+
+	if err := VerifyCommitQuorum(state, sm, sigs("1", "3")); err != nil {
+		t.Errorf("70%% of stake should reach quorum: %v", err)
+	}
+	if err := VerifyCommitQuorum(state, sm, sigs("2", "3")); err == nil {
+		t.Errorf("40%% of stake should not reach quorum")
+	}
+	if err := VerifyCommitQuorum(state, sm, sigs("1", "outsider")); err == nil {
+		t.Errorf("Signers outside the active set should carry no weight")
+	}
+
+	// Without bonded stake each active validator weighs one
+	if err := VerifyCommitQuorum(state, nil, sigs("2", "3")); err != nil {
+		t.Errorf("2 of 3 validators should reach quorum without stake: %v", err)
+	}
+	if err := VerifyCommitQuorum(state, nil, sigs("3")); err == nil {
+		t.Errorf("1 of 3 validators should not reach quorum")
+	}
+}
+
+// TestReceivedVotesReachLastCommit tests that verified gossiped votes are
+// recorded and included in the next LastCommit
+func TestReceivedVotesReachLastCommit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	chain, _ := setupTestBlockchain(db)
+	state, _ := setupTestState(db)
+
+	privKey1, validator1 := createTestValidator("v1")
+	privKey2, validator2 := createTestValidator("v2")
+	state.UpdateValidator(validator1)
+	state.UpdateValidator(validator2)
+
+	vs, err := NewValidatorService(validator1.ID, privKey1, chain, state, setupTestMempool(), NewProofOfHistory())
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+	vs.votingManager.SetState(state)
+
+	blockHash := sha256.Sum256([]byte("parent"))
+	sig2, _ := SignVote(blockHash[:], privKey2)
+	forged, _ := SignVote(blockHash[:], privKey1)
+
+	if err := vs.HandleReceivedVote(blockHash[:4], validator2.ID, sig2); err == nil {
+		t.Errorf("Vote with a short block hash should be rejected")
+	}
+	if err := vs.HandleReceivedVote(blockHash[:], validator2.ID, forged); err == nil {
+		t.Errorf("Vote signed with another key should be rejected")
+	}
+	if err := vs.HandleReceivedVote(blockHash[:], validator2.ID, sig2); err != nil {
+		t.Fatalf("Valid vote rejected: %v", err)
+	}
+	if err := vs.HandleReceivedVote(blockHash[:], validator2.ID, sig2); err != nil {
+		t.Errorf("Duplicate vote should be ignored, got %v", err)
+	}
+
+	commit := vs.buildLastCommit(blockHash[:])
+	if len(commit) != 1 || commit[0].ValidatorID != validator2.ID {
+		t.Fatalf("Expected the received vote in LastCommit, got %v", commit)
+	}
+	if err := VerifyLastCommit(state, blockHash[:], commit); err != nil {
+		t.Errorf("LastCommit from received votes should verify: %v", err)
+	}
+}
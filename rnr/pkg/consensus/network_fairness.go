@@ -2,10 +2,9 @@ package consensus
 
 import (
 	"math/big"
-	"net"
-	"strings"
 
 	"rnr-blockchain/pkg/core"
+	"rnr-blockchain/pkg/network"
 )
 
 type NetworkGroup struct {
@@ -14,13 +13,17 @@ type NetworkGroup struct {
 	TotalScore float64
 }
 
-func GroupValidatorsByNetwork(validators map[string]*core.ValidatorInfo) map[string]*NetworkGroup {
+// GroupValidatorsByNetwork groups validators by their recorded ASN, or by
+// the origin AS resolver finds for their IP. Without a dataset (nil resolver)
+// IPs are grouped by subnet. When the groups decide rewards, resolver must be
+// pinned to the genesis dataset so every node groups alike.
+func GroupValidatorsByNetwork(validators map[string]*core.ValidatorInfo, resolver *network.ASNResolver) map[string]*NetworkGroup {
 	groups := make(map[string]*NetworkGroup)
 
 	for _, validator := range validators {
 		asn := validator.NetworkASN
-		if asn == "" {
-			asn = extractASNFromIP(validator.IPAddress)
+		if asn == "" || asn == "unknown" {
+			asn = extractASNFromIP(validator.IPAddress, resolver)
 		}
 
 		if asn == "" {
@@ -42,34 +45,8 @@ func GroupValidatorsByNetwork(validators map[string]*core.ValidatorInfo) map[str
 	return groups
 }
 
-func extractASNFromIP(ipAddr string) string {
-	if ipAddr == "" {
-		return "unknown"
-	}
-
-	ip := net.ParseIP(ipAddr)
-	if ip == nil {
-		return "unknown"
-	}
-
-	if ip.IsLoopback() {
-		return "local"
-	}
-
-	if ip.IsPrivate() {
-		return "private"
-	}
-
-	subnet := getSubnet24(ipAddr)
-	return subnet
-}
-
-func getSubnet24(ipAddr string) string {
-	parts := strings.Split(ipAddr, ".")
-	if len(parts) >= 3 {
-		return parts[0] + "." + parts[1] + "." + parts[2] + ".0/24"
-	}
-	return "unknown"
+func extractASNFromIP(ipAddr string, resolver *network.ASNResolver) string {
+	return resolver.NetworkGroup(ipAddr)
 }
 
 func DistributePoBRewardFairly(pobReward *big.Int, groups map[string]*NetworkGroup, topCount int) map[string]*big.Int {
@@ -99,8 +76,8 @@ func DistributePoBRewardFairly(pobReward *big.Int, groups map[string]*NetworkGro
 	return rewards
 }
 
-func GetTopPoBContributorsByGroup(validators map[string]*core.ValidatorInfo, maxGroups int) []string {
-	groups := GroupValidatorsByNetwork(validators)
+func GetTopPoBContributorsByGroup(validators map[string]*core.ValidatorInfo, resolver *network.ASNResolver, maxGroups int) []string {
+	groups := GroupValidatorsByNetwork(validators, resolver)
 
 	type groupScore struct {
 		asn   string
@@ -0,0 +1,311 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"rnr-blockchain/pkg/blockchain"
+	"rnr-blockchain/pkg/core"
+)
+
+// PoB tester collusion detection: the committee median limits what a single
+// tester can do to a candidate's result, but a tester (or several) may still
+// keep favoring friends. Every completed round compares each tester's result
+// with the committee median and keeps a per-tester profile. A tester is
+// flagged when its results sit consistently above the median, or when it
+// keeps favoring the same candidate or the same candidate ASN. A flagged
+// tester loses reputation, which lowers its weight in future committees (see
+// SelectWeightedTestCommittee), and is reported to the ByzantineDetector.
+//
+// Profiles are built only from finalized rounds, so every node flags the
+// same testers at the same height.
+
+// CollusionPattern names what a flag was raised for.
+type CollusionPattern string
+
+const (
+	CollusionConsistentDeviation CollusionPattern = "consistent_deviation"
+	CollusionFavorsCandidate     CollusionPattern = "favors_candidate"
+	CollusionFavorsASN           CollusionPattern = "favors_asn"
+)
+
+const (
+	collusionWindow            = 20   // Recent deviations kept per tester
+	collusionMinSamples        = 10   // Deviations needed before a tester is judged
+	collusionMeanDeviation     = 0.15 // Mean favor above the median that is consistent deviation
+	collusionFavorDeviation    = 0.25 // Favor above the median that counts a result as favoring
+	collusionMinCandidateTests = 3    // Tests of one candidate before favoritism is judged
+	collusionMinASNTests       = 5    // Tests of one ASN before favoritism is judged
+	collusionFavorRatio        = 0.8  // Share of favoring results that flags a tester
+	collusionReputationPenalty = 20
+)
+
+// favorCount counts a tester's results for one candidate or ASN
+type favorCount struct {
+	Tests   int `json:"tests"`
+	Favored int `json:"favored"`
+}
+
+// TesterProfile is a tester's record against committee medians
+type TesterProfile struct {
+	TesterID   string                 `json:"tester_id"`
+	Deviations []float64              `json:"deviations"` // Most recent last
+	Candidates map[string]*favorCount `json:"candidates"`
+	ASNs       map[string]*favorCount `json:"asns"`
+	Flags      []*CollusionFlag       `json:"flags,omitempty"`
+}
+
+// CollusionFlag records one pattern a tester was flagged for
+type CollusionFlag struct {
+	TesterID string           `json:"tester_id"`
+	Pattern  CollusionPattern `json:"pattern"`
+	Subject  string           `json:"subject,omitempty"` // Candidate or ASN favored
+	RoundID  string           `json:"round_id"`
+	Height   uint64           `json:"height"`
+	Score    float64          `json:"score"` // Mean deviation or favoring share
+}
+
+// CollusionDetector keeps tester profiles and flags colluding testers
+type CollusionDetector struct {
+	db                *leveldb.DB
+	state             *blockchain.State
+	byzantineDetector *ByzantineDetector
+	profiles          map[string]*TesterProfile
+	mu                sync.Mutex
+}
+
+// NewCollusionDetector creates a detector and loads tester profiles from db
+func NewCollusionDetector(db *leveldb.DB, state *blockchain.State) *CollusionDetector {
+	cd := &CollusionDetector{
+		db:       db,
+		state:    state,
+		profiles: make(map[string]*TesterProfile),
+	}
+	cd.loadProfiles()
+	return cd
+}
+
+// SetByzantineDetector reports flagged testers to detector
+func (cd *CollusionDetector) SetByzantineDetector(detector *ByzantineDetector) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	cd.byzantineDetector = detector
+}
+
+func (cd *CollusionDetector) loadProfiles() {
+	iter := cd.db.NewIterator(util.BytesPrefix([]byte("pob_tester_")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var profile TesterProfile
+		if err := json.Unmarshal(iter.Value(), &profile); err != nil {
+			continue
+		}
+		cd.profiles[profile.TesterID] = &profile
+	}
+	if len(cd.profiles) > 0 {
+		log.Printf("🕵️  Loaded %d PoB tester profiles", len(cd.profiles))
+	}
+}
+
+func (cd *CollusionDetector) saveProfile(profile *TesterProfile) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return
+	}
+	if err := cd.db.Put([]byte("pob_tester_"+profile.TesterID), data, nil); err != nil {
+		log.Printf("⚠️  Failed to persist tester profile %s: %v", shortValidatorID(profile.TesterID), err)
+	}
+}
+
+func (cd *CollusionDetector) profile(testerID string) *TesterProfile {
+	profile, ok := cd.profiles[testerID]
+	if !ok {
+		profile = &TesterProfile{
+			TesterID:   testerID,
+			Candidates: make(map[string]*favorCount),
+			ASNs:       make(map[string]*favorCount),
+		}
+		cd.profiles[testerID] = profile
+	}
+	return profile
+}
+
+// GetProfile returns the profile of testerID, or nil if it has none
+func (cd *CollusionDetector) GetProfile(testerID string) *TesterProfile {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	return cd.profiles[testerID]
+}
+
+// HasFlag reports whether the tester was flagged for flag's pattern and
+// subject in flag's round
+func (cd *CollusionDetector) HasFlag(flag *CollusionFlag) bool {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	profile := cd.profiles[flag.TesterID]
+	if profile == nil {
+		return false
+	}
+	for _, raised := range profile.Flags {
+		if raised.Pattern == flag.Pattern && raised.Subject == flag.Subject && raised.RoundID == flag.RoundID {
+			return true
+		}
+	}
+	return false
+}
+
+// testerFavor is how far result favors the candidate relative to the
+// committee: its relative excess upload over the median. Latency is left
+// out, since a tester close to the candidate honestly measures less of it
+// than the committee does, round after round.
+func testerFavor(result *SpeedTestResult, medianUpload float64) float64 {
+	if medianUpload <= 0 {
+		return 0
+	}
+	return (result.UploadBandwidth - medianUpload) / medianUpload
+}
+
+// AnalyzeRound adds a completed round to its testers' profiles, flags the
+// testers whose profiles now show collusion, penalizes their reputation and
+// reports them. Returns the flags raised.
+func (cd *CollusionDetector) AnalyzeRound(round *PoBRound) []*CollusionFlag {
+	if round.Status != PoBRoundCompleted || round.Aggregation == nil {
+		return nil
+	}
+
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	medianUpload := round.Aggregation.UploadBandwidth()
+	asn := ""
+	if info, err := cd.state.GetValidator(round.CandidateID); err == nil && info != nil && info.NetworkASN != "unknown" {
+		asn = info.NetworkASN
+	}
+
+	testers := make([]string, 0, len(round.Results))
+	for testerID := range round.Results {
+		testers = append(testers, testerID)
+	}
+	sort.Strings(testers)
+
+	flags := make([]*CollusionFlag, 0)
+	for _, testerID := range testers {
+		result := round.Results[testerID]
+		if _, disputed := round.Disputed[testerID]; disputed || len(result.Anomalies) > 0 || !result.PayloadVerified {
+			continue
+		}
+
+		favor := testerFavor(result, medianUpload)
+		favored := favor > collusionFavorDeviation
+
+		profile := cd.profile(testerID)
+		profile.Deviations = append(profile.Deviations, favor)
+		if len(profile.Deviations) > collusionWindow {
+			profile.Deviations = profile.Deviations[len(profile.Deviations)-collusionWindow:]
+		}
+		raise := func(pattern CollusionPattern, subject string, score float64) {
+			flags = append(flags, &CollusionFlag{
+				TesterID: testerID,
+				Pattern:  pattern,
+				Subject:  subject,
+				RoundID:  round.ID,
+				Height:   round.ClosedHeight,
+				Score:    score,
+			})
+		}
+
+		if len(profile.Deviations) >= collusionMinSamples {
+			var sum float64
+			for _, d := range profile.Deviations {
+				sum += d
+			}
+			if mean := sum / float64(len(profile.Deviations)); mean > collusionMeanDeviation {
+				raise(CollusionConsistentDeviation, "", mean)
+				profile.Deviations = nil
+			}
+		}
+
+		if share, ok := countFavor(profile.Candidates, round.CandidateID, favored, collusionMinCandidateTests); ok {
+			raise(CollusionFavorsCandidate, round.CandidateID, share)
+		}
+		if asn != "" {
+			if share, ok := countFavor(profile.ASNs, asn, favored, collusionMinASNTests); ok {
+				raise(CollusionFavorsASN, asn, share)
+			}
+		}
+	}
+
+	for _, flag := range flags {
+		profile := cd.profiles[flag.TesterID]
+		profile.Flags = append(profile.Flags, flag)
+		cd.penalize(flag)
+	}
+	for _, testerID := range testers {
+		if profile, ok := cd.profiles[testerID]; ok {
+			cd.saveProfile(profile)
+		}
+	}
+
+	return flags
+}
+
+// countFavor counts one result for subject and reports the favoring share
+// once it has minTests results and reaches collusionFavorRatio. The count
+// restarts after a flag.
+func countFavor(counts map[string]*favorCount, subject string, favored bool, minTests int) (float64, bool) {
+	count, ok := counts[subject]
+	if !ok {
+		count = &favorCount{}
+		counts[subject] = count
+	}
+	count.Tests++
+	if favored {
+		count.Favored++
+	}
+
+	if count.Tests < minTests {
+		return 0, false
+	}
+	share := float64(count.Favored) / float64(count.Tests)
+	if share < collusionFavorRatio {
+		return share, false
+	}
+	delete(counts, subject)
+	return share, true
+}
+
+// penalize lowers the flagged tester's reputation and reports it
+func (cd *CollusionDetector) penalize(flag *CollusionFlag) {
+	if info, err := cd.state.GetValidator(flag.TesterID); err == nil && info != nil {
+		if info.Reputation == 0 {
+			info.Reputation = core.InitialReputation
+		}
+		// Reputation stays at least 1; 0 means never set
+		info.Reputation -= collusionReputationPenalty
+		if info.Reputation < 1 {
+			info.Reputation = 1
+		}
+		cd.state.UpdateValidator(info)
+	}
+
+	subject := ""
+	if flag.Subject != "" {
+		subject = fmt.Sprintf(" (%s)", shortValidatorID(flag.Subject))
+	}
+	log.Printf("🕵️  PoB tester %s flagged for %s%s in round %s, score %.2f",
+		shortValidatorID(flag.TesterID), flag.Pattern, subject, flag.RoundID, flag.Score)
+
+	if cd.byzantineDetector != nil {
+		evidence, _ := json.Marshal(flag)
+		if err := cd.byzantineDetector.ReportTesterCollusion(flag.TesterID, string(flag.Pattern), evidence); err != nil {
+			log.Printf("🚨 %v", err)
+		}
+	}
+}
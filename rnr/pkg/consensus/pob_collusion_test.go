@@ -0,0 +1,154 @@
+package consensus
+
+import (
+	"fmt"
+	"testing"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// completedRound builds a completed round with the given tester uploads
+func completedRound(t *testing.T, id, candidate string, uploads map[string]float64) *PoBRound {
+	thresholds := &PoBThresholds{MinUploadBandwidth: 7.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+	round := &PoBRound{
+		ID:           id,
+		CandidateID:  candidate,
+		Results:      make(map[string]*SpeedTestResult),
+		Status:       PoBRoundCompleted,
+		ClosedHeight: 100,
+		Thresholds:   thresholds,
+	}
+	results := make([]*SpeedTestResult, 0, len(uploads))
+	for tester, upload := range uploads {
+		result := testerResult(tester, upload, 50.0, 0.0)
+		round.Results[tester] = result
+		results = append(results, result)
+	}
+	statement, _, err := BuildPoBAggregation(id, results, thresholds)
+	if err != nil {
+		t.Fatalf("BuildPoBAggregation failed: %v", err)
+	}
+	round.Aggregation = statement
+	return round
+}
+
+// TestCollusionDetection tests flagging testers that keep favoring a candidate or an ASN
+func TestCollusionDetection(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	for _, id := range []string{"friend", "honest1", "honest2", "c1", "c2", "c3", "c4", "c5", "c6"} {
+		_, info := createTestValidator(id)
+		state.UpdateValidator(info)
+	}
+	for _, id := range []string{"c2", "c3", "c4", "c5", "c6"} {
+		info, _ := state.GetValidator(id)
+		info.NetworkASN = "AS64500"
+		state.UpdateValidator(info)
+	}
+
+	cd := NewCollusionDetector(db, state)
+	detector := NewByzantineDetector()
+	cd.SetByzantineDetector(detector)
+
+	// Only completed rounds are profiled; a proving round may still fail
+	proving := completedRound(t, "proving", "c1", map[string]float64{"friend": 14.0, "honest1": 8.0, "honest2": 9.0})
+	proving.Status = PoBRoundProving
+	cd.AnalyzeRound(proving)
+	if cd.GetProfile("friend") != nil {
+		t.Fatalf("Proving round should not reach the tester profiles")
+	}
+
+	// "friend" reports well above the committee median every time it tests c1
+	uploads := map[string]float64{"friend": 14.0, "honest1": 8.0, "honest2": 9.0}
+	for i := 0; i < collusionMinCandidateTests; i++ {
+		flags := cd.AnalyzeRound(completedRound(t, fmt.Sprintf("c1-%d", i), "c1", uploads))
+		if last := i == collusionMinCandidateTests-1; last != (len(flags) == 1) {
+			t.Fatalf("Expected a flag only after %d favoring results, got %d after %d", collusionMinCandidateTests, len(flags), i+1)
+		}
+		if len(flags) == 1 && (flags[0].TesterID != "friend" || flags[0].Pattern != CollusionFavorsCandidate) {
+			t.Fatalf("Expected friend flagged for favoring c1, got %s for %s", flags[0].TesterID, flags[0].Pattern)
+		}
+	}
+
+	info, _ := state.GetValidator("friend")
+	if info.Reputation != core.InitialReputation-collusionReputationPenalty {
+		t.Errorf("Flagged tester should lose reputation, got %d", info.Reputation)
+	}
+	if honest, _ := state.GetValidator("honest1"); honest.Reputation != core.InitialReputation {
+		t.Errorf("Honest tester should keep its reputation, got %d", honest.Reputation)
+	}
+	if evidence := detector.GetByzantineEvidence("friend"); len(evidence) != 1 || evidence[0].EvidenceType != "tester_collusion" {
+		t.Errorf("Flagged tester should be reported to the Byzantine detector")
+	}
+
+	// Favoring different candidates of one ASN is caught by ASN
+	var asnFlag *CollusionFlag
+	for i, candidate := range []string{"c2", "c3", "c4", "c5", "c6"} {
+		for _, flag := range cd.AnalyzeRound(completedRound(t, fmt.Sprintf("asn-%d", i), candidate, uploads)) {
+			if flag.Pattern == CollusionFavorsASN {
+				asnFlag = flag
+			}
+		}
+	}
+	if asnFlag == nil || asnFlag.TesterID != "friend" || asnFlag.Subject != "AS64500" {
+		t.Fatalf("Expected friend flagged for favoring AS64500, got %+v", asnFlag)
+	}
+
+	// Profiles persist across restarts
+	if profile := NewCollusionDetector(db, state).GetProfile("friend"); profile == nil || len(profile.Flags) < 2 {
+		t.Errorf("Tester profile with its flags should be reloaded from the database")
+	}
+}
+
+// TestWeightedTestCommittee tests that low reputation testers are selected less often
+func TestWeightedTestCommittee(t *testing.T) {
+	pool := []string{"t1", "t2", "t3", "t4", "t5", "t6"}
+	weights := map[string]int{"t1": 100, "t2": 100, "t3": 100, "t4": 100, "t5": 100, "t6": 20}
+
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		seed := []byte(fmt.Sprintf("seed-%d", i))
+		committee := SelectWeightedTestCommittee("candidate", seed, pool, weights, CommitteeSize)
+		if len(committee) != CommitteeSize {
+			t.Fatalf("Expected %d testers, got %d", CommitteeSize, len(committee))
+		}
+		for _, tester := range committee {
+			counts[tester]++
+		}
+
+		again := SelectWeightedTestCommittee("candidate", seed, pool, weights, CommitteeSize)
+		for j := range committee {
+			if committee[j] != again[j] {
+				t.Fatalf("Weighted committee selection is not deterministic")
+			}
+		}
+	}
+
+	average := 300 * CommitteeSize / len(pool)
+	if counts["t6"] >= average/2 {
+		t.Errorf("Low reputation tester selected %d times, expected well under the average %d", counts["t6"], average)
+	}
+}
+
+// TestCollusionIgnoresNearbyTesterLatency tests that a tester measuring low
+// latency because it is close to the candidate is not flagged
+func TestCollusionIgnoresNearbyTesterLatency(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	for _, id := range []string{"near", "far1", "far2", "candidate"} {
+		_, info := createTestValidator(id)
+		state.UpdateValidator(info)
+	}
+
+	cd := NewCollusionDetector(db, state)
+	uploads := map[string]float64{"near": 9.0, "far1": 8.0, "far2": 9.5}
+	for i := 0; i < collusionWindow; i++ {
+		round := completedRound(t, fmt.Sprintf("near-%d", i), "candidate", uploads)
+		round.Results["near"].Latency = 5.0
+		if flags := cd.AnalyzeRound(round); len(flags) != 0 {
+			t.Fatalf("Tester with honest upload flagged for %s after %d rounds", flags[0].Pattern, i+1)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// PoB disputes: every result in a PoB round is a raw measurement record
+// signed by its tester. Until core.PoBDisputeWindowBlocks after the round
+// closes, any validator may challenge a record with evidence every node can
+// check against it. An upheld dispute drops the record from the round's
+// aggregation and is reported to the ByzantineDetector.
+
+// PoBDisputeKind names the contradiction a dispute proves.
+type PoBDisputeKind string
+
+const (
+	// Another record for the same session, also signed by the accused
+	DisputeConflictingRecord PoBDisputeKind = "conflicting_record"
+	// Payload marked verified but the chunk timestamps fail VerifyChunkSequence
+	DisputeChunkSequence PoBDisputeKind = "chunk_sequence"
+	// Claimed upload above what the record's own chunk timestamps allow
+	DisputeInflatedBandwidth PoBDisputeKind = "inflated_bandwidth"
+	// Payload marked verified but the Merkle root is not the round payload's
+	DisputePayloadRoot PoBDisputeKind = "payload_root"
+)
+
+// inflatedBandwidthTolerance is the margin over the chunk-derived upload
+// before a claim counts as inflated
+const inflatedBandwidthTolerance = 1.10
+
+// PoBDispute challenges the record Accused submitted in RoundID.
+type PoBDispute struct {
+	Accused     string           `json:"accused"`
+	Kind        PoBDisputeKind   `json:"kind"`
+	Conflicting *SpeedTestResult `json:"conflicting,omitempty"` // for DisputeConflictingRecord
+}
+
+// CheckPoBDispute checks dispute against the record it challenges in round.
+// Returns nil if the evidence holds.
+func CheckPoBDispute(round *PoBRound, record *SpeedTestResult, dispute *PoBDispute, accusedKey []byte) error {
+	switch dispute.Kind {
+	case DisputeConflictingRecord:
+		other := dispute.Conflicting
+		if other == nil {
+			return fmt.Errorf("missing conflicting record")
+		}
+		if other.SessionID != record.SessionID || other.TesterID != record.TesterID {
+			return fmt.Errorf("conflicting record is for another session or tester")
+		}
+		if bytes.Equal(other.signingHash(), record.signingHash()) {
+			return fmt.Errorf("records do not conflict")
+		}
+		publicKey, err := DecodeECDSAPublicKey(accusedKey)
+		if err != nil {
+			return fmt.Errorf("invalid public key for %s: %w", shortValidatorID(record.TesterID), err)
+		}
+		if !other.VerifySignature(publicKey) {
+			return fmt.Errorf("conflicting record is not signed by %s", shortValidatorID(record.TesterID))
+		}
+		return nil
+
+	case DisputeChunkSequence:
+		if !record.PayloadVerified {
+			return fmt.Errorf("record does not claim a verified payload")
+		}
+		if valid, _ := VerifyChunkSequence(record.ChunkTimestamps); valid {
+			return fmt.Errorf("chunk sequence is valid")
+		}
+		return nil
+
+	case DisputeInflatedBandwidth:
+		if len(record.ChunkTimestamps) < 2 {
+			if record.UploadBandwidth > 0 {
+				return nil // a bandwidth claim with nothing timed to back it
+			}
+			return fmt.Errorf("record claims no bandwidth")
+		}
+		span := record.ChunkTimestamps[len(record.ChunkTimestamps)-1].Sub(record.ChunkTimestamps[0])
+		if span <= 0 {
+			return fmt.Errorf("chunk timestamps span no time to derive a bandwidth from")
+		}
+		maxUpload := float64(record.ReceivedChunks*ChunkSize) / (1024 * 1024) / span.Seconds()
+		if record.UploadBandwidth <= maxUpload*inflatedBandwidthTolerance {
+			return fmt.Errorf("claimed %.2f MB/s is within the %.2f MB/s the chunk timestamps allow",
+				record.UploadBandwidth, maxUpload)
+		}
+		return nil
+
+	case DisputePayloadRoot:
+		if !record.PayloadVerified {
+			return fmt.Errorf("record does not claim a verified payload")
+		}
+		_, _, expectedRoot, err := round.PayloadGenerator(record.TesterID).GeneratePayload()
+		if err != nil {
+			return fmt.Errorf("failed to regenerate round payload: %w", err)
+		}
+		if record.MerkleRoot == expectedRoot {
+			return fmt.Errorf("Merkle root matches the round payload")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown dispute kind: %s", dispute.Kind)
+	}
+}
+
+// validateDispute checks a dispute tx sent by challenger and returns the
+// round it targets.
+func (rm *PoBRoundManager) validateDispute(challenger string, payload *PoBTx, blockHeight uint64) (*PoBRound, error) {
+	dispute := payload.Dispute
+	if dispute == nil {
+		return nil, fmt.Errorf("missing dispute")
+	}
+	if info, err := rm.state.GetValidator(challenger); err != nil || info == nil {
+		return nil, fmt.Errorf("unknown validator: %s", challenger)
+	}
+	if challenger == dispute.Accused {
+		return nil, fmt.Errorf("validator cannot dispute its own record")
+	}
+
+	round, ok := rm.rounds[payload.RoundID]
+	if !ok {
+		return nil, fmt.Errorf("unknown PoB round: %s", payload.RoundID)
+	}
+	if round.Status != PoBRoundTesting && blockHeight > round.ClosedHeight+core.PoBDisputeWindowBlocks {
+		return nil, fmt.Errorf("dispute window for round %s closed at block #%d",
+			round.ID, round.ClosedHeight+core.PoBDisputeWindowBlocks)
+	}
+
+	record, ok := round.Results[dispute.Accused]
+	if !ok {
+		return nil, fmt.Errorf("%s has no record in round %s", shortValidatorID(dispute.Accused), round.ID)
+	}
+	if kind, disputed := round.Disputed[dispute.Accused]; disputed {
+		return nil, fmt.Errorf("record of %s already disputed (%s)", shortValidatorID(dispute.Accused), kind)
+	}
+
+	info, err := rm.state.GetValidator(dispute.Accused)
+	if err != nil || info == nil {
+		return nil, fmt.Errorf("unknown validator: %s", dispute.Accused)
+	}
+	if err := CheckPoBDispute(round, record, dispute, info.PublicKey); err != nil {
+		return nil, fmt.Errorf("dispute rejected: %w", err)
+	}
+
+	return round, nil
+}
+
+// applyDispute upholds a validated dispute: the record leaves the round's
+// aggregation and the accused is reported for speed test cheating.
+// Caller holds rm.mu.
+func (rm *PoBRoundManager) applyDispute(challenger string, payload *PoBTx, block *core.Block) {
+	dispute := payload.Dispute
+	round := rm.rounds[payload.RoundID]
+	record := round.Results[dispute.Accused]
+
+	if round.Disputed == nil {
+		round.Disputed = make(map[string]PoBDisputeKind)
+	}
+	round.Disputed[dispute.Accused] = dispute.Kind
+
+	log.Printf("⚖️  PoB dispute upheld: %s's record in round %s (%s, raised by %s)",
+		shortValidatorID(dispute.Accused), round.ID, dispute.Kind, shortValidatorID(challenger))
+
+	if rm.byzantineDetector != nil {
+		evidence, _ := json.Marshal(&speedTestCheatEvidence{RoundID: round.ID, Record: record, Dispute: dispute})
+		if err := rm.byzantineDetector.DetectSpeedTestCheat(dispute.Accused, string(dispute.Kind), evidence); err != nil {
+			log.Printf("🚨 %v", err)
+		}
+	}
+
+	// A completed round is re-aggregated without the disputed record, and
+	// proven again. Until the new aggregation completes, the candidate has
+	// no score: the one it had rests on the disputed record.
+	if round.Status == PoBRoundCompleted || round.Status == PoBRoundProving {
+		rm.closeRound(round, block.Header.Height, block.Header.Timestamp)
+		if round.Status != PoBRoundCompleted {
+			if info, err := rm.state.GetValidator(round.CandidateID); err == nil && info != nil {
+				info.PoBScore = 0
+				rm.state.UpdateValidator(info)
+			}
+		}
+	}
+	rm.saveRound(round)
+}
+
+// PruneExpiredRounds drops closed rounds whose dispute window ended before
+// height. Returns the number pruned.
+func (rm *PoBRoundManager) PruneExpiredRounds(height uint64) int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	ids := make([]string, 0)
+	for id, round := range rm.rounds {
+		if !round.IsOpen() && height > round.ClosedHeight+core.PoBDisputeWindowBlocks {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		delete(rm.rounds, id)
+		rm.db.Delete([]byte("pob_round_"+id), nil)
+	}
+	return len(ids)
+}
@@ -0,0 +1,176 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// timedResult is a signed record with a full, evenly spaced chunk sequence
+func timedResult(t *testing.T, round *PoBRound, tester string, key *ecdsa.PrivateKey, upload float64) *SpeedTestResult {
+	start := time.Now()
+	result := &SpeedTestResult{
+		TesterID:        tester,
+		CandidateID:     round.CandidateID,
+		SessionID:       round.ID,
+		UploadBandwidth: upload,
+		Latency:         40.0,
+		ReceivedChunks:  NumChunks,
+		PayloadVerified: true,
+		Timestamp:       start,
+	}
+	for i := 0; i < NumChunks; i++ {
+		result.ChunkTimestamps = append(result.ChunkTimestamps, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	if err := result.Sign(key); err != nil {
+		t.Fatalf("Failed to sign result: %v", err)
+	}
+	return result
+}
+
+// TestPoBDispute tests challenging signed measurement records within the dispute window
+func TestPoBDispute(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+
+	rm := NewPoBRoundManager(db, state)
+	detector := NewByzantineDetector()
+	rm.SetByzantineDetector(detector)
+	collusion := NewCollusionDetector(db, state)
+	rm.SetCollusionDetector(collusion)
+	thresholds := &PoBThresholds{MinUploadBandwidth: 4.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+
+	block := &core.Block{Header: &core.BlockHeader{Height: 10, VRFOutput: []byte("vrf-output-10"), Timestamp: time.Now()}}
+	round, err := rm.ApplyPoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxRequest}), block)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	// 16 chunks of 512 KB over 1.5s allow at most ~5.3 MB/s
+	liar := round.Committee[0]
+	uploads := map[string]float64{liar: 50.0}
+	for i, upload := range []float64{5.0, 5.2, 4.9, 5.1, 5.3, 5.0, 5.2} {
+		uploads[round.Committee[i+1]] = upload
+	}
+	for _, tester := range round.Committee {
+		result := timedResult(t, round, tester, keys[tester], uploads[tester])
+		if _, err := rm.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}), block); err != nil {
+			t.Fatalf("Result from %s rejected: %v", tester, err)
+		}
+	}
+	testedAt := time.Now()
+	rm.ProcessRounds(11, testedAt, thresholds)
+	if round.Status != PoBRoundCompleted || round.ClosedHeight != 11 {
+		t.Fatalf("Expected round completed at #11, got %s at #%d", round.Status, round.ClosedHeight)
+	}
+
+	challenger := ""
+	for id := range keys {
+		if id != "1" && !round.IsCommitteeMember(id) {
+			challenger = id
+		}
+	}
+	dispute := func(accused string, kind PoBDisputeKind) *core.Transaction {
+		return pobTx(t, challenger, &PoBTx{Type: PoBTxDispute, RoundID: round.ID, Dispute: &PoBDispute{Accused: accused, Kind: kind}})
+	}
+
+	honest := round.Committee[1]
+	if err := rm.ValidatePoBTx(dispute(honest, DisputeInflatedBandwidth), 12); err == nil {
+		t.Errorf("Dispute of a consistent record should be rejected")
+	}
+	if err := rm.ValidatePoBTx(dispute(honest, DisputeChunkSequence), 12); err == nil {
+		t.Errorf("Dispute of a valid chunk sequence should be rejected")
+	}
+	self := pobTx(t, liar, &PoBTx{Type: PoBTxDispute, RoundID: round.ID, Dispute: &PoBDispute{Accused: liar, Kind: DisputeInflatedBandwidth}})
+	if err := rm.ValidatePoBTx(self, 12); err == nil {
+		t.Errorf("Validator disputing its own record should be rejected")
+	}
+
+	next := &core.Block{Header: &core.BlockHeader{Height: 12, Timestamp: testedAt.Add(time.Hour)}}
+	if _, err := rm.ApplyPoBTx(dispute(liar, DisputeInflatedBandwidth), next); err != nil {
+		t.Fatalf("Inflated bandwidth dispute should be upheld: %v", err)
+	}
+	if round.Disputed[liar] != DisputeInflatedBandwidth {
+		t.Errorf("Record of %s should be marked disputed", liar)
+	}
+	if evidence := detector.GetByzantineEvidence(liar); len(evidence) != 1 {
+		t.Errorf("Upheld dispute should be reported to the Byzantine detector, got %d entries", len(evidence))
+	}
+	if _, err := rm.ApplyPoBTx(dispute(liar, DisputeInflatedBandwidth), next); err == nil {
+		t.Errorf("Record already disputed should be rejected")
+	}
+
+	// Re-aggregated without the inflated record
+	info, _ := state.GetValidator("1")
+	if round.Status != PoBRoundCompleted || info.UploadBandwidth != 5.1 {
+		t.Errorf("Expected re-aggregated median 5.1, got %v (%s)", info.UploadBandwidth, round.Status)
+	}
+	if !info.LastPoBTest.Equal(testedAt) {
+		t.Errorf("Re-aggregation should keep the test time %v, got %v", testedAt, info.LastPoBTest)
+	}
+	if profile := collusion.GetProfile(honest); profile == nil || len(profile.Deviations) != 1 {
+		t.Errorf("Re-aggregated round should reach the tester profiles once, got %+v", profile)
+	}
+
+	// Chunk timestamps spanning no time prove nothing about the bandwidth
+	instant := timedResult(t, round, honest, keys[honest], 50.0)
+	for i := range instant.ChunkTimestamps {
+		instant.ChunkTimestamps[i] = instant.ChunkTimestamps[0]
+	}
+	if err := CheckPoBDispute(round, instant, &PoBDispute{Accused: honest, Kind: DisputeInflatedBandwidth}, nil); err == nil {
+		t.Errorf("Inflated bandwidth dispute without a timed span should be rejected")
+	}
+
+	// A second record signed for the same session is equivocation
+	conflicting := timedResult(t, round, honest, keys[honest], 6.0)
+	equivocation := pobTx(t, challenger, &PoBTx{Type: PoBTxDispute, RoundID: round.ID,
+		Dispute: &PoBDispute{Accused: honest, Kind: DisputeConflictingRecord, Conflicting: round.Results[honest]}})
+	if err := rm.ValidatePoBTx(equivocation, 12); err == nil {
+		t.Errorf("Identical record should not count as conflicting")
+	}
+	equivocation = pobTx(t, challenger, &PoBTx{Type: PoBTxDispute, RoundID: round.ID,
+		Dispute: &PoBDispute{Accused: honest, Kind: DisputeConflictingRecord, Conflicting: conflicting}})
+	if err := rm.ValidatePoBTx(equivocation, 12); err != nil {
+		t.Errorf("Conflicting signed record should be upheld: %v", err)
+	}
+
+	// With a proof system the re-aggregation waits for a new proof, and the
+	// candidate has no score until it arrives
+	rm.SetZKProofSystem(&ZKProofSystem{})
+	if _, err := rm.ApplyPoBTx(equivocation, next); err != nil {
+		t.Fatalf("Conflicting record dispute should be upheld: %v", err)
+	}
+	info, _ = state.GetValidator("1")
+	if round.Status != PoBRoundProving || info.PoBScore != 0 {
+		t.Errorf("Expected round proving and score cleared, got %s with score %v", round.Status, info.PoBScore)
+	}
+	rm.ProcessRounds(round.ProofDeadline+1, testedAt, thresholds)
+	info, _ = state.GetValidator("1")
+	if round.Status != PoBRoundFailed || info.PoBScore != 0 {
+		t.Errorf("Expected unproven round failed and score cleared, got %s with score %v", round.Status, info.PoBScore)
+	}
+
+	// The window closes, then the round is pruned
+	if err := rm.ValidatePoBTx(equivocation, 11+core.PoBDisputeWindowBlocks+1); err == nil {
+		t.Errorf("Dispute after the window should be rejected")
+	}
+	if pruned := rm.PruneExpiredRounds(11 + core.PoBDisputeWindowBlocks); pruned != 0 {
+		t.Errorf("Round pruned inside its dispute window")
+	}
+	if pruned := rm.PruneExpiredRounds(11 + core.PoBDisputeWindowBlocks + 1); pruned != 1 || rm.GetRound(round.ID) != nil {
+		t.Errorf("Round should be pruned after its dispute window")
+	}
+	if NewPoBRoundManager(db, state).GetRound(round.ID) != nil {
+		t.Errorf("Pruned round should be deleted from the database")
+	}
+}
@@ -1,15 +1,33 @@
 package consensus
 
 import (
+        "encoding/hex"
+        "fmt"
         "log"
         "rnr-blockchain/pkg/core"
-        "time"
+        "sync"
 )
 
 // PoBRetargetManager handles difficulty adjustment every 50 blocks (Whitepaper Bab 9.1-9.2)
+//
+// Retargeting is consensus-critical, so the thresholds are derived from the
+// chain alone: a block inherits the thresholds committed in its parent's
+// header, and at every window boundary they are adjusted by the average
+// number of validators that signed the commits of the previous window, in
+// integer milli-units. Parent and window are the block's own ancestors,
+// walked back by PrevBlockHash, so a fork block is judged by its fork. Every node holding the same chain reaches the same
+// thresholds, whatever it observed locally, and a block committing other
+// thresholds is rejected (see VerifyHeaderThresholds).
 type PoBRetargetManager struct {
-        currentThresholds *PoBThresholds
-        validatorHistory  []int // Rolling window of validator counts (50 blocks)
+        chain    RetargetChain
+        adjusted map[string]*PoBThresholds // Boundary thresholds by parent block hash
+        mu       sync.Mutex
+}
+
+// RetargetChain is the chain PoB thresholds are derived from
+type RetargetChain interface {
+        GetBlockByHash(hash []byte) (*core.Block, error)
+        GetLatestBlock() *core.Block
 }
 
 // PoBThresholds stores current difficulty thresholds
@@ -17,8 +35,6 @@ type PoBThresholds struct {
         MinUploadBandwidth float64 // MB/s
         TargetLatency      float64 // ms
         TargetPacketLoss   float64 // %
-        LastAdjustHeight   uint64
-        LastAdjustTime     time.Time
 }
 
 const (
@@ -26,49 +42,108 @@ const (
         MaxAdjustmentFactor = 0.20 // Whitepaper: ±20% max adjustment
 )
 
-func NewPoBRetargetManager() *PoBRetargetManager {
+// Threshold bounds in milli-units (MB/s, ms, %)
+const (
+        minUploadMilli     = 5000
+        maxUploadMilli     = 10000
+        minLatencyMilli    = 50000
+        maxLatencyMilli    = 200000
+        minPacketLossMilli = 50
+        maxPacketLossMilli = 500
+)
+
+// NewPoBRetargetManager creates a retarget manager deriving thresholds from chain
+func NewPoBRetargetManager(chain RetargetChain) *PoBRetargetManager {
         return &PoBRetargetManager{
-                currentThresholds: &PoBThresholds{
-                        MinUploadBandwidth: core.MinUploadBandwidth,
-                        TargetLatency:      core.TargetLatency,
-                        TargetPacketLoss:   core.TargetPacketLoss,
-                        LastAdjustHeight:   0,
-                        LastAdjustTime:     time.Now(),
-                },
-                validatorHistory: make([]int, 0, RetargetWindow),
+                chain:    chain,
+                adjusted: make(map[string]*PoBThresholds),
         }
 }
 
-// RecordValidatorCount adds current validator count to rolling window (Whitepaper Bab 9.1)
-// "protokol akan mengevaluasi jumlah rata-rata validator aktif dalam window 50 blok"
-func (prm *PoBRetargetManager) RecordValidatorCount(validatorCount int) {
-        prm.validatorHistory = append(prm.validatorHistory, validatorCount)
-        
-        // Keep only last 50 blocks
-        if len(prm.validatorHistory) > RetargetWindow {
-                prm.validatorHistory = prm.validatorHistory[len(prm.validatorHistory)-RetargetWindow:]
+// defaultThresholds returns the genesis thresholds
+func defaultThresholds() *PoBThresholds {
+        return thresholdsFromMilli(
+                toMilliUnits(core.MinUploadBandwidth),
+                toMilliUnits(core.TargetLatency),
+                toMilliUnits(core.TargetPacketLoss),
+        )
+}
+
+// thresholdsFromMilli builds thresholds from milli-unit values
+func thresholdsFromMilli(upload, latency, packetLoss int64) *PoBThresholds {
+        return &PoBThresholds{
+                MinUploadBandwidth: float64(upload) / 1000,
+                TargetLatency:      float64(latency) / 1000,
+                TargetPacketLoss:   float64(packetLoss) / 1000,
+        }
+}
+
+// ThresholdsAt returns the thresholds the block with header must commit:
+// those of its parent header.PrevBlockHash, adjusted when header.Height is a
+// window boundary
+func (prm *PoBRetargetManager) ThresholdsAt(header *core.BlockHeader) (*PoBThresholds, error) {
+        if header.Height == 0 {
+                return defaultThresholds(), nil
+        }
+        parent, err := prm.chain.GetBlockByHash(header.PrevBlockHash)
+        if err != nil {
+                return nil, fmt.Errorf("parent of block #%d unavailable: %w", header.Height, err)
+        }
+        if parent.Header.Height+1 != header.Height {
+                return nil, fmt.Errorf("parent of block #%d is at height %d", header.Height, parent.Header.Height)
         }
-        
-        // Log setiap 10 blocks untuk monitoring (bukan setiap block agar tidak spam)
-        if len(prm.validatorHistory)%10 == 0 {
-                avgCount := prm.calculateAverageValidatorCount()
-                log.Printf("📈 Validator History: %d blocks recorded, rolling avg: %d validators", 
-                        len(prm.validatorHistory), avgCount)
+        // Genesis commits no thresholds
+        base := HeaderThresholds(parent.Header)
+        if base == nil {
+                base = defaultThresholds()
         }
+        if !prm.ShouldRetarget(header.Height) {
+                return base, nil
+        }
+
+        key := hex.EncodeToString(header.PrevBlockHash)
+
+        prm.mu.Lock()
+        defer prm.mu.Unlock()
+        if thresholds, ok := prm.adjusted[key]; ok {
+                return thresholds, nil
+        }
+        avgValidatorCount, err := prm.windowAverageValidatorCount(parent)
+        if err != nil {
+                return nil, err
+        }
+        thresholds := adjustThresholds(base, avgValidatorCount, header.Height)
+        prm.adjusted[key] = thresholds
+        return thresholds, nil
 }
 
-// calculateAverageValidatorCount computes mean over last 50 blocks (Whitepaper Bab 9.1)
-func (prm *PoBRetargetManager) calculateAverageValidatorCount() int {
-        if len(prm.validatorHistory) == 0 {
-                return 0
+// windowAverageValidatorCount computes the mean validator count over the 50
+// blocks up to and including parent (Whitepaper Bab 9.1)
+// "protokol akan mengevaluasi jumlah rata-rata validator aktif dalam window 50 blok"
+// A block's active validators are counted as the signers of its LastCommit,
+// which every node reads from the chain. Blocks without a commit (genesis and
+// its child) are not counted.
+func (prm *PoBRetargetManager) windowAverageValidatorCount(parent *core.Block) (int, error) {
+        sum, blocks := 0, 0
+        block := parent
+        for i := 0; i < RetargetWindow; i++ {
+                if len(block.LastCommit) > 0 {
+                        sum += len(block.LastCommit)
+                        blocks++
+                }
+                if block.Header.Height == 0 || i == RetargetWindow-1 {
+                        break
+                }
+                prev, err := prm.chain.GetBlockByHash(block.Header.PrevBlockHash)
+                if err != nil {
+                        return 0, fmt.Errorf("retarget window block #%d unavailable: %w", block.Header.Height-1, err)
+                }
+                block = prev
         }
-        
-        sum := 0
-        for _, count := range prm.validatorHistory {
-                sum += count
+        if blocks == 0 {
+                return 0, nil
         }
-        
-        return sum / len(prm.validatorHistory)
+        return sum / blocks, nil
 }
 
 // ShouldRetarget checks if we're at a retarget window
@@ -76,19 +151,12 @@ func (prm *PoBRetargetManager) ShouldRetarget(blockHeight uint64) bool {
         return blockHeight > 0 && blockHeight%RetargetWindow == 0
 }
 
-// AdjustDifficulty adjusts PoB thresholds based on validator count (Whitepaper Bab 9.2)
-func (prm *PoBRetargetManager) AdjustDifficulty(blockHeight uint64) error {
-        if !prm.ShouldRetarget(blockHeight) {
-                return nil
-        }
-
-        // Whitepaper Bab 9.1: "protokol akan mengevaluasi jumlah rata-rata validator aktif dalam window 50 blok"
-        // MUST use rolling 50-block average, not instantaneous count
-        avgValidatorCount := prm.calculateAverageValidatorCount()
-        
+// adjustThresholds adjusts PoB thresholds based on validator count (Whitepaper Bab 9.2)
+// at the window boundary blockHeight
+func adjustThresholds(base *PoBThresholds, avgValidatorCount int, blockHeight uint64) *PoBThresholds {
         if avgValidatorCount == 0 {
                 log.Printf("⚠️  PoB Retarget (Block %d): No validator history, skipping adjustment", blockHeight)
-                return nil
+                return base
         }
 
         // Whitepaper: "Difficulty akan disesuaikan untuk menjaga validator count dalam rentang sehat"
@@ -99,55 +167,43 @@ func (prm *PoBRetargetManager) AdjustDifficulty(blockHeight uint64) error {
         )
 
         adjustmentFactor := 0.0
+        adjustmentMilli := int64(0)
 
         if avgValidatorCount < targetMinValidators {
                 // Too few validators: LOOSEN requirements (decrease difficulty)
                 adjustmentFactor = -MaxAdjustmentFactor
+                adjustmentMilli = -toMilliUnits(MaxAdjustmentFactor)
                 log.Printf("📉 PoB Retarget (Block %d): Too few validators (%d < %d), LOOSENING difficulty by %.0f%%",
                         blockHeight, avgValidatorCount, targetMinValidators, MaxAdjustmentFactor*100)
         } else if avgValidatorCount > targetMaxValidators {
                 // Too many validators: TIGHTEN requirements (increase difficulty)
                 adjustmentFactor = MaxAdjustmentFactor
+                adjustmentMilli = toMilliUnits(MaxAdjustmentFactor)
                 log.Printf("📈 PoB Retarget (Block %d): Too many validators (%d > %d), TIGHTENING difficulty by %.0f%%",
                         blockHeight, avgValidatorCount, targetMaxValidators, MaxAdjustmentFactor*100)
         } else {
                 log.Printf("✅ PoB Retarget (Block %d): Validator count healthy (%d), no adjustment needed",
                         blockHeight, avgValidatorCount)
-                return nil
+                return base
         }
 
         // Apply bounded adjustment (±20%)
-        oldUpload := prm.currentThresholds.MinUploadBandwidth
-        oldLatency := prm.currentThresholds.TargetLatency
-        oldPacketLoss := prm.currentThresholds.TargetPacketLoss
+        oldUpload := base.MinUploadBandwidth
+        oldLatency := base.TargetLatency
+        oldPacketLoss := base.TargetPacketLoss
 
-        // Adjust thresholds
-        prm.currentThresholds.MinUploadBandwidth = oldUpload * (1.0 + adjustmentFactor)
-        prm.currentThresholds.TargetLatency = oldLatency * (1.0 - adjustmentFactor) // Lower latency = harder
-        prm.currentThresholds.TargetPacketLoss = oldPacketLoss * (1.0 - adjustmentFactor) // Lower loss = harder
+        // Adjust thresholds in integer milli-units so every node computes the same values
+        upload := toMilliUnits(oldUpload) * (1000 + adjustmentMilli) / 1000
+        latency := toMilliUnits(oldLatency) * (1000 - adjustmentMilli) / 1000       // Lower latency = harder
+        packetLoss := toMilliUnits(oldPacketLoss) * (1000 - adjustmentMilli) / 1000 // Lower loss = harder
 
         // Bounds checking: prevent extreme values
-        if prm.currentThresholds.MinUploadBandwidth < 5.0 {
-                prm.currentThresholds.MinUploadBandwidth = 5.0
-        }
-        if prm.currentThresholds.MinUploadBandwidth > 10.0 {
-                prm.currentThresholds.MinUploadBandwidth = 10.0
-        }
-        if prm.currentThresholds.TargetLatency < 50.0 {
-                prm.currentThresholds.TargetLatency = 50.0
-        }
-        if prm.currentThresholds.TargetLatency > 200.0 {
-                prm.currentThresholds.TargetLatency = 200.0
-        }
-        if prm.currentThresholds.TargetPacketLoss < 0.05 {
-                prm.currentThresholds.TargetPacketLoss = 0.05
-        }
-        if prm.currentThresholds.TargetPacketLoss > 0.5 {
-                prm.currentThresholds.TargetPacketLoss = 0.5
-        }
+        upload = clampMilli(upload, minUploadMilli, maxUploadMilli)
+        latency = clampMilli(latency, minLatencyMilli, maxLatencyMilli)
+        packetLoss = clampMilli(packetLoss, minPacketLossMilli, maxPacketLossMilli)
 
-        prm.currentThresholds.LastAdjustHeight = blockHeight
-        prm.currentThresholds.LastAdjustTime = time.Now()
+        // Always a new value: rounds keep the thresholds they closed with
+        adjusted := thresholdsFromMilli(upload, latency, packetLoss)
 
         // Whitepaper Bab 9.1-9.2: Enhanced telemetry untuk monitoring retargeting behavior
         log.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
@@ -161,28 +217,96 @@ func (prm *PoBRetargetManager) AdjustDifficulty(blockHeight uint64) error {
                 map[float64]string{-MaxAdjustmentFactor: "LOOSEN", MaxAdjustmentFactor: "TIGHTEN", 0: "STABLE"}[adjustmentFactor])
         log.Printf("📈 Threshold Changes:")
         log.Printf("   Upload:      %.2f → %.2f MB/s (%.1f%% change)", 
-                oldUpload, prm.currentThresholds.MinUploadBandwidth, 
-                ((prm.currentThresholds.MinUploadBandwidth-oldUpload)/oldUpload)*100)
+                oldUpload, adjusted.MinUploadBandwidth, 
+                ((adjusted.MinUploadBandwidth-oldUpload)/oldUpload)*100)
         log.Printf("   Latency:     %.0f → %.0f ms (%.1f%% change)", 
-                oldLatency, prm.currentThresholds.TargetLatency,
-                ((prm.currentThresholds.TargetLatency-oldLatency)/oldLatency)*100)
+                oldLatency, adjusted.TargetLatency,
+                ((adjusted.TargetLatency-oldLatency)/oldLatency)*100)
         log.Printf("   Packet Loss: %.3f → %.3f%% (%.1f%% change)", 
-                oldPacketLoss, prm.currentThresholds.TargetPacketLoss,
-                ((prm.currentThresholds.TargetPacketLoss-oldPacketLoss)/oldPacketLoss)*100)
+                oldPacketLoss, adjusted.TargetPacketLoss,
+                ((adjusted.TargetPacketLoss-oldPacketLoss)/oldPacketLoss)*100)
         log.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
-        return nil
+        return adjusted
+}
+
+func clampMilli(v, min, max int64) int64 {
+        if v < min {
+                return min
+        }
+        if v > max {
+                return max
+        }
+        return v
 }
 
-// GetCurrentThresholds returns current difficulty thresholds
+// GetCurrentThresholds returns the thresholds of the next block on the tip,
+// or the genesis thresholds if they cannot be derived
 func (prm *PoBRetargetManager) GetCurrentThresholds() *PoBThresholds {
-        return prm.currentThresholds
+        tip := prm.chain.GetLatestBlock()
+        if tip == nil {
+                return defaultThresholds()
+        }
+        tipHash, err := tip.Hash()
+        if err != nil {
+                log.Printf("⚠️  Failed to derive PoB thresholds: %v", err)
+                return defaultThresholds()
+        }
+        thresholds, err := prm.ThresholdsAt(&core.BlockHeader{Height: tip.Header.Height + 1, PrevBlockHash: tipHash})
+        if err != nil {
+                log.Printf("⚠️  Failed to derive PoB thresholds: %v", err)
+                return defaultThresholds()
+        }
+        return thresholds
+}
+
+// CommitThresholds writes the thresholds of header's height and parent into
+// header. The proposer commits the thresholds its block's PoB results are
+// evaluated against.
+func (prm *PoBRetargetManager) CommitThresholds(header *core.BlockHeader) error {
+        thresholds, err := prm.ThresholdsAt(header)
+        if err != nil {
+                return err
+        }
+        header.PoBMinUpload = toMilliUnits(thresholds.MinUploadBandwidth)
+        header.PoBMaxLatency = toMilliUnits(thresholds.TargetLatency)
+        header.PoBMaxPacketLoss = toMilliUnits(thresholds.TargetPacketLoss)
+        return nil
+}
+
+// VerifyHeaderThresholds checks that header commits the thresholds derived
+// from its ancestors
+func (prm *PoBRetargetManager) VerifyHeaderThresholds(header *core.BlockHeader) error {
+        expected := &core.BlockHeader{Height: header.Height, PrevBlockHash: header.PrevBlockHash}
+        if err := prm.CommitThresholds(expected); err != nil {
+                return fmt.Errorf("cannot derive PoB thresholds: %w", err)
+        }
+        if header.PoBMinUpload != expected.PoBMinUpload ||
+                header.PoBMaxLatency != expected.PoBMaxLatency ||
+                header.PoBMaxPacketLoss != expected.PoBMaxPacketLoss {
+                return fmt.Errorf("invalid PoB thresholds: got %d/%d/%d, expected %d/%d/%d",
+                        header.PoBMinUpload, header.PoBMaxLatency, header.PoBMaxPacketLoss,
+                        expected.PoBMinUpload, expected.PoBMaxLatency, expected.PoBMaxPacketLoss)
+        }
+        return nil
+}
+
+// HeaderThresholds returns the thresholds committed in header, or nil if the
+// header commits none
+func HeaderThresholds(header *core.BlockHeader) *PoBThresholds {
+        if header.PoBMinUpload == 0 && header.PoBMaxLatency == 0 && header.PoBMaxPacketLoss == 0 {
+                return nil
+        }
+        return thresholdsFromMilli(header.PoBMinUpload, header.PoBMaxLatency, header.PoBMaxPacketLoss)
 }
 
 // EvaluateWithCurrentThresholds checks if result passes current thresholds
 func (prm *PoBRetargetManager) EvaluateWithCurrentThresholds(result *PoBTestResult) bool {
-        thresholds := prm.GetCurrentThresholds()
-        
+        return prm.GetCurrentThresholds().Evaluate(result)
+}
+
+// Evaluate checks if result passes these thresholds
+func (thresholds *PoBThresholds) Evaluate(result *PoBTestResult) bool {
         if result.UploadBandwidth < thresholds.MinUploadBandwidth {
                 return false
         }
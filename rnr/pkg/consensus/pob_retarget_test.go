@@ -1,72 +1,110 @@
 package consensus
 
 import (
+        "bytes"
+        "fmt"
         "math/big"
         "testing"
+
+        "rnr-blockchain/pkg/core"
 )
 
+// testRetargetChain is an in-memory chain for deriving thresholds
+type testRetargetChain []*core.Block
+
+func newTestRetargetChain() *testRetargetChain {
+        genesis := &core.Block{Header: &core.BlockHeader{Height: 0}}
+        return &testRetargetChain{genesis}
+}
+
+func (c *testRetargetChain) GetBlockByHash(hash []byte) (*core.Block, error) {
+        for _, block := range *c {
+                if blockHash, _ := block.Hash(); bytes.Equal(blockHash, hash) {
+                        return block, nil
+                }
+        }
+        return nil, fmt.Errorf("block %x not found", hash)
+}
+
+func (c *testRetargetChain) GetLatestBlock() *core.Block {
+        return (*c)[len(*c)-1]
+}
+
+// nextHeader returns the header of a block on the tip, without thresholds
+func (c *testRetargetChain) nextHeader(t *testing.T) *core.BlockHeader {
+        t.Helper()
+        tip := c.GetLatestBlock()
+        tipHash, err := tip.Hash()
+        if err != nil {
+                t.Fatalf("Failed to hash block %d: %v", tip.Header.Height, err)
+        }
+        return &core.BlockHeader{Height: tip.Header.Height + 1, PrevBlockHash: tipHash}
+}
+
+// extend appends blocks up to height, each committing the thresholds mgr
+// derives for it and carrying a commit of signers signatures
+func (c *testRetargetChain) extend(t *testing.T, mgr *PoBRetargetManager, height uint64, signers int) {
+        t.Helper()
+        for h := uint64(len(*c)); h <= height; h++ {
+                header := c.nextHeader(t)
+                if err := mgr.CommitThresholds(header); err != nil {
+                        t.Fatalf("CommitThresholds failed at block %d: %v", h, err)
+                }
+                block := &core.Block{Header: header}
+                // Genesis is never voted on, so block 1 carries an empty commit
+                if h > 1 {
+                        for i := 0; i < signers; i++ {
+                                block.LastCommit = append(block.LastCommit, &core.CommitSig{ValidatorID: fmt.Sprintf("v%d", i)})
+                        }
+                }
+                *c = append(*c, block)
+        }
+}
+
 // TestRollingAverageWindow tests 50-block rolling window calculation (Whitepaper Bab 9.1)
 func TestRollingAverageWindow(t *testing.T) {
-        mgr := NewPoBRetargetManager()
+        chain := newTestRetargetChain()
+        mgr := NewPoBRetargetManager(chain)
 
         // Test case 1: Empty history
-        if avg := mgr.calculateAverageValidatorCount(); avg != 0 {
+        if avg, _ := mgr.windowAverageValidatorCount(chain.GetLatestBlock()); avg != 0 {
                 t.Errorf("Expected 0 for empty history, got %d", avg)
         }
 
-        // Test case 2: Less than 50 blocks
-        for i := 1; i <= 30; i++ {
-                mgr.RecordValidatorCount(100)
-        }
-        if avg := mgr.calculateAverageValidatorCount(); avg != 100 {
+        // Test case 2: Less than 50 blocks, blocks without a commit are not counted
+        chain.extend(t, mgr, 30, 100)
+        if avg, _ := mgr.windowAverageValidatorCount(chain.GetLatestBlock()); avg != 100 {
                 t.Errorf("Expected 100 for 30 blocks, got %d", avg)
         }
 
         // Test case 3: Exactly 50 blocks
-        for i := 31; i <= 50; i++ {
-                mgr.RecordValidatorCount(100)
-        }
-        if avg := mgr.calculateAverageValidatorCount(); avg != 100 {
+        chain.extend(t, mgr, 50, 100)
+        if avg, _ := mgr.windowAverageValidatorCount(chain.GetLatestBlock()); avg != 100 {
                 t.Errorf("Expected 100 for 50 blocks, got %d", avg)
         }
-        if len(mgr.validatorHistory) != 50 {
-                t.Errorf("Expected history length 50, got %d", len(mgr.validatorHistory))
-        }
 
-        // Test case 4: More than 50 blocks - should keep only last 50
-        for i := 1; i <= 20; i++ {
-                mgr.RecordValidatorCount(200)
-        }
-        if len(mgr.validatorHistory) != 50 {
-                t.Errorf("Expected history length 50 after overflow, got %d", len(mgr.validatorHistory))
-        }
-        // Average should shift toward 200
-        avg := mgr.calculateAverageValidatorCount()
-        if avg < 100 || avg > 200 {
-                t.Errorf("Expected average between 100-200, got %d", avg)
+        // Test case 4: More than 50 blocks - should count only last 50
+        chain.extend(t, mgr, 70, 200)
+        if avg, _ := mgr.windowAverageValidatorCount(chain.GetLatestBlock()); avg != 140 {
+                t.Errorf("Expected average 140 over blocks 21-70, got %d", avg)
         }
 
-        // Test case 5: Rolling window behavior
-        mgr2 := NewPoBRetargetManager()
-        // Add 50 blocks of 100 validators
-        for i := 0; i < 50; i++ {
-                mgr2.RecordValidatorCount(100)
-        }
-        if avg := mgr2.calculateAverageValidatorCount(); avg != 100 {
-                t.Errorf("Expected 100, got %d", avg)
-        }
-        // Add 50 more blocks of 200 validators - should completely replace
-        for i := 0; i < 50; i++ {
-                mgr2.RecordValidatorCount(200)
-        }
-        if avg := mgr2.calculateAverageValidatorCount(); avg != 200 {
+        // Test case 5: Rolling window behavior - should completely replace
+        chain.extend(t, mgr, 100, 200)
+        if avg, _ := mgr.windowAverageValidatorCount(chain.GetLatestBlock()); avg != 200 {
                 t.Errorf("Expected 200 after full replacement, got %d", avg)
         }
+
+        // A window reaching past the known blocks cannot be averaged
+        orphan := &core.Block{Header: &core.BlockHeader{Height: 200, PrevBlockHash: []byte("unknown")}}
+        if _, err := mgr.windowAverageValidatorCount(orphan); err == nil {
+                t.Errorf("Missing window blocks should be an error")
+        }
 }
 
 // TestRetargetTiming tests retargeting triggers every 50 blocks (Whitepaper Bab 9.1)
 func TestRetargetTiming(t *testing.T) {
-        mgr := NewPoBRetargetManager()
+        mgr := NewPoBRetargetManager(newTestRetargetChain())
 
         testCases := []struct {
                 height   uint64
@@ -93,40 +131,115 @@ func TestRetargetTiming(t *testing.T) {
 
 // TestDifficultyAdjustmentBounds tests ±20% adjustment bounds (Whitepaper Bab 9.2)
 func TestDifficultyAdjustmentBounds(t *testing.T) {
-        mgr := NewPoBRetargetManager()
+        chain := newTestRetargetChain()
+        mgr := NewPoBRetargetManager(chain)
 
-        // Record 50 blocks with very low validator count to trigger max loosening
-        for i := 0; i < 50; i++ {
-                mgr.RecordValidatorCount(10) // Far below target minimum of 50
-        }
-
-        oldUpload := mgr.currentThresholds.MinUploadBandwidth
-        oldLatency := mgr.currentThresholds.TargetLatency
-        oldPacketLoss := mgr.currentThresholds.TargetPacketLoss
+        // 50 blocks with very low validator count to trigger max loosening
+        chain.extend(t, mgr, 49, 10) // Far below target minimum of 50
 
-        // Trigger retarget at block 50
-        err := mgr.AdjustDifficulty(50)
+        old := defaultThresholds()
+        adjusted, err := mgr.ThresholdsAt(chain.nextHeader(t))
         if err != nil {
-                t.Fatalf("AdjustDifficulty failed: %v", err)
+                t.Fatalf("ThresholdsAt failed: %v", err)
         }
 
         // Check bounds: max change should be ±20%
-        uploadChange := (mgr.currentThresholds.MinUploadBandwidth - oldUpload) / oldUpload
+        uploadChange := (adjusted.MinUploadBandwidth - old.MinUploadBandwidth) / old.MinUploadBandwidth
         if uploadChange > 0.21 || uploadChange < -0.21 {
                 t.Errorf("Upload change %.2f%% exceeds ±20%% bound", uploadChange*100)
         }
 
-        latencyChange := (mgr.currentThresholds.TargetLatency - oldLatency) / oldLatency
+        latencyChange := (adjusted.TargetLatency - old.TargetLatency) / old.TargetLatency
         if latencyChange > 0.21 || latencyChange < -0.21 {
                 t.Errorf("Latency change %.2f%% exceeds ±20%% bound", latencyChange*100)
         }
 
-        packetLossChange := (mgr.currentThresholds.TargetPacketLoss - oldPacketLoss) / oldPacketLoss
+        packetLossChange := (adjusted.TargetPacketLoss - old.TargetPacketLoss) / old.TargetPacketLoss
         if packetLossChange > 0.21 || packetLossChange < -0.21 {
                 t.Errorf("PacketLoss change %.2f%% exceeds ±20%% bound", packetLossChange*100)
         }
 }
 
+// TestRetargetDerivedFromChain tests that every node derives the same
+// thresholds from the chain and that they are committed in block headers
+func TestRetargetDerivedFromChain(t *testing.T) {
+        chain := newTestRetargetChain()
+        mgr := NewPoBRetargetManager(chain)
+        chain.extend(t, mgr, RetargetWindow-1, 10)
+
+        // Blocks inside the window inherit the genesis thresholds
+        if inherited := HeaderThresholds((*chain)[RetargetWindow-1].Header); inherited.MinUploadBandwidth != 7 {
+                t.Fatalf("Expected 7 MB/s before the first boundary, got %v MB/s", inherited.MinUploadBandwidth)
+        }
+
+        header := chain.nextHeader(t)
+        if err := mgr.CommitThresholds(header); err != nil {
+                t.Fatalf("CommitThresholds failed: %v", err)
+        }
+        thresholds := HeaderThresholds(header)
+        if thresholds.MinUploadBandwidth != 5.6 || thresholds.TargetLatency != 120 || thresholds.TargetPacketLoss != 0.12 {
+                t.Fatalf("Expected 5.6 MB/s, 120 ms, 0.12%%, got %v MB/s, %v ms, %v%%",
+                        thresholds.MinUploadBandwidth, thresholds.TargetLatency, thresholds.TargetPacketLoss)
+        }
+
+        // A node that never observed the window, e.g. one that just synced
+        // or restarted, derives the same thresholds from the same chain
+        synced := NewPoBRetargetManager(chain)
+        if err := synced.VerifyHeaderThresholds(header); err != nil {
+                t.Fatalf("Committed thresholds rejected: %v", err)
+        }
+
+        header.PoBMinUpload = 7000
+        if err := synced.VerifyHeaderThresholds(header); err == nil {
+                t.Errorf("Header with stale thresholds should be rejected")
+        }
+
+        // The adjustment holds until the next boundary, whatever the commits
+        chain.extend(t, mgr, RetargetWindow, 10)
+        chain.extend(t, mgr, 2*RetargetWindow-1, 100)
+        if current := synced.GetCurrentThresholds(); current.MinUploadBandwidth != 5.6 {
+                t.Errorf("Expected 5.6 MB/s from block %d until the next boundary, got %v MB/s", RetargetWindow, current.MinUploadBandwidth)
+        }
+
+        if _, err := synced.ThresholdsAt(&core.BlockHeader{Height: uint64(len(*chain)) + 1, PrevBlockHash: []byte("unknown")}); err == nil {
+                t.Errorf("Thresholds of a block without a known parent should not be derived")
+        }
+        if HeaderThresholds(&core.BlockHeader{}) != nil {
+                t.Errorf("Header without thresholds should commit none")
+        }
+}
+
+// TestRetargetFollowsFork tests that a block on a fork is retargeted from its
+// own ancestors, not from the blocks at the same heights on the main chain
+func TestRetargetFollowsFork(t *testing.T) {
+        chain := newTestRetargetChain()
+        mgr := NewPoBRetargetManager(chain)
+        chain.extend(t, mgr, 10, 10)
+
+        // Both branches share blocks 0-10; the fork's window is well signed
+        fork := append(testRetargetChain{}, (*chain)...)
+        forkMgr := NewPoBRetargetManager(&fork)
+        fork.extend(t, forkMgr, RetargetWindow-1, 1000)
+        chain.extend(t, mgr, RetargetWindow-1, 10)
+
+        // The main chain's manager sees fork blocks only by hash
+        both := append(append(testRetargetChain{}, (*chain)...), fork[11:]...)
+        mgr = NewPoBRetargetManager(&both)
+
+        onChain, err := mgr.ThresholdsAt(chain.nextHeader(t))
+        if err != nil {
+                t.Fatalf("ThresholdsAt failed on the main chain: %v", err)
+        }
+        onFork, err := mgr.ThresholdsAt(fork.nextHeader(t))
+        if err != nil {
+                t.Fatalf("ThresholdsAt failed on the fork: %v", err)
+        }
+        if onChain.MinUploadBandwidth != 5.6 || onFork.MinUploadBandwidth != 8.4 {
+                t.Errorf("Expected 5.6 MB/s on the main chain and 8.4 MB/s on the fork, got %v and %v",
+                        onChain.MinUploadBandwidth, onFork.MinUploadBandwidth)
+        }
+}
+
 // TestObserverDurationPhases tests 3 phases of observer duration (Whitepaper Bab 5.1.2)
 func TestObserverDurationPhases(t *testing.T) {
         testCases := []struct {
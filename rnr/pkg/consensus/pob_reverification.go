@@ -0,0 +1,87 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"log"
+	"sort"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Scheduled PoB re-verification: an active validator whose last completed
+// PoB round is core.ReverificationInterval blocks old is re-tested by a
+// committee round the chain opens itself. Every node picks the same
+// validators from the block height and VRF output. A validator whose
+// re-verification fails the thresholds it closed with, or that its committee
+// cannot measure at all, is demoted to observer; passing any later PoB round
+// reinstates it.
+
+// ScheduleReverifications opens re-verification rounds in block for the
+// validators due. At most one in ReverificationInterval of the active set is
+// opened per block, ranked by SHA-256(seed || validator ID), so a full pass
+// over the set takes about one interval. Returns the rounds opened.
+func (rm *PoBRoundManager) ScheduleReverifications(block *core.Block) []*PoBRound {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	height := block.Header.Height
+	active := rm.state.GetActiveValidators()
+
+	due := make([]string, 0)
+	for _, vid := range active {
+		info, err := rm.state.GetValidator(vid)
+		if err != nil || info == nil || info.IsSuspended || info.IsObserver {
+			continue
+		}
+		if height < info.LastPoBHeight+core.ReverificationInterval {
+			continue
+		}
+		if rm.openRoundFor(vid) != nil {
+			continue
+		}
+		due = append(due, vid)
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	seed := roundSeed(block)
+	ranks := make(map[string][]byte, len(due))
+	for _, vid := range due {
+		rank := sha256.Sum256(append(append([]byte{}, seed...), vid...))
+		ranks[vid] = rank[:]
+	}
+	sort.Slice(due, func(i, j int) bool { return bytes.Compare(ranks[due[i]], ranks[due[j]]) < 0 })
+
+	limit := (len(active) + core.ReverificationInterval - 1) / core.ReverificationInterval
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	opened := make([]*PoBRound, 0, len(due))
+	for _, vid := range due {
+		round, err := rm.openRound(vid, block, true)
+		if err != nil {
+			log.Printf("⚠️  Cannot re-verify %s: %v", shortValidatorID(vid), err)
+			continue
+		}
+		log.Printf("🔁 Scheduled PoB re-verification of %s in round %s", shortValidatorID(vid), round.ID)
+		opened = append(opened, round)
+	}
+	return opened
+}
+
+// demote makes the candidate of a failed re-verification round an observer.
+// Caller updates info in the state.
+func (rm *PoBRoundManager) demote(round *PoBRound, info *core.ValidatorInfo, blockTime time.Time) {
+	round.Demoted = true
+	info.IsActive = false
+	info.IsObserver = true
+	info.ObserverStartTime = blockTime
+	info.DemotedAtHeight = round.ClosedHeight
+
+	log.Printf("⬇️  %s failed PoB re-verification in round %s and is demoted to observer",
+		shortValidatorID(round.CandidateID), round.ID)
+}
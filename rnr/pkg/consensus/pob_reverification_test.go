@@ -0,0 +1,159 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// TestPoBReverification tests scheduling re-verification rounds and demoting validators that fail them
+func TestPoBReverification(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		info.LastPoBHeight = 50
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+
+	rm := NewPoBRoundManager(db, state)
+	thresholds := &PoBThresholds{MinUploadBandwidth: 7.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+
+	early := &core.Block{Header: &core.BlockHeader{Height: 149, VRFOutput: []byte("vrf-output-149"), Timestamp: time.Now()}}
+	if opened := rm.ScheduleReverifications(early); len(opened) != 0 {
+		t.Fatalf("No validator is due before the interval, got %d rounds", len(opened))
+	}
+
+	// All seven are due; one in ReverificationInterval of the active set per block
+	block := &core.Block{Header: &core.BlockHeader{Height: 150, VRFOutput: []byte("vrf-output-150"), Timestamp: time.Now()}}
+	opened := rm.ScheduleReverifications(block)
+	if len(opened) != 1 || !opened[0].Reverification {
+		t.Fatalf("Expected one re-verification round, got %d", len(opened))
+	}
+	round := opened[0]
+
+	// Every node schedules the same validator from the same block
+	replica := NewPoBRoundManager(setupTestDB(t), state)
+	if again := replica.ScheduleReverifications(block); len(again) != 1 || again[0].CandidateID != round.CandidateID {
+		t.Fatalf("Re-verification schedule is not deterministic")
+	}
+
+	// The candidate's network degraded below the upload threshold
+	for _, tester := range round.Committee {
+		result := signedResult(t, round, tester, keys[tester], 4.0)
+		if _, err := rm.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}), block); err != nil {
+			t.Fatalf("Result rejected: %v", err)
+		}
+	}
+	rm.ProcessRounds(151, time.Now(), thresholds)
+
+	info, _ := state.GetValidator(round.CandidateID)
+	if !round.Demoted || info.IsActive || !info.IsObserver || info.DemotedAtHeight != 151 {
+		t.Fatalf("Validator failing re-verification should be demoted to observer (active=%v, observer=%v)", info.IsActive, info.IsObserver)
+	}
+	if info.LastPoBHeight != 151 {
+		t.Errorf("Expected last PoB height 151, got %d", info.LastPoBHeight)
+	}
+
+	// Observers are not re-verified; they request a round to be reinstated
+	next := &core.Block{Header: &core.BlockHeader{Height: 152, VRFOutput: []byte("vrf-output-152"), Timestamp: time.Now()}}
+	for _, r := range rm.ScheduleReverifications(next) {
+		if r.CandidateID == round.CandidateID {
+			t.Fatalf("Demoted validator should not be scheduled")
+		}
+	}
+
+	request := &core.Block{Header: &core.BlockHeader{Height: 160, VRFOutput: []byte("vrf-output-160"), Timestamp: time.Now()}}
+	retest, err := rm.ApplyPoBTx(pobTx(t, round.CandidateID, &PoBTx{Type: PoBTxRequest}), request)
+	if err != nil {
+		t.Fatalf("Demoted validator should be able to request a round: %v", err)
+	}
+	for _, tester := range retest.Committee {
+		result := signedResult(t, retest, tester, keys[tester], 9.0)
+		if _, err := rm.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: retest.ID, Result: result}), request); err != nil {
+			t.Fatalf("Result rejected: %v", err)
+		}
+	}
+	rm.ProcessRounds(161, time.Now(), thresholds)
+
+	info, _ = state.GetValidator(round.CandidateID)
+	if !info.IsActive || info.IsObserver || info.DemotedAtHeight != 0 {
+		t.Errorf("Validator passing PoB again should be reinstated")
+	}
+}
+
+// TestPoBReverificationVoidedWithoutReports tests that a re-verification
+// round its testers do not report in does not demote the candidate
+func TestPoBReverificationVoidedWithoutReports(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		info.LastPoBHeight = 50
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+
+	rm := NewPoBRoundManager(db, state)
+	thresholds := &PoBThresholds{MinUploadBandwidth: 7.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+
+	block := &core.Block{Header: &core.BlockHeader{Height: 150, VRFOutput: []byte("vrf-output-150"), Timestamp: time.Now()}}
+	opened := rm.ScheduleReverifications(block)
+	if len(opened) != 1 {
+		t.Fatalf("Expected one re-verification round, got %d", len(opened))
+	}
+	round := opened[0]
+
+	// A single tester reports before the deadline
+	tester := round.Committee[0]
+	result := signedResult(t, round, tester, keys[tester], 9.0)
+	if _, err := rm.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}), block); err != nil {
+		t.Fatalf("Result rejected: %v", err)
+	}
+	rm.ProcessRounds(round.Deadline, time.Now(), thresholds)
+
+	info, _ := state.GetValidator(round.CandidateID)
+	if round.Status != PoBRoundVoided || round.Demoted || !info.IsActive || info.IsObserver {
+		t.Fatalf("Candidate should stay active when its testers do not report (status %s)", round.Status)
+	}
+	if info.LastPoBHeight != 50 {
+		t.Errorf("Voided round should leave the candidate due, got last PoB height %d", info.LastPoBHeight)
+	}
+
+	// Testers that report the candidate unreachable do demote it
+	var next *core.Block
+	var again *PoBRound
+	for height := round.Deadline + 1; again == nil && height < round.Deadline+10; height++ {
+		next = &core.Block{Header: &core.BlockHeader{Height: height, VRFOutput: []byte{byte(height)}, Timestamp: time.Now()}}
+		for _, r := range rm.ScheduleReverifications(next) {
+			if r.CandidateID == round.CandidateID {
+				again = r
+			}
+		}
+	}
+	if again == nil {
+		t.Fatalf("Candidate of a voided round should be scheduled again")
+	}
+	for _, tester := range again.Committee {
+		unreachable := signedResult(t, again, tester, keys[tester], 0)
+		unreachable.PacketLoss = 100
+		unreachable.Anomalies = []string{"connection refused"}
+		unreachable.Sign(keys[tester])
+		if _, err := rm.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: again.ID, Result: unreachable}), next); err != nil {
+			t.Fatalf("Result rejected: %v", err)
+		}
+	}
+	rm.ProcessRounds(next.Header.Height, time.Now(), thresholds)
+	if again.Status != PoBRoundFailed || !again.Demoted {
+		t.Errorf("Candidate its testers cannot reach should be demoted, got %s", again.Status)
+	}
+}
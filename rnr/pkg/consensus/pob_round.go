@@ -0,0 +1,759 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"rnr-blockchain/pkg/blockchain"
+	"rnr-blockchain/pkg/core"
+)
+
+// On-chain PoB rounds (Whitepaper Bab 3.1): a candidate asks to be tested
+// with a request transaction to core.PoBModuleAddress, or the chain opens a
+// re-verification round itself (see pob_reverification.go). The VRF output
+// of the block that opens it seeds the round, so every node derives the same
+// committee and the payload each tester expects. Committee members challenge
+// the candidate over the speed test stream protocol and submit their signed
+// measurements in result transactions. Once every tester has reported, or the
+// round times out, the results are aggregated on-chain with
+// BuildPoBAggregation. The candidate then proves the aggregation in a proof
+// transaction, which every node verifies before the candidate's
+// ValidatorInfo is updated; a round left unproven fails. Each
+// result is the tester's signed raw measurement record; records stay on
+// record for core.PoBDisputeWindowBlocks after the round closes so any
+// validator can dispute them (see pob_dispute.go).
+
+type PoBTxType string
+
+const (
+	PoBTxRequest PoBTxType = "request"
+	PoBTxResult  PoBTxType = "result"
+	PoBTxDispute PoBTxType = "dispute"
+	PoBTxProof   PoBTxType = "proof"
+)
+
+type PoBRoundStatus string
+
+const (
+	PoBRoundTesting   PoBRoundStatus = "testing"
+	PoBRoundProving   PoBRoundStatus = "proving" // Aggregated, waiting for the aggregation proof
+	PoBRoundCompleted PoBRoundStatus = "completed"
+	PoBRoundFailed    PoBRoundStatus = "failed"
+	PoBRoundVoided    PoBRoundStatus = "voided" // Too few testers reported to judge the candidate
+)
+
+// pobAbsentTesterPenalty is the reputation a committee member loses for not
+// reporting in a round that is voided for lack of results
+const pobAbsentTesterPenalty = 10
+
+// PoBTx is the payload carried in core.Transaction.Data for transactions
+// sent to core.PoBModuleAddress. A request is sent by the candidate itself;
+// a result by the committee member that measured it; a dispute by any
+// validator; a proof by the candidate or a committee member.
+type PoBTx struct {
+	Type    PoBTxType        `json:"type"`
+	RoundID string           `json:"round_id,omitempty"`
+	Result  *SpeedTestResult `json:"result,omitempty"`
+	Dispute *PoBDispute      `json:"dispute,omitempty"`
+	Proof   []byte           `json:"proof,omitempty"`
+}
+
+func (p *PoBTx) Marshal() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+type PoBRound struct {
+	ID             string                      `json:"id"`
+	CandidateID    string                      `json:"candidate_id"`
+	RequestHeight  uint64                      `json:"request_height"`
+	Deadline       uint64                      `json:"deadline"`
+	Seed           []byte                      `json:"seed"`
+	Committee      []string                    `json:"committee"`
+	Results        map[string]*SpeedTestResult `json:"results"`
+	Status         PoBRoundStatus              `json:"status"`
+	Aggregation    *PoBAggregationStatement    `json:"aggregation,omitempty"`
+	FailureReason  string                      `json:"failure_reason,omitempty"`
+	ClosedHeight   uint64                      `json:"closed_height,omitempty"`
+	ClosedTime     time.Time                   `json:"closed_time"` // Time of the test; kept when a dispute re-aggregates
+	ProofDeadline  uint64                      `json:"proof_deadline,omitempty"`
+	Reverification bool                        `json:"reverification,omitempty"` // Scheduled by the chain, not requested
+	Demoted        bool                        `json:"demoted,omitempty"`        // Re-verification failed; candidate demoted to observer
+	Thresholds     *PoBThresholds              `json:"thresholds,omitempty"`
+	Disputed       map[string]PoBDisputeKind   `json:"disputed,omitempty"` // testerID -> upheld dispute
+	Analyzed       bool                        `json:"analyzed,omitempty"` // Added to tester profiles on first completion
+}
+
+// IsOpen reports whether the round's outcome is still pending.
+func (r *PoBRound) IsOpen() bool {
+	return r.Status == PoBRoundTesting || r.Status == PoBRoundProving
+}
+
+// IsCommitteeMember reports whether validatorID tests this round.
+func (r *PoBRound) IsCommitteeMember(validatorID string) bool {
+	for _, tester := range r.Committee {
+		if tester == validatorID {
+			return true
+		}
+	}
+	return false
+}
+
+// PayloadGenerator returns the generator for the payload the candidate must
+// upload to tester.
+func (r *PoBRound) PayloadGenerator(testerID string) *CryptographicPayloadGenerator {
+	return NewSeededPayloadGenerator(r.ID, testerID, r.Seed)
+}
+
+func pobRoundID(candidateID string, height uint64) string {
+	heightBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(heightBytes, height)
+	hash := sha256.Sum256(append([]byte(candidateID), heightBytes...))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+type PoBRoundManager struct {
+	db                *leveldb.DB
+	state             *blockchain.State
+	rounds            map[string]*PoBRound
+	byzantineDetector *ByzantineDetector
+	collusion         *CollusionDetector
+	zkSystem          *ZKProofSystem
+	mu                sync.RWMutex
+}
+
+func NewPoBRoundManager(db *leveldb.DB, state *blockchain.State) *PoBRoundManager {
+	rm := &PoBRoundManager{
+		db:     db,
+		state:  state,
+		rounds: make(map[string]*PoBRound),
+	}
+
+	rm.loadRounds()
+
+	return rm
+}
+
+// SetByzantineDetector reports upheld disputes to detector.
+func (rm *PoBRoundManager) SetByzantineDetector(detector *ByzantineDetector) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.byzantineDetector = detector
+}
+
+// SetCollusionDetector adds every completed round to detector's tester
+// profiles.
+func (rm *PoBRoundManager) SetCollusionDetector(detector *CollusionDetector) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.collusion = detector
+}
+
+// SetZKProofSystem makes closed rounds wait for an aggregation proof
+// verified by zkSystem. Without one, rounds complete as soon as they close.
+func (rm *PoBRoundManager) SetZKProofSystem(zkSystem *ZKProofSystem) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.zkSystem = zkSystem
+}
+
+func (rm *PoBRoundManager) loadRounds() {
+	iter := rm.db.NewIterator(util.BytesPrefix([]byte("pob_round_")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var round PoBRound
+		if err := json.Unmarshal(iter.Value(), &round); err == nil {
+			rm.rounds[round.ID] = &round
+		}
+	}
+
+	log.Printf("📶 Loaded %d PoB rounds", len(rm.rounds))
+}
+
+func (rm *PoBRoundManager) saveRound(round *PoBRound) {
+	data, err := json.Marshal(round)
+	if err != nil {
+		return
+	}
+	if err := rm.db.Put([]byte("pob_round_"+round.ID), data, nil); err != nil {
+		log.Printf("⚠️  Failed to persist PoB round %s: %v", round.ID, err)
+	}
+}
+
+// IsPoBTx reports whether tx targets the PoB module.
+func IsPoBTx(tx *core.Transaction) bool {
+	return tx.To == core.PoBModuleAddress
+}
+
+func DecodePoBTx(tx *core.Transaction) (*PoBTx, error) {
+	var payload PoBTx
+	if err := json.Unmarshal(tx.Data, &payload); err != nil {
+		return nil, fmt.Errorf("invalid PoB payload: %w", err)
+	}
+	return &payload, nil
+}
+
+// ValidatePoBTx checks a PoB transaction for inclusion at blockHeight.
+func (rm *PoBRoundManager) ValidatePoBTx(tx *core.Transaction, blockHeight uint64) error {
+	payload, err := DecodePoBTx(tx)
+	if err != nil {
+		return err
+	}
+	if tx.Amount != nil && tx.Amount.Sign() != 0 {
+		return fmt.Errorf("PoB tx must not transfer funds")
+	}
+
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	switch payload.Type {
+	case PoBTxRequest:
+		return rm.validateRequest(tx.From)
+	case PoBTxResult:
+		return rm.validateResult(tx.From, payload, blockHeight)
+	case PoBTxDispute:
+		_, err := rm.validateDispute(tx.From, payload, blockHeight)
+		return err
+	case PoBTxProof:
+		return rm.validateProof(tx.From, payload, blockHeight)
+	default:
+		return fmt.Errorf("unknown PoB tx type: %s", payload.Type)
+	}
+}
+
+func (rm *PoBRoundManager) validateRequest(candidateID string) error {
+	info, err := rm.state.GetValidator(candidateID)
+	if err != nil || info == nil {
+		return fmt.Errorf("unknown validator: %s", candidateID)
+	}
+	if round := rm.openRoundFor(candidateID); round != nil {
+		return fmt.Errorf("PoB round %s already open for %s", round.ID, shortValidatorID(candidateID))
+	}
+	if len(rm.testerPool(candidateID)) < core.MinTestCommitteeSize {
+		return fmt.Errorf("insufficient validators for committee: need %d", core.MinTestCommitteeSize)
+	}
+	return nil
+}
+
+func (rm *PoBRoundManager) validateResult(testerID string, payload *PoBTx, blockHeight uint64) error {
+	round, ok := rm.rounds[payload.RoundID]
+	if !ok {
+		return fmt.Errorf("unknown PoB round: %s", payload.RoundID)
+	}
+	if round.Status != PoBRoundTesting {
+		return fmt.Errorf("PoB round %s is %s", round.ID, round.Status)
+	}
+	if blockHeight > round.Deadline {
+		return fmt.Errorf("PoB round %s timed out at block #%d", round.ID, round.Deadline)
+	}
+	if !round.IsCommitteeMember(testerID) {
+		return fmt.Errorf("%s is not in the committee of round %s", shortValidatorID(testerID), round.ID)
+	}
+	if _, done := round.Results[testerID]; done {
+		return fmt.Errorf("%s already submitted a result for round %s", shortValidatorID(testerID), round.ID)
+	}
+
+	result := payload.Result
+	if result == nil {
+		return fmt.Errorf("missing speed test result")
+	}
+	if result.TesterID != testerID || result.SessionID != round.ID || result.CandidateID != round.CandidateID {
+		return fmt.Errorf("result does not belong to round %s", round.ID)
+	}
+	if len(result.VerifiedHashes) > 0 && result.MerkleRoot != calculateMerkleRootFromHashes(result.VerifiedHashes) {
+		return fmt.Errorf("result Merkle root does not match its chunk hashes")
+	}
+
+	info, err := rm.state.GetValidator(testerID)
+	if err != nil || info == nil {
+		return fmt.Errorf("unknown validator: %s", testerID)
+	}
+	publicKey, err := DecodeECDSAPublicKey(info.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for %s: %w", shortValidatorID(testerID), err)
+	}
+	if !result.VerifySignature(publicKey) {
+		return fmt.Errorf("invalid tester signature from %s", shortValidatorID(testerID))
+	}
+
+	return nil
+}
+
+// validateProof checks that a proof tx proves the aggregation the round
+// closed with.
+func (rm *PoBRoundManager) validateProof(prover string, payload *PoBTx, blockHeight uint64) error {
+	round, ok := rm.rounds[payload.RoundID]
+	if !ok {
+		return fmt.Errorf("unknown PoB round: %s", payload.RoundID)
+	}
+	if round.Status != PoBRoundProving {
+		return fmt.Errorf("PoB round %s is %s", round.ID, round.Status)
+	}
+	if blockHeight > round.ProofDeadline {
+		return fmt.Errorf("proof for PoB round %s was due by block #%d", round.ID, round.ProofDeadline)
+	}
+	if prover != round.CandidateID && !round.IsCommitteeMember(prover) {
+		return fmt.Errorf("%s is neither the candidate nor in the committee of round %s", shortValidatorID(prover), round.ID)
+	}
+	if rm.zkSystem == nil {
+		return fmt.Errorf("no proof system to verify PoB round %s", round.ID)
+	}
+
+	statement := *round.Aggregation
+	statement.Proof = payload.Proof
+	valid, err := rm.zkSystem.VerifyAggregation(&statement)
+	if err != nil {
+		return fmt.Errorf("aggregation proof verification failed: %w", err)
+	}
+	if !valid {
+		return fmt.Errorf("invalid aggregation proof for PoB round %s", round.ID)
+	}
+	return nil
+}
+
+// ApplyPoBTx applies a PoB transaction included in block. For a request it
+// returns the newly opened round.
+func (rm *PoBRoundManager) ApplyPoBTx(tx *core.Transaction, block *core.Block) (*PoBRound, error) {
+	if err := rm.ValidatePoBTx(tx, block.Header.Height); err != nil {
+		return nil, err
+	}
+	payload, _ := DecodePoBTx(tx)
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if payload.Type == PoBTxDispute {
+		rm.applyDispute(tx.From, payload, block)
+		return nil, nil
+	}
+
+	if payload.Type == PoBTxProof {
+		round := rm.rounds[payload.RoundID]
+		round.Aggregation.Proof = payload.Proof
+		log.Printf("🔏 Aggregation proof for PoB round %s verified", round.ID)
+		rm.completeRound(round, block.Header.Timestamp)
+		rm.saveRound(round)
+		return nil, nil
+	}
+
+	if payload.Type == PoBTxResult {
+		round := rm.rounds[payload.RoundID]
+		round.Results[tx.From] = payload.Result
+		rm.saveRound(round)
+
+		log.Printf("📶 PoB result from %s for round %s (%d/%d)",
+			shortValidatorID(tx.From), round.ID, len(round.Results), len(round.Committee))
+		return nil, nil
+	}
+
+	return rm.openRound(tx.From, block, false)
+}
+
+// roundSeed is the committee and payload seed of rounds opened in block.
+// The block's VRF output is unknown to the candidate when it sends the
+// request, so it cannot pick its committee.
+func roundSeed(block *core.Block) []byte {
+	seed := block.Header.VRFOutput
+	if len(seed) == 0 {
+		seed, _ = block.Hash()
+	}
+	return seed
+}
+
+// committeeSize is the committee size for a tester pool: the whole pool up to
+// core.MaxTestCommitteeSize, or 0 below core.MinTestCommitteeSize.
+func committeeSize(pool []string) int {
+	if len(pool) < core.MinTestCommitteeSize {
+		return 0
+	}
+	if len(pool) > core.MaxTestCommitteeSize {
+		return core.MaxTestCommitteeSize
+	}
+	return len(pool)
+}
+
+// openRound opens a round testing candidateID in block. Caller holds rm.mu.
+func (rm *PoBRoundManager) openRound(candidateID string, block *core.Block, reverification bool) (*PoBRound, error) {
+	pool := rm.testerPool(candidateID)
+	size := committeeSize(pool)
+	if size == 0 {
+		return nil, fmt.Errorf("insufficient validators for committee: need %d", core.MinTestCommitteeSize)
+	}
+	seed := roundSeed(block)
+	committee := SelectWeightedTestCommittee(candidateID, seed, pool, rm.testerWeights(pool), size)
+
+	round := &PoBRound{
+		ID:             pobRoundID(candidateID, block.Header.Height),
+		CandidateID:    candidateID,
+		RequestHeight:  block.Header.Height,
+		Deadline:       block.Header.Height + core.PoBRoundTimeoutBlocks,
+		Seed:           seed,
+		Committee:      committee,
+		Results:        make(map[string]*SpeedTestResult),
+		Status:         PoBRoundTesting,
+		Reverification: reverification,
+	}
+	rm.rounds[round.ID] = round
+	rm.saveRound(round)
+
+	log.Printf("📶 PoB round %s opened for %s at block #%d (committee: %d, deadline #%d)",
+		round.ID, shortValidatorID(candidateID), block.Header.Height, len(committee), round.Deadline)
+	return round, nil
+}
+
+// ProcessRounds closes every round that has all its results or has timed
+// out by height and aggregates it against thresholds, and fails rounds whose
+// aggregation proof is overdue. Returns the rounds closed.
+func (rm *PoBRoundManager) ProcessRounds(height uint64, blockTime time.Time, thresholds *PoBThresholds) []*PoBRound {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	ids := make([]string, 0)
+	unproven := make([]string, 0)
+	for id, round := range rm.rounds {
+		if round.Status == PoBRoundTesting &&
+			(len(round.Results) == len(round.Committee) || height >= round.Deadline) {
+			ids = append(ids, id)
+		}
+		if round.Status == PoBRoundProving && height > round.ProofDeadline {
+			unproven = append(unproven, id)
+		}
+	}
+	sort.Strings(ids)
+	sort.Strings(unproven)
+
+	for _, id := range unproven {
+		round := rm.rounds[id]
+		rm.failRound(round, fmt.Sprintf("no aggregation proof by block #%d", round.ProofDeadline), blockTime)
+		rm.saveRound(round)
+	}
+
+	closed := make([]*PoBRound, 0, len(ids))
+	for _, id := range ids {
+		round := rm.rounds[id]
+		snapshot := *thresholds
+		round.ClosedHeight = height
+		round.ClosedTime = blockTime
+		round.Thresholds = &snapshot
+		rm.closeRound(round, height, blockTime)
+		rm.saveRound(round)
+		closed = append(closed, round)
+	}
+
+	return closed
+}
+
+// undisputedResults returns the round's results without upheld disputes.
+func (r *PoBRound) undisputedResults() []*SpeedTestResult {
+	results := make([]*SpeedTestResult, 0, len(r.Results))
+	for testerID, result := range r.Results {
+		if _, disputed := r.Disputed[testerID]; !disputed {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// closeRound aggregates the round's undisputed results against the
+// thresholds it closed with. With a proof system the outcome waits for the
+// aggregation proof until core.PoBProofTimeoutBlocks after height. A round
+// with too few reports is the committee's failure, not the candidate's, and
+// is voided.
+func (rm *PoBRoundManager) closeRound(round *PoBRound, height uint64, blockTime time.Time) {
+	results := round.undisputedResults()
+	if len(results) < MinAggregatedResults {
+		rm.voidRound(round, len(results))
+		return
+	}
+
+	statement, _, err := BuildPoBAggregation(round.ID, results, round.Thresholds)
+	if err != nil {
+		rm.failRound(round, err.Error(), blockTime)
+		return
+	}
+
+	round.Aggregation = statement
+	if rm.zkSystem != nil {
+		round.Status = PoBRoundProving
+		round.ProofDeadline = height + core.PoBProofTimeoutBlocks
+		log.Printf("🔏 PoB round %s for %s aggregated, proof due by block #%d",
+			round.ID, shortValidatorID(round.CandidateID), round.ProofDeadline)
+		return
+	}
+	rm.completeRound(round, blockTime)
+}
+
+// voidRound ends a round too few testers reported in, leaving the
+// candidate as it was; a validator due for re-verification is scheduled
+// again. Committee members that did not report lose reputation, so they are
+// picked less often.
+func (rm *PoBRoundManager) voidRound(round *PoBRound, reports int) {
+	round.Status = PoBRoundVoided
+	round.Aggregation = nil
+	round.FailureReason = fmt.Sprintf("%d of %d testers reported, need %d", reports, len(round.Committee), MinAggregatedResults)
+	log.Printf("⚪ PoB round %s for %s voided: %s", round.ID, shortValidatorID(round.CandidateID), round.FailureReason)
+
+	for _, tester := range round.Committee {
+		if _, reported := round.Results[tester]; reported {
+			continue
+		}
+		info, err := rm.state.GetValidator(tester)
+		if err != nil || info == nil {
+			continue
+		}
+		if info.Reputation == 0 {
+			info.Reputation = core.InitialReputation
+		}
+		// Reputation stays at least 1; 0 means never set
+		info.Reputation -= pobAbsentTesterPenalty
+		if info.Reputation < 1 {
+			info.Reputation = 1
+		}
+		rm.state.UpdateValidator(info)
+		log.Printf("⚠️  PoB tester %s did not report in round %s", shortValidatorID(tester), round.ID)
+	}
+}
+
+// failRound ends the round without a result. A validator whose own testers
+// cannot measure it, or that does not prove its aggregation, fails
+// re-verification.
+func (rm *PoBRoundManager) failRound(round *PoBRound, reason string, blockTime time.Time) {
+	round.Status = PoBRoundFailed
+	round.Aggregation = nil
+	round.FailureReason = reason
+	log.Printf("❌ PoB round %s for %s failed: %s", round.ID, shortValidatorID(round.CandidateID), reason)
+
+	if round.Reverification {
+		if info, err := rm.state.GetValidator(round.CandidateID); err == nil && info != nil {
+			info.LastPoBHeight = round.ClosedHeight
+			rm.demote(round, info, blockTime)
+			rm.state.UpdateValidator(info)
+		}
+	}
+}
+
+// completeRound writes the round's aggregation to the candidate's
+// ValidatorInfo. The result is dated when the round closed, also when it
+// completes later after a proof or a dispute. Only the first completion
+// reaches the tester profiles, so a re-aggregation is not counted twice.
+func (rm *PoBRoundManager) completeRound(round *PoBRound, blockTime time.Time) {
+	results := round.undisputedResults()
+	statement := round.Aggregation
+	round.Status = PoBRoundCompleted
+
+	if rm.collusion != nil && !round.Analyzed {
+		round.Analyzed = true
+		rm.collusion.AnalyzeRound(round)
+	}
+
+	testedAt := round.ClosedTime
+	if testedAt.IsZero() {
+		testedAt = blockTime
+	}
+
+	aggregated := &PoBTestResult{
+		CandidateID:     round.CandidateID,
+		UploadBandwidth: statement.UploadBandwidth(),
+		Latency:         statement.Latency(),
+		Jitter:          medianJitter(results),
+		PacketLoss:      statement.PacketLoss(),
+		Timestamp:       testedAt,
+		Passed:          statement.Passed,
+		Aggregation:     statement,
+	}
+
+	info, err := rm.state.GetValidator(round.CandidateID)
+	if err != nil || info == nil {
+		return
+	}
+	info.PoBScore = CalculatePoBScore([]*PoBTestResult{aggregated})
+	info.UploadBandwidth = aggregated.UploadBandwidth
+	info.Latency = aggregated.Latency
+	info.PacketLoss = aggregated.PacketLoss
+	info.LastPoBTest = testedAt
+	info.LastPoBHeight = round.ClosedHeight
+	passed := round.Thresholds.Evaluate(aggregated)
+	if round.Reverification && !passed {
+		rm.demote(round, info, blockTime)
+	} else if passed && info.DemotedAtHeight != 0 {
+		round.Demoted = false
+		info.DemotedAtHeight = 0
+		info.IsObserver = false
+		info.IsActive = true
+		log.Printf("✅ %s passed PoB again and is reinstated as an active validator", shortValidatorID(round.CandidateID))
+	}
+	rm.state.UpdateValidator(info)
+
+	log.Printf("📊 PoB round %s for %s: %.2f MB/s, %.2f ms, %.3f%% loss, score %.3f, passed=%v",
+		round.ID, shortValidatorID(round.CandidateID), aggregated.UploadBandwidth, aggregated.Latency,
+		aggregated.PacketLoss, info.PoBScore, aggregated.Passed)
+}
+
+// medianJitter returns the median jitter of the results the aggregation
+// includes. Jitter is informational, so it is not part of the proof.
+func medianJitter(results []*SpeedTestResult) float64 {
+	jitters := make([]float64, 0, len(results))
+	for _, r := range results {
+		if len(r.Anomalies) == 0 && r.PayloadVerified {
+			jitters = append(jitters, r.Jitter)
+		}
+	}
+	if len(jitters) == 0 {
+		return 0
+	}
+	sort.Float64s(jitters)
+	mid := len(jitters) / 2
+	if len(jitters)%2 == 0 {
+		return (jitters[mid-1] + jitters[mid]) / 2
+	}
+	return jitters[mid]
+}
+
+// testerPool returns the validators eligible to test candidateID, sorted.
+func (rm *PoBRoundManager) testerPool(candidateID string) []string {
+	pool := make([]string, 0)
+	for _, vid := range rm.state.GetActiveValidators() {
+		if vid == candidateID {
+			continue
+		}
+		if info, err := rm.state.GetValidator(vid); err == nil && info != nil && !info.IsSuspended {
+			pool = append(pool, vid)
+		}
+	}
+	sort.Strings(pool)
+	return pool
+}
+
+// testerWeights weights testers by reputation, so testers flagged for
+// collusion are picked less often. A reputation never set counts as
+// core.InitialReputation.
+func (rm *PoBRoundManager) testerWeights(pool []string) map[string]int {
+	weights := make(map[string]int, len(pool))
+	for _, vid := range pool {
+		weights[vid] = core.InitialReputation
+		if info, err := rm.state.GetValidator(vid); err == nil && info != nil && info.Reputation != 0 {
+			weights[vid] = info.Reputation
+		}
+	}
+	return weights
+}
+
+func (rm *PoBRoundManager) openRoundFor(candidateID string) *PoBRound {
+	for _, round := range rm.rounds {
+		if round.CandidateID == candidateID && round.IsOpen() {
+			return round
+		}
+	}
+	return nil
+}
+
+// GetRound returns the round with the given ID, or nil.
+func (rm *PoBRoundManager) GetRound(roundID string) *PoBRound {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.rounds[roundID]
+}
+
+// ProveRound proves the aggregation of a round waiting for its proof. Every
+// node holding the round's results can build the witness.
+func (rm *PoBRoundManager) ProveRound(roundID string) ([]byte, error) {
+	rm.mu.RLock()
+	round, ok := rm.rounds[roundID]
+	if !ok || round.Status != PoBRoundProving {
+		rm.mu.RUnlock()
+		return nil, fmt.Errorf("PoB round %s is not waiting for a proof", roundID)
+	}
+	statement, witness, err := BuildPoBAggregation(round.ID, round.undisputedResults(), round.Thresholds)
+	expected := round.Aggregation.Commitment
+	zkSystem := rm.zkSystem
+	rm.mu.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if statement.Commitment != expected {
+		return nil, fmt.Errorf("results of PoB round %s do not match its aggregation", roundID)
+	}
+	if zkSystem == nil {
+		return nil, fmt.Errorf("no proof system")
+	}
+	return zkSystem.ProveAggregation(witness)
+}
+
+// AwaitingProof returns candidateID's rounds waiting for an aggregation
+// proof.
+func (rm *PoBRoundManager) AwaitingProof(candidateID string) []*PoBRound {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	rounds := make([]*PoBRound, 0)
+	for _, round := range rm.rounds {
+		if round.CandidateID == candidateID && round.Status == PoBRoundProving {
+			rounds = append(rounds, round)
+		}
+	}
+	return rounds
+}
+
+// GetOpenRound returns candidateID's round in progress, or nil.
+func (rm *PoBRoundManager) GetOpenRound(candidateID string) *PoBRound {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.openRoundFor(candidateID)
+}
+
+// signingHash covers the raw measurement record a tester attests to: the
+// session, the Merkle root of the chunks received, every chunk's arrival
+// time and the values measured from them.
+func (r *SpeedTestResult) signingHash() []byte {
+	chunkTimes := make([]int64, len(r.ChunkTimestamps))
+	for i, ts := range r.ChunkTimestamps {
+		chunkTimes[i] = ts.UnixNano()
+	}
+
+	data, _ := json.Marshal(struct {
+		SessionID       string
+		TesterID        string
+		CandidateID     string
+		MerkleRoot      string
+		ChunkTimestamps []int64
+		UploadBandwidth float64
+		Latency         float64
+		Jitter          float64
+		PacketLoss      float64
+		ReceivedChunks  int
+		PayloadVerified bool
+		Anomalies       []string
+		Timestamp       int64
+	}{
+		r.SessionID, r.TesterID, r.CandidateID, r.MerkleRoot, chunkTimes,
+		r.UploadBandwidth, r.Latency, r.Jitter, r.PacketLoss,
+		r.ReceivedChunks, r.PayloadVerified, r.Anomalies, r.Timestamp.UnixNano(),
+	})
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// Sign sets TesterSignature with the tester's validator key.
+func (r *SpeedTestResult) Sign(privateKey *ecdsa.PrivateKey) error {
+	signature, err := signDigest(r.signingHash(), privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign result: %w", err)
+	}
+	r.TesterSignature = signature
+	return nil
+}
+
+func (r *SpeedTestResult) VerifySignature(publicKey *ecdsa.PublicKey) bool {
+	return VerifyVote(&Vote{BlockHash: r.signingHash(), ValidatorID: r.TesterID, Signature: r.TesterSignature}, publicKey)
+}
@@ -0,0 +1,323 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+func pobTx(t *testing.T, from string, payload *PoBTx) *core.Transaction {
+	data, err := payload.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to encode PoB tx: %v", err)
+	}
+	return &core.Transaction{
+		ID:     "pob_" + from,
+		From:   from,
+		To:     core.PoBModuleAddress,
+		Amount: big.NewInt(0),
+		Fee:    big.NewInt(0),
+		Data:   data,
+	}
+}
+
+func signedResult(t *testing.T, round *PoBRound, tester string, key *ecdsa.PrivateKey, upload float64) *SpeedTestResult {
+	result := &SpeedTestResult{
+		TesterID:        tester,
+		CandidateID:     round.CandidateID,
+		SessionID:       round.ID,
+		UploadBandwidth: upload,
+		Latency:         40.0,
+		PacketLoss:      0,
+		PayloadVerified: true,
+		Timestamp:       time.Now(),
+	}
+	if err := result.Sign(key); err != nil {
+		t.Fatalf("Failed to sign result: %v", err)
+	}
+	return result
+}
+
+// TestPoBRoundLifecycle tests request, committee results and on-chain aggregation of a PoB round
+func TestPoBRoundLifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+
+	rm := NewPoBRoundManager(db, state)
+	candidate := "1"
+	thresholds := &PoBThresholds{MinUploadBandwidth: 7.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+
+	block := &core.Block{Header: &core.BlockHeader{Height: 10, VRFOutput: []byte("vrf-output-10"), Timestamp: time.Now()}}
+	round, err := rm.ApplyPoBTx(pobTx(t, candidate, &PoBTx{Type: PoBTxRequest}), block)
+	if err != nil || round == nil {
+		t.Fatalf("Request should open a round: %v", err)
+	}
+	if len(round.Committee) != core.MaxTestCommitteeSize || round.IsCommitteeMember(candidate) {
+		t.Fatalf("Expected %d testers excluding the candidate, got %v", core.MaxTestCommitteeSize, round.Committee)
+	}
+	if round.Deadline != 10+core.PoBRoundTimeoutBlocks {
+		t.Errorf("Expected deadline %d, got %d", 10+core.PoBRoundTimeoutBlocks, round.Deadline)
+	}
+
+	// Every node derives the same committee from the block
+	pool := rm.testerPool(candidate)
+	expected := SelectWeightedTestCommittee(candidate, block.Header.VRFOutput, pool, rm.testerWeights(pool), core.MaxTestCommitteeSize)
+	for i := range expected {
+		if expected[i] != round.Committee[i] {
+			t.Fatalf("Committee not reproducible: %v vs %v", expected, round.Committee)
+		}
+	}
+
+	if err := rm.ValidatePoBTx(pobTx(t, candidate, &PoBTx{Type: PoBTxRequest}), 11); err == nil {
+		t.Errorf("Second request while a round is open should be rejected")
+	}
+
+	outsider := ""
+	for id := range keys {
+		if id != candidate && !round.IsCommitteeMember(id) {
+			outsider = id
+		}
+	}
+	result := signedResult(t, round, outsider, keys[outsider], 10.0)
+	if err := rm.ValidatePoBTx(pobTx(t, outsider, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}), 11); err == nil {
+		t.Errorf("Result from outside the committee should be rejected")
+	}
+
+	tester := round.Committee[0]
+	forged := signedResult(t, round, tester, keys[tester], 10.0)
+	forged.UploadBandwidth = 50.0
+	if err := rm.ValidatePoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: forged}), 11); err == nil {
+		t.Errorf("Result altered after signing should be rejected")
+	}
+
+	uploads := []float64{8.0, 12.0, 9.0, 9.0, 10.0, 7.0, 9.0, 11.0}
+	next := &core.Block{Header: &core.BlockHeader{Height: 11, Timestamp: time.Now()}}
+	for i, tester := range round.Committee {
+		result := signedResult(t, round, tester, keys[tester], uploads[i])
+		tx := pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result})
+		if _, err := rm.ApplyPoBTx(tx, next); err != nil {
+			t.Fatalf("Result from committee member %s rejected: %v", tester, err)
+		}
+		if _, err := rm.ApplyPoBTx(tx, next); err == nil {
+			t.Errorf("Duplicate result from %s should be rejected", tester)
+		}
+
+		closed := rm.ProcessRounds(11, next.Header.Timestamp, thresholds)
+		if last := i == len(round.Committee)-1; last != (len(closed) == 1) {
+			t.Fatalf("Round should close exactly when all results are in (after %d results, closed %d)", i+1, len(closed))
+		}
+	}
+
+	if round.Status != PoBRoundCompleted || round.Aggregation == nil || !round.Aggregation.Passed {
+		t.Fatalf("Expected a passed, completed round, got %s (%s)", round.Status, round.FailureReason)
+	}
+	info, _ := state.GetValidator(candidate)
+	if info.UploadBandwidth != 9.0 || info.Latency != 40.0 {
+		t.Errorf("Expected median upload 9 and latency 40, got %v and %v", info.UploadBandwidth, info.Latency)
+	}
+
+	// Rounds persist across restarts
+	if reloaded := NewPoBRoundManager(db, state).GetRound(round.ID); reloaded == nil || reloaded.Status != PoBRoundCompleted {
+		t.Errorf("Completed round should be reloaded from the database")
+	}
+}
+
+// TestPoBRoundTimeout tests that a round without enough results is voided at its deadline
+func TestPoBRoundTimeout(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+
+	rm := NewPoBRoundManager(db, state)
+	thresholds := &PoBThresholds{MinUploadBandwidth: 7.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+
+	block := &core.Block{Header: &core.BlockHeader{Height: 1, VRFOutput: []byte("vrf-output-1"), Timestamp: time.Now()}}
+	round, err := rm.ApplyPoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxRequest}), block)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	tester := round.Committee[0]
+	result := signedResult(t, round, tester, keys[tester], 10.0)
+	if _, err := rm.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}), block); err != nil {
+		t.Fatalf("Result rejected: %v", err)
+	}
+
+	if closed := rm.ProcessRounds(round.Deadline-1, time.Now(), thresholds); len(closed) != 0 {
+		t.Fatalf("Round closed before its deadline")
+	}
+	if closed := rm.ProcessRounds(round.Deadline, time.Now(), thresholds); len(closed) != 1 || round.Status != PoBRoundVoided {
+		t.Fatalf("Round with one result should be voided at the deadline, got %s", round.Status)
+	}
+
+	// Testers that did not report are penalized, the one that did is not
+	for _, id := range round.Committee {
+		info, _ := state.GetValidator(id)
+		expected := core.InitialReputation - pobAbsentTesterPenalty
+		if id == tester {
+			expected = 100 // createTestValidator's reputation
+		}
+		if info.Reputation != expected {
+			t.Errorf("Expected reputation %d for %s, got %d", expected, id, info.Reputation)
+		}
+	}
+
+	late := signedResult(t, round, round.Committee[1], keys[round.Committee[1]], 10.0)
+	if err := rm.ValidatePoBTx(pobTx(t, round.Committee[1], &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: late}), round.Deadline+1); err == nil {
+		t.Errorf("Result after the round closed should be rejected")
+	}
+
+	// The candidate may ask again once the round is over
+	if err := rm.ValidatePoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxRequest}), round.Deadline+1); err != nil {
+		t.Errorf("New request after a failed round should be accepted: %v", err)
+	}
+}
+
+// TestPoBRoundAggregationProof tests that a closed round completes only with
+// a valid aggregation proof, and fails once the proof is overdue
+func TestPoBRoundAggregationProof(t *testing.T) {
+	zk, err := NewZKProofSystem()
+	if err != nil {
+		t.Fatalf("NewZKProofSystem failed: %v", err)
+	}
+
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"} {
+		key, info := createTestValidator(id)
+		info.PublicKey, _ = core.EncodePublicKey(&key.PublicKey)
+		keys[id] = key
+		state.UpdateValidator(info)
+	}
+
+	rm := NewPoBRoundManager(db, state)
+	rm.SetZKProofSystem(zk)
+	thresholds := &PoBThresholds{MinUploadBandwidth: 7.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+
+	openWithResults := func(candidate string, height uint64) *PoBRound {
+		block := &core.Block{Header: &core.BlockHeader{Height: height, VRFOutput: []byte("vrf"), Timestamp: time.Now()}}
+		round, err := rm.ApplyPoBTx(pobTx(t, candidate, &PoBTx{Type: PoBTxRequest}), block)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		for _, tester := range round.Committee {
+			result := signedResult(t, round, tester, keys[tester], 10.0)
+			if _, err := rm.ApplyPoBTx(pobTx(t, tester, &PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}), block); err != nil {
+				t.Fatalf("Result rejected: %v", err)
+			}
+		}
+		rm.ProcessRounds(height, time.Now(), thresholds)
+		if round.Status != PoBRoundProving {
+			t.Fatalf("Closed round should wait for its proof, got %s", round.Status)
+		}
+		return round
+	}
+
+	round := openWithResults("1", 10)
+	if info, _ := state.GetValidator("1"); info.UploadBandwidth == 10.0 {
+		t.Errorf("Outcome must not apply before the proof")
+	}
+	if err := rm.ValidatePoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxRequest}), 11); err == nil {
+		t.Errorf("New request while the proof is pending should be rejected")
+	}
+
+	proof, err := rm.ProveRound(round.ID)
+	if err != nil {
+		t.Fatalf("ProveRound failed: %v", err)
+	}
+	tampered := append([]byte(nil), proof...)
+	tampered[len(tampered)/2] ^= 0xff
+	if err := rm.ValidatePoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxProof, RoundID: round.ID, Proof: tampered}), 11); err == nil {
+		t.Errorf("Tampered proof should be rejected")
+	}
+	for id := range keys {
+		if id == round.CandidateID || round.IsCommitteeMember(id) {
+			continue
+		}
+		if err := rm.ValidatePoBTx(pobTx(t, id, &PoBTx{Type: PoBTxProof, RoundID: round.ID, Proof: proof}), 11); err == nil {
+			t.Errorf("Proof from outside the round should be rejected")
+		}
+		break
+	}
+
+	next := &core.Block{Header: &core.BlockHeader{Height: 11, Timestamp: time.Now()}}
+	if _, err := rm.ApplyPoBTx(pobTx(t, "1", &PoBTx{Type: PoBTxProof, RoundID: round.ID, Proof: proof}), next); err != nil {
+		t.Fatalf("Valid proof rejected: %v", err)
+	}
+	if round.Status != PoBRoundCompleted {
+		t.Fatalf("Proven round should complete, got %s", round.Status)
+	}
+	if info, _ := state.GetValidator("1"); info.UploadBandwidth != 10.0 {
+		t.Errorf("Expected upload 10 after the proof, got %v", info.UploadBandwidth)
+	}
+
+	// Without a proof the round fails once it is overdue
+	unproven := openWithResults("2", 20)
+	rm.ProcessRounds(unproven.ProofDeadline, time.Now(), thresholds)
+	if unproven.Status != PoBRoundProving {
+		t.Fatalf("Round should still wait at its proof deadline")
+	}
+	rm.ProcessRounds(unproven.ProofDeadline+1, time.Now(), thresholds)
+	if unproven.Status != PoBRoundFailed {
+		t.Errorf("Unproven round should fail after its deadline, got %s", unproven.Status)
+	}
+}
+
+// TestPoBModuleTxNonces tests that PoB txs submitted while earlier ones are pending take consecutive nonces
+func TestPoBModuleTxNonces(t *testing.T) {
+	db := setupTestDB(t)
+	chain, _ := setupTestBlockchain(db)
+	state, _ := setupTestState(db)
+
+	key, info := createTestValidator("1")
+	state.UpdateValidator(info)
+	state.UpdateAccount(&core.Account{Address: info.ID, Balance: big.NewInt(0), Nonce: 4})
+
+	vs, err := NewValidatorService(info.ID, key, chain, state, setupTestMempool(), NewProofOfHistory())
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+
+	result, err := vs.submitModuleTx(core.PoBModuleAddress, []byte("result"))
+	if err != nil {
+		t.Fatalf("Failed to submit result tx: %v", err)
+	}
+	proof, err := vs.submitModuleTx(core.PoBModuleAddress, []byte("proof"))
+	if err != nil {
+		t.Fatalf("Failed to submit proof tx: %v", err)
+	}
+	if result.Nonce != 4 || proof.Nonce != 5 {
+		t.Fatalf("Expected nonces 4 and 5, got %d and %d", result.Nonce, proof.Nonce)
+	}
+
+	// Once the result is included, the next tx still follows the pending proof
+	vs.mempool.RemoveTransaction(result.ID)
+	state.UpdateAccount(&core.Account{Address: info.ID, Balance: big.NewInt(0), Nonce: 5})
+	next, err := vs.submitModuleTx(core.PoBModuleAddress, []byte("next"))
+	if err != nil {
+		t.Fatalf("Failed to submit tx: %v", err)
+	}
+	if next.Nonce != 6 {
+		t.Errorf("Expected nonce 6 after the pending proof, got %d", next.Nonce)
+	}
+}
@@ -4,13 +4,18 @@ import (
         "fmt"
         "log"
         "net"
+
+        "rnr-blockchain/pkg/network"
 )
 
-// PoBTestServer handles incoming PoB speed test connections
+// PoBTestServer handles incoming PoB speed test connections, with a UDP
+// probe responder on the same port number for latency and loss probes
 type PoBTestServer struct {
-        port     int
-        listener net.Listener
-        done     chan struct{}
+        port      int
+        listener  net.Listener
+        probes    *network.UDPProbeResponder
+        emulation *network.NetemLink
+        done      chan struct{}
 }
 
 // NewPoBTestServer creates a new PoB test server
@@ -21,6 +26,12 @@ func NewPoBTestServer(port int) *PoBTestServer {
         }
 }
 
+// SetNetworkEmulation serves testers over link, as a candidate with that
+// link's bandwidth, latency and loss would. Call before Start.
+func (s *PoBTestServer) SetNetworkEmulation(link *network.NetemLink) {
+        s.emulation = link
+}
+
 // Start begins listening for PoB test connections
 func (s *PoBTestServer) Start() error {
         listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
@@ -29,12 +40,30 @@ func (s *PoBTestServer) Start() error {
         }
         
         s.listener = listener
+        if s.emulation != nil {
+                s.listener = s.emulation.Listener(listener)
+                log.Printf("🧪 PoB Test Server running on an emulated link")
+        }
         log.Printf("✅ PoB Test Server listening on port %d", s.port)
+
+        probes := network.NewUDPProbeResponder()
+        probes.SetNetworkEmulation(s.emulation)
+        if err := probes.Listen(fmt.Sprintf(":%d", s.port)); err != nil {
+                log.Printf("⚠️  UDP probes disabled: %v", err)
+        } else {
+                s.probes = probes
+                log.Printf("✅ PoB UDP probe responder listening on port %d", s.port)
+        }
         
         go s.acceptConnections()
         return nil
 }
 
+// ProbeResponder returns the UDP probe responder, or nil if it failed to start
+func (s *PoBTestServer) ProbeResponder() *network.UDPProbeResponder {
+        return s.probes
+}
+
 // acceptConnections handles incoming PoB test connections
 // Gracefully stops on shutdown signal or listener close
 func (s *PoBTestServer) acceptConnections() {
@@ -60,7 +89,7 @@ func (s *PoBTestServer) acceptConnections() {
                 
                 // Handle connection in goroutine
                 go func(c net.Conn) {
-                        if err := HandlePoBTestRequest(c); err != nil {
+                        if err := handlePoBTestRequest(c, s.probes); err != nil {
                                 log.Printf("⚠️  PoB test handler error: %v", err)
                         }
                 }(conn)
@@ -70,6 +99,9 @@ func (s *PoBTestServer) acceptConnections() {
 // Stop closes the PoB test server gracefully
 func (s *PoBTestServer) Stop() error {
         close(s.done)
+        if s.probes != nil {
+                s.probes.Close()
+        }
         if s.listener != nil {
                 return s.listener.Close()
         }
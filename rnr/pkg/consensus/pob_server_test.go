@@ -0,0 +1,119 @@
+package consensus
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/network"
+)
+
+// TestUDPProbeThroughPoBServer tests that a tester gets a probe key authorized over TCP and measures RTT and loss over UDP
+func TestUDPProbeThroughPoBServer(t *testing.T) {
+	server := NewPoBTestServer(0)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start PoB server: %v", err)
+	}
+	defer server.Stop()
+
+	if server.ProbeResponder() == nil {
+		t.Fatalf("PoB server should start a UDP probe responder")
+	}
+	probePort := server.ProbeResponder().Port()
+
+	addr := server.listener.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	probe, err := requestUDPProbe(conn, addr)
+	if err != nil {
+		t.Fatalf("UDP probe failed: %v", err)
+	}
+	if probe.Received != probe.Sent || probe.PacketLoss != 0 {
+		t.Errorf("Expected no loss on loopback, got %d/%d (%.2f%%)", probe.Received, probe.Sent, probe.PacketLoss)
+	}
+	if probe.MedianRTT <= 0 || probe.MedianRTT > probe.P95RTT || probe.P95RTT > probe.MaxRTT {
+		t.Errorf("Inconsistent RTT distribution: median %.3f, p95 %.3f, max %.3f", probe.MedianRTT, probe.P95RTT, probe.MaxRTT)
+	}
+
+	// Probes under a key the candidate never authorized go unanswered
+	probeAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(probePort))
+	config := network.UDPProbeConfig{Count: 20, Interval: time.Millisecond, Timeout: 200 * time.Millisecond}
+	unauthorized, err := network.ProbeUDP(probeAddr, []byte("not-an-authorized-probe-key"), config)
+	if err != nil {
+		t.Fatalf("ProbeUDP failed: %v", err)
+	}
+	if unauthorized.Received != 0 || unauthorized.PacketLoss != 100 {
+		t.Errorf("Unauthorized probes should all be lost, got %d replies", unauthorized.Received)
+	}
+}
+
+// TestPoBOverEmulatedLinks tests that PoB measurements see an emulated candidate's loss, latency and bandwidth
+func TestPoBOverEmulatedLinks(t *testing.T) {
+	startServer := func(profile network.NetemProfile) *PoBTestServer {
+		server := NewPoBTestServer(0)
+		server.SetNetworkEmulation(network.NewNetemLink(profile))
+		if err := server.Start(); err != nil {
+			t.Fatalf("Failed to start PoB server: %v", err)
+		}
+		t.Cleanup(func() { server.Stop() })
+		return server
+	}
+
+	// A lossy, distant candidate: UDP probes drop and take the link's latency
+	lossy := startServer(network.NetemProfile{Latency: 25 * time.Millisecond, PacketLoss: 5, Seed: 7})
+	addr := lossy.listener.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	probe, err := requestUDPProbe(conn, addr)
+	if err != nil {
+		t.Fatalf("UDP probe failed: %v", err)
+	}
+	if probe.PacketLoss < 2 || probe.PacketLoss > 9 {
+		t.Errorf("Expected about 5%% loss, got %.2f%%", probe.PacketLoss)
+	}
+	if probe.MedianRTT < 25 {
+		t.Errorf("Expected RTT of at least the emulated 25 ms, got %.2f ms", probe.MedianRTT)
+	}
+
+	// A slow candidate's uplink caps what a tester receives from it
+	link := network.NewNetemLink(network.NetemProfile{UploadBandwidth: 2 * 1024 * 1024, Latency: 5 * time.Millisecond, Seed: 1})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		candidate := link.Conn(c)
+		candidate.Write(make([]byte, 1024*1024))
+		candidate.Close()
+	}()
+
+	tester, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer tester.Close()
+	start := time.Now()
+	received, err := io.Copy(io.Discard, tester)
+	if err != nil || received != 1024*1024 {
+		t.Fatalf("Expected 1 MB through the emulated link, got %d bytes: %v", received, err)
+	}
+	mbps := float64(received) / (1024 * 1024) / time.Since(start).Seconds()
+	if mbps > 2.2 || mbps < 1.0 {
+		t.Errorf("Expected about 2 MB/s through the emulated link, got %.2f MB/s", mbps)
+	}
+}
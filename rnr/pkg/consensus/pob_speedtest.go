@@ -10,6 +10,8 @@ import (
         "io"
         "sync"
         "time"
+
+        "rnr-blockchain/pkg/core"
 )
 
 const (
@@ -42,11 +44,13 @@ type SpeedTestResult struct {
         CandidateID        string
         SessionID          string
         UploadBandwidth    float64           // MB/s - Whitepaper: ≥ 7 MB/s
-        Latency            float64           // milliseconds - Whitepaper: ≤ 100 ms
-        PacketLoss         float64           // percentage - Whitepaper: 0.1%
+        Latency            float64           // milliseconds - Whitepaper: ≤ 100 ms (median UDP probe RTT)
+        Jitter             float64           // milliseconds - mean RTT variation between UDP probes
+        PacketLoss         float64           // percentage - Whitepaper: 0.1% (UDP probes lost)
         ChunkTimestamps    []time.Time       // Monotonic timestamps for each chunk
         ReceivedChunks     int
         VerifiedHashes     []string          // BLAKE3 hashes of received chunks
+        MerkleRoot         string            // Merkle root of VerifiedHashes
         PayloadVerified    bool              // True if Merkle root matches
         Timestamp          time.Time
         TesterSignature    []byte
@@ -82,6 +86,21 @@ func NewCryptographicPayloadGenerator(sessionID, testerID string) (*Cryptographi
         }, nil
 }
 
+// NewSeededPayloadGenerator creates a generator whose payload is fixed by seed,
+// so a tester can verify what the candidate uploads without receiving it first
+func NewSeededPayloadGenerator(sessionID, testerID string, seed []byte) *CryptographicPayloadGenerator {
+        hasher := sha256.New()
+        hasher.Write(seed)
+        hasher.Write([]byte(sessionID))
+        hasher.Write([]byte(testerID))
+
+        return &CryptographicPayloadGenerator{
+                seed:      hasher.Sum(nil),
+                sessionID: sessionID,
+                testerID:  testerID,
+        }
+}
+
 // GeneratePayload creates an 8 MB cryptographic payload using AES-CTR keystream
 // This ensures high entropy data that cannot be compressed
 func (cpg *CryptographicPayloadGenerator) GeneratePayload() ([]byte, []string, string, error) {
@@ -161,20 +180,19 @@ type P2PSpeedTestManager struct {
 
 // NewP2PSpeedTestManager creates a new P2P speed test manager
 func NewP2PSpeedTestManager() *P2PSpeedTestManager {
-        zkSystem, err := NewZKProofSystem()
-        if err != nil {
-                fmt.Printf("⚠️  Failed to initialize ZK proof system: %v (using fallback verification)\n", err)
-                zkSystem = nil
-        } else {
-                fmt.Println("✅ ZK-SNARK proof system initialized for P2P speed tests")
-        }
-        
         return &P2PSpeedTestManager{
                 activeSessions: make(map[string]*P2PSpeedTestSession),
-                zkSystem:       zkSystem,
         }
 }
 
+// SetZKProofSystem sets the proof system used to prove and verify aggregated
+// speed test results
+func (psm *P2PSpeedTestManager) SetZKProofSystem(zkSystem *ZKProofSystem) {
+        psm.mu.Lock()
+        defer psm.mu.Unlock()
+        psm.zkSystem = zkSystem
+}
+
 // InitiateP2PSpeedTest starts a P2P speed test session with committee selection
 func (psm *P2PSpeedTestManager) InitiateP2PSpeedTest(candidateID string, allValidators []string) (*P2PSpeedTestSession, error) {
         psm.mu.Lock()
@@ -251,8 +269,11 @@ func (psm *P2PSpeedTestManager) SubmitTesterResult(sessionID string, result *Spe
         return nil
 }
 
-// AggregateP2PResults aggregates results from all committee testers
-func (psm *P2PSpeedTestManager) AggregateP2PResults(sessionID string) (*PoBTestResult, error) {
+// AggregateP2PResults aggregates results from all committee testers. The
+// medians, score and pass/fail outcome against thresholds come from
+// BuildPoBAggregation and are proven by the aggregation circuit when a ZK
+// system is available.
+func (psm *P2PSpeedTestManager) AggregateP2PResults(sessionID string, thresholds *PoBThresholds) (*PoBTestResult, error) {
         psm.mu.RLock()
         defer psm.mu.RUnlock()
 
@@ -265,67 +286,54 @@ func (psm *P2PSpeedTestManager) AggregateP2PResults(sessionID string) (*PoBTestR
                 return nil, fmt.Errorf("no tester results available")
         }
 
-        // Calculate median values to handle outliers (Whitepaper Bab 3.1.3)
-        var uploads, latencies, packetLosses []float64
-        validCount := 0
+        if thresholds == nil {
+                thresholds = &PoBThresholds{
+                        MinUploadBandwidth: core.MinUploadBandwidth,
+                        TargetLatency:      core.TargetLatency,
+                        TargetPacketLoss:   core.TargetPacketLoss,
+                }
+        }
 
+        // Anomalous or unverified results are committed to but excluded from
+        // the medians (Whitepaper Bab 3.1.3)
+        results := make([]*SpeedTestResult, 0, len(session.TesterResults))
         for _, result := range session.TesterResults {
-                // Check for anomalies
                 if len(result.Anomalies) > 0 {
                         fmt.Printf("⚠️  Tester %s detected anomalies: %v\n", result.TesterID, result.Anomalies)
-                        continue
-                }
-
-                // Verify payload integrity
-                if !result.PayloadVerified {
+                } else if !result.PayloadVerified {
                         fmt.Printf("⚠️  Tester %s: payload verification failed\n", result.TesterID)
-                        continue
                 }
-
-                uploads = append(uploads, result.UploadBandwidth)
-                latencies = append(latencies, result.Latency)
-                packetLosses = append(packetLosses, result.PacketLoss)
-                validCount++
+                results = append(results, result)
         }
 
-        // Require at least 2 valid results
-        if validCount < 2 {
-                return nil, fmt.Errorf("insufficient valid results: need 2, got %d", validCount)
+        statement, witness, err := BuildPoBAggregation(sessionID, results, thresholds)
+        if err != nil {
+                return nil, err
         }
 
-        // Calculate median values (Whitepaper: avoid anomalies with median)
-        medianUpload := calculateMedian(uploads)
-        medianLatency := calculateMedian(latencies)
-        medianPacketLoss := calculateMedian(packetLosses)
-
-        // Calculate PoB score
-        score := calculatePoBScore(medianUpload, medianLatency, medianPacketLoss)
-        
         // Generate test data hash for ZK proof
         testDataHash := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", session.CandidateID, session.StartTime.Unix())))
-        
+
         // Create aggregated result
         aggregated := &PoBTestResult{
                 CandidateID:      session.CandidateID,
-                UploadBandwidth:  medianUpload,
-                Latency:          medianLatency,
-                PacketLoss:       medianPacketLoss,
+                UploadBandwidth:  statement.UploadBandwidth(),
+                Latency:          statement.Latency(),
+                PacketLoss:       statement.PacketLoss(),
                 Timestamp:        time.Now(),
-                Passed:           false,
+                Passed:           statement.Passed,
                 TestDataHash:     hex.EncodeToString(testDataHash[:]),
+                Aggregation:      statement,
         }
 
-        // Evaluate if test passed
-        aggregated.Passed = evaluatePoBResult(aggregated)
-        
-        // Generate ZK-SNARK proof if ZK system is available
+        // Prove the aggregation if ZK system is available
         if psm.zkSystem != nil {
-                proof, err := psm.zkSystem.GenerateProof(aggregated, score)
+                proof, err := psm.zkSystem.ProveAggregation(witness)
                 if err != nil {
-                        fmt.Printf("⚠️  ZK proof generation failed: %v (result still valid)\n", err)
+                        fmt.Printf("⚠️  ZK aggregation proof failed: %v (result still valid)\n", err)
                 } else {
-                        aggregated.ZKProof = proof
-                        fmt.Printf("✅ ZK-SNARK proof generated for candidate %s\n", session.CandidateID)
+                        statement.Proof = proof
+                        fmt.Printf("✅ ZK-SNARK aggregation proof generated for candidate %s\n", session.CandidateID)
                 }
         }
 
@@ -455,12 +463,33 @@ func calculatePoBScore(upload, latency, packetLoss float64) float64 {
         return (uploadScore + latencyScore + packetLossScore) / 3.0
 }
 
-// VerifyPoBResultZKProof verifies ZK-SNARK proof for PoB test result
+// VerifyPoBResultZKProof verifies ZK-SNARK proof for PoB test result. Committee
+// results carry an aggregation statement, which must match the result.
 func (psm *P2PSpeedTestManager) VerifyPoBResultZKProof(result *PoBTestResult) (bool, error) {
         if psm.zkSystem == nil {
                 // ZK system not available, skip verification
                 return true, nil
         }
+
+        if agg := result.Aggregation; agg != nil {
+                if agg.UploadBandwidth() != result.UploadBandwidth ||
+                        agg.Latency() != result.Latency ||
+                        agg.PacketLoss() != result.PacketLoss ||
+                        agg.Passed != result.Passed {
+                        return false, fmt.Errorf("result does not match its aggregation statement")
+                }
+
+                valid, err := psm.zkSystem.VerifyAggregation(agg)
+                if err != nil {
+                        return false, fmt.Errorf("ZK aggregation proof verification failed: %w", err)
+                }
+                if !valid {
+                        return false, fmt.Errorf("ZK aggregation proof is invalid")
+                }
+
+                fmt.Printf("✅ ZK-SNARK aggregation proof verified for candidate %s\n", result.CandidateID)
+                return true, nil
+        }
         
         if len(result.ZKProof) == 0 {
                 return false, fmt.Errorf("no ZK proof provided")
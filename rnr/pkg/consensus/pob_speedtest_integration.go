@@ -1,9 +1,15 @@
 package consensus
 
 import (
+        "crypto/ecdsa"
+        "encoding/hex"
         "fmt"
         "log"
+        "math/big"
         "time"
+
+        "rnr-blockchain/pkg/core"
+        "rnr-blockchain/pkg/network"
 )
 
 // RunP2PSpeedTest conducts P2P-based PoB speed test with cryptographic payloads
@@ -130,7 +136,7 @@ func (vs *ValidatorService) RunP2PSpeedTest(candidateID string) (float64, error)
 
 aggregate:
         // Aggregate results using median to handle outliers
-        aggregated, err := vs.p2pSpeedTestMgr.AggregateP2PResults(session.SessionID)
+        aggregated, err := vs.p2pSpeedTestMgr.AggregateP2PResults(session.SessionID, vs.retargetMgr.GetCurrentThresholds())
         if err != nil {
                 return 0, fmt.Errorf("failed to aggregate results: %w", err)
         }
@@ -169,3 +175,237 @@ func (vs *ValidatorService) GetP2PSpeedTestSession(sessionID string) (*P2PSpeedT
 
         return session, nil
 }
+
+// RequestPoBRound submits a request transaction asking the network to test
+// this validator in an on-chain PoB round
+func (vs *ValidatorService) RequestPoBRound() (*core.Transaction, error) {
+        if round := vs.pobRounds.GetOpenRound(vs.validatorID); round != nil {
+                return nil, fmt.Errorf("PoB round %s already in progress", round.ID)
+        }
+
+        payload, err := (&PoBTx{Type: PoBTxRequest}).Marshal()
+        if err != nil {
+                return nil, err
+        }
+
+        tx, err := vs.submitModuleTx(core.PoBModuleAddress, payload)
+        if err != nil {
+                return nil, fmt.Errorf("failed to submit PoB request: %w", err)
+        }
+
+        log.Printf("📶 Requested PoB round (tx %s)", tx.ID[:12])
+        return tx, nil
+}
+
+// testPoBCandidate runs this validator's part of a PoB round as a committee
+// member: a timed challenge over the speed test stream, then a signed result
+// transaction. An unreachable candidate is reported as a failed measurement.
+func (vs *ValidatorService) testPoBCandidate(round *PoBRound) {
+        result := &SpeedTestResult{
+                TesterID:    vs.validatorID,
+                CandidateID: round.CandidateID,
+                SessionID:   round.ID,
+                Anomalies:   []string{},
+        }
+
+        measured, err := vs.challengePoBCandidate(round)
+        if err != nil {
+                log.Printf("⚠️  PoB challenge of %s failed: %v", shortValidatorID(round.CandidateID), err)
+                result.PacketLoss = 100
+                result.Anomalies = append(result.Anomalies, err.Error())
+        } else {
+                result.UploadBandwidth = measured.UploadBandwidth
+                result.Latency = measured.Latency
+                result.Jitter = measured.Jitter
+                result.PacketLoss = measured.PacketLoss
+                result.ChunkTimestamps = measured.ChunkTimestamps
+                result.ReceivedChunks = measured.ReceivedChunks
+                result.VerifiedHashes = measured.VerifiedHashes
+                result.MerkleRoot = calculateMerkleRootFromHashes(measured.VerifiedHashes)
+                result.PayloadVerified = measured.PayloadVerified
+                result.Anomalies = append(result.Anomalies, measured.Anomalies...)
+
+                if sequenceValid, anomalies := VerifyChunkSequence(measured.ChunkTimestamps); !sequenceValid {
+                        result.PayloadVerified = false
+                        result.Anomalies = append(result.Anomalies, anomalies...)
+                }
+        }
+        result.Timestamp = time.Now()
+
+        if err := result.Sign(vs.privateKey); err != nil {
+                log.Printf("❌ Failed to sign PoB result: %v", err)
+                return
+        }
+
+        payload, err := (&PoBTx{Type: PoBTxResult, RoundID: round.ID, Result: result}).Marshal()
+        if err != nil {
+                log.Printf("❌ Failed to encode PoB result: %v", err)
+                return
+        }
+        if _, err := vs.submitModuleTx(core.PoBModuleAddress, payload); err != nil {
+                log.Printf("❌ Failed to submit PoB result for round %s: %v", round.ID, err)
+                return
+        }
+
+        log.Printf("✅ Submitted PoB result for %s (round %s):", shortValidatorID(round.CandidateID), round.ID)
+        log.Printf("   Upload: %.2f MB/s, Latency: %.2f ms, Jitter: %.2f ms, Packet Loss: %.2f%%",
+                result.UploadBandwidth, result.Latency, result.Jitter, result.PacketLoss)
+}
+
+// provePoBRound proves the aggregation of this validator's closed PoB round
+// and submits the proof, which completes the round on-chain
+func (vs *ValidatorService) provePoBRound(roundID string) {
+        proof, err := vs.pobRounds.ProveRound(roundID)
+        if err != nil {
+                log.Printf("❌ Failed to prove PoB round %s: %v", roundID, err)
+                return
+        }
+
+        payload, err := (&PoBTx{Type: PoBTxProof, RoundID: roundID, Proof: proof}).Marshal()
+        if err != nil {
+                log.Printf("❌ Failed to encode PoB proof: %v", err)
+                return
+        }
+        if _, err := vs.submitModuleTx(core.PoBModuleAddress, payload); err != nil {
+                log.Printf("❌ Failed to submit PoB proof for round %s: %v", roundID, err)
+                return
+        }
+
+        log.Printf("🔏 Submitted aggregation proof for PoB round %s", roundID)
+}
+
+// challengePoBCandidate sends the anti-DRDoS challenge to the candidate and
+// measures the payload it uploads in response
+func (vs *ValidatorService) challengePoBCandidate(round *PoBRound) (*network.SpeedTestResult, error) {
+        if vs.p2pNetwork == nil {
+                return nil, fmt.Errorf("P2P network not available")
+        }
+
+        peerID, err := vs.p2pNetwork.GetPeerIDByValidatorID(round.CandidateID)
+        if err != nil {
+                return nil, err
+        }
+
+        _, chunkHashes, merkleRoot, err := round.PayloadGenerator(vs.validatorID).GeneratePayload()
+        if err != nil {
+                return nil, err
+        }
+
+        challenge, err := vs.pobManager.antiDRDoS.GenerateChallenge(round.CandidateID, vs.validatorID)
+        if err != nil {
+                return nil, err
+        }
+
+        request := &network.SpeedTestRequest{
+                SessionID:       round.ID,
+                TesterID:        vs.validatorID,
+                CandidateID:     round.CandidateID,
+                PayloadRootHash: merkleRoot,
+                ExpectedChunks:  NumChunks,
+                ChallengeNonce:  challenge.Nonce,
+                Timestamp:       time.Now(),
+        }
+
+        measured, err := vs.p2pNetwork.ChallengeSpeedTest(peerID, request, chunkHashes, core.PoBChallengeTimeout, core.PoBTransferTimeout)
+        if err != nil {
+                return nil, err
+        }
+
+        if _, err := vs.pobManager.VerifyAndStartTest(challenge.ChallengeID, hex.EncodeToString(measured.ResponseHash)); err != nil {
+                return nil, err
+        }
+
+        return measured, nil
+}
+
+// answerPoBChallenge serves a committee member's challenge while this
+// validator is the candidate of an open round
+func (vs *ValidatorService) answerPoBChallenge(request *network.SpeedTestRequest) ([]byte, func() ([]byte, error), error) {
+        round := vs.pobRounds.GetRound(request.SessionID)
+        if round == nil || round.Status != PoBRoundTesting {
+                return nil, nil, fmt.Errorf("no open PoB round %s", request.SessionID)
+        }
+        if round.CandidateID != vs.validatorID || request.CandidateID != vs.validatorID {
+                return nil, nil, fmt.Errorf("round %s is not testing this validator", round.ID)
+        }
+        if !round.IsCommitteeMember(request.TesterID) {
+                return nil, nil, fmt.Errorf("%s is not in the committee", shortValidatorID(request.TesterID))
+        }
+
+        response := vs.pobManager.antiDRDoS.ComputeChallengeResponse(vs.validatorID, request.TesterID, request.ChallengeNonce)
+        responseHash, err := hex.DecodeString(response)
+        if err != nil {
+                return nil, nil, err
+        }
+
+        payload := func() ([]byte, error) {
+                data, _, _, err := round.PayloadGenerator(request.TesterID).GeneratePayload()
+                return data, err
+        }
+        return responseHash, payload, nil
+}
+
+// submitModuleTx signs a zero-value transaction from this validator to a
+// module address, adds it to the mempool and broadcasts it. Its nonce follows
+// our transactions still pending in the mempool, so a result and a proof, or
+// txs of several rounds in flight, are included one after another instead of
+// competing for the same nonce.
+func (vs *ValidatorService) submitModuleTx(to string, data []byte) (*core.Transaction, error) {
+        vs.txMu.Lock()
+        defer vs.txMu.Unlock()
+
+        account, err := vs.state.GetAccount(vs.validatorID)
+        if err != nil {
+                return nil, err
+        }
+
+        tx := &core.Transaction{
+                From:      vs.validatorID,
+                To:        to,
+                Amount:    big.NewInt(0),
+                Timestamp: time.Now(),
+                Nonce:     vs.mempool.PendingNonce(vs.validatorID, account.Nonce),
+                Data:      data,
+        }
+
+        // Leave headroom for the base fee rising before inclusion
+        baseFee := CalculateNextBaseFee(vs.blockchain.GetLatestBlock().Header)
+        tx.Fee = new(big.Int).Mul(TransactionBaseFee(baseFee, tx), big.NewInt(2))
+
+        txHash, err := tx.Hash()
+        if err != nil {
+                return nil, err
+        }
+        tx.ID = fmt.Sprintf("%x", txHash)
+
+        if err := signTransaction(tx, vs.privateKey); err != nil {
+                return nil, err
+        }
+
+        if err := vs.mempool.AddTransaction(tx); err != nil {
+                return nil, err
+        }
+        if vs.p2pNetwork != nil {
+                if err := vs.p2pNetwork.BroadcastTransaction(tx); err != nil {
+                        log.Printf("⚠️  Failed to broadcast tx %s: %v", tx.ID[:12], err)
+                }
+        }
+
+        return tx, nil
+}
+
+// signTransaction sets tx.Signature to r||s, each padded to 32 bytes
+func signTransaction(tx *core.Transaction, privateKey *ecdsa.PrivateKey) error {
+        txHash, err := tx.Hash()
+        if err != nil {
+                return err
+        }
+
+        signature, err := signDigest(txHash, privateKey)
+        if err != nil {
+                return fmt.Errorf("failed to sign transaction: %w", err)
+        }
+
+        tx.Signature = signature
+        return nil
+}
@@ -3,13 +3,17 @@ package consensus
 import (
         "crypto/rand"
         "crypto/sha256"
+        "encoding/binary"
         "encoding/hex"
         "fmt"
         "io"
+        "log"
         "net"
+        "strconv"
         "time"
 
         "rnr-blockchain/pkg/core"
+        "rnr-blockchain/pkg/network"
 )
 
 type PoBTestResult struct {
@@ -17,6 +21,7 @@ type PoBTestResult struct {
         TesterID         string
         UploadBandwidth  float64 // MB/s - Whitepaper: ≥ 7 MB/s
         Latency          float64 // ms - Whitepaper: ≤ 100 ms
+        Jitter           float64 // ms - mean RTT variation between UDP probes
         PacketLoss       float64 // % - Whitepaper: 0.1%
         TestDataHash     string
         MerkleRoot       string
@@ -24,6 +29,7 @@ type PoBTestResult struct {
         Timestamp        time.Time
         Passed           bool
         ZKProof          []byte
+        Aggregation      *PoBAggregationStatement // Committee median proof (P2P speed tests)
 }
 
 type PoBTestManager struct {
@@ -42,24 +48,21 @@ type PoBTestSession struct {
 }
 
 func NewPoBTestManager() *PoBTestManager {
-        zkSystem, err := NewZKProofSystem()
-        if err != nil {
-                fmt.Printf("⚠️  Failed to initialize ZK proof system: %v (using fallback verification)\n", err)
-                zkSystem = nil
-        } else {
-                fmt.Println("✅ ZK-SNARK proof system initialized")
-        }
-        
         antiDRDoS := NewAntiDRDoSManager()
         fmt.Println("✅ Anti-DRDoS protection enabled")
         
         return &PoBTestManager{
                 activeTests:  make(map[string]*PoBTestSession),
-                zkSystem:     zkSystem,
                 antiDRDoS:    antiDRDoS,
         }
 }
 
+// SetZKProofSystem sets the proof system shared by all nodes (ceremony keys).
+// Until it is set, ZK proofs are neither generated nor verified.
+func (ptm *PoBTestManager) SetZKProofSystem(zkSystem *ZKProofSystem) {
+        ptm.zkSystem = zkSystem
+}
+
 func (ptm *PoBTestManager) InitiateTest(candidateID string, testers []string) (*PoBTestSession, error) {
         for _, testerID := range testers {
                 remaining := ptm.antiDRDoS.GetRemainingTests(testerID)
@@ -136,12 +139,22 @@ func ConductBandwidthTest(candidateAddr string, testData []byte, timeout time.Du
         }
         defer conn.Close()
 
-        // Measure Latency and Packet Loss (Whitepaper Bab 3.1.2)
+        // Measure Latency and Packet Loss (Whitepaper Bab 3.1.2) over UDP, where
+        // losses are not hidden by retransmits
+        probe, err := requestUDPProbe(conn, candidateAddr)
+        if err != nil {
+                log.Printf("⚠️  UDP probe of %s unavailable, measuring over TCP: %v", candidateAddr, err)
+        } else {
+                result.Latency = probe.MedianRTT
+                result.Jitter = probe.Jitter
+                result.PacketLoss = probe.PacketLoss
+        }
+
         numPings := 100
         latencyMeasurements := make([]float64, 0, numPings)
         packetsLost := 0
 
-        for i := 0; i < numPings; i++ {
+        for i := 0; probe == nil && i < numPings; i++ {
                 latencyStart := time.Now()
                 pingData := []byte("PING")
                 _, err = conn.Write(pingData)
@@ -161,18 +174,20 @@ func ConductBandwidthTest(candidateAddr string, testData []byte, timeout time.Du
                 latencyMeasurements = append(latencyMeasurements, float64(latency))
         }
 
-        // Calculate average latency from successful pings
-        avgLatency := 0.0
-        if len(latencyMeasurements) > 0 {
-                for _, lat := range latencyMeasurements {
-                        avgLatency += lat
+        if probe == nil {
+                // Calculate average latency from successful pings
+                avgLatency := 0.0
+                if len(latencyMeasurements) > 0 {
+                        for _, lat := range latencyMeasurements {
+                                avgLatency += lat
+                        }
+                        avgLatency /= float64(len(latencyMeasurements))
                 }
-                avgLatency /= float64(len(latencyMeasurements))
-        }
-        result.Latency = avgLatency
+                result.Latency = avgLatency
 
-        // Calculate packet loss percentage (Whitepaper: Target 0.1%)
-        result.PacketLoss = (float64(packetsLost) / float64(numPings)) * 100.0
+                // Calculate packet loss percentage (Whitepaper: Target 0.1%)
+                result.PacketLoss = (float64(packetsLost) / float64(numPings)) * 100.0
+        }
 
         uploadStart := time.Now()
         totalSent := 0
@@ -340,11 +355,49 @@ func calculateMerkleRoot(data []byte) string {
         return ""
 }
 
+// requestUDPProbe asks the candidate on conn to authorize a fresh probe key
+// on its UDP responder, then probes it
+func requestUDPProbe(conn net.Conn, candidateAddr string) (*network.UDPProbeResult, error) {
+        key := make([]byte, 32)
+        if _, err := rand.Read(key); err != nil {
+                return nil, fmt.Errorf("failed to generate probe key: %w", err)
+        }
+        if _, err := conn.Write(append([]byte("UDPP"), key...)); err != nil {
+                return nil, fmt.Errorf("failed to request probes: %w", err)
+        }
+
+        reply := make([]byte, 6)
+        conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+        defer conn.SetReadDeadline(time.Time{})
+        if _, err := io.ReadFull(conn, reply[:4]); err != nil {
+                return nil, fmt.Errorf("no probe reply: %w", err)
+        }
+        if string(reply[:4]) != "UDPK" {
+                return nil, fmt.Errorf("candidate offers no UDP probes")
+        }
+        if _, err := io.ReadFull(conn, reply[4:]); err != nil {
+                return nil, fmt.Errorf("no probe port: %w", err)
+        }
+
+        host, _, err := net.SplitHostPort(candidateAddr)
+        if err != nil {
+                return nil, fmt.Errorf("invalid candidate address: %w", err)
+        }
+        port := int(binary.BigEndian.Uint16(reply[4:]))
+        return network.ProbeUDP(net.JoinHostPort(host, strconv.Itoa(port)), key, network.DefaultUDPProbeConfig())
+}
+
+// HandlePoBTestRequest answers a tester on conn without UDP probes
 func HandlePoBTestRequest(conn net.Conn) error {
+        return handlePoBTestRequest(conn, nil)
+}
+
+func handlePoBTestRequest(conn net.Conn, probes *network.UDPProbeResponder) error {
         defer conn.Close()
 
         // Phase 1: Respond to PING requests for latency measurement
-        // ConductBandwidthTest sends ~100 PINGs sequentially
+        // ConductBandwidthTest sends ~100 PINGs sequentially, or first asks
+        // with UDPP for a probe key to be authorized on the UDP responder
         pingBuf := make([]byte, 4)
         for {
                 n, err := conn.Read(pingBuf)
@@ -355,7 +408,20 @@ func HandlePoBTestRequest(conn net.Conn) error {
                         return err
                 }
 
-                if n == 4 && string(pingBuf) == "PING" {
+                if n == 4 && string(pingBuf) == "UDPP" {
+                        key := make([]byte, 32)
+                        if _, err := io.ReadFull(conn, key); err != nil {
+                                return err
+                        }
+                        reply := []byte("UDPN")
+                        if probes != nil && probes.Port() > 0 {
+                                probes.Authorize(key, core.PoBTransferTimeout)
+                                reply = binary.BigEndian.AppendUint16([]byte("UDPK"), uint16(probes.Port()))
+                        }
+                        if _, err := conn.Write(reply); err != nil {
+                                return err
+                        }
+                } else if n == 4 && string(pingBuf) == "PING" {
                         // Respond with PONG for latency measurement
                         _, err = conn.Write([]byte("PONG"))
                         if err != nil {
@@ -0,0 +1,86 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// TestProposerRankingMatchesSelection tests that the primary proposer is the head of the ranking
+func TestProposerRankingMatchesSelection(t *testing.T) {
+	validators := []string{"validator_a", "validator_b", "validator_c", "validator_d"}
+	keys := map[string][]byte{
+		"validator_a": []byte("key_a"),
+		"validator_b": []byte("key_b"),
+		"validator_c": []byte("key_c"),
+		"validator_d": []byte("key_d"),
+	}
+	scores := map[string]float64{"validator_a": 0.9, "validator_b": 0.5, "validator_c": 0.7, "validator_d": 0.6}
+
+	seen := make(map[string]bool)
+	for height := uint64(1); height <= 20; height++ {
+		seed := GenerateProposerSeed(height, []byte("parent"))
+
+		ranking, err := PoBWeightedRankProposers(seed, validators, keys, scores)
+		if err != nil {
+			t.Fatalf("Ranking failed: %v", err)
+		}
+		if len(ranking) != len(validators) {
+			t.Fatalf("Expected %d ranked validators, got %d", len(validators), len(ranking))
+		}
+
+		selected, _ := PoBWeightedSelectProposer(seed, validators, keys, scores)
+		if ranking[0] != selected {
+			t.Errorf("Height %d: ranking head %s differs from selected %s", height, ranking[0], selected)
+		}
+		seen[selected] = true
+	}
+
+	if len(seen) < 2 {
+		t.Errorf("Selection should rotate between validators, always got %v", seen)
+	}
+}
+
+// TestProposerSlotStart tests that fallback slots open one timeout apart after the block time
+func TestProposerSlotStart(t *testing.T) {
+	parent := &core.Block{Header: &core.BlockHeader{Timestamp: time.Unix(1000, 0)}}
+
+	primary := ProposerSlotStart(parent, 0)
+	if !primary.Equal(parent.Header.Timestamp.Add(core.BlockTime)) {
+		t.Errorf("Primary slot should start one block time after the parent")
+	}
+	if gap := ProposerSlotStart(parent, 2).Sub(ProposerSlotStart(parent, 1)); gap != core.ProposerFallbackTimeout {
+		t.Errorf("Expected fallback slots %v apart, got %v", core.ProposerFallbackTimeout, gap)
+	}
+}
+
+// TestProposerEligibility tests that only active, unsuspended validators with enough PoB score may propose
+func TestProposerEligibility(t *testing.T) {
+	validators := []*core.ValidatorInfo{
+		{ID: "validator_ok", IsActive: true, PoBScore: 0.9},
+		{ID: "validator_low", IsActive: true, PoBScore: core.MinProposerPoBScore - 0.01},
+		{ID: "validator_jailed", IsActive: true, IsSuspended: true, PoBScore: 0.9},
+	}
+
+	testCases := []struct {
+		proposer string
+		eligible bool
+	}{
+		{"validator_ok", true},
+		{"validator_low", false},
+		{"validator_jailed", false},
+		{"validator_unknown", false},
+	}
+
+	for _, tc := range testCases {
+		block := &core.Block{Header: &core.BlockHeader{Height: 1}, ProposerID: tc.proposer, VRFProof: []byte("proof")}
+		err := VerifyVRFProposerSelection(block, validators, nil)
+		if tc.eligible && err != nil {
+			t.Errorf("%s should be eligible: %v", tc.proposer, err)
+		}
+		if !tc.eligible && err == nil {
+			t.Errorf("%s should not be eligible", tc.proposer)
+		}
+	}
+}
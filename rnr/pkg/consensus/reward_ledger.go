@@ -0,0 +1,343 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"rnr-blockchain/pkg/blockchain"
+	"rnr-blockchain/pkg/core"
+)
+
+// The reward ledger records every block's reward breakdown and accrues the
+// amounts as claimable balances instead of paying them out directly. Minted
+// rewards sit in the reward module account until the recipient submits a
+// withdraw-rewards transaction, so operators can reconcile every payout
+// against the per-block records.
+
+type RewardKind string
+
+const (
+	RewardKindProposer       RewardKind = "proposer"
+	RewardKindPoBContributor RewardKind = "pob_contributor"
+	RewardKindDelegation     RewardKind = "delegation" // Delegator share of a validator's reward
+	RewardKindPriorityFee    RewardKind = "priority_fee"
+)
+
+const RewardTxWithdraw = "withdraw_rewards"
+
+type RewardEntry struct {
+	Recipient    string     `json:"recipient"`
+	ValidatorID  string     `json:"validator_id"` // Validator whose work earned the reward
+	Kind         RewardKind `json:"kind"`
+	NetworkGroup string     `json:"network_group,omitempty"`
+	Amount       *big.Int   `json:"amount"`
+}
+
+type BlockRewardRecord struct {
+	Height         uint64              `json:"height"`
+	ProposerID     string              `json:"proposer_id"`
+	BlockReward    *big.Int            `json:"block_reward"`
+	ProposerReward *big.Int            `json:"proposer_reward"`
+	PoBReward      *big.Int            `json:"pob_reward"`
+	Distributed    *big.Int            `json:"distributed"`
+	GroupTotals    map[string]*big.Int `json:"group_totals"`
+	Entries        []*RewardEntry      `json:"entries"`
+	Timestamp      time.Time           `json:"timestamp"`
+}
+
+// AddEntry appends an entry and keeps the distributed and per-group totals in sync.
+func (r *BlockRewardRecord) AddEntry(entry *RewardEntry) {
+	r.Entries = append(r.Entries, entry)
+	r.Distributed = new(big.Int).Add(valueOrZero(r.Distributed), entry.Amount)
+	if entry.NetworkGroup != "" {
+		if r.GroupTotals == nil {
+			r.GroupTotals = make(map[string]*big.Int)
+		}
+		r.GroupTotals[entry.NetworkGroup] = new(big.Int).Add(valueOrZero(r.GroupTotals[entry.NetworkGroup]), entry.Amount)
+	}
+}
+
+type RewardBalance struct {
+	Address          string   `json:"address"`
+	Accumulated      *big.Int `json:"accumulated"`
+	Withdrawn        *big.Int `json:"withdrawn"`
+	LastRewardHeight uint64   `json:"last_reward_height"`
+}
+
+func (b *RewardBalance) Claimable() *big.Int {
+	return new(big.Int).Sub(b.Accumulated, b.Withdrawn)
+}
+
+// RewardTx is the payload carried in core.Transaction.Data for transactions
+// sent to core.RewardModuleAddress. A nil Amount withdraws everything claimable.
+type RewardTx struct {
+	Type   string   `json:"type"`
+	Amount *big.Int `json:"amount,omitempty"`
+}
+
+func (p *RewardTx) Marshal() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+type RewardLedger struct {
+	db       *leveldb.DB
+	state    *blockchain.State
+	balances map[string]*RewardBalance
+	mu       sync.RWMutex
+}
+
+func NewRewardLedger(db *leveldb.DB, state *blockchain.State) *RewardLedger {
+	rl := &RewardLedger{
+		db:       db,
+		state:    state,
+		balances: make(map[string]*RewardBalance),
+	}
+
+	rl.loadBalances()
+
+	return rl
+}
+
+func (rl *RewardLedger) loadBalances() {
+	iter := rl.db.NewIterator(util.BytesPrefix([]byte("reward_balance_")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var balance RewardBalance
+		if err := json.Unmarshal(iter.Value(), &balance); err == nil {
+			rl.balances[balance.Address] = &balance
+		}
+	}
+
+	log.Printf("💰 Loaded reward ledger: %d accounts with reward balances", len(rl.balances))
+}
+
+// IsRewardTx reports whether tx targets the reward module.
+func IsRewardTx(tx *core.Transaction) bool {
+	return tx.To == core.RewardModuleAddress
+}
+
+func DecodeRewardTx(tx *core.Transaction) (*RewardTx, error) {
+	var payload RewardTx
+	if err := json.Unmarshal(tx.Data, &payload); err != nil {
+		return nil, fmt.Errorf("invalid reward payload: %w", err)
+	}
+	return &payload, nil
+}
+
+// RecordBlockRewards stores the block's reward breakdown, credits each entry
+// to the recipient's claimable balance and mints the distributed total into
+// the reward module account. The record is dated with its block's timestamp,
+// so every node stores the same record. Recording the same height twice is a
+// no-op.
+func (rl *RewardLedger) RecordBlockRewards(record *BlockRewardRecord) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	recordKey := []byte(fmt.Sprintf("reward_block_%d", record.Height))
+	if has, _ := rl.db.Has(recordKey, nil); has {
+		return nil
+	}
+
+	if record.Timestamp.IsZero() {
+		return fmt.Errorf("reward record for block #%d has no block timestamp", record.Height)
+	}
+
+	batch := new(leveldb.Batch)
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reward record: %w", err)
+	}
+	batch.Put(recordKey, recordBytes)
+
+	updated := make(map[string]*RewardBalance)
+	for _, entry := range record.Entries {
+		if entry.Amount.Sign() <= 0 {
+			continue
+		}
+		balance, ok := updated[entry.Recipient]
+		if !ok {
+			balance = rl.copyBalanceLocked(entry.Recipient)
+			updated[entry.Recipient] = balance
+		}
+		balance.Accumulated = new(big.Int).Add(balance.Accumulated, entry.Amount)
+		balance.LastRewardHeight = record.Height
+	}
+
+	for _, balance := range updated {
+		if err := stageRewardBalance(batch, balance); err != nil {
+			return err
+		}
+	}
+
+	distributed := valueOrZero(record.Distributed)
+	if distributed.Sign() > 0 {
+		module, _ := rl.state.GetAccount(core.RewardModuleAddress)
+		if module == nil {
+			return fmt.Errorf("reward module account unavailable")
+		}
+		module.Balance = new(big.Int).Add(module.Balance, distributed)
+		if err := rl.state.UpdateAccount(module); err != nil {
+			return fmt.Errorf("failed to mint block rewards: %w", err)
+		}
+	}
+
+	if err := rl.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to persist reward record: %w", err)
+	}
+
+	for addr, balance := range updated {
+		rl.balances[addr] = balance
+	}
+
+	return nil
+}
+
+// ValidateWithdrawTx checks a withdraw-rewards transaction without mutating state.
+func (rl *RewardLedger) ValidateWithdrawTx(tx *core.Transaction) error {
+	payload, err := DecodeRewardTx(tx)
+	if err != nil {
+		return err
+	}
+	if payload.Type != RewardTxWithdraw {
+		return fmt.Errorf("unknown reward tx type: %s", payload.Type)
+	}
+	if tx.Amount != nil && tx.Amount.Sign() != 0 {
+		return fmt.Errorf("withdraw tx must not transfer funds")
+	}
+
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	balance, ok := rl.balances[tx.From]
+	if !ok || balance.Claimable().Sign() == 0 {
+		return fmt.Errorf("no claimable rewards for %s", tx.From)
+	}
+	if payload.Amount != nil {
+		if payload.Amount.Sign() <= 0 {
+			return fmt.Errorf("withdraw amount must be positive")
+		}
+		if payload.Amount.Cmp(balance.Claimable()) > 0 {
+			return fmt.Errorf("withdraw amount %s exceeds claimable %s",
+				payload.Amount.String(), balance.Claimable().String())
+		}
+	}
+
+	return nil
+}
+
+// ApplyWithdrawTx pays claimable rewards from the reward module account to
+// the sender of a finalized withdraw-rewards transaction.
+func (rl *RewardLedger) ApplyWithdrawTx(tx *core.Transaction, blockHeight uint64) error {
+	if err := rl.ValidateWithdrawTx(tx); err != nil {
+		return err
+	}
+
+	payload, _ := DecodeRewardTx(tx)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	balance := rl.copyBalanceLocked(tx.From)
+	amount := balance.Claimable()
+	if payload.Amount != nil {
+		amount = new(big.Int).Set(payload.Amount)
+	}
+
+	module, _ := rl.state.GetAccount(core.RewardModuleAddress)
+	if module == nil || module.Balance.Cmp(amount) < 0 {
+		return fmt.Errorf("reward module balance below %s", amount.String())
+	}
+	recipient, _ := rl.state.GetAccount(tx.From)
+	if recipient == nil {
+		return fmt.Errorf("recipient account unavailable: %s", tx.From)
+	}
+
+	module.Balance = new(big.Int).Sub(module.Balance, amount)
+	recipient.Balance = new(big.Int).Add(recipient.Balance, amount)
+	if err := rl.state.BatchUpdateAccountsAtomic([]*core.Account{module, recipient}); err != nil {
+		return fmt.Errorf("failed to pay out rewards: %w", err)
+	}
+
+	balance.Withdrawn = new(big.Int).Add(balance.Withdrawn, amount)
+	batch := new(leveldb.Batch)
+	if err := stageRewardBalance(batch, balance); err != nil {
+		return err
+	}
+	if err := rl.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to persist reward balance: %w", err)
+	}
+	rl.balances[tx.From] = balance
+
+	shortID := tx.From
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+	log.Printf("💸 Rewards withdrawn: %s RNR to %s at block #%d", amount.String(), shortID, blockHeight)
+
+	return nil
+}
+
+func (rl *RewardLedger) GetBlockRewards(height uint64) (*BlockRewardRecord, error) {
+	data, err := rl.db.Get([]byte(fmt.Sprintf("reward_block_%d", height)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("no reward record for block %d: %w", height, err)
+	}
+
+	var record BlockRewardRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode reward record: %w", err)
+	}
+	return &record, nil
+}
+
+// GetBalance returns a copy of the address's reward balance (zero if none).
+func (rl *RewardLedger) GetBalance(address string) *RewardBalance {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.copyBalanceLocked(address)
+}
+
+// GetTotalClaimable returns the sum of all unclaimed rewards, which should
+// equal the reward module account balance.
+func (rl *RewardLedger) GetTotalClaimable() *big.Int {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	total := big.NewInt(0)
+	for _, balance := range rl.balances {
+		total.Add(total, balance.Claimable())
+	}
+	return total
+}
+
+func (rl *RewardLedger) copyBalanceLocked(address string) *RewardBalance {
+	if balance, ok := rl.balances[address]; ok {
+		return &RewardBalance{
+			Address:          balance.Address,
+			Accumulated:      new(big.Int).Set(balance.Accumulated),
+			Withdrawn:        new(big.Int).Set(balance.Withdrawn),
+			LastRewardHeight: balance.LastRewardHeight,
+		}
+	}
+	return &RewardBalance{
+		Address:     address,
+		Accumulated: big.NewInt(0),
+		Withdrawn:   big.NewInt(0),
+	}
+}
+
+func stageRewardBalance(batch *leveldb.Batch, balance *RewardBalance) error {
+	data, err := json.Marshal(balance)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reward balance: %w", err)
+	}
+	batch.Put([]byte("reward_balance_"+balance.Address), data)
+	return nil
+}
@@ -0,0 +1,82 @@
+package consensus
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// TestRewardLedgerClaimAndWithdraw tests that rewards accrue as claimable and are paid on withdraw
+func TestRewardLedgerClaimAndWithdraw(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+	state.UpdateAccount(&core.Account{Address: "validator_a", Balance: big.NewInt(0)})
+
+	ledger := NewRewardLedger(db, state)
+
+	blockTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	record := &BlockRewardRecord{Height: 1, ProposerID: "validator_a", Timestamp: blockTime}
+	record.AddEntry(&RewardEntry{Recipient: "validator_a", ValidatorID: "validator_a", Kind: RewardKindProposer, Amount: big.NewInt(800)})
+	record.AddEntry(&RewardEntry{Recipient: "validator_b", ValidatorID: "validator_b", Kind: RewardKindPoBContributor, NetworkGroup: "AS1", Amount: big.NewInt(150)})
+	record.AddEntry(&RewardEntry{Recipient: "validator_c", ValidatorID: "validator_c", Kind: RewardKindPoBContributor, NetworkGroup: "AS1", Amount: big.NewInt(50)})
+
+	if err := ledger.RecordBlockRewards(record); err != nil {
+		t.Fatalf("RecordBlockRewards failed: %v", err)
+	}
+	// Recording the same height again must not double-credit
+	if err := ledger.RecordBlockRewards(record); err != nil {
+		t.Fatalf("RecordBlockRewards (replay) failed: %v", err)
+	}
+
+	if claimable := ledger.GetBalance("validator_a").Claimable(); claimable.Cmp(big.NewInt(800)) != 0 {
+		t.Errorf("Expected 800 claimable, got %s", claimable.String())
+	}
+	if total := ledger.GetTotalClaimable(); total.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("Expected 1000 total claimable, got %s", total.String())
+	}
+
+	stored, err := ledger.GetBlockRewards(1)
+	if err != nil {
+		t.Fatalf("GetBlockRewards failed: %v", err)
+	}
+	if stored.GroupTotals["AS1"].Cmp(big.NewInt(200)) != 0 {
+		t.Errorf("Expected AS1 group total 200, got %s", stored.GroupTotals["AS1"].String())
+	}
+	if !stored.Timestamp.Equal(blockTime) {
+		t.Errorf("Expected the record dated with its block at %v, got %v", blockTime, stored.Timestamp)
+	}
+	if err := ledger.RecordBlockRewards(&BlockRewardRecord{Height: 2, ProposerID: "validator_a"}); err == nil {
+		t.Errorf("Record without a block timestamp should be rejected")
+	}
+
+	payload, _ := (&RewardTx{Type: RewardTxWithdraw, Amount: big.NewInt(300)}).Marshal()
+	withdraw := &core.Transaction{
+		ID:     "withdraw_1",
+		From:   "validator_a",
+		To:     core.RewardModuleAddress,
+		Amount: big.NewInt(0),
+		Fee:    big.NewInt(0),
+		Data:   payload,
+	}
+	if err := ledger.ApplyWithdrawTx(withdraw, 2); err != nil {
+		t.Fatalf("ApplyWithdrawTx failed: %v", err)
+	}
+
+	account, _ := state.GetAccount("validator_a")
+	if account.Balance.Cmp(big.NewInt(300)) != 0 {
+		t.Errorf("Expected balance 300 after withdraw, got %s", account.Balance.String())
+	}
+
+	reloaded := NewRewardLedger(db, state)
+	if claimable := reloaded.GetBalance("validator_a").Claimable(); claimable.Cmp(big.NewInt(500)) != 0 {
+		t.Errorf("Expected 500 claimable after reload, got %s", claimable.String())
+	}
+
+	tooMuch, _ := (&RewardTx{Type: RewardTxWithdraw, Amount: big.NewInt(501)}).Marshal()
+	withdraw.Data = tooMuch
+	if err := reloaded.ValidateWithdrawTx(withdraw); err == nil {
+		t.Errorf("Expected withdraw above claimable to be rejected")
+	}
+}
@@ -8,6 +8,7 @@ import (
         "time"
 
         "github.com/syndtr/goleveldb/leveldb"
+        "rnr-blockchain/pkg/core"
 )
 
 type SlashingReason string
@@ -37,6 +38,8 @@ type SlashingManager struct {
         doubleVoteTracker map[string]map[uint64][]byte
         mu                sync.RWMutex
         suspensionDurations map[SlashingReason]time.Duration
+        slashFractions    map[SlashingReason]int64 // Basis points of delegated stake burned per reason
+        stakingMgr        *StakingManager
 }
 
 func NewSlashingManager(db *leveldb.DB, registry *ValidatorRegistry) *SlashingManager {
@@ -46,6 +49,7 @@ func NewSlashingManager(db *leveldb.DB, registry *ValidatorRegistry) *SlashingMa
                 events:            make([]*SlashingEvent, 0),
                 doubleVoteTracker: make(map[string]map[uint64][]byte),
                 suspensionDurations: make(map[SlashingReason]time.Duration),
+                slashFractions:    make(map[SlashingReason]int64),
         }
 
         sm.initializeSuspensionDurations()
@@ -60,6 +64,20 @@ func (sm *SlashingManager) initializeSuspensionDurations() {
         sm.suspensionDurations[ReasonInvalidBlock] = 24 * time.Hour
         sm.suspensionDurations[ReasonInvalidVote] = 1 * time.Hour
         sm.suspensionDurations[ReasonMaliciousBehavior] = 24 * time.Hour
+
+        sm.slashFractions[ReasonDoubleVoting] = 500 // 5%
+        sm.slashFractions[ReasonDowntime] = 1       // 0.01%
+        sm.slashFractions[ReasonInvalidBlock] = 500
+        sm.slashFractions[ReasonInvalidVote] = 0
+        sm.slashFractions[ReasonMaliciousBehavior] = 1000
+}
+
+// SetStakingManager enables slashing of bonded stake (validator self-bond and
+// delegations) alongside the suspension.
+func (sm *SlashingManager) SetStakingManager(stakingMgr *StakingManager) {
+        sm.mu.Lock()
+        defer sm.mu.Unlock()
+        sm.stakingMgr = stakingMgr
 }
 
 func (sm *SlashingManager) loadSlashingEvents() {
@@ -145,6 +163,12 @@ func (sm *SlashingManager) slashValidator(validatorID string, reason SlashingRea
 
         sm.events = append(sm.events, event)
 
+        if sm.stakingMgr != nil {
+                if _, err := sm.stakingMgr.SlashDelegations(validatorID, sm.slashFractions[reason], blockHeight); err != nil {
+                        log.Printf("⚠️  Failed to slash delegated stake: %v", err)
+                }
+        }
+
         eventBytes, err := json.Marshal(event)
         if err != nil {
                 return err
@@ -165,6 +189,51 @@ func (sm *SlashingManager) slashValidator(validatorID string, reason SlashingRea
         return nil
 }
 
+// JailForDowntime slashes delegated stake of a validator that missed too
+// many blocks in the liveness window, jails it and records the slashing
+// event, dated blockTime so every node records the same event. A failed
+// slash leaves the validator unjailed, to be retried at the next block.
+func (sm *SlashingManager) JailForDowntime(validatorID string, blockHeight uint64, blockTime time.Time, evidence []byte) error {
+        sm.mu.Lock()
+        defer sm.mu.Unlock()
+
+        if sm.stakingMgr != nil {
+                if _, err := sm.stakingMgr.SlashDelegations(validatorID, sm.slashFractions[ReasonDowntime], blockHeight); err != nil {
+                        return fmt.Errorf("failed to slash delegated stake: %w", err)
+                }
+        }
+
+        untilHeight := blockHeight + core.DowntimeJailBlocks
+        if err := sm.registry.JailValidator(validatorID, untilHeight, string(ReasonDowntime)); err != nil {
+                return fmt.Errorf("failed to jail validator: %w", err)
+        }
+
+        event := &SlashingEvent{
+                ValidatorID: validatorID,
+                Reason:      ReasonDowntime,
+                Evidence:    evidence,
+                Timestamp:   blockTime,
+                BlockHeight: blockHeight,
+        }
+        sm.events = append(sm.events, event)
+
+        eventBytes, err := json.Marshal(event)
+        if err != nil {
+                return err
+        }
+
+        key := fmt.Sprintf("slashing_%s_%d", validatorID, blockHeight)
+        return sm.db.Put([]byte(key), eventBytes, nil)
+}
+
+// UnjailValidator releases a jailed validator once its jail period has passed.
+func (sm *SlashingManager) UnjailValidator(validatorID string) error {
+        sm.mu.Lock()
+        defer sm.mu.Unlock()
+
+        return sm.registry.UnjailValidator(validatorID)
+}
+
 func (sm *SlashingManager) GetSlashingHistory(validatorID string) []*SlashingEvent {
         sm.mu.RLock()
         defer sm.mu.RUnlock()
@@ -188,7 +257,8 @@ func (sm *SlashingManager) CheckAndClearExpiredSuspensions() int {
 
         validators := sm.registry.GetAllValidators()
         for _, validator := range validators {
-                if validator.IsSuspended && now.After(validator.SuspensionEndTime) {
+                // Jailed validators must unjail explicitly
+                if validator.IsSuspended && !validator.IsJailed && now.After(validator.SuspensionEndTime) {
                         if err := sm.registry.ClearSuspension(validator.ID); err == nil {
                                 shortID := validator.ID
                                 if len(validator.ID) > 12 {
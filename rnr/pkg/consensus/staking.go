@@ -0,0 +1,634 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"rnr-blockchain/pkg/blockchain"
+	"rnr-blockchain/pkg/core"
+)
+
+// Delegated staking lets token holders without PoB-qualifying hardware bond
+// tokens to a validator. Bonded tokens are escrowed in the staking module
+// account; delegators share the validator's rewards pro rata after commission,
+// are slashed alongside it, and withdraw through an unbonding queue.
+
+type StakingTxType string
+
+const (
+	StakingTxDelegate      StakingTxType = "delegate"
+	StakingTxUndelegate    StakingTxType = "undelegate"
+	StakingTxSetCommission StakingTxType = "set_commission"
+)
+
+// StakingTx is the payload carried in core.Transaction.Data for transactions
+// sent to core.StakingModuleAddress. For delegations the bonded amount is the
+// transaction Amount itself, so the transfer escrows the funds.
+type StakingTx struct {
+	Type                 StakingTxType `json:"type"`
+	ValidatorID          string        `json:"validator_id"`
+	Amount               *big.Int      `json:"amount,omitempty"`
+	CommissionBasisPoint int64         `json:"commission_basis_point,omitempty"`
+}
+
+type Delegation struct {
+	DelegatorID    string   `json:"delegator_id"`
+	ValidatorID    string   `json:"validator_id"`
+	Amount         *big.Int `json:"amount"`
+	CreationHeight uint64   `json:"creation_height"`
+}
+
+type UnbondingEntry struct {
+	DelegatorID      string   `json:"delegator_id"`
+	ValidatorID      string   `json:"validator_id"`
+	Amount           *big.Int `json:"amount"`
+	CreationHeight   uint64   `json:"creation_height"`
+	CompletionHeight uint64   `json:"completion_height"`
+	TxID             string   `json:"tx_id,omitempty"` // Undelegation that queued the entry
+}
+
+type StakingManager struct {
+	db          *leveldb.DB
+	state       *blockchain.State
+	delegations map[string]map[string]*Delegation // validatorID -> delegatorID -> delegation
+	unbonding   []*UnbondingEntry
+	commissions map[string]int64 // validatorID -> commission in basis points
+	mu          sync.RWMutex
+}
+
+func NewStakingManager(db *leveldb.DB, state *blockchain.State) *StakingManager {
+	sm := &StakingManager{
+		db:          db,
+		state:       state,
+		delegations: make(map[string]map[string]*Delegation),
+		unbonding:   make([]*UnbondingEntry, 0),
+		commissions: make(map[string]int64),
+	}
+
+	sm.loadStakingState()
+
+	return sm
+}
+
+func (sm *StakingManager) loadStakingState() {
+	iter := sm.db.NewIterator(util.BytesPrefix([]byte("delegation_")), nil)
+	for iter.Next() {
+		var d Delegation
+		if err := json.Unmarshal(iter.Value(), &d); err == nil {
+			if _, ok := sm.delegations[d.ValidatorID]; !ok {
+				sm.delegations[d.ValidatorID] = make(map[string]*Delegation)
+			}
+			sm.delegations[d.ValidatorID][d.DelegatorID] = &d
+		}
+	}
+	iter.Release()
+
+	iter = sm.db.NewIterator(util.BytesPrefix([]byte("unbonding_")), nil)
+	for iter.Next() {
+		var e UnbondingEntry
+		if err := json.Unmarshal(iter.Value(), &e); err == nil {
+			sm.unbonding = append(sm.unbonding, &e)
+		}
+	}
+	iter.Release()
+	sm.sortUnbonding()
+
+	iter = sm.db.NewIterator(util.BytesPrefix([]byte("commission_")), nil)
+	for iter.Next() {
+		var bp int64
+		if err := json.Unmarshal(iter.Value(), &bp); err == nil {
+			sm.commissions[strings.TrimPrefix(string(iter.Key()), "commission_")] = bp
+		}
+	}
+	iter.Release()
+
+	log.Printf("🥩 Loaded staking state: %d validators with delegations, %d unbonding entries",
+		len(sm.delegations), len(sm.unbonding))
+}
+
+// IsStakingTx reports whether tx targets the staking module.
+func IsStakingTx(tx *core.Transaction) bool {
+	return tx.To == core.StakingModuleAddress
+}
+
+func DecodeStakingTx(tx *core.Transaction) (*StakingTx, error) {
+	var payload StakingTx
+	if err := json.Unmarshal(tx.Data, &payload); err != nil {
+		return nil, fmt.Errorf("invalid staking payload: %w", err)
+	}
+	return &payload, nil
+}
+
+func (p *StakingTx) Marshal() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// ValidateStakingTx checks a staking transaction against current staking state
+// without mutating anything. Used during block building and validation.
+func (sm *StakingManager) ValidateStakingTx(tx *core.Transaction) error {
+	payload, err := DecodeStakingTx(tx)
+	if err != nil {
+		return err
+	}
+
+	if payload.ValidatorID == "" {
+		return fmt.Errorf("staking tx missing validator ID")
+	}
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	switch payload.Type {
+	case StakingTxDelegate:
+		if tx.Amount == nil || tx.Amount.Cmp(core.MinDelegationAmount) < 0 {
+			return fmt.Errorf("delegation below minimum of %s", core.MinDelegationAmount.String())
+		}
+		if _, err := sm.state.GetValidator(payload.ValidatorID); err != nil {
+			return fmt.Errorf("unknown validator: %s", payload.ValidatorID)
+		}
+	case StakingTxUndelegate:
+		if tx.Amount != nil && tx.Amount.Sign() != 0 {
+			return fmt.Errorf("undelegate tx must not transfer funds")
+		}
+		if payload.Amount == nil || payload.Amount.Sign() <= 0 {
+			return fmt.Errorf("undelegate amount must be positive")
+		}
+		d := sm.delegations[payload.ValidatorID][tx.From]
+		if d == nil || d.Amount.Cmp(payload.Amount) < 0 {
+			return fmt.Errorf("insufficient bonded amount for undelegation")
+		}
+		if sm.countUnbonding(tx.From, payload.ValidatorID) >= core.MaxUnbondingEntries {
+			return fmt.Errorf("too many pending unbonding entries")
+		}
+	case StakingTxSetCommission:
+		if tx.From != payload.ValidatorID {
+			return fmt.Errorf("only the validator can set its commission")
+		}
+		if payload.CommissionBasisPoint < 0 || payload.CommissionBasisPoint > core.MaxCommissionBasisPoint {
+			return fmt.Errorf("commission out of range: %d", payload.CommissionBasisPoint)
+		}
+	default:
+		return fmt.Errorf("unknown staking tx type: %s", payload.Type)
+	}
+
+	return nil
+}
+
+// ApplyStakingTx records the effect of a finalized staking transaction.
+// The token transfer into the module account has already been applied.
+func (sm *StakingManager) ApplyStakingTx(tx *core.Transaction, blockHeight uint64) error {
+	if err := sm.ValidateStakingTx(tx); err != nil {
+		return err
+	}
+
+	payload, _ := DecodeStakingTx(tx)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	batch := new(leveldb.Batch)
+
+	switch payload.Type {
+	case StakingTxDelegate:
+		if _, ok := sm.delegations[payload.ValidatorID]; !ok {
+			sm.delegations[payload.ValidatorID] = make(map[string]*Delegation)
+		}
+		d, ok := sm.delegations[payload.ValidatorID][tx.From]
+		if !ok {
+			d = &Delegation{
+				DelegatorID:    tx.From,
+				ValidatorID:    payload.ValidatorID,
+				Amount:         big.NewInt(0),
+				CreationHeight: blockHeight,
+			}
+			sm.delegations[payload.ValidatorID][tx.From] = d
+		}
+		d.Amount = new(big.Int).Add(d.Amount, tx.Amount)
+		if err := sm.stageDelegation(batch, d); err != nil {
+			return err
+		}
+
+		log.Printf("🥩 Delegated %s RNR from %s to validator %s",
+			tx.Amount.String(), shortValidatorID(tx.From), shortValidatorID(payload.ValidatorID))
+
+	case StakingTxUndelegate:
+		d := sm.delegations[payload.ValidatorID][tx.From]
+		d.Amount = new(big.Int).Sub(d.Amount, payload.Amount)
+		if err := sm.stageDelegation(batch, d); err != nil {
+			return err
+		}
+		sm.setDelegationLocked(d)
+
+		entry := &UnbondingEntry{
+			DelegatorID:      tx.From,
+			ValidatorID:      payload.ValidatorID,
+			Amount:           new(big.Int).Set(payload.Amount),
+			CreationHeight:   blockHeight,
+			CompletionHeight: blockHeight + core.UnbondingPeriodBlocks,
+			TxID:             tx.ID,
+		}
+		if err := sm.stageUnbonding(batch, entry); err != nil {
+			return err
+		}
+		sm.unbonding = append(sm.unbonding, entry)
+		sm.sortUnbonding()
+
+		log.Printf("⏳ Undelegation queued: %s RNR from %s, matures at block #%d",
+			payload.Amount.String(), shortValidatorID(tx.From), entry.CompletionHeight)
+
+	case StakingTxSetCommission:
+		sm.commissions[payload.ValidatorID] = payload.CommissionBasisPoint
+		bpBytes, _ := json.Marshal(payload.CommissionBasisPoint)
+		batch.Put([]byte("commission_"+payload.ValidatorID), bpBytes)
+	}
+
+	return sm.db.Write(batch, nil)
+}
+
+// RefundStakingTx returns the funds a staking transaction transferred into
+// the module account when the transaction could not be applied, so they are
+// not stranded there
+func (sm *StakingManager) RefundStakingTx(tx *core.Transaction) error {
+	if tx.Amount == nil || tx.Amount.Sign() <= 0 {
+		return nil
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.moveFromModule(tx.From, tx.Amount)
+}
+
+// ProcessMatureUnbondings releases unbonding entries whose completion height
+// has been reached, moving funds from the module account back to delegators.
+// Mature entries are deleted before any funds move, so an entry whose delete
+// failed is never paid twice; one the module account cannot pay is stored
+// again and retried at a later block.
+func (sm *StakingManager) ProcessMatureUnbondings(blockHeight uint64) (int, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	batch := new(leveldb.Batch)
+	remaining := make([]*UnbondingEntry, 0, len(sm.unbonding))
+	mature := make([]*UnbondingEntry, 0)
+	for _, entry := range sm.unbonding {
+		if entry.CompletionHeight > blockHeight {
+			remaining = append(remaining, entry)
+			continue
+		}
+		batch.Delete(unbondingKey(entry))
+		mature = append(mature, entry)
+	}
+
+	if len(mature) == 0 {
+		return 0, nil
+	}
+	if err := sm.db.Write(batch, nil); err != nil {
+		return 0, fmt.Errorf("failed to delete mature unbonding entries: %w", err)
+	}
+
+	restore := new(leveldb.Batch)
+	released := 0
+	for _, entry := range mature {
+		if entry.Amount.Sign() > 0 {
+			if err := sm.moveFromModule(entry.DelegatorID, entry.Amount); err != nil {
+				log.Printf("⚠️  Failed to release unbonding for %s: %v", shortValidatorID(entry.DelegatorID), err)
+				if err := sm.stageUnbonding(restore, entry); err != nil {
+					return released, err
+				}
+				remaining = append(remaining, entry)
+				continue
+			}
+		}
+		released++
+	}
+	sm.unbonding = remaining
+	sm.sortUnbonding()
+
+	if restore.Len() > 0 {
+		if err := sm.db.Write(restore, nil); err != nil {
+			return released, fmt.Errorf("failed to restore unreleased unbonding entries: %w", err)
+		}
+	}
+
+	log.Printf("🔓 Released %d mature unbonding entries at block #%d", released, blockHeight)
+	return released, nil
+}
+
+// SplitValidatorReward divides a reward earned by validatorID between the
+// validator and its delegators. The validator takes its commission first and
+// the remainder is shared pro rata by bonded amount; the validator's own bond
+// (if any) is just another delegation. Rounding dust goes to the validator.
+func (sm *StakingManager) SplitValidatorReward(validatorID string, reward *big.Int) map[string]*big.Int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	payouts := make(map[string]*big.Int)
+	if reward == nil || reward.Sign() <= 0 {
+		return payouts
+	}
+
+	totalBonded := sm.totalBondedLocked(validatorID)
+	if totalBonded.Sign() == 0 {
+		payouts[validatorID] = new(big.Int).Set(reward)
+		return payouts
+	}
+
+	commission := new(big.Int).Mul(reward, big.NewInt(sm.commissionLocked(validatorID)))
+	commission.Div(commission, big.NewInt(core.MaxCommissionBasisPoint))
+	distributable := new(big.Int).Sub(reward, commission)
+
+	distributed := big.NewInt(0)
+	for _, delegatorID := range sm.sortedDelegatorsLocked(validatorID) {
+		d := sm.delegations[validatorID][delegatorID]
+		if d.Amount.Sign() == 0 {
+			continue
+		}
+		share := new(big.Int).Mul(distributable, d.Amount)
+		share.Div(share, totalBonded)
+		if share.Sign() == 0 {
+			continue
+		}
+		payouts[delegatorID] = new(big.Int).Add(valueOrZero(payouts[delegatorID]), share)
+		distributed.Add(distributed, share)
+	}
+
+	validatorCut := new(big.Int).Sub(reward, distributed)
+	payouts[validatorID] = new(big.Int).Add(valueOrZero(payouts[validatorID]), validatorCut)
+
+	return payouts
+}
+
+// SlashDelegations burns fractionBasisPoints of every bond and pending
+// unbonding entry of validatorID. Returns the total amount burned.
+func (sm *StakingManager) SlashDelegations(validatorID string, fractionBasisPoints int64, infractionHeight uint64) (*big.Int, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	burned := big.NewInt(0)
+	if fractionBasisPoints <= 0 {
+		return burned, nil
+	}
+
+	batch := new(leveldb.Batch)
+	slash := func(amount *big.Int) *big.Int {
+		cut := new(big.Int).Mul(amount, big.NewInt(fractionBasisPoints))
+		cut.Div(cut, big.NewInt(core.MaxCommissionBasisPoint))
+		burned.Add(burned, cut)
+		return new(big.Int).Sub(amount, cut)
+	}
+
+	// Slashed copies are staged; the stored bonds change only once the
+	// burn is known to be covered and the batch is written
+	delegations := make([]*Delegation, 0, len(sm.delegations[validatorID]))
+	for _, delegatorID := range sm.sortedDelegatorsLocked(validatorID) {
+		slashed := *sm.delegations[validatorID][delegatorID]
+		slashed.Amount = slash(slashed.Amount)
+		if err := sm.stageDelegation(batch, &slashed); err != nil {
+			return nil, err
+		}
+		delegations = append(delegations, &slashed)
+	}
+
+	// Tokens that were still bonded when the infraction happened remain slashable
+	entries := make([]*UnbondingEntry, 0)
+	amounts := make([]*big.Int, 0)
+	for _, entry := range sm.unbonding {
+		if entry.ValidatorID != validatorID || entry.CreationHeight < infractionHeight {
+			continue
+		}
+		slashed := *entry
+		slashed.Amount = slash(entry.Amount)
+		if err := sm.stageUnbonding(batch, &slashed); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		amounts = append(amounts, slashed.Amount)
+	}
+
+	var module *core.Account
+	if burned.Sign() > 0 {
+		module, _ = sm.state.GetAccount(core.StakingModuleAddress)
+		if module == nil || module.Balance.Cmp(burned) < 0 {
+			return nil, fmt.Errorf("staking module balance below slashed amount")
+		}
+	}
+
+	if err := sm.db.Write(batch, nil); err != nil {
+		return nil, fmt.Errorf("failed to persist slashed delegations: %w", err)
+	}
+
+	if module != nil {
+		module.Balance = new(big.Int).Sub(module.Balance, burned)
+		sm.state.UpdateAccount(module)
+	}
+	for _, d := range delegations {
+		sm.setDelegationLocked(d)
+	}
+	for i, entry := range entries {
+		entry.Amount = amounts[i]
+	}
+
+	log.Printf("🔥 Slashed %s RNR of delegated stake for validator %s (%d bp)",
+		burned.String(), shortValidatorID(validatorID), fractionBasisPoints)
+
+	return burned, nil
+}
+
+// BondGenesisStake credits the stake assigned to a validator in the genesis
+// config to the staking module account and records it as the validator's
+// self-bond. It runs at most once per validator.
+func (sm *StakingManager) BondGenesisStake(validatorID string, amount *big.Int) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	markerKey := []byte("genesis_stake_" + validatorID)
+	if has, _ := sm.db.Has(markerKey, nil); has {
+		return nil
+	}
+
+	module, _ := sm.state.GetAccount(core.StakingModuleAddress)
+	if module == nil {
+		return fmt.Errorf("staking module account unavailable")
+	}
+	module.Balance = new(big.Int).Add(module.Balance, amount)
+	if err := sm.state.UpdateAccount(module); err != nil {
+		return fmt.Errorf("failed to fund staking module: %w", err)
+	}
+
+	if _, ok := sm.delegations[validatorID]; !ok {
+		sm.delegations[validatorID] = make(map[string]*Delegation)
+	}
+	d, ok := sm.delegations[validatorID][validatorID]
+	if !ok {
+		d = &Delegation{
+			DelegatorID: validatorID,
+			ValidatorID: validatorID,
+			Amount:      big.NewInt(0),
+		}
+		sm.delegations[validatorID][validatorID] = d
+	}
+	d.Amount = new(big.Int).Add(d.Amount, amount)
+
+	batch := new(leveldb.Batch)
+	if err := sm.stageDelegation(batch, d); err != nil {
+		return err
+	}
+	batch.Put(markerKey, []byte{1})
+	if err := sm.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to persist genesis stake: %w", err)
+	}
+
+	log.Printf("🥩 Bonded genesis stake of %s RNR for validator %s", amount.String(), shortValidatorID(validatorID))
+	return nil
+}
+
+func (sm *StakingManager) GetDelegation(delegatorID, validatorID string) *Delegation {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.delegations[validatorID][delegatorID]
+}
+
+func (sm *StakingManager) GetTotalBonded(validatorID string) *big.Int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.totalBondedLocked(validatorID)
+}
+
+func (sm *StakingManager) GetUnbondingEntries(delegatorID string) []*UnbondingEntry {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	entries := make([]*UnbondingEntry, 0)
+	for _, entry := range sm.unbonding {
+		if entry.DelegatorID == delegatorID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (sm *StakingManager) GetCommission(validatorID string) int64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.commissionLocked(validatorID)
+}
+
+func (sm *StakingManager) commissionLocked(validatorID string) int64 {
+	if bp, ok := sm.commissions[validatorID]; ok {
+		return bp
+	}
+	return core.DefaultCommissionBasisPoint
+}
+
+func (sm *StakingManager) totalBondedLocked(validatorID string) *big.Int {
+	total := big.NewInt(0)
+	for _, d := range sm.delegations[validatorID] {
+		total.Add(total, d.Amount)
+	}
+	return total
+}
+
+// sortedDelegatorsLocked gives a deterministic iteration order so that every
+// node rounds rewards and slashes identically.
+func (sm *StakingManager) sortedDelegatorsLocked(validatorID string) []string {
+	ids := make([]string, 0, len(sm.delegations[validatorID]))
+	for id := range sm.delegations[validatorID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (sm *StakingManager) countUnbonding(delegatorID, validatorID string) int {
+	count := 0
+	for _, entry := range sm.unbonding {
+		if entry.DelegatorID == delegatorID && entry.ValidatorID == validatorID {
+			count++
+		}
+	}
+	return count
+}
+
+func (sm *StakingManager) sortUnbonding() {
+	sort.SliceStable(sm.unbonding, func(i, j int) bool {
+		return sm.unbonding[i].CompletionHeight < sm.unbonding[j].CompletionHeight
+	})
+}
+
+func (sm *StakingManager) moveFromModule(to string, amount *big.Int) error {
+	module, _ := sm.state.GetAccount(core.StakingModuleAddress)
+	if module == nil || module.Balance.Cmp(amount) < 0 {
+		return fmt.Errorf("staking module balance below %s", amount.String())
+	}
+	recipient, _ := sm.state.GetAccount(to)
+	if recipient == nil {
+		return fmt.Errorf("recipient account unavailable: %s", to)
+	}
+
+	module.Balance = new(big.Int).Sub(module.Balance, amount)
+	recipient.Balance = new(big.Int).Add(recipient.Balance, amount)
+
+	return sm.state.BatchUpdateAccountsAtomic([]*core.Account{module, recipient})
+}
+
+func (sm *StakingManager) stageDelegation(batch *leveldb.Batch, d *Delegation) error {
+	key := []byte(fmt.Sprintf("delegation_%s_%s", d.ValidatorID, d.DelegatorID))
+	if d.Amount.Sign() == 0 {
+		batch.Delete(key)
+		return nil
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delegation: %w", err)
+	}
+	batch.Put(key, data)
+	return nil
+}
+
+// setDelegationLocked stores d in memory, dropping it once nothing is bonded
+func (sm *StakingManager) setDelegationLocked(d *Delegation) {
+	if d.Amount.Sign() == 0 {
+		delete(sm.delegations[d.ValidatorID], d.DelegatorID)
+		return
+	}
+	if _, ok := sm.delegations[d.ValidatorID]; !ok {
+		sm.delegations[d.ValidatorID] = make(map[string]*Delegation)
+	}
+	sm.delegations[d.ValidatorID][d.DelegatorID] = d
+}
+
+func (sm *StakingManager) stageUnbonding(batch *leveldb.Batch, entry *UnbondingEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal unbonding entry: %w", err)
+	}
+	batch.Put(unbondingKey(entry), data)
+	return nil
+}
+
+// unbondingKey is unique per undelegation, so several undelegations of a
+// delegator in one block each keep their entry
+func unbondingKey(entry *UnbondingEntry) []byte {
+	return []byte(fmt.Sprintf("unbonding_%020d_%s_%s_%d_%s",
+		entry.CompletionHeight, entry.DelegatorID, entry.ValidatorID, entry.CreationHeight, entry.TxID))
+}
+
+func valueOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return big.NewInt(0)
+	}
+	return v
+}
+
+func shortValidatorID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
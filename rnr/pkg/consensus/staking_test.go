@@ -0,0 +1,231 @@
+package consensus
+
+import (
+	"math/big"
+	"testing"
+
+	"rnr-blockchain/pkg/blockchain"
+	"rnr-blockchain/pkg/core"
+)
+
+// bondViaTx mirrors what finalizeBlock does for a staking tx: the transfer
+// into the module account followed by ApplyStakingTx.
+func bondViaTx(t *testing.T, state *blockchain.State, sm *StakingManager, tx *core.Transaction, height uint64) {
+	from, _ := state.GetAccount(tx.From)
+	module, _ := state.GetAccount(core.StakingModuleAddress)
+	from.Balance = new(big.Int).Sub(from.Balance, tx.Amount)
+	module.Balance = new(big.Int).Add(module.Balance, tx.Amount)
+	state.UpdateAccount(from)
+	state.UpdateAccount(module)
+
+	if err := sm.ApplyStakingTx(tx, height); err != nil {
+		t.Fatalf("ApplyStakingTx failed: %v", err)
+	}
+}
+
+func newStakingTx(t *testing.T, from string, amount int64, payload *StakingTx) *core.Transaction {
+	data, err := payload.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal staking payload: %v", err)
+	}
+	return &core.Transaction{
+		ID:     from + string(payload.Type),
+		From:   from,
+		To:     core.StakingModuleAddress,
+		Amount: big.NewInt(amount),
+		Fee:    big.NewInt(0),
+		Data:   data,
+	}
+}
+
+// TestDelegationLifecycle covers delegate -> reward split -> undelegate -> maturity -> slash
+func TestDelegationLifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	_, validator := createTestValidator("validator_staking")
+	state.UpdateValidator(validator)
+	state.UpdateAccount(&core.Account{Address: "delegator_1", Balance: big.NewInt(1000)})
+
+	sm := NewStakingManager(db, state)
+
+	delegate := newStakingTx(t, "delegator_1", 600, &StakingTx{Type: StakingTxDelegate, ValidatorID: validator.ID})
+	bondViaTx(t, state, sm, delegate, 10)
+
+	if bonded := sm.GetTotalBonded(validator.ID); bonded.Cmp(big.NewInt(600)) != 0 {
+		t.Errorf("Expected 600 bonded, got %s", bonded.String())
+	}
+
+	// Default commission is 10%: validator keeps 100, delegator gets the rest
+	payouts := sm.SplitValidatorReward(validator.ID, big.NewInt(1000))
+	if payouts[validator.ID].Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("Expected validator commission 100, got %s", payouts[validator.ID].String())
+	}
+	if payouts["delegator_1"].Cmp(big.NewInt(900)) != 0 {
+		t.Errorf("Expected delegator share 900, got %s", payouts["delegator_1"].String())
+	}
+
+	undelegate := newStakingTx(t, "delegator_1", 0, &StakingTx{
+		Type:        StakingTxUndelegate,
+		ValidatorID: validator.ID,
+		Amount:      big.NewInt(200),
+	})
+	if err := sm.ApplyStakingTx(undelegate, 20); err != nil {
+		t.Fatalf("Undelegate failed: %v", err)
+	}
+
+	if released, err := sm.ProcessMatureUnbondings(100); err != nil || released != 0 {
+		t.Errorf("Unbonding released before completion height: %d, %v", released, err)
+	}
+
+	// An entry the module account cannot pay stays queued, also on disk
+	module, _ := state.GetAccount(core.StakingModuleAddress)
+	funded := module.Balance
+	module.Balance = big.NewInt(10)
+	state.UpdateAccount(module)
+	if released, err := sm.ProcessMatureUnbondings(20 + core.UnbondingPeriodBlocks); err != nil || released != 0 {
+		t.Errorf("Unpayable unbonding should not be released, got %d, %v", released, err)
+	}
+	if entries := NewStakingManager(db, state).GetUnbondingEntries("delegator_1"); len(entries) != 1 {
+		t.Errorf("Expected the unpaid unbonding entry stored, got %d", len(entries))
+	}
+	module.Balance = funded
+	state.UpdateAccount(module)
+
+	if released, err := sm.ProcessMatureUnbondings(20 + core.UnbondingPeriodBlocks); err != nil || released != 1 {
+		t.Errorf("Expected 1 released unbonding entry, got %d, %v", released, err)
+	}
+	if entries := NewStakingManager(db, state).GetUnbondingEntries("delegator_1"); len(entries) != 0 {
+		t.Errorf("Released unbonding entry should be deleted, got %d", len(entries))
+	}
+
+	account, _ := state.GetAccount("delegator_1")
+	if account.Balance.Cmp(big.NewInt(600)) != 0 {
+		t.Errorf("Expected delegator balance 600 after unbonding, got %s", account.Balance.String())
+	}
+
+	// A burn the module account cannot cover leaves every bond as it was
+	module, _ = state.GetAccount(core.StakingModuleAddress)
+	funded = module.Balance
+	module.Balance = big.NewInt(10)
+	state.UpdateAccount(module)
+	if _, err := sm.SlashDelegations(validator.ID, 1000, 0); err == nil {
+		t.Errorf("Slash beyond the module balance should fail")
+	}
+	if d := sm.GetDelegation("delegator_1", validator.ID); d == nil || d.Amount.Cmp(big.NewInt(400)) != 0 {
+		t.Errorf("Expected 400 still bonded after a failed slash, got %v", d)
+	}
+	if bonded := NewStakingManager(db, state).GetTotalBonded(validator.ID); bonded.Cmp(big.NewInt(400)) != 0 {
+		t.Errorf("Expected 400 stored after a failed slash, got %s", bonded.String())
+	}
+	module.Balance = funded
+	state.UpdateAccount(module)
+
+	burned, err := sm.SlashDelegations(validator.ID, 1000, 0)
+	if err != nil {
+		t.Fatalf("SlashDelegations failed: %v", err)
+	}
+	if burned.Cmp(big.NewInt(40)) != 0 {
+		t.Errorf("Expected 40 burned, got %s", burned.String())
+	}
+	if d := sm.GetDelegation("delegator_1", validator.ID); d == nil || d.Amount.Cmp(big.NewInt(360)) != 0 {
+		t.Errorf("Expected 360 bonded after slash, got %v", d)
+	}
+
+	// Staking state survives a restart
+	reloaded := NewStakingManager(db, state)
+	if bonded := reloaded.GetTotalBonded(validator.ID); bonded.Cmp(big.NewInt(360)) != 0 {
+		t.Errorf("Expected 360 bonded after reload, got %s", bonded.String())
+	}
+}
+
+// TestStakingTxValidation tests rejection of malformed staking transactions
+func TestStakingTxValidation(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	_, validator := createTestValidator("validator_staking")
+	state.UpdateValidator(validator)
+
+	sm := NewStakingManager(db, state)
+
+	testCases := []struct {
+		tx          *core.Transaction
+		description string
+	}{
+		{newStakingTx(t, "delegator_1", 100, &StakingTx{Type: StakingTxDelegate, ValidatorID: "unknown"}), "unknown validator"},
+		{newStakingTx(t, "delegator_1", 0, &StakingTx{Type: StakingTxDelegate, ValidatorID: validator.ID}), "zero delegation"},
+		{newStakingTx(t, "delegator_1", 0, &StakingTx{Type: StakingTxUndelegate, ValidatorID: validator.ID, Amount: big.NewInt(1)}), "undelegate without bond"},
+		{newStakingTx(t, "delegator_1", 0, &StakingTx{Type: StakingTxSetCommission, ValidatorID: validator.ID, CommissionBasisPoint: 500}), "commission set by non-validator"},
+		{newStakingTx(t, validator.ID, 0, &StakingTx{Type: StakingTxSetCommission, ValidatorID: validator.ID, CommissionBasisPoint: 20000}), "commission above 100%"},
+	}
+
+	for _, tc := range testCases {
+		if err := sm.ValidateStakingTx(tc.tx); err == nil {
+			t.Errorf("%s: expected validation error", tc.description)
+		}
+	}
+}
+
+// TestUndelegationsInOneBlock checks that two undelegations of a delegator
+// in the same block both get an unbonding entry, also after a restart
+func TestUndelegationsInOneBlock(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+
+	_, validator := createTestValidator("validator_staking")
+	state.UpdateValidator(validator)
+	state.UpdateAccount(&core.Account{Address: "delegator_1", Balance: big.NewInt(1000)})
+
+	sm := NewStakingManager(db, state)
+	bondViaTx(t, state, sm, newStakingTx(t, "delegator_1", 600, &StakingTx{Type: StakingTxDelegate, ValidatorID: validator.ID}), 10)
+
+	for i, id := range []string{"undelegate_a", "undelegate_b"} {
+		tx := newStakingTx(t, "delegator_1", 0, &StakingTx{Type: StakingTxUndelegate, ValidatorID: validator.ID, Amount: big.NewInt(int64(100 * (i + 1)))})
+		tx.ID = id
+		if err := sm.ApplyStakingTx(tx, 20); err != nil {
+			t.Fatalf("Undelegate %s failed: %v", id, err)
+		}
+	}
+
+	reloaded := NewStakingManager(db, state)
+	if entries := reloaded.GetUnbondingEntries("delegator_1"); len(entries) != 2 {
+		t.Fatalf("Expected 2 unbonding entries after reload, got %d", len(entries))
+	}
+	if released, err := reloaded.ProcessMatureUnbondings(20 + core.UnbondingPeriodBlocks); err != nil || released != 2 {
+		t.Errorf("Expected 2 released unbonding entries, got %d, %v", released, err)
+	}
+	account, _ := state.GetAccount("delegator_1")
+	if account.Balance.Cmp(big.NewInt(700)) != 0 {
+		t.Errorf("Expected delegator balance 700 after unbonding, got %s", account.Balance.String())
+	}
+}
+
+// TestRefundStakingTx checks that the escrow of a staking tx that failed to
+// apply is returned to the sender
+func TestRefundStakingTx(t *testing.T) {
+	db := setupTestDB(t)
+	state, _ := setupTestState(db)
+	state.UpdateAccount(&core.Account{Address: "delegator_1", Balance: big.NewInt(1000)})
+
+	sm := NewStakingManager(db, state)
+	tx := newStakingTx(t, "delegator_1", 600, &StakingTx{Type: StakingTxDelegate, ValidatorID: "unknown"})
+
+	from, _ := state.GetAccount(tx.From)
+	module, _ := state.GetAccount(core.StakingModuleAddress)
+	from.Balance = new(big.Int).Sub(from.Balance, tx.Amount)
+	module.Balance = new(big.Int).Add(module.Balance, tx.Amount)
+	state.UpdateAccount(from)
+	state.UpdateAccount(module)
+
+	if err := sm.ApplyStakingTx(tx, 10); err == nil {
+		t.Fatal("Expected delegation to an unknown validator to fail")
+	}
+	if err := sm.RefundStakingTx(tx); err != nil {
+		t.Fatalf("RefundStakingTx failed: %v", err)
+	}
+	account, _ := state.GetAccount("delegator_1")
+	if account.Balance.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("Expected refunded balance 1000, got %s", account.Balance.String())
+	}
+}
@@ -0,0 +1,54 @@
+package consensus
+
+import (
+	"fmt"
+
+	"rnr-blockchain/pkg/network"
+)
+
+// Validator identity: the records binding validators to their libp2p peers
+// are signed with the validator's block signing key and proven with its VRF
+// key, and checked against both keys in state. The network uses them to
+// reach a validator's node for votes and PoB challenges.
+
+// signValidatorRecord signs a record binding this validator to its node
+func (vs *ValidatorService) signValidatorRecord(record *network.ValidatorRecord) error {
+	hash := record.SigningHash()
+	signature, err := signDigest(hash, vs.privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign record: %w", err)
+	}
+	output, err := vs.vrfSystem.Generate(hash)
+	if err != nil {
+		return fmt.Errorf("failed to prove record: %w", err)
+	}
+	record.Signature = signature
+	record.VRFOutput = output.Value
+	record.VRFProof = output.Proof
+	return nil
+}
+
+// VerifyValidatorRecord checks a record's signature and VRF proof against
+// the keys its validator has in state
+func (vs *ValidatorService) VerifyValidatorRecord(record *network.ValidatorRecord) error {
+	info, err := vs.state.GetValidator(record.ValidatorID)
+	if err != nil || info == nil {
+		return fmt.Errorf("unknown validator %s", shortValidatorID(record.ValidatorID))
+	}
+	publicKey, err := DecodeECDSAPublicKey(info.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key of %s: %w", shortValidatorID(record.ValidatorID), err)
+	}
+
+	hash := record.SigningHash()
+	if !VerifyVote(&Vote{BlockHash: hash, ValidatorID: record.ValidatorID, Signature: record.Signature}, publicKey) {
+		return fmt.Errorf("invalid validator signature")
+	}
+	if len(info.VRFPublicKey) == 0 {
+		return fmt.Errorf("no VRF key in state for %s", shortValidatorID(record.ValidatorID))
+	}
+	if !VerifyVRFWithPublicKey(hash, record.VRFOutput, record.VRFProof, info.VRFPublicKey) {
+		return fmt.Errorf("invalid VRF proof")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/network"
+)
+
+func TestValidatorRecordSignedAndVerifiedAgainstState(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	chain, _ := setupTestBlockchain(db)
+	state, _ := setupTestState(db)
+
+	privKey1, validator1 := createTestValidator("v1")
+	privKey2, validator2 := createTestValidator("v2")
+	state.UpdateValidator(validator1)
+	state.UpdateValidator(validator2)
+
+	mp := setupTestMempool()
+	poh := NewProofOfHistory()
+	vs1, err := NewValidatorService(validator1.ID, privKey1, chain, state, mp, poh)
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+	vs2, err := NewValidatorService(validator2.ID, privKey2, chain, state, mp, poh)
+	if err != nil {
+		t.Fatalf("Failed to create validator service: %v", err)
+	}
+
+	newRecord := func(validatorID string) *network.ValidatorRecord {
+		return &network.ValidatorRecord{
+			ValidatorID: validatorID,
+			PeerID:      "12D3KooWGRUVh7ShDd9g2ZbPKvnXbs1FD7sQqcSp3oRPDhuJvEGG",
+			Addrs:       []string{"/ip4/203.0.113.7/tcp/6000"},
+			Timestamp:   time.Now().UnixNano(),
+		}
+	}
+
+	record := newRecord(validator1.ID)
+	if err := vs1.signValidatorRecord(record); err != nil {
+		t.Fatalf("Failed to sign record: %v", err)
+	}
+	if err := vs2.VerifyValidatorRecord(record); err != nil {
+		t.Fatalf("Valid record rejected: %v", err)
+	}
+
+	// Rebinding the validator to another peer breaks both signatures
+	moved := *record
+	moved.PeerID = "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo"
+	if err := vs2.VerifyValidatorRecord(&moved); err == nil {
+		t.Error("Record rebound to another peer was accepted")
+	}
+
+	// A validator cannot sign a record for another validator
+	forged := newRecord(validator1.ID)
+	if err := vs2.signValidatorRecord(forged); err != nil {
+		t.Fatalf("Failed to sign record: %v", err)
+	}
+	if err := vs1.VerifyValidatorRecord(forged); err == nil {
+		t.Error("Record signed with another validator's keys was accepted")
+	}
+
+	unknown := newRecord("unknown-validator")
+	if err := vs1.signValidatorRecord(unknown); err != nil {
+		t.Fatalf("Failed to sign record: %v", err)
+	}
+	if err := vs2.VerifyValidatorRecord(unknown); err == nil {
+		t.Error("Record of a validator not in state was accepted")
+	}
+}
@@ -145,7 +145,7 @@ func (vr *ValidatorRegistry) RegisterValidator(tx *RegistrationTx) error {
                 ID:                tx.ValidatorID,
                 PublicKey:         tx.PublicKey,
                 PoBScore:          0.0,
-                Reputation:        100,
+                Reputation:        core.InitialReputation,
                 LastPoBTest:       time.Time{},
                 IsActive:          false,
                 RewardAddress:     tx.RewardAddress,
@@ -303,6 +303,53 @@ func (vr *ValidatorRegistry) ClearSuspension(validatorID string) error {
         return nil
 }
 
+// JailValidator suspends a validator until it sends an unjail transaction at
+// or after untilHeight. Unlike SuspendValidator, jailing does not expire.
+func (vr *ValidatorRegistry) JailValidator(validatorID string, untilHeight uint64, reason string) error {
+        vr.mu.Lock()
+        defer vr.mu.Unlock()
+
+        validatorInfo, err := vr.state.GetValidator(validatorID)
+        if err != nil {
+                return fmt.Errorf("validator not found: %w", err)
+        }
+
+        validatorInfo.IsSuspended = true
+        validatorInfo.IsJailed = true
+        validatorInfo.JailedUntilHeight = untilHeight
+        validatorInfo.SuspensionReason = reason
+
+        vr.state.UpdateValidator(validatorInfo)
+
+        shortID := validatorID
+        if len(validatorID) > 12 {
+                shortID = validatorID[:12]
+        }
+        log.Printf("🔒 Validator jailed: %s, reason: %s, unjail allowed from block #%d",
+                shortID, reason, untilHeight)
+
+        return nil
+}
+
+func (vr *ValidatorRegistry) UnjailValidator(validatorID string) error {
+        vr.mu.Lock()
+        defer vr.mu.Unlock()
+
+        validatorInfo, err := vr.state.GetValidator(validatorID)
+        if err != nil {
+                return fmt.Errorf("validator not found: %w", err)
+        }
+
+        validatorInfo.IsSuspended = false
+        validatorInfo.IsJailed = false
+        validatorInfo.JailedUntilHeight = 0
+        validatorInfo.SuspensionReason = ""
+
+        vr.state.UpdateValidator(validatorInfo)
+
+        return nil
+}
+
 func (vr *ValidatorRegistry) GetAllValidators() []*core.ValidatorInfo {
         vr.mu.RLock()
         defer vr.mu.RUnlock()
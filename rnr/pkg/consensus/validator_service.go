@@ -1,14 +1,15 @@
 package consensus
 
 import (
+        "bytes"
         "crypto/ecdsa"
         "crypto/ed25519"
-        "crypto/rand"
         "encoding/hex"
         "fmt"
         "log"
         "math/big"
         "sort"
+        "sync"
         "time"
 
         "rnr-blockchain/pkg/blockchain"
@@ -26,11 +27,36 @@ type ValidatorService struct {
         p2pSpeedTestMgr *P2PSpeedTestManager
         finalityTracker *FinalityTracker
         retargetMgr     *PoBRetargetManager // Whitepaper Bab 9.1-9.2: PoB difficulty retargeting
+        stakingMgr      *StakingManager     // Delegated staking: bonds, commission, unbonding queue
+        rewardLedger    *RewardLedger       // Per-block reward records and claimable balances
+        livenessTracker *LivenessTracker    // Missed-block bitmaps and downtime jailing
+        pobRounds       *PoBRoundManager    // On-chain PoB rounds: committee tests and aggregation
+        collusion       *CollusionDetector  // Flags PoB testers that keep favoring candidates
+        pobProofs       map[string]bool     // Aggregations of our PoB rounds already being proven
+        asnResolver     *network.ASNResolver // Groups validators by origin AS for PoB rewards
         blockchain      *blockchain.Blockchain
         state           *blockchain.State
         mempool         *blockchain.Mempool
         poh             *ProofOfHistory
         p2pNetwork      *network.P2PNetwork // P2P network for speed tests
+        pendingBlocks   map[string]*pendingBlock // Validated blocks extending the tip, by hash, until one is final
+        heightVotes     map[uint64]*heightVote   // Our vote at each height not yet final
+        blockMu         sync.Mutex          // Serializes validating and finalizing blocks
+        txMu            sync.Mutex          // Serializes nonce assignment of our module txs
+}
+
+// pendingBlock is a validated block extending the chain tip. It is applied
+// once votes of two thirds of the bonded stake are seen for it.
+type pendingBlock struct {
+        block    *core.Block
+        hash     []byte
+        received time.Time
+}
+
+// heightVote is the block this node voted for at a height
+type heightVote struct {
+        hash []byte
+        at   time.Time
 }
 
 func NewValidatorService(
@@ -59,12 +85,20 @@ func NewValidatorService(
                 pobManager:      NewPoBTestManager(),
                 p2pSpeedTestMgr: NewP2PSpeedTestManager(),
                 finalityTracker: NewFinalityTracker(),
-                retargetMgr:     NewPoBRetargetManager(), // Whitepaper Bab 9.1-9.2
+                retargetMgr:     NewPoBRetargetManager(blockchain), // Whitepaper Bab 9.1-9.2
+                stakingMgr:      NewStakingManager(state.GetDB(), state),
+                rewardLedger:    NewRewardLedger(state.GetDB(), state),
+                pobRounds:       NewPoBRoundManager(state.GetDB(), state),
+                collusion:       NewCollusionDetector(state.GetDB(), state),
+                pobProofs:       make(map[string]bool),
+                pendingBlocks:   make(map[string]*pendingBlock),
+                heightVotes:     make(map[uint64]*heightVote),
                 blockchain:      blockchain,
                 state:           state,
                 mempool:         mempool,
                 poh:             poh,
         }
+        vs.pobRounds.SetCollusionDetector(vs.collusion)
 
         // SECURITY FIX: Store VRF public key in validator info for on-chain verification
         validatorInfo, err := state.GetValidator(validatorID)
@@ -85,15 +119,54 @@ func NewValidatorService(
 }
 
 func (vs *ValidatorService) IsProposer(blockHeight uint64) (bool, string, error) {
-        latestBlock := vs.blockchain.GetLatestBlock()
-        prevBlockHash, err := latestBlock.Hash()
+        ranking, err := vs.proposerRanking(blockHeight, vs.blockchain.GetLatestBlock())
         if err != nil {
                 return false, "", err
         }
 
+        return ranking[0] == vs.validatorID, ranking[0], nil
+}
+
+// ProposerRank returns this validator's position in the ranked proposer list
+// for blockHeight (0 = elected proposer, 1..MaxProposerFallbacks = backups,
+// -1 = not eligible) together with the full ranking.
+func (vs *ValidatorService) ProposerRank(blockHeight uint64) (int, []string, error) {
+        ranking, err := vs.proposerRanking(blockHeight, vs.blockchain.GetLatestBlock())
+        if err != nil {
+                return -1, nil, err
+        }
+
+        for rank, vid := range ranking {
+                if rank > core.MaxProposerFallbacks {
+                        break
+                }
+                if vid == vs.validatorID {
+                        return rank, ranking, nil
+                }
+        }
+
+        return -1, ranking, nil
+}
+
+// ProposerSlotStart is the earliest time the proposer at rank may propose on
+// top of parent. The elected proposer's slot opens one block time after the
+// parent; each backup waits a further ProposerFallbackTimeout.
+func ProposerSlotStart(parent *core.Block, rank int) time.Time {
+        return parent.Header.Timestamp.Add(core.BlockTime + time.Duration(rank)*core.ProposerFallbackTimeout)
+}
+
+// proposerRanking orders the eligible (active, unsuspended, with at least
+// MinProposerPoBScore) validators for the block at blockHeight built on
+// parent, matching VerifyVRFProposerSelection.
+func (vs *ValidatorService) proposerRanking(blockHeight uint64, parent *core.Block) ([]string, error) {
+        prevBlockHash, err := parent.Hash()
+        if err != nil {
+                return nil, err
+        }
+
         activeValidators := vs.state.GetActiveValidators()
         if len(activeValidators) == 0 {
-                return false, "", fmt.Errorf("no active validators")
+                return nil, fmt.Errorf("no active validators")
         }
 
         validatorKeys := make(map[string][]byte)
@@ -103,7 +176,7 @@ func (vs *ValidatorService) IsProposer(blockHeight uint64) (bool, string, error)
         for _, vid := range activeValidators {
                 validatorInfo, err := vs.state.GetValidator(vid)
                 if err == nil && validatorInfo != nil {
-                        if !validatorInfo.IsSuspended {
+                        if !validatorInfo.IsSuspended && validatorInfo.PoBScore >= core.MinProposerPoBScore {
                                 validatorKeys[vid] = validatorInfo.PublicKey
                                 pobScores[vid] = validatorInfo.PoBScore
                                 eligibleValidators = append(eligibleValidators, vid)
@@ -112,16 +185,55 @@ func (vs *ValidatorService) IsProposer(blockHeight uint64) (bool, string, error)
         }
 
         if len(eligibleValidators) == 0 {
-                return false, "", fmt.Errorf("no eligible validators (all suspended)")
+                return nil, fmt.Errorf("no eligible validators (all suspended or below PoB score %.2f)", core.MinProposerPoBScore)
         }
 
         seed := GenerateProposerSeed(blockHeight, prevBlockHash)
-        proposerID, err := PoBWeightedSelectProposer(seed, eligibleValidators, validatorKeys, pobScores)
+        return PoBWeightedRankProposers(seed, eligibleValidators, validatorKeys, pobScores)
+}
+
+// verifyProposerSlot checks that the block's proposer is the elected proposer
+// or a backup whose fallback slot had started when the block was made and
+// has started on our clock.
+func (vs *ValidatorService) verifyProposerSlot(block *core.Block) error {
+        parent, err := vs.blockchain.GetBlockByHeight(block.Header.Height - 1)
         if err != nil {
-                return false, "", err
+                return fmt.Errorf("parent block not found: %w", err)
+        }
+
+        ranking, err := vs.proposerRanking(block.Header.Height, parent)
+        if err != nil {
+                return err
+        }
+
+        rank := -1
+        for i, vid := range ranking {
+                if i > core.MaxProposerFallbacks {
+                        break
+                }
+                if vid == block.ProposerID {
+                        rank = i
+                        break
+                }
+        }
+
+        if rank < 0 {
+                return fmt.Errorf("proposer %s is not eligible for block #%d", block.ProposerID[:8], block.Header.Height)
+        }
+
+        if rank > 0 {
+                slotStart := ProposerSlotStart(parent, rank)
+                if block.Header.Timestamp.Before(slotStart) {
+                        return fmt.Errorf("fallback block from rank %d proposer timestamped before its slot", rank)
+                }
+                if time.Now().Add(core.MaxSlotClockDrift).Before(slotStart) {
+                        return fmt.Errorf("fallback slot for rank %d has not started yet", rank)
+                }
+                log.Printf("🔁 Accepting fallback block #%d from rank %d proposer %s",
+                        block.Header.Height, rank, block.ProposerID[:8])
         }
 
-        return proposerID == vs.validatorID, proposerID, nil
+        return nil
 }
 
 func (vs *ValidatorService) ProposeBlock() (*core.Block, error) {
@@ -134,27 +246,32 @@ func (vs *ValidatorService) ProposeBlock() (*core.Block, error) {
         }
 
         validatorInfo, _ := vs.state.GetValidator(vs.validatorID)
-        uploadBandwidth := core.MinUploadBandwidth // Default 8 MB/s
+        uploadBandwidth := vs.proposerUploadBandwidth(vs.validatorID)
         pobScore := 1.0
         if validatorInfo != nil {
-                if validatorInfo.UploadBandwidth > 0 {
-                        uploadBandwidth = validatorInfo.UploadBandwidth
-                }
                 pobScore = validatorInfo.PoBScore
         }
 
         // WHITEPAPER COMPLIANCE: Dynamic Block Capacity based on upload bandwidth
         // Formula: 0.30 × Upload_Validator (MB/s) × 10 detik
         maxBlockCapacityBytes := vs.calculateDynamicBlockCapacity(uploadBandwidth)
+
+        // EIP-1559 style base fee derived from how full the parent block was
+        baseFee := CalculateNextBaseFee(latestBlock.Header)
         
         // Select transactions up to capacity limit (in bytes, not count!)
         transactions := vs.mempool.GetTransactionsBySize(maxBlockCapacityBytes)
         
-        filteredTxs := vs.selectAndValidateTransactions(transactions)
+        filteredTxs := vs.selectAndValidateTransactions(transactions, baseFee)
+
+        sizeUsed := uint64(0)
+        for _, tx := range filteredTxs {
+                sizeUsed += uint64(blockchain.EstimateTransactionSize(tx))
+        }
 
         log.Printf("📊 Dynamic Block Capacity: %.2f MB (%.0f bytes) based on Upload: %.2f MB/s", 
                 float64(maxBlockCapacityBytes)/(1024*1024), float64(maxBlockCapacityBytes), uploadBandwidth)
-        log.Printf("   Selected %d transactions (PoB score: %.2f)", len(filteredTxs), pobScore)
+        log.Printf("   Selected %d transactions (PoB score: %.2f, base fee: %s/byte)", len(filteredTxs), pobScore, baseFee.String())
 
         merkleRoot, err := vs.calculateMerkleRoot(filteredTxs)
         if err != nil {
@@ -192,6 +309,13 @@ func (vs *ValidatorService) ProposeBlock() (*core.Block, error) {
                 PoBWeight:     pobWeight,        // Whitepaper Bab 4.3: For cumulative difficulty calculation
                 VRFProof:      vrfResult.Proof,  // SECURITY: Include VRF proof in header
                 VRFOutput:     vrfResult.Value,  // VRF output for randomness
+                BaseFee:       baseFee,
+                SizeUsed:      sizeUsed,
+                SizeLimit:     uint64(maxBlockCapacityBytes),
+        }
+        // Whitepaper Bab 9.2: consensus thresholds
+        if err := vs.retargetMgr.CommitThresholds(header); err != nil {
+                return nil, fmt.Errorf("failed to commit PoB thresholds: %w", err)
         }
 
         block := &core.Block{
@@ -201,6 +325,7 @@ func (vs *ValidatorService) ProposeBlock() (*core.Block, error) {
                 PoHSequence:  pohSequence,
                 Signature:    []byte{},
                 VRFProof:     vrfResult.Proof,  // SECURITY: Duplicate for easy access
+                LastCommit:   vs.buildLastCommit(prevBlockHash),
         }
 
         signature, err := vs.signBlock(block)
@@ -219,38 +344,215 @@ func (vs *ValidatorService) ProposeBlock() (*core.Block, error) {
         return block, nil
 }
 
+// VoteOnBlock validates our own proposal and votes on it. Like a received
+// block it is applied once final; its commit is carried by the next block's
+// LastCommit.
 func (vs *ValidatorService) VoteOnBlock(block *core.Block) error {
-        if err := vs.validateBlock(block); err != nil {
+        if err := vs.ProcessBlock(block); err != nil {
                 log.Printf("❌ Block validation failed: %v", err)
+                return err
+        }
+        return vs.AttestBlock(block)
+}
+
+// ProcessBlock validates a block, our own proposal or one received from a
+// peer by gossip or sync, and applies it once it is final. A valid block
+// extending the chain tip is kept pending until votes of two thirds of the
+// bonded stake are seen for it, gossiped or in the LastCommit of a child.
+// Competing blocks at a height are thus settled by votes before any of them
+// is applied, and the chain never has to roll back. Like our own proposals,
+// every block goes through validateBlock and finalizeBlock, so all nodes
+// apply the same transactions, staking, rewards, liveness and PoB rounds.
+func (vs *ValidatorService) ProcessBlock(block *core.Block) error {
+        vs.blockMu.Lock()
+        defer vs.blockMu.Unlock()
+
+        blockHash, err := block.Hash()
+        if err != nil {
+                return err
+        }
+
+        tip := vs.blockchain.GetLatestBlock()
+        if block.Header.Height <= tip.Header.Height {
+                if final, err := vs.blockchain.GetBlockByHeight(block.Header.Height); err == nil {
+                        if finalHash, _ := final.Hash(); bytes.Equal(finalHash, blockHash) {
+                                return nil
+                        }
+                }
+                return fmt.Errorf("block #%d conflicts with the finalized chain", block.Header.Height)
+        }
+
+        // A child carries the commit that finalizes its pending parent
+        if block.Header.Height == tip.Header.Height+2 {
+                parent := vs.pendingBlocks[hex.EncodeToString(block.Header.PrevBlockHash)]
+                if parent == nil {
+                        return fmt.Errorf("parent of block #%d is unknown", block.Header.Height)
+                }
+                if err := VerifyLastCommit(vs.state, parent.hash, block.LastCommit); err != nil {
+                        return fmt.Errorf("invalid last commit: %w", err)
+                }
+                if err := VerifyCommitQuorum(vs.state, vs.stakingMgr, block.LastCommit); err != nil {
+                        return fmt.Errorf("invalid last commit: %w", err)
+                }
+                if err := vs.applyFinalBlock(parent); err != nil {
+                        return err
+                }
+        }
+
+        key := hex.EncodeToString(blockHash)
+        if vs.pendingBlocks[key] != nil {
+                return nil
+        }
+        if err := vs.validateBlock(block); err != nil {
                 return fmt.Errorf("block validation failed: %w", err)
         }
+        vs.pendingBlocks[key] = &pendingBlock{block: block, hash: blockHash, received: time.Now()}
+
+        // Votes may have arrived before the block
+        return vs.finalizeIfCommitted(key)
+}
+
+// finalizeIfCommitted applies the pending block once the votes seen for it
+// reach two thirds of the bonded stake
+func (vs *ValidatorService) finalizeIfCommitted(key string) error {
+        pending := vs.pendingBlocks[key]
+        if pending == nil {
+                return nil
+        }
+        if err := VerifyCommitQuorum(vs.state, vs.stakingMgr, vs.buildLastCommit(pending.hash)); err != nil {
+                return nil
+        }
+        return vs.applyFinalBlock(pending)
+}
 
+// applyFinalBlock finalizes a pending block onto the chain and drops the
+// blocks that competed with it
+func (vs *ValidatorService) applyFinalBlock(final *pendingBlock) error {
+        height := final.block.Header.Height
+        for key, pending := range vs.pendingBlocks {
+                if pending.block.Header.Height <= height {
+                        delete(vs.pendingBlocks, key)
+                }
+        }
+        for h := range vs.heightVotes {
+                if h <= height {
+                        delete(vs.heightVotes, h)
+                }
+        }
+
+        if err := vs.finalizeBlock(final.block); err != nil {
+                return err
+        }
+        log.Printf("🎉 Block #%d finalized (hash: %s)", height, hex.EncodeToString(final.hash)[:8])
+        return nil
+}
+
+// AwaitingFinality reports whether a block at height is pending within its
+// voting phase. Proposing another block at the height would only split the
+// votes.
+func (vs *ValidatorService) AwaitingFinality(height uint64) bool {
+        vs.blockMu.Lock()
+        defer vs.blockMu.Unlock()
+
+        for _, pending := range vs.pendingBlocks {
+                if pending.block.Header.Height == height && time.Since(pending.received) < core.VerificationVotingPhase {
+                        return true
+                }
+        }
+        return false
+}
+
+// AttestBlock votes on a block that ProcessBlock validated, and gossips the
+// vote so every node counts it towards the block's finality and the next
+// proposer's LastCommit. We vote for one block per height, and for another
+// only once the voting phase of our vote passed without that block becoming
+// final, so a height whose votes split still gets a block.
+func (vs *ValidatorService) AttestBlock(block *core.Block) error {
         blockHash, err := block.Hash()
         if err != nil {
                 return err
         }
 
+        vs.blockMu.Lock()
+        height := block.Header.Height
+        if vs.pendingBlocks[hex.EncodeToString(blockHash)] == nil {
+                tipHash, err := vs.blockchain.GetLatestBlock().Hash()
+                if err != nil || !bytes.Equal(blockHash, tipHash) {
+                        vs.blockMu.Unlock()
+                        return fmt.Errorf("block #%d is neither pending nor the chain tip", height)
+                }
+        }
+        if voted := vs.heightVotes[height]; voted != nil &&
+                (bytes.Equal(voted.hash, blockHash) || time.Since(voted.at) < core.VerificationVotingPhase) {
+                vs.blockMu.Unlock()
+                return nil
+        }
+        vs.heightVotes[height] = &heightVote{hash: blockHash, at: time.Now()}
+        vs.blockMu.Unlock()
+
         signature, err := SignVote(blockHash, vs.privateKey)
         if err != nil {
                 return fmt.Errorf("failed to sign vote: %w", err)
         }
 
-        err = vs.votingManager.CastVote(blockHash, vs.validatorID, signature)
-        if err != nil {
+        if err := vs.HandleReceivedVote(blockHash, vs.validatorID, signature); err != nil {
                 return fmt.Errorf("failed to cast vote: %w", err)
         }
 
-        log.Printf("✅ Voted on block #%d (hash: %s)", block.Header.Height, hex.EncodeToString(blockHash)[:8])
+        log.Printf("✅ Voted on block #%d (hash: %s)", height, hex.EncodeToString(blockHash)[:8])
 
-        isFinalized, voteCount, _ := vs.votingManager.CheckFinality(blockHash)
-        if isFinalized {
-                vs.finalizeBlock(block)
-                log.Printf("🎉 Block #%d finalized with %d votes!", block.Header.Height, voteCount)
+        if vs.p2pNetwork != nil {
+                if err := vs.p2pNetwork.SendVote(block.ProposerID, blockHash, vs.validatorID, signature); err != nil {
+                        log.Printf("⚠️  Failed to send vote: %v", err)
+                }
         }
 
         return nil
 }
 
+// HandleReceivedVote records a vote gossiped by a validator, or our own, and
+// finalizes the pending block it completes a commit of. Recorded votes end up
+// in the LastCommit of the next block. The signature is checked against the
+// validator's key before a voting session is opened for the block, so forged
+// votes cannot create sessions. A vote already recorded is ignored.
+func (vs *ValidatorService) HandleReceivedVote(blockHash []byte, validatorID string, signature []byte) error {
+        if len(blockHash) != 32 {
+                return fmt.Errorf("invalid vote block hash length %d", len(blockHash))
+        }
+
+        info, err := vs.state.GetValidator(validatorID)
+        if err != nil || info == nil {
+                return fmt.Errorf("vote from unknown validator %s", shortValidatorID(validatorID))
+        }
+        publicKey, err := DecodeECDSAPublicKey(info.PublicKey)
+        if err != nil {
+                return fmt.Errorf("invalid public key for %s: %w", shortValidatorID(validatorID), err)
+        }
+        if !VerifyVote(&Vote{BlockHash: blockHash, ValidatorID: validatorID, Signature: signature}, publicKey) {
+                return fmt.Errorf("invalid vote signature from %s", shortValidatorID(validatorID))
+        }
+
+        if votes, err := vs.votingManager.GetVotes(blockHash); err == nil {
+                for _, vote := range votes {
+                        if vote.ValidatorID == validatorID {
+                                return nil
+                        }
+                }
+        } else {
+                // A concurrent vote may have opened the session first; CastVote
+                // reports it if there is still none
+                vs.votingManager.StartVotingSession(blockHash, len(vs.state.GetActiveValidators()))
+        }
+
+        if err := vs.votingManager.CastVote(blockHash, validatorID, signature); err != nil {
+                return err
+        }
+
+        vs.blockMu.Lock()
+        defer vs.blockMu.Unlock()
+        return vs.finalizeIfCommitted(hex.EncodeToString(blockHash))
+}
+
 func (vs *ValidatorService) finalizeBlock(block *core.Block) error {
         blockHash, _ := block.Hash()
         
@@ -258,28 +560,89 @@ func (vs *ValidatorService) finalizeBlock(block *core.Block) error {
                 return fmt.Errorf("failed to add block to blockchain: %w", err)
         }
 
+        totalTips := big.NewInt(0)
+        totalBurned := big.NewInt(0)
         for _, tx := range block.Transactions {
                 vs.mempool.RemoveTransaction(tx.ID)
-                vs.applyTransaction(tx)
+                burned, tip := vs.applyTransaction(tx, block.Header.BaseFee)
+                totalBurned.Add(totalBurned, burned)
+                totalTips.Add(totalTips, tip)
+
+                if IsStakingTx(tx) {
+                        if err := vs.stakingMgr.ApplyStakingTx(tx, block.Header.Height); err != nil {
+                                log.Printf("⚠️  Failed to apply staking tx %s: %v", tx.ID, err)
+                                if err := vs.stakingMgr.RefundStakingTx(tx); err != nil {
+                                        log.Printf("⚠️  Failed to refund staking tx %s: %v", tx.ID, err)
+                                }
+                        }
+                }
+
+                if IsRewardTx(tx) {
+                        if err := vs.rewardLedger.ApplyWithdrawTx(tx, block.Header.Height); err != nil {
+                                log.Printf("⚠️  Failed to apply withdraw tx %s: %v", tx.ID, err)
+                        }
+                }
+
+                if IsUnjailTx(tx) && vs.livenessTracker != nil {
+                        if err := vs.livenessTracker.ApplyUnjailTx(tx, block.Header.Height); err != nil {
+                                log.Printf("⚠️  Failed to apply unjail tx %s: %v", tx.ID, err)
+                        }
+                }
+
+                if IsPoBTx(tx) {
+                        round, err := vs.pobRounds.ApplyPoBTx(tx, block)
+                        if err != nil {
+                                log.Printf("⚠️  Failed to apply PoB tx %s: %v", tx.ID, err)
+                        } else if round != nil && round.IsCommitteeMember(vs.validatorID) {
+                                go vs.testPoBCandidate(round)
+                        }
+                }
         }
 
-        vs.distributeBlockRewards(block)
+        if _, err := vs.stakingMgr.ProcessMatureUnbondings(block.Header.Height); err != nil {
+                log.Printf("⚠️  Failed to release mature unbondings at #%d: %v", block.Header.Height, err)
+        }
 
-        vs.finalityTracker.MarkFinalized(blockHash)
+        // PoB rounds complete once every tester reported or the round timed
+        // out, against the thresholds the block commits; validators due for
+        // re-verification get a round of their own
+        thresholds := HeaderThresholds(block.Header)
+        if thresholds == nil {
+                thresholds = defaultThresholds()
+        }
+        vs.pobRounds.ProcessRounds(block.Header.Height, block.Header.Timestamp, thresholds)
+        for _, round := range vs.pobRounds.ScheduleReverifications(block) {
+                if round.IsCommitteeMember(vs.validatorID) {
+                        go vs.testPoBCandidate(round)
+                }
+        }
+        for _, round := range vs.pobRounds.AwaitingProof(vs.validatorID) {
+                key := round.ID + ":" + round.Aggregation.Commitment
+                if !vs.pobProofs[key] {
+                        vs.pobProofs[key] = true
+                        go vs.provePoBRound(round.ID)
+                }
+        }
+        vs.pobRounds.PruneExpiredRounds(block.Header.Height)
 
-        // Whitepaper Bab 9.1: Record validator count for retargeting calculation
-        activeValidators := vs.state.GetActiveValidators()
-        vs.retargetMgr.RecordValidatorCount(len(activeValidators))
-        
-        // Whitepaper Bab 9.2: Adjust difficulty every 50 blocks
-        if err := vs.retargetMgr.AdjustDifficulty(block.Header.Height); err != nil {
-                log.Printf("⚠️  Failed to adjust PoB difficulty: %v", err)
+        // Liveness: the block's LastCommit is the signer set of its parent
+        if vs.livenessTracker != nil && block.Header.Height > 1 {
+                vs.livenessTracker.RecordCommit(block.Header.Height-1, block.Header.Timestamp, block.LastCommit)
+        }
+
+        if totalBurned.Sign() > 0 {
+                log.Printf("🔥 Base fee burned in block #%d: %s RNR (base fee %s/byte)",
+                        block.Header.Height, totalBurned.String(), block.Header.BaseFee.String())
         }
 
+        vs.distributeBlockRewards(block, totalTips)
+
+        vs.finalityTracker.MarkFinalized(blockHash)
+
         return nil
 }
 
-func (vs *ValidatorService) distributeBlockRewards(block *core.Block) {
+func (vs *ValidatorService) distributeBlockRewards(block *core.Block, priorityFees *big.Int) {
         blockReward := vs.calculateBlockReward(block.Header.Height)
         
         proposerReward := new(big.Int).Mul(blockReward, core.ProposerRewardPercentage)
@@ -287,10 +650,30 @@ func (vs *ValidatorService) distributeBlockRewards(block *core.Block) {
         
         pobReward := new(big.Int).Mul(blockReward, core.PoBContributorPercentage)
         pobReward = pobReward.Div(pobReward, big.NewInt(100))
+
+        record := &BlockRewardRecord{
+                Height:         block.Header.Height,
+                ProposerID:     block.ProposerID,
+                BlockReward:    blockReward,
+                ProposerReward: proposerReward,
+                PoBReward:      pobReward,
+                Distributed:    big.NewInt(0),
+                GroupTotals:    make(map[string]*big.Int),
+                Entries:        make([]*RewardEntry, 0),
+                Timestamp:      block.Header.Timestamp,
+        }
         
-        proposerAccount, _ := vs.state.GetAccount(block.ProposerID)
-        proposerAccount.Balance = new(big.Int).Add(proposerAccount.Balance, proposerReward)
-        vs.state.UpdateAccount(proposerAccount)
+        vs.creditValidatorReward(record, block.ProposerID, proposerReward, RewardKindProposer, "")
+
+        // Priority tips go to the proposer alone; the base fee part was burned
+        if priorityFees.Sign() > 0 {
+                record.AddEntry(&RewardEntry{
+                        Recipient:   block.ProposerID,
+                        ValidatorID: block.ProposerID,
+                        Kind:        RewardKindPriorityFee,
+                        Amount:      priorityFees,
+                })
+        }
         
         log.Printf("💰 Block Reward Distributed: Proposer=%s got %s RNR", 
                 block.ProposerID[:12], proposerReward.String())
@@ -305,12 +688,26 @@ func (vs *ValidatorService) distributeBlockRewards(block *core.Block) {
                 }
         }
 
-        groups := GroupValidatorsByNetwork(validatorInfos)
+        groups := GroupValidatorsByNetwork(validatorInfos, vs.asnResolver)
         pobRewards := DistributePoBRewardFairly(pobReward, groups, core.MaxPoBContributors)
 
+        validatorGroup := make(map[string]string)
+        for asn, group := range groups {
+                for _, vid := range group.Validators {
+                        validatorGroup[vid] = asn
+                }
+        }
+
+        contributors := make([]string, 0, len(pobRewards))
+        for validatorID := range pobRewards {
+                contributors = append(contributors, validatorID)
+        }
+        sort.Strings(contributors)
+
         totalDistributed := big.NewInt(0)
         suspendedCount := 0
-        for validatorID, reward := range pobRewards {
+        for _, validatorID := range contributors {
+                reward := pobRewards[validatorID]
                 if reward.Cmp(big.NewInt(0)) > 0 {
                         validatorInfo, _ := vs.state.GetValidator(validatorID)
                         if validatorInfo != nil && validatorInfo.IsSuspended {
@@ -318,9 +715,7 @@ func (vs *ValidatorService) distributeBlockRewards(block *core.Block) {
                                 continue
                         }
                         
-                        account, _ := vs.state.GetAccount(validatorID)
-                        account.Balance = new(big.Int).Add(account.Balance, reward)
-                        vs.state.UpdateAccount(account)
+                        vs.creditValidatorReward(record, validatorID, reward, RewardKindPoBContributor, validatorGroup[validatorID])
                         totalDistributed = new(big.Int).Add(totalDistributed, reward)
                 }
         }
@@ -329,6 +724,36 @@ func (vs *ValidatorService) distributeBlockRewards(block *core.Block) {
                 log.Printf("📊 PoB Rewards: %d network groups, %s RNR total distributed", 
                         len(groups), totalDistributed.String())
         }
+
+        if err := vs.rewardLedger.RecordBlockRewards(record); err != nil {
+                log.Printf("⚠️  Failed to record block rewards: %v", err)
+        }
+}
+
+// creditValidatorReward adds a validator's reward to the block's reward
+// record, sharing it with the validator's delegators after commission.
+func (vs *ValidatorService) creditValidatorReward(record *BlockRewardRecord, validatorID string, reward *big.Int, kind RewardKind, networkGroup string) {
+        payouts := vs.stakingMgr.SplitValidatorReward(validatorID, reward)
+
+        recipients := make([]string, 0, len(payouts))
+        for addr := range payouts {
+                recipients = append(recipients, addr)
+        }
+        sort.Strings(recipients)
+
+        for _, addr := range recipients {
+                entryKind := kind
+                if addr != validatorID {
+                        entryKind = RewardKindDelegation
+                }
+                record.AddEntry(&RewardEntry{
+                        Recipient:    addr,
+                        ValidatorID:  validatorID,
+                        Kind:         entryKind,
+                        NetworkGroup: networkGroup,
+                        Amount:       payouts[addr],
+                })
+        }
 }
 
 func (vs *ValidatorService) calculateBlockReward(blockHeight uint64) *big.Int {
@@ -352,6 +777,14 @@ func (vs *ValidatorService) validateBlock(block *core.Block) error {
                 return err
         }
 
+        proposer, err := vs.state.GetValidator(block.ProposerID)
+        if err != nil || proposer == nil {
+                return fmt.Errorf("unknown proposer %s", shortValidatorID(block.ProposerID))
+        }
+        if err := verifyBlockSignature(block, proposer.PublicKey); err != nil {
+                return err
+        }
+
         if !vs.poh.VerifySequence(block.PoHSequence) {
                 return fmt.Errorf("invalid PoH sequence")
         }
@@ -364,6 +797,27 @@ func (vs *ValidatorService) validateBlock(block *core.Block) error {
                 return fmt.Errorf("merkle root mismatch")
         }
 
+        if err := vs.validateBlockFees(block); err != nil {
+                return err
+        }
+
+        if err := vs.retargetMgr.VerifyHeaderThresholds(block.Header); err != nil {
+                return err
+        }
+
+        if parent, err := vs.blockchain.GetBlockByHeight(block.Header.Height - 1); err == nil {
+                parentHash, _ := parent.Hash()
+                if err := VerifyLastCommit(vs.state, parentHash, block.LastCommit); err != nil {
+                        return fmt.Errorf("invalid last commit: %w", err)
+                }
+                // Genesis is never voted on, so block 1 carries an empty commit
+                if parent.Header.Height > 0 {
+                        if err := VerifyCommitQuorum(vs.state, vs.stakingMgr, block.LastCommit); err != nil {
+                                return fmt.Errorf("invalid last commit: %w", err)
+                        }
+                }
+        }
+
         // Anti-spam: Limit new addresses per block (prevent wallet creation spam attacks)
         newAddresses := make(map[string]bool)
         for _, tx := range block.Transactions {
@@ -396,6 +850,10 @@ func (vs *ValidatorService) validateBlock(block *core.Block) error {
                 }
         }
 
+        if err := vs.verifyProposerSlot(block); err != nil {
+                return fmt.Errorf("proposer slot verification failed: %w", err)
+        }
+
         // Verify VRF proof on-chain
         if err := ValidateVRFProofOnChain(block, validators, vs.vrfSystem); err != nil {
                 log.Printf("❌ VRF proof verification failed: %v", err)
@@ -408,7 +866,7 @@ func (vs *ValidatorService) validateBlock(block *core.Block) error {
         return nil
 }
 
-func (vs *ValidatorService) selectAndValidateTransactions(txs []*core.Transaction) []*core.Transaction {
+func (vs *ValidatorService) selectAndValidateTransactions(txs []*core.Transaction, baseFee *big.Int) []*core.Transaction {
         valid := make([]*core.Transaction, 0)
 
         sort.Slice(txs, func(i, j int) bool {
@@ -416,7 +874,7 @@ func (vs *ValidatorService) selectAndValidateTransactions(txs []*core.Transactio
         })
 
         for _, tx := range txs {
-                if vs.validateTransaction(tx) {
+                if vs.validateTransaction(tx, baseFee) {
                         valid = append(valid, tx)
                 }
         }
@@ -424,7 +882,7 @@ func (vs *ValidatorService) selectAndValidateTransactions(txs []*core.Transactio
         return valid
 }
 
-func (vs *ValidatorService) validateTransaction(tx *core.Transaction) bool {
+func (vs *ValidatorService) validateTransaction(tx *core.Transaction, baseFee *big.Int) bool {
         account, err := vs.state.GetAccount(tx.From)
         if err != nil {
                 return false
@@ -439,6 +897,40 @@ func (vs *ValidatorService) validateTransaction(tx *core.Transaction) bool {
                 return false
         }
 
+        // Fee is the sender's cap and must cover the burned base fee
+        if tx.Fee.Cmp(TransactionBaseFee(baseFee, tx)) < 0 {
+                return false
+        }
+
+        if IsStakingTx(tx) {
+                if err := vs.stakingMgr.ValidateStakingTx(tx); err != nil {
+                        return false
+                }
+        }
+
+        if IsRewardTx(tx) {
+                if err := vs.rewardLedger.ValidateWithdrawTx(tx); err != nil {
+                        return false
+                }
+        }
+
+        if IsUnjailTx(tx) {
+                if vs.livenessTracker == nil {
+                        return false
+                }
+                nextHeight := vs.blockchain.GetLatestBlock().Header.Height + 1
+                if err := vs.livenessTracker.ValidateUnjailTx(tx, nextHeight); err != nil {
+                        return false
+                }
+        }
+
+        if IsPoBTx(tx) {
+                nextHeight := vs.blockchain.GetLatestBlock().Header.Height + 1
+                if err := vs.pobRounds.ValidatePoBTx(tx, nextHeight); err != nil {
+                        return false
+                }
+        }
+
         if len(tx.Signature) > 0 {
                 txHash, err := tx.Hash()
                 if err != nil {
@@ -470,22 +962,22 @@ func (vs *ValidatorService) validateTransaction(tx *core.Transaction) bool {
         return true
 }
 
-func (vs *ValidatorService) applyTransaction(tx *core.Transaction) {
+// applyTransaction moves tx.Amount to the recipient and charges tx.Fee to
+// the sender. The base fee part of the fee is burned and the remaining tip is
+// returned for the proposer.
+func (vs *ValidatorService) applyTransaction(tx *core.Transaction, baseFee *big.Int) (*big.Int, *big.Int) {
         fromAccount, _ := vs.state.GetAccount(tx.From)
         toAccount, _ := vs.state.GetAccount(tx.To)
 
-        // Whitepaper Bab 7.3: Base Fee Burning (EIP-1559 style)
-        // Base Fee = 0.00000001% of transaction value = amount × 0.0000000001
-        baseFee := new(big.Int).Mul(tx.Amount, big.NewInt(1))        // amount × 1
-        baseFee = baseFee.Div(baseFee, big.NewInt(10000000000))      // ÷ 10^10 = 0.00000001%
-        
-        // Priority fee goes to proposer (handled in distributeBlockRewards)
-        priorityFee := new(big.Int).Sub(tx.Fee, baseFee)
-        if priorityFee.Sign() < 0 {
-                priorityFee = big.NewInt(0)
+        if baseFee == nil {
+                baseFee = core.InitialBaseFee
+        }
+        burned, tip := SplitTransactionFee(baseFee, tx)
+        if burned.Cmp(tx.Fee) > 0 {
+                burned = new(big.Int).Set(tx.Fee)
         }
 
-        // Total cost: amount + priority fee + base fee
+        // Total cost: amount + fee (base fee + priority tip)
         totalCost := new(big.Int).Add(tx.Amount, tx.Fee)
         fromAccount.Balance.Sub(fromAccount.Balance, totalCost)
         fromAccount.Nonce++
@@ -493,14 +985,10 @@ func (vs *ValidatorService) applyTransaction(tx *core.Transaction) {
         // Recipient gets the amount (not including any fees)
         toAccount.Balance.Add(toAccount.Balance, tx.Amount)
 
-        // Base fee is BURNED (not added to anyone's balance)
-        // This is deflationary mechanism as per whitepaper
-        if baseFee.Cmp(big.NewInt(0)) > 0 {
-                log.Printf("🔥 Base fee burned: %s RNR from tx %s", baseFee.String(), tx.ID[:16])
-        }
-
         vs.state.UpdateAccount(fromAccount)
         vs.state.UpdateAccount(toAccount)
+
+        return burned, tip
 }
 
 func (vs *ValidatorService) calculateMerkleRoot(txs []*core.Transaction) ([]byte, error) {
@@ -520,11 +1008,7 @@ func (vs *ValidatorService) signBlock(block *core.Block) ([]byte, error) {
         if err != nil {
                 return nil, err
         }
-        r, s, err := ecdsa.Sign(rand.Reader, vs.privateKey, hash)
-        if err != nil {
-                return nil, err
-        }
-        return append(r.Bytes(), s.Bytes()...), nil
+        return signDigest(hash, vs.privateKey)
 }
 
 func (vs *ValidatorService) RunPoBTest(candidateID string) (float64, error) {
@@ -575,10 +1059,129 @@ func (vs *ValidatorService) RunPoBTest(candidateID string) (float64, error) {
         return score, nil
 }
 
+func (vs *ValidatorService) GetStakingManager() *StakingManager {
+        return vs.stakingMgr
+}
+
+// SetLivenessTracker enables missed-block tracking and downtime jailing
+func (vs *ValidatorService) SetLivenessTracker(tracker *LivenessTracker) {
+        vs.livenessTracker = tracker
+}
+
+// SetASNResolver groups validators by origin AS when distributing PoB rewards.
+// Rewards are consensus, so the resolver must be pinned to the genesis
+// dataset hash (network.NewPinnedASNResolver); without one validators are
+// grouped by subnet on every node alike.
+func (vs *ValidatorService) SetASNResolver(resolver *network.ASNResolver) {
+        vs.asnResolver = resolver
+}
+
+// SetByzantineDetector reports upheld PoB disputes as speed test cheats and
+// flagged PoB testers as suspected colluders
+func (vs *ValidatorService) SetByzantineDetector(detector *ByzantineDetector) {
+        vs.pobRounds.SetByzantineDetector(detector)
+        vs.collusion.SetByzantineDetector(detector)
+}
+
+// SetZKProofSystem installs the PoB proof system (normally loaded from the
+// setup ceremony keys) used by both PoB test managers.
+func (vs *ValidatorService) SetZKProofSystem(zkSystem *ZKProofSystem) {
+        vs.pobManager.SetZKProofSystem(zkSystem)
+        vs.p2pSpeedTestMgr.SetZKProofSystem(zkSystem)
+        vs.pobRounds.SetZKProofSystem(zkSystem)
+}
+
+// buildLastCommit collects the votes this node saw on the parent block, in
+// canonical order, for inclusion in the next block.
+func (vs *ValidatorService) buildLastCommit(parentHash []byte) []*core.CommitSig {
+        votes, err := vs.votingManager.GetVotes(parentHash)
+        if err != nil {
+                return []*core.CommitSig{}
+        }
+
+        commit := make([]*core.CommitSig, 0, len(votes))
+        for _, vote := range votes {
+                commit = append(commit, &core.CommitSig{
+                        ValidatorID: vote.ValidatorID,
+                        Signature:   vote.Signature,
+                })
+        }
+        sort.Slice(commit, func(i, j int) bool {
+                return commit[i].ValidatorID < commit[j].ValidatorID
+        })
+
+        return commit
+}
+
+func (vs *ValidatorService) GetRewardLedger() *RewardLedger {
+        return vs.rewardLedger
+}
+
+func (vs *ValidatorService) GetPoBRoundManager() *PoBRoundManager {
+        return vs.pobRounds
+}
+
+// SetP2PNetwork connects the service to the P2P network, used to run PoB
+// challenges as a tester and answer them as a candidate, and to send votes
+// to proposers. The service publishes this validator's identity record and
+// verifies the records of others.
+func (vs *ValidatorService) SetP2PNetwork(p2p *network.P2PNetwork) {
+        vs.p2pNetwork = p2p
+        p2p.SetSpeedTestChallengeHandler(vs.answerPoBChallenge)
+        p2p.SetValidatorRecordVerifier(vs.VerifyValidatorRecord)
+        p2p.SetValidatorRecordSigner(vs.validatorID, vs.signValidatorRecord)
+}
+
 func (vs *ValidatorService) GetVRFPublicKey() ed25519.PublicKey {
         return vs.vrfSystem.GetPublicKey()
 }
 
+// proposerUploadBandwidth returns the measured upload bandwidth of a
+// proposer, defaulting to the whitepaper minimum when none is recorded.
+func (vs *ValidatorService) proposerUploadBandwidth(proposerID string) float64 {
+        validatorInfo, _ := vs.state.GetValidator(proposerID)
+        if validatorInfo != nil && validatorInfo.UploadBandwidth > 0 {
+                return validatorInfo.UploadBandwidth
+        }
+        return core.MinUploadBandwidth
+}
+
+// validateBlockFees checks the header's base fee and size accounting against
+// the parent block and the proposer's dynamic capacity, and that every
+// transaction's fee covers the base fee.
+func (vs *ValidatorService) validateBlockFees(block *core.Block) error {
+        parent, err := vs.blockchain.GetBlockByHeight(block.Header.Height - 1)
+        if err != nil {
+                return fmt.Errorf("parent block not found: %w", err)
+        }
+
+        expectedBaseFee := CalculateNextBaseFee(parent.Header)
+        if block.Header.BaseFee == nil || block.Header.BaseFee.Cmp(expectedBaseFee) != 0 {
+                return fmt.Errorf("invalid base fee: expected %s", expectedBaseFee.String())
+        }
+
+        expectedLimit := uint64(vs.calculateDynamicBlockCapacity(vs.proposerUploadBandwidth(block.ProposerID)))
+        if block.Header.SizeLimit != expectedLimit {
+                return fmt.Errorf("invalid block size limit: got %d, expected %d", block.Header.SizeLimit, expectedLimit)
+        }
+
+        sizeUsed := uint64(0)
+        for _, tx := range block.Transactions {
+                sizeUsed += uint64(blockchain.EstimateTransactionSize(tx))
+                if tx.Fee == nil || tx.Fee.Cmp(TransactionBaseFee(block.Header.BaseFee, tx)) < 0 {
+                        return fmt.Errorf("transaction %s fee below base fee", tx.ID)
+                }
+        }
+        if block.Header.SizeUsed != sizeUsed {
+                return fmt.Errorf("invalid block size used: got %d, expected %d", block.Header.SizeUsed, sizeUsed)
+        }
+        if sizeUsed > block.Header.SizeLimit {
+                return fmt.Errorf("block exceeds dynamic capacity: %d > %d bytes", sizeUsed, block.Header.SizeLimit)
+        }
+
+        return nil
+}
+
 // calculateDynamicBlockCapacity implements the whitepaper formula (Bab 4.2):
 // Kapasitas Blok Maks = 0.30 × Upload_Validator (MB/s) × 10 detik
 // This prevents network congestion by ensuring blocks match proposer's upload capability
@@ -598,15 +1201,12 @@ func (vs *ValidatorService) calculateDynamicBlockCapacity(uploadBandwidthMBps fl
         maxCapacityBytes := int(maxCapacityMB * 1024 * 1024)
         
         // Apply reasonable bounds (min 5 MB, max 300 MB per block)
-        minCapacityBytes := 5 * 1024 * 1024   // 5 MB minimum
-        maxCapacityBytes_limit := 300 * 1024 * 1024 // 300 MB maximum
-        
-        if maxCapacityBytes < minCapacityBytes {
-                maxCapacityBytes = minCapacityBytes
+        if maxCapacityBytes < core.MinBlockCapacityBytes {
+                maxCapacityBytes = core.MinBlockCapacityBytes
         }
         
-        if maxCapacityBytes > maxCapacityBytes_limit {
-                maxCapacityBytes = maxCapacityBytes_limit
+        if maxCapacityBytes > core.MaxBlockCapacityBytes {
+                maxCapacityBytes = core.MaxBlockCapacityBytes
         }
 
         return maxCapacityBytes
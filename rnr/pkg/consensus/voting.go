@@ -109,13 +109,10 @@ func (vm *VotingManager) CastVote(blockHash []byte, validatorID string, signatur
         session.mu.Lock()
         defer session.mu.Unlock()
 
-        if session.IsFinalized {
-                return errors.New("voting session already finalized")
-        }
-
-        if time.Now().After(session.VotingDeadline) {
-                return errors.New("voting deadline exceeded")
-        }
+        // Votes keep counting past the vote count and the voting phase: a
+        // block is final once its votes hold two thirds of the bonded stake
+        // (VerifyCommitQuorum), and late votes still go into the next
+        // LastCommit
 
         // SECURITY FIX: Check if validator already voted in this session
         if _, hasVoted := session.Votes[validatorID]; hasVoted {
@@ -254,11 +251,25 @@ func (vm *VotingManager) CleanupOldSessions(maxAge time.Duration) {
 }
 
 func SignVote(blockHash []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
-        r, s, err := ecdsa.Sign(rand.Reader, privateKey, blockHash)
+        // Padded r||s: an unpadded short r or s would split unevenly in VerifyVote
+        signature, err := signDigest(blockHash, privateKey)
         if err != nil {
                 return nil, fmt.Errorf("failed to sign vote: %w", err)
         }
-        signature := append(r.Bytes(), s.Bytes()...)
+        return signature, nil
+}
+
+// signDigest signs digest as r||s with each half padded to 32 bytes, so the
+// signature always splits evenly in VerifyVote
+func signDigest(digest []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+        r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest)
+        if err != nil {
+                return nil, err
+        }
+
+        signature := make([]byte, 64)
+        r.FillBytes(signature[:32])
+        s.FillBytes(signature[32:])
         return signature, nil
 }
 
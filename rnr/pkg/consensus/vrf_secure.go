@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/big"
+	"sort"
 
 	"github.com/yahoo/coname/vrf"
 )
@@ -63,69 +64,53 @@ func VerifyVRFWithPublicKey(input []byte, vrfOutput []byte, proof []byte, pubKey
 }
 
 func DeterministicSelectProposer(seed []byte, validators []string, validatorKeys map[string][]byte) (string, error) {
-	if len(validators) == 0 {
-		return "", fmt.Errorf("no validators available")
+	ranking, err := DeterministicRankProposers(seed, validators, validatorKeys)
+	if err != nil {
+		return "", err
 	}
+	return ranking[0], nil
+}
 
-	lowestHash := new(big.Int).SetBytes(make([]byte, 32))
-	for i := range lowestHash.Bytes() {
-		lowestHash.Bytes()[i] = 0xFF
+// DeterministicRankProposers orders all validators by their selection hash
+// for the seed. The first entry is the proposer; the rest are its backups in
+// fallback order.
+func DeterministicRankProposers(seed []byte, validators []string, validatorKeys map[string][]byte) ([]string, error) {
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("no validators available")
 	}
-	
-	selectedProposer := validators[0]
 
+	scores := make(map[string]*big.Int, len(validators))
 	for _, validatorID := range validators {
-		input := append(seed, []byte(validatorID)...)
-		
-		validatorPubKey := validatorKeys[validatorID]
-		if len(validatorPubKey) > 0 {
-			input = append(input, validatorPubKey...)
-		}
-		
-		hash := sha256.Sum256(input)
-		hashValue := new(big.Int).SetBytes(hash[:])
-		
-		if hashValue.Cmp(lowestHash) < 0 {
-			lowestHash = hashValue
-			selectedProposer = validatorID
-		}
+		scores[validatorID] = proposerHash(seed, validatorID, validatorKeys[validatorID])
 	}
 
-	return selectedProposer, nil
+	return rankByScore(validators, scores), nil
 }
 
 func PoBWeightedSelectProposer(seed []byte, validators []string, validatorKeys map[string][]byte, pobScores map[string]float64) (string, error) {
-	if len(validators) == 0 {
-		return "", fmt.Errorf("no validators available")
+	ranking, err := PoBWeightedRankProposers(seed, validators, validatorKeys, pobScores)
+	if err != nil {
+		return "", err
 	}
+	return ranking[0], nil
+}
 
-	lowestWeightedHash := new(big.Int).SetBytes(make([]byte, 32))
-	for i := range lowestWeightedHash.Bytes() {
-		lowestWeightedHash.Bytes()[i] = 0xFF
+// PoBWeightedRankProposers orders all validators by PoB-weighted selection
+// hash for the seed. Higher PoB scores shrink the hash, moving validators up
+// the ranking. The first entry is the proposer; the rest are its backups.
+func PoBWeightedRankProposers(seed []byte, validators []string, validatorKeys map[string][]byte, pobScores map[string]float64) ([]string, error) {
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("no validators available")
 	}
-	
-	selectedProposer := validators[0]
-	maxPoBScore := 0.0
 
+	scores := make(map[string]*big.Int, len(validators))
 	for _, validatorID := range validators {
 		pobScore := pobScores[validatorID]
 		if pobScore == 0 {
 			pobScore = 0.5
 		}
 
-		if pobScore > maxPoBScore {
-			maxPoBScore = pobScore
-		}
-
-		input := append(seed, []byte(validatorID)...)
-		
-		validatorPubKey := validatorKeys[validatorID]
-		if len(validatorPubKey) > 0 {
-			input = append(input, validatorPubKey...)
-		}
-		
-		hash := sha256.Sum256(input)
-		hashValue := new(big.Int).SetBytes(hash[:])
+		hashValue := proposerHash(seed, validatorID, validatorKeys[validatorID])
 
 		weightFactor := big.NewFloat(2.0 - pobScore)
 		if pobScore < 0.1 {
@@ -134,16 +119,35 @@ func PoBWeightedSelectProposer(seed []byte, validators []string, validatorKeys m
 
 		hashFloat := new(big.Float).SetInt(hashValue)
 		weightedHashFloat := new(big.Float).Mul(hashFloat, weightFactor)
-		
+
 		weightedHash, _ := weightedHashFloat.Int(nil)
-		
-		if weightedHash.Cmp(lowestWeightedHash) < 0 {
-			lowestWeightedHash = weightedHash
-			selectedProposer = validatorID
-		}
+		scores[validatorID] = weightedHash
 	}
 
-	return selectedProposer, nil
+	return rankByScore(validators, scores), nil
+}
+
+func proposerHash(seed []byte, validatorID string, validatorPubKey []byte) *big.Int {
+	input := make([]byte, 0, len(seed)+len(validatorID)+len(validatorPubKey))
+	input = append(input, seed...)
+	input = append(input, []byte(validatorID)...)
+	if len(validatorPubKey) > 0 {
+		input = append(input, validatorPubKey...)
+	}
+
+	hash := sha256.Sum256(input)
+	return new(big.Int).SetBytes(hash[:])
+}
+
+// rankByScore sorts validators by ascending score. The sort is stable so
+// equal scores keep the input order.
+func rankByScore(validators []string, scores map[string]*big.Int) []string {
+	ranking := make([]string, len(validators))
+	copy(ranking, validators)
+	sort.SliceStable(ranking, func(i, j int) bool {
+		return scores[ranking[i]].Cmp(scores[ranking[j]]) < 0
+	})
+	return ranking
 }
 
 func SecureSelectProposer(blockHash []byte, validators []string, validatorKeys map[string][]byte, vrfSys *SecureVRFSystem) (string, error) {
@@ -189,6 +193,35 @@ func SecureSelectTestCommittee(candidateID string, blockHash []byte, validators
 	return selected, nil
 }
 
+// SelectWeightedTestCommittee picks count testers for candidateID like
+// SecureSelectTestCommittee, but divides each tester's hash score by its
+// weight, so testers with a lower weight are picked less often. Weights
+// below 1 count as 1.
+func SelectWeightedTestCommittee(candidateID string, seed []byte, validators []string, weights map[string]int, count int) []string {
+	scores := make(map[string]*big.Int, len(validators))
+	pool := make([]string, 0, len(validators))
+	for _, validatorID := range validators {
+		if validatorID == candidateID {
+			continue
+		}
+		input := append(append([]byte{}, seed...), []byte(candidateID+validatorID)...)
+		hash := sha256.Sum256(input)
+
+		weight := int64(weights[validatorID])
+		if weight < 1 {
+			weight = 1
+		}
+		scores[validatorID] = new(big.Int).Div(new(big.Int).SetBytes(hash[:]), big.NewInt(weight))
+		pool = append(pool, validatorID)
+	}
+
+	ranking := rankByScore(pool, scores)
+	if count > len(ranking) {
+		count = len(ranking)
+	}
+	return ranking[:count]
+}
+
 func (v *SecureVRFSystem) GetPublicKey() []byte {
 	return v.publicKey
 }
@@ -34,12 +34,14 @@ func VerifyBlockVRFProof(block *core.Block, vrfPublicKey []byte, input []byte, v
                 }
         }
 
-        log.Printf("✅ VRF proof verified for block proposer %s", block.ProposerID[:8])
+        log.Printf("✅ VRF proof verified for block proposer %s", shortValidatorID(block.ProposerID))
         return nil
 }
 
-// VerifyVRFProposerSelection verifies that proposer was correctly selected via VRF
-// Checks that VRF output falls within proposer's selection range based on PoB score
+// VerifyVRFProposerSelection verifies that the proposer is eligible to
+// propose: an active, unsuspended validator with sufficient PoB score. Its
+// place in the ranked proposer list is checked by
+// ValidatorService.verifyProposerSlot.
 func VerifyVRFProposerSelection(
         block *core.Block,
         validators []*core.ValidatorInfo,
@@ -63,62 +65,17 @@ func VerifyVRFProposerSelection(
                 return fmt.Errorf("proposer %s not found in validator set", block.ProposerID[:8])
         }
 
-        // Verify proposer has sufficient PoB score
-        if proposer.PoBScore < 0.5 {
-                return fmt.Errorf("proposer PoB score too low: %.3f", proposer.PoBScore)
-        }
-
-        // Calculate total weighted stake
-        totalWeight := 0.0
-        for _, v := range validators {
-                if v.IsActive && !v.IsSuspended {
-                        totalWeight += v.PoBScore
-                }
-        }
-
-        // Verify VRF output corresponds to valid selection
-        vrfOutput := block.Header.VRFOutput
-        if vrfOutput == nil {
-                vrfOutput = block.VRFProof[:32]
-        }
-
-        // Convert VRF output to selection value [0, 1)
-        selectionValue := float64(vrfOutput[0]) / 256.0
-
-        // Calculate proposer's selection range
-        cumulativeWeight := 0.0
-        proposerStart := 0.0
-        proposerEnd := 0.0
-
-        for _, v := range validators {
-                if !v.IsActive || v.IsSuspended {
-                        continue
-                }
-
-                rangeStart := cumulativeWeight / totalWeight
-                cumulativeWeight += v.PoBScore
-                rangeEnd := cumulativeWeight / totalWeight
-
-                if v.ID == block.ProposerID {
-                        proposerStart = rangeStart
-                        proposerEnd = rangeEnd
-                        break
-                }
+        if !proposer.IsActive || proposer.IsSuspended {
+                return fmt.Errorf("proposer %s is not an active validator", block.ProposerID[:8])
         }
 
-        // Verify selection value falls within proposer's range
-        if selectionValue < proposerStart || selectionValue >= proposerEnd {
-                return fmt.Errorf(
-                        "VRF selection mismatch: value %.4f not in proposer range [%.4f, %.4f)",
-                        selectionValue, proposerStart, proposerEnd,
-                )
+        // Verify proposer has sufficient PoB score
+        if proposer.PoBScore < core.MinProposerPoBScore {
+                return fmt.Errorf("proposer PoB score too low: %.3f", proposer.PoBScore)
         }
 
-        log.Printf("✅ VRF proposer selection verified:")
-        log.Printf("   Proposer: %s", block.ProposerID[:8])
-        log.Printf("   PoB Score: %.3f", proposer.PoBScore)
-        log.Printf("   Selection value: %.4f in range [%.4f, %.4f)", 
-                selectionValue, proposerStart, proposerEnd)
+        log.Printf("✅ VRF proposer eligibility verified: %s (PoB Score: %.3f)",
+                block.ProposerID[:8], proposer.PoBScore)
 
         return nil
 }
@@ -174,7 +131,9 @@ func ValidateVRFProofOnChain(
                 return fmt.Errorf("VRF proof verification failed: %w", err)
         }
 
-        // Step 2: Verify proposer selection is correct based on VRF output
+        // Step 2: Verify the proposer is eligible to propose. Its rank (elected
+        // proposer or a backup whose fallback slot has started) is checked
+        // against the ranked proposer list by ValidatorService.verifyProposerSlot.
         if err := VerifyVRFProposerSelection(block, validators, vrfSystem); err != nil {
                 return fmt.Errorf("VRF proposer selection verification failed: %w", err)
         }
@@ -0,0 +1,443 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	nativemimc "github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"rnr-blockchain/pkg/core"
+)
+
+// Committee aggregation proof (Whitepaper Bab 3.1.3).
+//
+// A candidate's PoB result is the median of its committee testers'
+// measurements. PoBAggregationCircuit proves, against a commitment to those
+// measurements, that:
+//   - the published medians are the medians of the included measurements,
+//   - Score is calculatePoBScore of the medians (per-mille, fixed point),
+//   - Passed is the outcome against the given PoBThresholds.
+//
+// Everything is an integer: measurements and thresholds in milli-units
+// (MB/s, ms and % ×1000), medians doubled (lower + upper middle value) so the
+// average of an even number of measurements stays exact.
+//
+// There is a slot for every tester of the largest committee
+// (core.MaxTestCommitteeSize); slots of smaller committees stay excluded.
+
+const (
+	PoBAggregationSlots  = core.MaxTestCommitteeSize
+	MinAggregatedResults = 2
+
+	// Measurements and thresholds must fit in aggregationValueBits;
+	// intermediate values in aggregationRangeBits.
+	aggregationValueBits = 32
+	aggregationRangeBits = 36
+
+	// calculatePoBScore reference points in circuit units
+	scoreUploadDivisor2  = 2 * 7         // 7 MB/s per mille, doubled median
+	scoreLatencyNumer2   = 2 * 100000000 // 100 ms × 1000 per-mille, doubled median
+	scorePacketLossSlope = 5             // 1000 per-mille / 0.1% / 2 (doubled median)
+	scoreMax             = 1000
+)
+
+type PoBAggregationCircuit struct {
+	Session    frontend.Variable                      `gnark:",secret"`
+	Upload     [PoBAggregationSlots]frontend.Variable `gnark:",secret"`
+	Latency    [PoBAggregationSlots]frontend.Variable `gnark:",secret"`
+	PacketLoss [PoBAggregationSlots]frontend.Variable `gnark:",secret"`
+	Included   [PoBAggregationSlots]frontend.Variable `gnark:",secret"`
+
+	// Prover-supplied helpers, all constrained below
+	UploadLo, UploadHi         frontend.Variable `gnark:",secret"`
+	LatencyLo, LatencyHi       frontend.Variable `gnark:",secret"`
+	PacketLossLo, PacketLossHi frontend.Variable `gnark:",secret"`
+	LowRank, HighRank          frontend.Variable `gnark:",secret"`
+	UploadScore, LatencyScore  frontend.Variable `gnark:",secret"`
+
+	Commitment        frontend.Variable `gnark:",public"`
+	MedianUpload2     frontend.Variable `gnark:",public"`
+	MedianLatency2    frontend.Variable `gnark:",public"`
+	MedianPacketLoss2 frontend.Variable `gnark:",public"`
+	MinUpload         frontend.Variable `gnark:",public"`
+	MaxLatency        frontend.Variable `gnark:",public"`
+	MaxPacketLoss     frontend.Variable `gnark:",public"`
+	Score             frontend.Variable `gnark:",public"`
+	Passed            frontend.Variable `gnark:",public"`
+}
+
+func (c *PoBAggregationCircuit) Define(api frontend.API) error {
+	h, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	h.Write(c.Session)
+	count := frontend.Variable(0)
+	for i := 0; i < PoBAggregationSlots; i++ {
+		api.AssertIsBoolean(c.Included[i])
+		api.ToBinary(c.Upload[i], aggregationValueBits)
+		api.ToBinary(c.Latency[i], aggregationValueBits)
+		api.ToBinary(c.PacketLoss[i], aggregationValueBits)
+
+		h.Write(packMeasurement(api, c.Upload[i], c.Latency[i], c.PacketLoss[i], c.Included[i]))
+		count = api.Add(count, c.Included[i])
+	}
+	api.AssertIsEqual(h.Sum(), c.Commitment)
+	api.AssertIsEqual(lessThan(api, count, MinAggregatedResults), 0)
+
+	// Ranks of the lower and upper middle value: LowRank + HighRank = count-1
+	// and they differ by count's parity
+	api.ToBinary(c.LowRank, 2)
+	api.AssertIsEqual(api.Add(c.LowRank, c.HighRank), api.Sub(count, 1))
+	api.AssertIsBoolean(api.Sub(c.HighRank, c.LowRank))
+
+	medians := []struct {
+		values  [PoBAggregationSlots]frontend.Variable
+		lo, hi  frontend.Variable
+		median2 frontend.Variable
+	}{
+		{c.Upload, c.UploadLo, c.UploadHi, c.MedianUpload2},
+		{c.Latency, c.LatencyLo, c.LatencyHi, c.MedianLatency2},
+		{c.PacketLoss, c.PacketLossLo, c.PacketLossHi, c.MedianPacketLoss2},
+	}
+	for _, m := range medians {
+		assertRank(api, m.values, c.Included, m.lo, c.LowRank, c.HighRank)
+		assertRank(api, m.values, c.Included, m.hi, c.HighRank, c.LowRank)
+		api.AssertIsEqual(api.Add(m.lo, m.hi), m.median2)
+	}
+
+	// Upload score: min(upload / 7 MB/s, 1)
+	uploadCapped := api.Sub(1, lessThan(api, c.MedianUpload2, scoreUploadDivisor2*scoreMax))
+	api.ToBinary(c.UploadScore, 11)
+	uploadRem := api.Select(uploadCapped, 0, api.Sub(c.MedianUpload2, api.Mul(c.UploadScore, scoreUploadDivisor2)))
+	api.ToBinary(uploadRem, aggregationRangeBits)
+	api.AssertIsEqual(lessThan(api, uploadRem, scoreUploadDivisor2), 1)
+	api.AssertIsEqual(api.Select(uploadCapped, c.UploadScore, scoreMax), scoreMax)
+
+	// Latency score: min(100 ms / latency, 1)
+	latencyCapped := api.Sub(1, lessThan(api, scoreLatencyNumer2/scoreMax, c.MedianLatency2))
+	api.ToBinary(c.LatencyScore, 11)
+	latencyRem := api.Select(latencyCapped, 0, api.Sub(scoreLatencyNumer2, api.Mul(c.LatencyScore, c.MedianLatency2)))
+	api.ToBinary(latencyRem, aggregationRangeBits)
+	api.AssertIsEqual(api.Select(latencyCapped, 1, lessThan(api, latencyRem, c.MedianLatency2)), 1)
+	api.AssertIsEqual(api.Select(latencyCapped, c.LatencyScore, scoreMax), scoreMax)
+
+	// Packet loss score: max(1 - loss / 0.1%, 0)
+	lossPenalty := api.Mul(c.MedianPacketLoss2, scorePacketLossSlope)
+	lossScore := api.Select(lessThan(api, scoreMax, lossPenalty), 0, api.Sub(scoreMax, lossPenalty))
+
+	// Score = floor(average of the three)
+	api.ToBinary(c.Score, 11)
+	scoreRem := api.Sub(api.Add(c.UploadScore, c.LatencyScore, lossScore), api.Mul(c.Score, 3))
+	api.ToBinary(scoreRem, 2)
+	api.AssertIsEqual(lessThan(api, scoreRem, 3), 1)
+
+	// Pass/fail against the thresholds
+	api.ToBinary(c.MinUpload, aggregationValueBits)
+	api.ToBinary(c.MaxLatency, aggregationValueBits)
+	api.ToBinary(c.MaxPacketLoss, aggregationValueBits)
+	uploadOK := api.Sub(1, lessThan(api, c.MedianUpload2, api.Mul(c.MinUpload, 2)))
+	latencyOK := api.Sub(1, lessThan(api, api.Mul(c.MaxLatency, 2), c.MedianLatency2))
+	lossOK := api.Sub(1, lessThan(api, api.Mul(c.MaxPacketLoss, 2), c.MedianPacketLoss2))
+	api.AssertIsEqual(api.Mul(uploadOK, latencyOK, lossOK), c.Passed)
+
+	return nil
+}
+
+// CompilePoBAggregationCircuit compiles PoBAggregationCircuit to its BN254 R1CS.
+func CompilePoBAggregationCircuit() (constraint.ConstraintSystem, error) {
+	circuit := PoBAggregationCircuit{}
+
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile aggregation circuit: %w", err)
+	}
+
+	return ccs, nil
+}
+
+// assertRank constrains v to be the included value of the given rank:
+// at most `below` included values are smaller and at most `above` larger.
+// Together with below+above = count-1 this forces v to be one of them.
+func assertRank(api frontend.API, values, included [PoBAggregationSlots]frontend.Variable, v, below, above frontend.Variable) {
+	api.ToBinary(v, aggregationValueBits)
+
+	smaller := frontend.Variable(0)
+	notLarger := frontend.Variable(0)
+	count := frontend.Variable(0)
+	for i := 0; i < PoBAggregationSlots; i++ {
+		less := lessThan(api, values[i], v)
+		equal := api.IsZero(api.Sub(values[i], v))
+		smaller = api.Add(smaller, api.Mul(included[i], less))
+		notLarger = api.Add(notLarger, api.Mul(included[i], api.Add(less, equal)))
+		count = api.Add(count, included[i])
+	}
+	larger := api.Sub(count, notLarger)
+	api.AssertIsEqual(lessThan(api, below, smaller), 0)
+	api.AssertIsEqual(lessThan(api, above, larger), 0)
+}
+
+// packMeasurement packs one tester's range-checked measurement into a single
+// field element for the commitment.
+func packMeasurement(api frontend.API, upload, latency, loss, included frontend.Variable) frontend.Variable {
+	return api.Add(upload,
+		api.Mul(latency, new(big.Int).Lsh(big.NewInt(1), aggregationValueBits)),
+		api.Mul(loss, new(big.Int).Lsh(big.NewInt(1), 2*aggregationValueBits)),
+		api.Mul(included, new(big.Int).Lsh(big.NewInt(1), 3*aggregationValueBits)))
+}
+
+// lessThan returns 1 if a < b, else 0, for a and b below 2^aggregationRangeBits.
+func lessThan(api frontend.API, a, b frontend.Variable) frontend.Variable {
+	// b - a - 1 + 2^n is at least 2^n exactly when a < b
+	shifted := api.Add(api.Sub(b, a, 1), new(big.Int).Lsh(big.NewInt(1), aggregationRangeBits))
+	return api.ToBinary(shifted, aggregationRangeBits+1)[aggregationRangeBits]
+}
+
+// PoBAggregationStatement is the public part of an aggregation proof. The
+// medians, thresholds and score are in circuit units (see above).
+type PoBAggregationStatement struct {
+	Commitment        string `json:"commitment"`
+	MedianUpload2     int64  `json:"median_upload_x2"`
+	MedianLatency2    int64  `json:"median_latency_x2"`
+	MedianPacketLoss2 int64  `json:"median_packet_loss_x2"`
+	MinUpload         int64  `json:"min_upload"`
+	MaxLatency        int64  `json:"max_latency"`
+	MaxPacketLoss     int64  `json:"max_packet_loss"`
+	Score             int64  `json:"score"`
+	Passed            bool   `json:"passed"`
+	Proof             []byte `json:"proof,omitempty"`
+}
+
+func (s *PoBAggregationStatement) UploadBandwidth() float64 {
+	return float64(s.MedianUpload2) / 2000
+}
+
+func (s *PoBAggregationStatement) Latency() float64 {
+	return float64(s.MedianLatency2) / 2000
+}
+
+func (s *PoBAggregationStatement) PacketLoss() float64 {
+	return float64(s.MedianPacketLoss2) / 2000
+}
+
+func (s *PoBAggregationStatement) ScoreValue() float64 {
+	return float64(s.Score) / scoreMax
+}
+
+// publicAssignment is the verifier's view of the statement.
+func (s *PoBAggregationStatement) publicAssignment() (*PoBAggregationCircuit, error) {
+	commitment, ok := new(big.Int).SetString(s.Commitment, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid measurement commitment")
+	}
+
+	return &PoBAggregationCircuit{
+		Commitment:        commitment,
+		MedianUpload2:     s.MedianUpload2,
+		MedianLatency2:    s.MedianLatency2,
+		MedianPacketLoss2: s.MedianPacketLoss2,
+		MinUpload:         s.MinUpload,
+		MaxLatency:        s.MaxLatency,
+		MaxPacketLoss:     s.MaxPacketLoss,
+		Score:             s.Score,
+		Passed:            boolToInt(s.Passed),
+	}, nil
+}
+
+// BuildPoBAggregation computes the committee aggregate of a session's tester
+// results exactly as the circuit checks it, and the full witness to prove
+// it. Results with anomalies or an unverified payload are committed to but
+// not included in the medians. Slots are ordered by tester ID, so anyone
+// holding the signed tester results can recompute the commitment.
+func BuildPoBAggregation(sessionID string, results []*SpeedTestResult, thresholds *PoBThresholds) (*PoBAggregationStatement, *PoBAggregationCircuit, error) {
+	if len(results) > PoBAggregationSlots {
+		return nil, nil, fmt.Errorf("too many tester results: %d (max %d)", len(results), PoBAggregationSlots)
+	}
+
+	sorted := make([]*SpeedTestResult, len(results))
+	copy(sorted, results)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].TesterID < sorted[j].TesterID })
+
+	session := fieldHash(sessionID)
+	w := &PoBAggregationCircuit{Session: session}
+	var uploads, latencies, losses []int64
+	h := nativemimc.NewMiMC()
+	writeField(h, session)
+
+	for i := 0; i < PoBAggregationSlots; i++ {
+		var upload, latency, loss, included int64
+		if i < len(sorted) {
+			r := sorted[i]
+			upload = toMilliUnits(r.UploadBandwidth)
+			latency = toMilliUnits(r.Latency)
+			loss = toMilliUnits(r.PacketLoss)
+			if len(r.Anomalies) == 0 && r.PayloadVerified {
+				included = 1
+				uploads = append(uploads, upload)
+				latencies = append(latencies, latency)
+				losses = append(losses, loss)
+			}
+		}
+
+		w.Upload[i] = upload
+		w.Latency[i] = latency
+		w.PacketLoss[i] = loss
+		w.Included[i] = included
+		packed := big.NewInt(included)
+		for _, v := range []int64{loss, latency, upload} {
+			packed.Lsh(packed, aggregationValueBits).Or(packed, big.NewInt(v))
+		}
+		writeField(h, packed)
+	}
+
+	count := len(uploads)
+	if count < MinAggregatedResults {
+		return nil, nil, fmt.Errorf("insufficient valid results: need %d, got %d", MinAggregatedResults, count)
+	}
+	lowRank, highRank := (count-1)/2, count/2
+
+	var commitment fr.Element
+	commitment.SetBytes(h.Sum(nil))
+
+	s := &PoBAggregationStatement{
+		Commitment:    commitment.Text(16),
+		MinUpload:     toMilliUnits(thresholds.MinUploadBandwidth),
+		MaxLatency:    toMilliUnits(thresholds.TargetLatency),
+		MaxPacketLoss: toMilliUnits(thresholds.TargetPacketLoss),
+	}
+
+	w.UploadLo, w.UploadHi, s.MedianUpload2 = middleValues(uploads, lowRank, highRank)
+	w.LatencyLo, w.LatencyHi, s.MedianLatency2 = middleValues(latencies, lowRank, highRank)
+	w.PacketLossLo, w.PacketLossHi, s.MedianPacketLoss2 = middleValues(losses, lowRank, highRank)
+	w.LowRank, w.HighRank = lowRank, highRank
+
+	uploadScore := int64(scoreMax)
+	if s.MedianUpload2 < scoreUploadDivisor2*scoreMax {
+		uploadScore = s.MedianUpload2 / scoreUploadDivisor2
+	}
+	latencyScore := int64(scoreMax)
+	if s.MedianLatency2 > scoreLatencyNumer2/scoreMax {
+		latencyScore = scoreLatencyNumer2 / s.MedianLatency2
+	}
+	lossScore := int64(scoreMax) - s.MedianPacketLoss2*scorePacketLossSlope
+	if lossScore < 0 {
+		lossScore = 0
+	}
+	w.UploadScore, w.LatencyScore = uploadScore, latencyScore
+	s.Score = (uploadScore + latencyScore + lossScore) / 3
+
+	s.Passed = s.MedianUpload2 >= 2*s.MinUpload &&
+		s.MedianLatency2 <= 2*s.MaxLatency &&
+		s.MedianPacketLoss2 <= 2*s.MaxPacketLoss
+
+	w.Commitment = commitment.BigInt(new(big.Int))
+	w.MedianUpload2 = s.MedianUpload2
+	w.MedianLatency2 = s.MedianLatency2
+	w.MedianPacketLoss2 = s.MedianPacketLoss2
+	w.MinUpload = s.MinUpload
+	w.MaxLatency = s.MaxLatency
+	w.MaxPacketLoss = s.MaxPacketLoss
+	w.Score = s.Score
+	w.Passed = boolToInt(s.Passed)
+
+	return s, w, nil
+}
+
+// ProveAggregation proves a witness built by BuildPoBAggregation.
+func (zk *ZKProofSystem) ProveAggregation(assignment *PoBAggregationCircuit) ([]byte, error) {
+	witness, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create witness: %w", err)
+	}
+
+	proof, err := groth16.Prove(zk.aggR1CS, zk.aggProvingKey, witness)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate aggregation proof: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize proof: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// VerifyAggregation checks the proof carried by an aggregation statement.
+func (zk *ZKProofSystem) VerifyAggregation(s *PoBAggregationStatement) (bool, error) {
+	if len(s.Proof) == 0 {
+		return false, fmt.Errorf("no aggregation proof provided")
+	}
+
+	assignment, err := s.publicAssignment()
+	if err != nil {
+		return false, err
+	}
+	publicWitness, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return false, fmt.Errorf("failed to create public witness: %w", err)
+	}
+
+	proof := groth16.NewProof(ecc.BN254)
+	if _, err := proof.ReadFrom(bytes.NewReader(s.Proof)); err != nil {
+		return false, fmt.Errorf("failed to unmarshal proof: %w", err)
+	}
+
+	if err := groth16.Verify(proof, zk.aggVerifyingKey, publicWitness); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// toMilliUnits converts a measurement to the circuit's milli-units, clamped
+// to the circuit's value range.
+func toMilliUnits(v float64) int64 {
+	if v <= 0 || math.IsNaN(v) {
+		return 0
+	}
+	milli := math.Round(v * 1000)
+	if milli >= 1<<aggregationValueBits {
+		return 1<<aggregationValueBits - 1
+	}
+	return int64(milli)
+}
+
+// fieldHash maps an identifier into the BN254 scalar field.
+func fieldHash(id string) *big.Int {
+	hash := sha256.Sum256([]byte(id))
+	var e fr.Element
+	e.SetBytes(hash[:])
+	return e.BigInt(new(big.Int))
+}
+
+func writeField(h hash.Hash, v *big.Int) {
+	var e fr.Element
+	e.SetBigInt(v)
+	b := e.Bytes()
+	h.Write(b[:])
+}
+
+func middleValues(values []int64, lowRank, highRank int) (lo, hi, median2 int64) {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	lo, hi = sorted[lowRank], sorted[highRank]
+	return lo, hi, lo + hi
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package consensus
+
+import (
+	"testing"
+)
+
+func testerResult(tester string, upload, latency, loss float64) *SpeedTestResult {
+	return &SpeedTestResult{
+		TesterID:        tester,
+		UploadBandwidth: upload,
+		Latency:         latency,
+		PacketLoss:      loss,
+		PayloadVerified: true,
+	}
+}
+
+// TestBuildPoBAggregation tests medians, score and pass/fail of the committee aggregate
+func TestBuildPoBAggregation(t *testing.T) {
+	thresholds := &PoBThresholds{MinUploadBandwidth: 7.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+
+	results := []*SpeedTestResult{
+		testerResult("c", 9.0, 40.0, 0.0),
+		testerResult("a", 8.0, 60.0, 0.05),
+		testerResult("b", 20.0, 50.0, 0.02),
+	}
+	s, _, err := BuildPoBAggregation("session", results, thresholds)
+	if err != nil {
+		t.Fatalf("BuildPoBAggregation failed: %v", err)
+	}
+	if s.UploadBandwidth() != 9.0 || s.Latency() != 50.0 || s.PacketLoss() != 0.02 {
+		t.Errorf("Expected medians 9/50/0.02, got %v/%v/%v", s.UploadBandwidth(), s.Latency(), s.PacketLoss())
+	}
+	if !s.Passed {
+		t.Errorf("Medians within thresholds should pass")
+	}
+	if s.Score != 933 {
+		t.Errorf("Expected score 933 (upload 1, latency 1, loss 0.8), got %d", s.Score)
+	}
+
+	// Anomalous results are excluded, leaving an even count
+	results[2].Anomalies = []string{"compressed payload"}
+	s, _, err = BuildPoBAggregation("session", results, thresholds)
+	if err != nil {
+		t.Fatalf("BuildPoBAggregation failed: %v", err)
+	}
+	if s.UploadBandwidth() != 8.5 || s.Latency() != 50.0 {
+		t.Errorf("Expected even-count medians 8.5/50, got %v/%v", s.UploadBandwidth(), s.Latency())
+	}
+
+	// Stricter thresholds fail the same measurements
+	strict := &PoBThresholds{MinUploadBandwidth: 10.0, TargetLatency: 100.0, TargetPacketLoss: 0.1}
+	if s, _, _ = BuildPoBAggregation("session", results, strict); s.Passed {
+		t.Errorf("Median upload below the threshold should fail")
+	}
+
+	results[1].PayloadVerified = false
+	if _, _, err := BuildPoBAggregation("session", results, thresholds); err == nil {
+		t.Errorf("A single valid result should not be aggregated")
+	}
+}
+
+// TestPoBAggregationProof tests proving and verifying an aggregated speed test result
+func TestPoBAggregationProof(t *testing.T) {
+	zk, err := NewZKProofSystem()
+	if err != nil {
+		t.Fatalf("NewZKProofSystem failed: %v", err)
+	}
+
+	psm := NewP2PSpeedTestManager()
+	psm.SetZKProofSystem(zk)
+
+	session, err := psm.InitiateP2PSpeedTest("candidate", []string{"candidate", "v1", "v2", "v3", "v4", "v5", "v6"})
+	if err != nil {
+		t.Fatalf("InitiateP2PSpeedTest failed: %v", err)
+	}
+	for i, tester := range session.CommitteeTesters {
+		result := testerResult(tester, 8.0+float64(i), 50.0+float64(i), 0.01)
+		if err := psm.SubmitTesterResult(session.SessionID, result); err != nil {
+			t.Fatalf("SubmitTesterResult failed: %v", err)
+		}
+	}
+
+	aggregated, err := psm.AggregateP2PResults(session.SessionID, nil)
+	if err != nil {
+		t.Fatalf("AggregateP2PResults failed: %v", err)
+	}
+	if aggregated.Aggregation == nil || len(aggregated.Aggregation.Proof) == 0 {
+		t.Fatalf("Aggregated result should carry a proven aggregation statement")
+	}
+	if aggregated.UploadBandwidth != 9.0 || !aggregated.Passed {
+		t.Errorf("Expected passing median upload 9, got %v (passed=%v)", aggregated.UploadBandwidth, aggregated.Passed)
+	}
+
+	if valid, err := psm.VerifyPoBResultZKProof(aggregated); err != nil || !valid {
+		t.Fatalf("Aggregation proof should verify (valid=%v, err=%v)", valid, err)
+	}
+
+	// Flipping the decision without the statement is caught by the match check
+	aggregated.Passed = false
+	if valid, _ := psm.VerifyPoBResultZKProof(aggregated); valid {
+		t.Errorf("Result not matching its statement should be rejected")
+	}
+
+	// Flipping both is caught by the proof
+	aggregated.Aggregation.Passed = false
+	if valid, _ := psm.VerifyPoBResultZKProof(aggregated); valid {
+		t.Errorf("Tampered aggregation statement should be rejected")
+	}
+}
@@ -0,0 +1,516 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	curve "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/groth16/bn254/mpcsetup"
+	"github.com/consensys/gnark/constraint"
+	cs "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// PoB circuit trusted setup.
+//
+// Every node must prove and verify PoB results against the same Groth16
+// keys, so the keys come from a multi-party ceremony rather than a per-node
+// groth16.Setup. The keys are sound as long as at least one participant
+// discarded their randomness. Phase 1 (powers of tau) is shared by all PoB
+// circuits; phase 2 runs once per circuit. The ceremony directory holds the
+// full transcript, and anyone can re-run FinalizeZKCeremony on it to check
+// that it yields the verifying keys pinned in the genesis config:
+//
+//	phase1_0000.bin …                   powers of tau
+//	phase2_<circuit>_0000.bin …         circuit specific contributions
+
+// ZKContribution describes one transcript entry written by the ceremony.
+type ZKContribution struct {
+	Phase   int
+	Circuit string
+	Index   int
+	Path    string
+	Hash    string
+}
+
+// zkCircuit is a circuit covered by the ceremony. The order is fixed: it
+// determines the verifying key set hash.
+type zkCircuit struct {
+	name    string
+	compile func() (constraint.ConstraintSystem, error)
+}
+
+var zkCircuits = []zkCircuit{
+	{name: "pob", compile: CompilePoBCircuit},
+	{name: "pob_aggregate", compile: CompilePoBAggregationCircuit},
+}
+
+// CompilePoBCircuit compiles PoBCircuit to its BN254 R1CS.
+func CompilePoBCircuit() (constraint.ConstraintSystem, error) {
+	circuit := PoBCircuit{}
+
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile circuit: %w", err)
+	}
+
+	return ccs, nil
+}
+
+// InitZKCeremony starts a ceremony in dir with the initial (trivial) phase 1
+// parameters, sized for the largest PoB circuit.
+func InitZKCeremony(dir string) (*ZKContribution, error) {
+	if len(ceremonyTranscript(dir, "phase1")) > 0 {
+		return nil, fmt.Errorf("ceremony already initialized in %s", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ceremony directory: %w", err)
+	}
+
+	_, power, err := compileCeremonyCircuits()
+	if err != nil {
+		return nil, err
+	}
+
+	srs1 := mpcsetup.InitPhase1(power)
+	return writeContribution(dir, 1, "", 0, &srs1, srs1.Hash)
+}
+
+// ContributeZKCeremony checks the latest transcript entries against their
+// predecessors, mixes in fresh randomness and writes the next entries: one
+// in phase 1, or one per circuit once StartZKCeremonyPhase2 has run.
+func ContributeZKCeremony(dir string) ([]*ZKContribution, error) {
+	if len(ceremonyTranscript(dir, phase2Prefix(zkCircuits[0].name))) == 0 {
+		paths := ceremonyTranscript(dir, "phase1")
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no ceremony found in %s", dir)
+		}
+		next := len(paths)
+
+		latest, err := readPhase1(paths[next-1])
+		if err != nil {
+			return nil, err
+		}
+		if next > 1 {
+			prev, err := readPhase1(paths[next-2])
+			if err != nil {
+				return nil, err
+			}
+			if err := mpcsetup.VerifyPhase1(prev, latest); err != nil {
+				return nil, fmt.Errorf("previous phase 1 contribution is invalid: %w", err)
+			}
+		}
+		latest.Contribute()
+
+		contribution, err := writeContribution(dir, 1, "", next, latest, latest.Hash)
+		if err != nil {
+			return nil, err
+		}
+		return []*ZKContribution{contribution}, nil
+	}
+
+	contributions := make([]*ZKContribution, 0, len(zkCircuits))
+	for _, circuit := range zkCircuits {
+		paths := ceremonyTranscript(dir, phase2Prefix(circuit.name))
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("phase 2 not started for circuit %s", circuit.name)
+		}
+		next := len(paths)
+
+		latest, err := readPhase2(paths[next-1])
+		if err != nil {
+			return nil, err
+		}
+		if next > 1 {
+			prev, err := readPhase2(paths[next-2])
+			if err != nil {
+				return nil, err
+			}
+			if err := mpcsetup.VerifyPhase2(prev, latest); err != nil {
+				return nil, fmt.Errorf("previous %s phase 2 contribution is invalid: %w", circuit.name, err)
+			}
+		}
+		latest.Contribute()
+
+		contribution, err := writeContribution(dir, 2, circuit.name, next, latest, latest.Hash)
+		if err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, contribution)
+	}
+
+	return contributions, nil
+}
+
+// StartZKCeremonyPhase2 verifies the phase 1 transcript and derives each
+// circuit's initial phase 2 parameters from its final entry.
+func StartZKCeremonyPhase2(dir string) ([]*ZKContribution, error) {
+	if len(ceremonyTranscript(dir, phase2Prefix(zkCircuits[0].name))) > 0 {
+		return nil, fmt.Errorf("phase 2 already started in %s", dir)
+	}
+
+	circuits, srs1, err := verifiedPhase1(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	contributions := make([]*ZKContribution, 0, len(zkCircuits))
+	for i, circuit := range zkCircuits {
+		srs2, _ := mpcsetup.InitPhase2(circuits[i], truncatePhase1(srs1, circuitPower(circuits[i])))
+
+		contribution, err := writeContribution(dir, 2, circuit.name, 0, &srs2, srs2.Hash)
+		if err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, contribution)
+	}
+
+	return contributions, nil
+}
+
+// FinalizeZKCeremony verifies the whole transcript, extracts every circuit's
+// proving and verifying keys into keysDir and returns the verifying key set
+// hash to pin in the genesis config. The result depends only on the
+// transcript.
+func FinalizeZKCeremony(dir, keysDir string) (string, error) {
+	circuits, srs1, err := verifiedPhase1(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(keysDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create keys directory: %w", err)
+	}
+
+	vks := make([]io.WriterTo, 0, len(zkCircuits))
+	for i, circuit := range zkCircuits {
+		paths := ceremonyTranscript(dir, phase2Prefix(circuit.name))
+		if len(paths) < 2 {
+			return "", fmt.Errorf("%s phase 2 needs at least one contribution", circuit.name)
+		}
+
+		contribs := make([]*mpcsetup.Phase2, len(paths))
+		for j, path := range paths {
+			if contribs[j], err = readPhase2(path); err != nil {
+				return "", err
+			}
+		}
+
+		// The first phase 2 entry must be the one derived from phase 1
+		ccs := circuits[i]
+		initial, evals := mpcsetup.InitPhase2(ccs, truncatePhase1(srs1, circuitPower(ccs)))
+		if !samePhase2Parameters(&initial, contribs[0]) {
+			return "", fmt.Errorf("%s phase 2 was not initialized from the phase 1 transcript", circuit.name)
+		}
+		if err := mpcsetup.VerifyPhase2(contribs[0], contribs[1], contribs[2:]...); err != nil {
+			return "", fmt.Errorf("%s phase 2 transcript is invalid: %w", circuit.name, err)
+		}
+
+		pk, vk := mpcsetup.ExtractKeys(srs1, contribs[len(contribs)-1], &evals, ccs.GetNbConstraints())
+
+		provingFile, verifyingFile := zkKeyFiles(keysDir, circuit.name)
+		if err := writeToFile(provingFile, &pk); err != nil {
+			return "", err
+		}
+		if err := writeToFile(verifyingFile, &vk); err != nil {
+			return "", err
+		}
+		vks = append(vks, &vk)
+	}
+
+	return verifyingKeyHash(vks...)
+}
+
+// LoadZKProofSystem loads the ceremony keys from keysDir. When
+// expectedVKHash is set (from the genesis config), the verifying keys must
+// match it.
+func LoadZKProofSystem(keysDir, expectedVKHash string) (*ZKProofSystem, error) {
+	type loadedCircuit struct {
+		ccs constraint.ConstraintSystem
+		pk  groth16.ProvingKey
+		vk  groth16.VerifyingKey
+	}
+
+	loaded := make([]loadedCircuit, len(zkCircuits))
+	vks := make([]io.WriterTo, len(zkCircuits))
+	for i, circuit := range zkCircuits {
+		ccs, err := circuit.compile()
+		if err != nil {
+			return nil, err
+		}
+
+		provingFile, verifyingFile := zkKeyFiles(keysDir, circuit.name)
+		pk := groth16.NewProvingKey(ecc.BN254)
+		if err := readFromFile(provingFile, pk); err != nil {
+			return nil, err
+		}
+		vk := groth16.NewVerifyingKey(ecc.BN254)
+		if err := readFromFile(verifyingFile, vk); err != nil {
+			return nil, err
+		}
+
+		loaded[i] = loadedCircuit{ccs: ccs, pk: pk, vk: vk}
+		vks[i] = vk
+	}
+
+	vkHash, err := verifyingKeyHash(vks...)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVKHash != "" && vkHash != expectedVKHash {
+		return nil, fmt.Errorf("verifying key hash %s does not match genesis %s", vkHash, expectedVKHash)
+	}
+
+	return &ZKProofSystem{
+		provingKey:      loaded[0].pk,
+		verifyingKey:    loaded[0].vk,
+		r1cs:            loaded[0].ccs,
+		aggProvingKey:   loaded[1].pk,
+		aggVerifyingKey: loaded[1].vk,
+		aggR1CS:         loaded[1].ccs,
+	}, nil
+}
+
+// VerifyingKeyHash is the SHA-256 of the serialized verifying keys, in
+// ceremony circuit order.
+func (zk *ZKProofSystem) VerifyingKeyHash() (string, error) {
+	return verifyingKeyHash(zk.verifyingKey, zk.aggVerifyingKey)
+}
+
+func verifyingKeyHash(vks ...io.WriterTo) (string, error) {
+	hasher := sha256.New()
+	for _, vk := range vks {
+		if _, err := vk.WriteTo(hasher); err != nil {
+			return "", fmt.Errorf("failed to serialize verifying key: %w", err)
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func zkKeyFiles(keysDir, circuit string) (provingFile, verifyingFile string) {
+	return filepath.Join(keysDir, circuit+"_proving.key"), filepath.Join(keysDir, circuit+"_verifying.key")
+}
+
+// compileCeremonyCircuits compiles every ceremony circuit and returns the
+// phase 1 power that fits the largest.
+func compileCeremonyCircuits() ([]*cs.R1CS, int, error) {
+	circuits := make([]*cs.R1CS, len(zkCircuits))
+	power := 0
+	for i, circuit := range zkCircuits {
+		ccs, err := circuit.compile()
+		if err != nil {
+			return nil, 0, err
+		}
+		circuits[i] = ccs.(*cs.R1CS)
+		if p := circuitPower(circuits[i]); p > power {
+			power = p
+		}
+	}
+	return circuits, power, nil
+}
+
+// circuitPower is the smallest power of two domain that fits the circuit.
+// mpcsetup sizes the phase 2 domain from the phase 1 parameters, so each
+// circuit gets phase 1 truncated to exactly this size.
+func circuitPower(ccs constraint.ConstraintSystem) int {
+	nbConstraints := ccs.GetNbConstraints()
+	if nbConstraints < 2 {
+		return 1
+	}
+	return bits.Len(uint(nbConstraints - 1))
+}
+
+// truncatePhase1 restricts powers of tau to a smaller domain; the lower
+// powers of the same τ, α and β remain a valid phase 1 result.
+func truncatePhase1(srs1 *mpcsetup.Phase1, power int) *mpcsetup.Phase1 {
+	n := 1 << power
+	if n >= len(srs1.Parameters.G2.Tau) {
+		return srs1
+	}
+
+	truncated := *srs1
+	truncated.Parameters.G1.Tau = srs1.Parameters.G1.Tau[:2*n-1]
+	truncated.Parameters.G1.AlphaTau = srs1.Parameters.G1.AlphaTau[:n]
+	truncated.Parameters.G1.BetaTau = srs1.Parameters.G1.BetaTau[:n]
+	truncated.Parameters.G2.Tau = srs1.Parameters.G2.Tau[:n]
+	return &truncated
+}
+
+func verifiedPhase1(dir string) ([]*cs.R1CS, *mpcsetup.Phase1, error) {
+	paths := ceremonyTranscript(dir, "phase1")
+	if len(paths) < 2 {
+		return nil, nil, fmt.Errorf("phase 1 needs at least one contribution")
+	}
+
+	contribs := make([]*mpcsetup.Phase1, len(paths))
+	for i, path := range paths {
+		var err error
+		if contribs[i], err = readPhase1(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	circuits, power, err := compileCeremonyCircuits()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	initial := mpcsetup.InitPhase1(power)
+	if !samePhase1Parameters(&initial, contribs[0]) {
+		return nil, nil, fmt.Errorf("phase 1 was not initialized for the PoB circuits")
+	}
+	if err := mpcsetup.VerifyPhase1(contribs[0], contribs[1], contribs[2:]...); err != nil {
+		return nil, nil, fmt.Errorf("phase 1 transcript is invalid: %w", err)
+	}
+
+	return circuits, contribs[len(contribs)-1], nil
+}
+
+// The initial entries carry a random (but meaningless) public key, so they
+// are compared on their parameters rather than their hash.
+func samePhase1Parameters(a, b *mpcsetup.Phase1) bool {
+	return sameG1Points(a.Parameters.G1.Tau, b.Parameters.G1.Tau) &&
+		sameG1Points(a.Parameters.G1.AlphaTau, b.Parameters.G1.AlphaTau) &&
+		sameG1Points(a.Parameters.G1.BetaTau, b.Parameters.G1.BetaTau) &&
+		sameG2Points(a.Parameters.G2.Tau, b.Parameters.G2.Tau) &&
+		a.Parameters.G2.Beta.Equal(&b.Parameters.G2.Beta)
+}
+
+func samePhase2Parameters(a, b *mpcsetup.Phase2) bool {
+	return sameG1Points(a.Parameters.G1.L, b.Parameters.G1.L) &&
+		sameG1Points(a.Parameters.G1.Z, b.Parameters.G1.Z) &&
+		a.Parameters.G1.Delta.Equal(&b.Parameters.G1.Delta) &&
+		a.Parameters.G2.Delta.Equal(&b.Parameters.G2.Delta)
+}
+
+func sameG1Points(a, b []curve.G1Affine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(&b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameG2Points(a, b []curve.G2Affine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(&b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ceremonyTranscript lists the consecutive transcript entries with prefix.
+func ceremonyTranscript(dir, prefix string) []string {
+	var paths []string
+	for i := 0; ; i++ {
+		path := filepath.Join(dir, ceremonyFileName(prefix, i))
+		if _, err := os.Stat(path); err != nil {
+			return paths
+		}
+		paths = append(paths, path)
+	}
+}
+
+func phase2Prefix(circuit string) string {
+	return "phase2_" + circuit
+}
+
+func ceremonyFileName(prefix string, index int) string {
+	return fmt.Sprintf("%s_%04d.bin", prefix, index)
+}
+
+func writeContribution(dir string, phase int, circuit string, index int, params io.WriterTo, hash []byte) (*ZKContribution, error) {
+	prefix := "phase1"
+	if phase == 2 {
+		prefix = phase2Prefix(circuit)
+	}
+
+	path := filepath.Join(dir, ceremonyFileName(prefix, index))
+	if err := writeToFile(path, params); err != nil {
+		return nil, err
+	}
+
+	return &ZKContribution{
+		Phase:   phase,
+		Circuit: circuit,
+		Index:   index,
+		Path:    path,
+		Hash:    hex.EncodeToString(hash),
+	}, nil
+}
+
+func readPhase1(path string) (*mpcsetup.Phase1, error) {
+	var p mpcsetup.Phase1
+	hash, err := readContribution(path, &p)
+	if err != nil {
+		return nil, err
+	}
+	p.Hash = hash
+	return &p, nil
+}
+
+func readPhase2(path string) (*mpcsetup.Phase2, error) {
+	var p mpcsetup.Phase2
+	hash, err := readContribution(path, &p)
+	if err != nil {
+		return nil, err
+	}
+	p.Hash = hash
+	return &p, nil
+}
+
+// readContribution decodes a transcript entry and returns its trailing
+// contribution hash. mpcsetup's ReadFrom buffers ahead of the hash, so the
+// hash it reads back is unreliable; take it from the file instead.
+func readContribution(path string, r io.ReaderFrom) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if len(data) < sha256.Size {
+		return nil, fmt.Errorf("ceremony file %s is truncated", path)
+	}
+	if _, err := r.ReadFrom(bytes.NewReader(data)); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to deserialize %s: %w", path, err)
+	}
+	return data[len(data)-sha256.Size:], nil
+}
+
+func writeToFile(path string, w io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", filepath.Base(path), err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func readFromFile(path string, r io.ReaderFrom) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if _, err := r.ReadFrom(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to deserialize %s: %w", path, err)
+	}
+	return nil
+}
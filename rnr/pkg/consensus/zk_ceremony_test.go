@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestZKCeremonySharedKeys tests that nodes loading the ceremony keys verify each other's proofs
+func TestZKCeremonySharedKeys(t *testing.T) {
+	dir := t.TempDir()
+	keysDir := filepath.Join(dir, "keys")
+	ceremonyDir := filepath.Join(dir, "ceremony")
+
+	if _, err := InitZKCeremony(ceremonyDir); err != nil {
+		t.Fatalf("InitZKCeremony failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ContributeZKCeremony(ceremonyDir); err != nil {
+			t.Fatalf("Phase 1 contribution %d failed: %v", i, err)
+		}
+	}
+	if _, err := StartZKCeremonyPhase2(ceremonyDir); err != nil {
+		t.Fatalf("StartZKCeremonyPhase2 failed: %v", err)
+	}
+	if _, err := ContributeZKCeremony(ceremonyDir); err != nil {
+		t.Fatalf("Phase 2 contribution failed: %v", err)
+	}
+
+	vkHash, err := FinalizeZKCeremony(ceremonyDir, keysDir)
+	if err != nil {
+		t.Fatalf("FinalizeZKCeremony failed: %v", err)
+	}
+
+	// Finalizing is deterministic in the transcript
+	if again, err := FinalizeZKCeremony(ceremonyDir, filepath.Join(dir, "keys2")); err != nil || again != vkHash {
+		t.Errorf("Re-finalizing should yield %s, got %s (%v)", vkHash, again, err)
+	}
+
+	prover, err := LoadZKProofSystem(keysDir, vkHash)
+	if err != nil {
+		t.Fatalf("LoadZKProofSystem failed: %v", err)
+	}
+	verifier, err := LoadZKProofSystem(keysDir, vkHash)
+	if err != nil {
+		t.Fatalf("LoadZKProofSystem failed: %v", err)
+	}
+
+	result := &PoBTestResult{
+		UploadBandwidth: 7.0,
+		Latency:         100.0,
+		PacketLoss:      0,
+		TestDataHash:    "1234",
+		Timestamp:       time.Now(),
+		Passed:          true,
+	}
+	proof, err := prover.GenerateProof(result, 1.0)
+	if err != nil {
+		t.Fatalf("GenerateProof failed: %v", err)
+	}
+	if valid, err := verifier.VerifyProof(proof, result.TestDataHash, 1.0, true); err != nil || !valid {
+		t.Errorf("Proof from another node should verify with the ceremony keys (valid=%v, err=%v)", valid, err)
+	}
+
+	if _, err := LoadZKProofSystem(keysDir, "deadbeef"); err == nil {
+		t.Errorf("Keys not matching the pinned hash should be rejected")
+	}
+}
+
+// TestZKCeremonyRejectsTamperedTranscript tests that a modified contribution fails verification
+func TestZKCeremonyRejectsTamperedTranscript(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := InitZKCeremony(dir); err != nil {
+		t.Fatalf("InitZKCeremony failed: %v", err)
+	}
+	if _, err := ContributeZKCeremony(dir); err != nil {
+		t.Fatalf("Contribution failed: %v", err)
+	}
+
+	// Replace the contribution with a fresh init: the proof of knowledge no longer matches
+	initial, _ := os.ReadFile(filepath.Join(dir, "phase1_0000.bin"))
+	os.WriteFile(filepath.Join(dir, "phase1_0001.bin"), initial, 0644)
+
+	if _, err := StartZKCeremonyPhase2(dir); err == nil {
+		t.Errorf("Tampered phase 1 transcript should be rejected")
+	}
+}
@@ -11,7 +11,6 @@ import (
         "github.com/consensys/gnark/backend/groth16"
         "github.com/consensys/gnark/constraint"
         "github.com/consensys/gnark/frontend"
-        "github.com/consensys/gnark/frontend/cs/r1cs"
         "rnr-blockchain/pkg/core"
 )
 
@@ -68,14 +67,21 @@ type ZKProofSystem struct {
         provingKey   groth16.ProvingKey
         verifyingKey groth16.VerifyingKey
         r1cs         constraint.ConstraintSystem
+
+        // Committee median aggregation circuit (zk_aggregation.go)
+        aggProvingKey   groth16.ProvingKey
+        aggVerifyingKey groth16.VerifyingKey
+        aggR1CS         constraint.ConstraintSystem
 }
 
+// NewZKProofSystem runs a single-party Groth16 setup with keys local to this
+// process. Proofs from such a system only verify against the same instance,
+// so it is for development networks and tests; nodes on a shared network load
+// ceremony keys with LoadZKProofSystem.
 func NewZKProofSystem() (*ZKProofSystem, error) {
-        circuit := PoBCircuit{}
-        
-        ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+        ccs, err := CompilePoBCircuit()
         if err != nil {
-                return nil, fmt.Errorf("failed to compile circuit: %w", err)
+                return nil, err
         }
         
         pk, vk, err := groth16.Setup(ccs)
@@ -83,10 +89,23 @@ func NewZKProofSystem() (*ZKProofSystem, error) {
                 return nil, fmt.Errorf("failed to setup keys: %w", err)
         }
         
+        aggCCS, err := CompilePoBAggregationCircuit()
+        if err != nil {
+                return nil, err
+        }
+        
+        aggPK, aggVK, err := groth16.Setup(aggCCS)
+        if err != nil {
+                return nil, fmt.Errorf("failed to setup aggregation keys: %w", err)
+        }
+        
         return &ZKProofSystem{
-                provingKey:   pk,
-                verifyingKey: vk,
-                r1cs:         ccs,
+                provingKey:      pk,
+                verifyingKey:    vk,
+                r1cs:            ccs,
+                aggProvingKey:   aggPK,
+                aggVerifyingKey: aggVK,
+                aggR1CS:         aggCCS,
         }, nil
 }
 
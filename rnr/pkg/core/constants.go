@@ -17,14 +17,21 @@ const (
         TestDataSize              = 8 * 1024 * 1024
         MaxTestCommitteeSize      = 8
         MinTestCommitteeSize      = 5
-        ReverificationInterval    = 100
+        ReverificationInterval    = 100 // Blocks between scheduled PoB re-verifications of a validator
+        InitialReputation         = 100 // Reputation of a new validator; weights its selection into PoB committees
         DynamicBlockCapacityRatio = 0.30
+        MinBlockCapacityBytes     = 5 * 1024 * 1024 // Bounds of a proposer's dynamic block capacity
+        MaxBlockCapacityBytes     = 300 * 1024 * 1024
         MinPeerMeasurement        = 8
         PeerSamplingCount         = 10
         MinBlockSize              = 50
         MaxBlockSize              = 1000
         BaseBlockSize             = 100
         MaxNewAddressesPerBlock   = 15
+        ProposerFallbackTimeout   = 10 * time.Second // Per-rank delay before a backup proposer may propose
+        MaxProposerFallbacks      = 3                // Backup proposers ranked after the elected one
+        MinProposerPoBScore       = 0.5              // PoB score a validator needs to propose blocks
+        MaxSlotClockDrift         = 2 * time.Second
 )
 
 var (
@@ -35,13 +42,59 @@ var (
         ProposerRewardPercentage = big.NewInt(80)
         PoBContributorPercentage = big.NewInt(20)
         MaxPoBContributors       = 20
-        BaseFeePercentage        = big.NewInt(1)
+        InitialBaseFee           = big.NewInt(1000000000) // Per transaction byte
+        MinBaseFee               = big.NewInt(1000)
+        MinDelegationAmount      = big.NewInt(1)
 )
 
+// Delegated staking: bonded tokens sit in the staking module account and are
+// released only after the unbonding period has elapsed.
+const (
+        StakingModuleAddress        = "rnr_module_staking"
+        UnbondingPeriodBlocks       = 2880 // ~24h at 30s block time
+        DefaultCommissionBasisPoint = 1000 // 10% validator commission
+        MaxCommissionBasisPoint     = 10000
+        MaxUnbondingEntries         = 7 // Per delegator/validator pair
+)
+
+// EIP-1559 style base fee: blocks target half of the proposer's dynamic
+// capacity and the base fee moves by at most 1/8 per block.
+const (
+        BaseFeeElasticityMultiplier = 2
+        BaseFeeChangeDenominator    = 8
+)
+
+// Block rewards are minted into the reward module account and paid out when
+// the recipient submits a withdraw-rewards transaction.
+const RewardModuleAddress = "rnr_module_rewards"
+
 const (
         SupermajorityThreshold = 0.85
 )
 
+// Liveness: validators must sign at least MinSignedPerWindow of the last
+// SignedBlocksWindow blocks or they are jailed until they send an unjail tx.
+const (
+        SlashingModuleAddress = "rnr_module_slashing"
+        SignedBlocksWindow    = 100
+        MinSignedPerWindow    = 0.5
+        DowntimeJailBlocks    = 720 // ~6h at 30s block time
+)
+
+// PoB rounds: a candidate's request tx to the PoB module opens a round; its
+// committee challenges the candidate and submits signed results, which are
+// aggregated once all are in or the round times out. The candidate then has
+// PoBProofTimeoutBlocks to prove the aggregation. Closed rounds are kept
+// for the dispute window so their results can be challenged.
+const (
+        PoBModuleAddress      = "rnr_module_pob"
+        PoBRoundTimeoutBlocks  = 10 // ~5 min at 30s block time
+        PoBProofTimeoutBlocks  = 10 // Aggregation proof due ~5 min after the round closes
+        PoBChallengeTimeout    = 10 * time.Second
+        PoBTransferTimeout     = 60 * time.Second
+        PoBDisputeWindowBlocks = 2880 // ~24h: signed measurement records stay challengeable
+)
+
 const (
         MnemonicLength   = 12
         DerivationPath   = "m/44'/60'/0'/0/0"
@@ -62,4 +115,15 @@ const (
         MessageTypeGovernanceVote
         MessageTypePing
         MessageTypePong
+        MessageTypeStatus           // Chain and head handshake
+        MessageTypeAuth             // Authentication challenge, response or result
+        MessageTypeSpeedTestChunk   // PoB payload chunk
+        MessageTypeSpeedTestAck
+        MessageTypeVRFProof
+        MessageTypeValidatorRecord
+        MessageTypeShreds           // Erasure coded pieces of a block
+        MessageTypePubSubRPC
+        MessageTypeBlockTxs         // Transactions of a relayed block, by short ID
+        MessageTypeError            // Request failed; the payload says why
+        MessageTypeTxAnnouncement   // Transaction ID offered by a peer
 )
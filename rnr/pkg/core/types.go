@@ -20,6 +20,15 @@ type BlockHeader struct {
         PoBWeight     uint64  // Whitepaper Bab 4.3: PoB weight for fork resolution (cumulative difficulty)
         VRFProof      []byte  // SECURITY: VRF proof for proposer selection verification
         VRFOutput     []byte  // VRF output (hash) used for randomness
+        BaseFee       *big.Int // EIP-1559 style protocol base fee per transaction byte (burned)
+        SizeUsed      uint64   // Total transaction bytes included in the block
+        SizeLimit     uint64   // Dynamic block capacity of the proposer in bytes
+
+        // PoB thresholds the block's PoB results are evaluated against, in
+        // milli-units (MB/s, ms, %), committed so every node agrees on them
+        PoBMinUpload     int64
+        PoBMaxLatency    int64
+        PoBMaxPacketLoss int64
 }
 
 type Block struct {
@@ -29,6 +38,13 @@ type Block struct {
         PoHSequence  []byte
         Signature    []byte
         VRFProof     []byte  // SECURITY: VRF proof for proposer selection (duplicated for easy access)
+        LastCommit   []*CommitSig // Votes on the parent block, used for liveness tracking
+}
+
+// CommitSig is a validator's vote signature over the parent block hash
+type CommitSig struct {
+        ValidatorID string
+        Signature   []byte
 }
 
 func (b *Block) Hash() ([]byte, error) {
@@ -75,6 +91,8 @@ type ValidatorInfo struct {
         PacketLoss        float64 // Packet loss percentage (measured from PoB test) - Whitepaper: 0.1%
         Reputation        int
         LastPoBTest       time.Time
+        LastPoBHeight     uint64 // Height of the last completed PoB round; re-verification is due ReverificationInterval later
+        DemotedAtHeight   uint64 // Height of the failed re-verification that made it an observer, 0 if none
         IsActive          bool
         RewardAddress     string
         NetworkASN        string
@@ -85,6 +103,8 @@ type ValidatorInfo struct {
         IsObserver        bool
         ObserverStartTime time.Time
         ObserverDuration  time.Duration
+        IsJailed          bool   // Jailed for downtime; stays suspended until an unjail tx
+        JailedUntilHeight uint64 // Earliest height at which an unjail tx is accepted
 }
 
 type Account struct {
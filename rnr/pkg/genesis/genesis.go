@@ -26,6 +26,8 @@ type GenesisConfig struct {
         BootstrapNodes   []string           `json:"bootstrap_nodes"`
         BlockTime        int                `json:"block_time_seconds"`
         InitialDifficulty int64             `json:"initial_difficulty"`
+        ZKVerifyingKeyHash string           `json:"zk_verifying_key_hash,omitempty"` // SHA-256 of the PoB ceremony verifying key
+        ASNDatasetHash   string             `json:"asn_dataset_hash,omitempty"`      // SHA-256 of the IP-to-ASN dataset PoB rewards group by
 }
 
 func DefaultGenesisConfig() *GenesisConfig {
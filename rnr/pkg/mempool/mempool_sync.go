@@ -1,7 +1,7 @@
 package mempool
 
 import (
-        "encoding/json"
+        "bytes"
         "fmt"
         "log"
         "sync"
@@ -9,6 +9,7 @@ import (
 
         "rnr-blockchain/pkg/blockchain"
         "rnr-blockchain/pkg/core"
+        "rnr-blockchain/pkg/network"
 )
 
 type MempoolSync struct {
@@ -188,31 +189,40 @@ func (ms *MempoolSync) CleanupOldKnownTxs(maxAge time.Duration) {
 }
 
 func (announcement *TxAnnouncement) Marshal() ([]byte, error) {
-        return json.Marshal(announcement)
+        return marshalFrame(core.MessageTypeTxAnnouncement, announcement)
 }
 
 func UnmarshalTxAnnouncement(data []byte) (*TxAnnouncement, error) {
         var announcement TxAnnouncement
-        err := json.Unmarshal(data, &announcement)
+        _, err := network.ReadMessageOf(bytes.NewReader(data), core.MessageTypeTxAnnouncement, &announcement)
         return &announcement, err
 }
 
 func (req *TxRequest) Marshal() ([]byte, error) {
-        return json.Marshal(req)
+        return marshalFrame(core.MessageTypeTxRequest, req)
 }
 
 func UnmarshalTxRequest(data []byte) (*TxRequest, error) {
         var req TxRequest
-        err := json.Unmarshal(data, &req)
+        _, err := network.ReadMessageOf(bytes.NewReader(data), core.MessageTypeTxRequest, &req)
         return &req, err
 }
 
 func (resp *TxResponse) Marshal() ([]byte, error) {
-        return json.Marshal(resp)
+        return marshalFrame(core.MessageTypeTxResponse, resp)
 }
 
 func UnmarshalTxResponse(data []byte) (*TxResponse, error) {
         var resp TxResponse
-        err := json.Unmarshal(data, &resp)
+        _, err := network.ReadMessageOf(bytes.NewReader(data), core.MessageTypeTxResponse, &resp)
         return &resp, err
 }
+
+// marshalFrame encodes v as a P2P wire frame of msgType
+func marshalFrame(msgType int, v interface{}) ([]byte, error) {
+        var buf bytes.Buffer
+        if err := network.WriteMessage(&buf, msgType, v, false); err != nil {
+                return nil, err
+        }
+        return buf.Bytes(), nil
+}
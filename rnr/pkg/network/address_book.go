@@ -0,0 +1,256 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// The address book remembers peers this node has been connected to, so it
+// can rejoin the network after a restart without depending on bootstrap
+// peers or discovery. Peers are recorded once their status is accepted.
+
+const AddressBookFile = "addrbook.json"
+
+const (
+	maxKnownPeers    = 1000
+	maxPeerAddrs     = 8
+	knownPeerExpiry  = 7 * 24 * time.Hour // Unseen peers are dropped after this
+	maxPeerFailures  = 10                 // Failures beyond successes before a peer is dropped
+	addressBookFlush = time.Minute
+)
+
+// KnownPeer is an address book entry
+type KnownPeer struct {
+	ID          string    `json:"id"`
+	Addrs       []string  `json:"addrs"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	LastAttempt time.Time `json:"last_attempt"`
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+}
+
+// AddressBook is a set of known peers, saved as JSON
+type AddressBook struct {
+	path  string
+	mu    sync.Mutex
+	peers map[peer.ID]*KnownPeer
+	dirty bool
+}
+
+// NewAddressBook loads the address book at path. With an empty path the
+// book is only kept in memory.
+func NewAddressBook(path string) (*AddressBook, error) {
+	ab := &AddressBook{
+		path:  path,
+		peers: make(map[peer.ID]*KnownPeer),
+	}
+	if path == "" {
+		return ab, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ab, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address book: %w", err)
+	}
+	var entries []*KnownPeer
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse address book %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		pid, err := peer.Decode(entry.ID)
+		if err != nil {
+			continue
+		}
+		ab.peers[pid] = entry
+	}
+	return ab, nil
+}
+
+// RecordSuccess records a connection to pid at addrs
+func (ab *AddressBook) RecordSuccess(pid peer.ID, addrs []multiaddr.Multiaddr) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	now := time.Now()
+	entry := ab.entry(pid, now)
+	entry.LastSeen = now
+	entry.LastAttempt = now
+	entry.Successes++
+	if len(addrs) > 0 {
+		entry.Addrs = entry.Addrs[:0]
+		for _, addr := range addrs {
+			if len(entry.Addrs) == maxPeerAddrs {
+				break
+			}
+			entry.Addrs = append(entry.Addrs, addr.String())
+		}
+	}
+	ab.dirty = true
+}
+
+// RecordFailure records a failed dial of pid. Unknown peers are not added.
+func (ab *AddressBook) RecordFailure(pid peer.ID) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	entry, ok := ab.peers[pid]
+	if !ok {
+		return
+	}
+	entry.LastAttempt = time.Now()
+	entry.Failures++
+	ab.dirty = true
+}
+
+// Remove forgets pid
+func (ab *AddressBook) Remove(pid peer.ID) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	if _, ok := ab.peers[pid]; ok {
+		delete(ab.peers, pid)
+		ab.dirty = true
+	}
+}
+
+// Get returns a copy of pid's entry, or nil
+func (ab *AddressBook) Get(pid peer.ID) *KnownPeer {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	entry, ok := ab.peers[pid]
+	if !ok {
+		return nil
+	}
+	copied := *entry
+	copied.Addrs = append([]string(nil), entry.Addrs...)
+	return &copied
+}
+
+// Peers returns copies of all entries, best first
+func (ab *AddressBook) Peers() []KnownPeer {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	peers := make([]KnownPeer, 0, len(ab.peers))
+	for _, entry := range ab.peers {
+		copied := *entry
+		copied.Addrs = append([]string(nil), entry.Addrs...)
+		peers = append(peers, copied)
+	}
+	sortKnownPeers(peers)
+	return peers
+}
+
+// BestPeers returns the addresses of up to n peers, most reliable and most
+// recently seen first
+func (ab *AddressBook) BestPeers(n int) []peer.AddrInfo {
+	var infos []peer.AddrInfo
+	for _, known := range ab.Peers() {
+		if len(infos) == n {
+			break
+		}
+		pid, err := peer.Decode(known.ID)
+		if err != nil {
+			continue
+		}
+		info := peer.AddrInfo{ID: pid}
+		for _, s := range known.Addrs {
+			if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+				info.Addrs = append(info.Addrs, addr)
+			}
+		}
+		if len(info.Addrs) > 0 {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Size returns the number of known peers
+func (ab *AddressBook) Size() int {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	return len(ab.peers)
+}
+
+// Save drops stale and failing peers and writes the book if it changed
+func (ab *AddressBook) Save() error {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	ab.prune(time.Now())
+	if ab.path == "" || !ab.dirty {
+		return nil
+	}
+
+	entries := make([]KnownPeer, 0, len(ab.peers))
+	for _, entry := range ab.peers {
+		entries = append(entries, *entry)
+	}
+	sortKnownPeers(entries)
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode address book: %w", err)
+	}
+	if err := writeFileAtomic(ab.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save address book: %w", err)
+	}
+	ab.dirty = false
+	return nil
+}
+
+func (ab *AddressBook) entry(pid peer.ID, now time.Time) *KnownPeer {
+	entry, ok := ab.peers[pid]
+	if !ok {
+		entry = &KnownPeer{ID: pid.String(), FirstSeen: now}
+		ab.peers[pid] = entry
+	}
+	return entry
+}
+
+// prune drops peers unseen for too long or failing far more than they
+// succeed, then the worst peers above maxKnownPeers
+func (ab *AddressBook) prune(now time.Time) {
+	for pid, entry := range ab.peers {
+		if now.Sub(entry.LastSeen) > knownPeerExpiry || entry.Failures-entry.Successes > maxPeerFailures {
+			delete(ab.peers, pid)
+			ab.dirty = true
+		}
+	}
+	if len(ab.peers) <= maxKnownPeers {
+		return
+	}
+
+	entries := make([]KnownPeer, 0, len(ab.peers))
+	for _, entry := range ab.peers {
+		entries = append(entries, *entry)
+	}
+	sortKnownPeers(entries)
+	for _, entry := range entries[maxKnownPeers:] {
+		pid, _ := peer.Decode(entry.ID)
+		delete(ab.peers, pid)
+	}
+	ab.dirty = true
+}
+
+// sortKnownPeers orders peers by successes net of failures, then by last seen
+func sortKnownPeers(peers []KnownPeer) {
+	sort.Slice(peers, func(i, j int) bool {
+		ni := peers[i].Successes - peers[i].Failures
+		nj := peers[j].Successes - peers[j].Failures
+		if ni != nj {
+			return ni > nj
+		}
+		return peers[i].LastSeen.After(peers[j].LastSeen)
+	})
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+// TestAddressBook tests that known peers are ranked by reliability, saved
+// and reloaded, and dropped once stale or failing
+func TestAddressBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AddressBookFile)
+	ab, err := NewAddressBook(path)
+	if err != nil {
+		t.Fatalf("NewAddressBook failed: %v", err)
+	}
+
+	addrs := func(n int, port int) []multiaddr.Multiaddr {
+		var out []multiaddr.Multiaddr
+		for i := 0; i < n; i++ {
+			out = append(out, multiaddr.StringCast(fmt.Sprintf("/ip4/198.51.100.%d/tcp/%d", i+1, port)))
+		}
+		return out
+	}
+	reliable, flaky, failing, stale := testPeerID(t), testPeerID(t), testPeerID(t), testPeerID(t)
+	for i := 0; i < 3; i++ {
+		ab.RecordSuccess(reliable, addrs(maxPeerAddrs+4, 4001))
+	}
+	ab.RecordSuccess(flaky, addrs(1, 4002))
+	ab.RecordSuccess(failing, addrs(1, 4003))
+	for i := 0; i < maxPeerFailures+2; i++ {
+		ab.RecordFailure(failing)
+	}
+	ab.RecordSuccess(stale, addrs(1, 4004))
+	ab.peers[stale].LastSeen = time.Now().Add(-knownPeerExpiry - time.Hour)
+	ab.RecordFailure(testPeerID(t))
+
+	if ab.Size() != 4 {
+		t.Fatalf("Expected 4 known peers, failures of unknown ones not added, got %d", ab.Size())
+	}
+	if known := ab.Get(reliable); known == nil || len(known.Addrs) != maxPeerAddrs || known.Successes != 3 {
+		t.Errorf("Expected the reliable peer with %d addresses and 3 successes, got %+v", maxPeerAddrs, known)
+	}
+	best := ab.BestPeers(2)
+	if len(best) != 2 || best[0].ID != reliable || best[1].ID != flaky {
+		t.Errorf("Expected the reliable then the flaky peer first, got %v", best)
+	}
+
+	if err := ab.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	reloaded, err := NewAddressBook(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if reloaded.Size() != 2 || reloaded.Get(failing) != nil || reloaded.Get(stale) != nil {
+		t.Errorf("Expected the failing and stale peers dropped, %d peers left", reloaded.Size())
+	}
+	if known := reloaded.Get(reliable); known == nil || known.Successes != 3 || len(known.Addrs) != maxPeerAddrs {
+		t.Errorf("Reliable peer did not survive the reload: %+v", known)
+	}
+
+	reloaded.Remove(flaky)
+	if reloaded.Get(flaky) != nil || reloaded.Size() != 1 {
+		t.Errorf("Removed peer should be forgotten")
+	}
+}
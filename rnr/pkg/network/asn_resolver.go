@@ -0,0 +1,613 @@
+package network
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ASNResolver maps IP addresses to their origin autonomous system using a
+// local IP-to-ASN dataset, so that reward fairness and peer diversity group
+// nodes by the network that actually routes them instead of by /24 subnet.
+// A provider announcing many /24s is one group.
+//
+// Supported datasets, optionally .gz or .bz2 compressed:
+//   - CSV/TSV: "prefix,asn" (e.g. "1.0.0.0/24,13335"), RouteViews pfx2as
+//     ("1.0.0.0<TAB>24<TAB>13335") or ranges ("start,end,asn,..." as in
+//     iptoasn.com ip2asn). Lines starting with '#' are ignored.
+//   - MRT RIB dumps (RFC 6396 TABLE_DUMP and TABLE_DUMP_V2); the origin AS
+//     is the last AS of each prefix's AS_PATH.
+//   - MaxMind DB (.mmdb) with an "autonomous_system_number" field, such as
+//     GeoLite2-ASN.
+//
+// IPv4 and IPv6 are both resolved by longest prefix match. Reload swaps in a
+// fresh copy of the dataset without interrupting lookups. Reward fairness is
+// consensus, so it only uses a resolver pinned to the dataset hash in genesis.
+type ASNResolver struct {
+	path       string
+	pinnedHash string // Hash every load must match, if set
+	table      asnTable
+	hash       string
+	loadedAt   time.Time
+	mu         sync.RWMutex
+}
+
+// asnTable is a loaded dataset
+type asnTable interface {
+	lookup(ip net.IP) (uint32, bool)
+	size() int
+}
+
+// NewASNResolver loads the dataset at path
+func NewASNResolver(path string) (*ASNResolver, error) {
+	r := &ASNResolver{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewPinnedASNResolver loads the dataset at path, whose SHA-256 must be
+// expectedHash (from the genesis config). Reloads refuse any other dataset,
+// so every node pinning the hash resolves the same ASNs.
+func NewPinnedASNResolver(path, expectedHash string) (*ASNResolver, error) {
+	if expectedHash == "" {
+		return nil, fmt.Errorf("no ASN dataset hash to pin")
+	}
+	r := &ASNResolver{path: path, pinnedHash: expectedHash}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the dataset again. On error the previous dataset stays in use.
+func (r *ASNResolver) Reload() error {
+	start := time.Now()
+	table, format, hash, err := loadASNTable(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to load ASN dataset %s: %w", r.path, err)
+	}
+	if r.pinnedHash != "" && hash != r.pinnedHash {
+		return fmt.Errorf("ASN dataset %s has hash %s, expected %s", r.path, hash, r.pinnedHash)
+	}
+	if table.size() == 0 {
+		return fmt.Errorf("ASN dataset %s contains no prefixes", r.path)
+	}
+
+	r.mu.Lock()
+	r.table = table
+	r.hash = hash
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+
+	log.Printf("🗺️  Loaded ASN dataset %s (%s): %d entries in %v", r.path, format, table.size(), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Hash returns the hex SHA-256 of the dataset file in use
+func (r *ASNResolver) Hash() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.hash
+}
+
+// LoadedAt returns when the dataset was last loaded
+func (r *ASNResolver) LoadedAt() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.loadedAt
+}
+
+// Lookup returns the origin AS of ip. A nil resolver knows no AS.
+func (r *ASNResolver) Lookup(ip net.IP) (uint32, bool) {
+	if r == nil || ip == nil {
+		return 0, false
+	}
+	r.mu.RLock()
+	table := r.table
+	r.mu.RUnlock()
+	if table == nil {
+		return 0, false
+	}
+	return table.lookup(ip)
+}
+
+// NetworkGroup returns the network group of ipAddr: "AS<n>" when the dataset
+// knows its origin AS, otherwise its /24 (IPv4) or /48 (IPv6) subnet.
+// Loopback and private addresses are "local" and "private", and missing or
+// unparsable ones "unknown". A nil resolver groups by subnet only.
+func (r *ASNResolver) NetworkGroup(ipAddr string) string {
+	if ipAddr == "" {
+		return "unknown"
+	}
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return "unknown"
+	}
+	if ip.IsLoopback() {
+		return "local"
+	}
+	if ip.IsPrivate() {
+		return "private"
+	}
+	if asn, ok := r.Lookup(ip); ok {
+		return fmt.Sprintf("AS%d", asn)
+	}
+	return subnetGroup(ip)
+}
+
+// subnetGroup is the fallback group of ip: its /24 or /48
+func subnetGroup(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// loadASNTable reads the dataset at path, picking the format from the file
+// name and contents. It also returns the hex SHA-256 of the file.
+func loadASNTable(path string) (asnTable, string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	table, format, err := readASNTable(io.TeeReader(f, h), strings.ToLower(path))
+	if err != nil {
+		return nil, "", "", err
+	}
+	// Parsers may stop before the end of the file
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, "", "", err
+	}
+	return table, format, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readASNTable parses a dataset named name from src
+func readASNTable(src io.Reader, name string) (asnTable, string, error) {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, "", err
+		}
+		defer gz.Close()
+		src = gz
+		name = strings.TrimSuffix(name, ".gz")
+	case strings.HasSuffix(name, ".bz2"):
+		src = bzip2.NewReader(src)
+		name = strings.TrimSuffix(name, ".bz2")
+	}
+
+	if strings.HasSuffix(name, ".mmdb") {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, "", err
+		}
+		db, err := openMMDB(data)
+		if err != nil {
+			return nil, "", err
+		}
+		return db, "MaxMind DB", nil
+	}
+
+	br := bufio.NewReaderSize(src, 1<<20)
+	if header, err := br.Peek(mrtHeaderSize); err == nil && isMRTHeader(header) {
+		table, err := parseMRT(br)
+		return table, "MRT", err
+	}
+	table, err := parseASNText(br)
+	return table, "CSV", err
+}
+
+// prefixTable resolves addresses by longest prefix match. Addresses are kept
+// in 16-byte form, IPv4 as IPv4-mapped IPv6, so both families share a table.
+type prefixTable struct {
+	byLen map[int]map[[16]byte]uint32
+	lens  []int // Prefix lengths present, longest first
+	count int
+}
+
+func newPrefixTable() *prefixTable {
+	return &prefixTable{byLen: make(map[int]map[[16]byte]uint32)}
+}
+
+// insert adds ip/bits, bits counted in the 128-bit form
+func (t *prefixTable) insert(ip net.IP, bits int, asn uint32) {
+	var key [16]byte
+	copy(key[:], ip.To16().Mask(net.CIDRMask(bits, 128)))
+
+	prefixes, ok := t.byLen[bits]
+	if !ok {
+		prefixes = make(map[[16]byte]uint32)
+		t.byLen[bits] = prefixes
+		t.lens = append(t.lens, bits)
+		sort.Sort(sort.Reverse(sort.IntSlice(t.lens)))
+	}
+	if _, exists := prefixes[key]; !exists {
+		t.count++
+	}
+	prefixes[key] = asn
+}
+
+// insertCIDR adds a prefix given in its own family's length
+func (t *prefixTable) insertCIDR(ip net.IP, bits int, asn uint32) {
+	if ip.To4() != nil {
+		bits += 96
+	}
+	t.insert(ip, bits, asn)
+}
+
+// insertRange adds the smallest set of prefixes covering start-end
+func (t *prefixTable) insertRange(start, end net.IP, asn uint32) error {
+	if (start.To4() == nil) != (end.To4() == nil) {
+		return fmt.Errorf("range %s-%s mixes address families", start, end)
+	}
+	s := new(big.Int).SetBytes(start.To16())
+	e := new(big.Int).SetBytes(end.To16())
+	if s.Cmp(e) > 0 {
+		return fmt.Errorf("range %s-%s is reversed", start, end)
+	}
+
+	one := big.NewInt(1)
+	for s.Cmp(e) <= 0 {
+		// Largest aligned block at s that stays within the range
+		size := int(s.TrailingZeroBits())
+		if s.Sign() == 0 || size > 128 {
+			size = 128
+		}
+		for size > 0 {
+			last := new(big.Int).Lsh(one, uint(size))
+			last.Add(last, s).Sub(last, one)
+			if last.Cmp(e) <= 0 {
+				break
+			}
+			size--
+		}
+
+		ip := make(net.IP, net.IPv6len)
+		s.FillBytes(ip)
+		t.insert(ip, 128-size, asn)
+		s.Add(s, new(big.Int).Lsh(one, uint(size)))
+	}
+	return nil
+}
+
+func (t *prefixTable) lookup(ip net.IP) (uint32, bool) {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return 0, false
+	}
+	isV4 := ip.To4() != nil
+	for _, bits := range t.lens {
+		// IPv4 prefixes live under ::ffff:0:0/96; shorter ones are IPv6
+		if isV4 && bits < 96 {
+			continue
+		}
+		var key [16]byte
+		copy(key[:], ip16.Mask(net.CIDRMask(bits, 128)))
+		if asn, ok := t.byLen[bits][key]; ok {
+			return asn, true
+		}
+	}
+	return 0, false
+}
+
+func (t *prefixTable) size() int {
+	return t.count
+}
+
+// parseASN parses an AS number such as "13335", "AS13335", a multi-origin
+// "13335_209242" or an AS set "{13335,209242}", taking the first origin
+func parseASN(field string) (uint32, error) {
+	field = strings.Trim(strings.TrimSpace(field), "{}")
+	if i := strings.IndexAny(field, "_, "); i >= 0 {
+		field = field[:i]
+	}
+	field = strings.TrimPrefix(strings.ToUpper(field), "AS")
+	asn, err := strconv.ParseUint(field, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AS number %q", field)
+	}
+	return uint32(asn), nil
+}
+
+// parseASNText reads a CSV or TSV dataset. Rows that do not parse, such as a
+// header, are skipped, as is unrouted space (AS 0).
+func parseASNText(src io.Reader) (*prefixTable, error) {
+	table := newPrefixTable()
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	skipped := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := ","
+		if strings.Contains(line, "\t") {
+			sep = "\t"
+		}
+		fields := strings.Split(line, sep)
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+		if err := insertASNRow(table, fields); err != nil {
+			skipped++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		log.Printf("⚠️  Skipped %d unparsable ASN dataset rows", skipped)
+	}
+	return table, nil
+}
+
+func insertASNRow(table *prefixTable, fields []string) error {
+	if len(fields) < 2 {
+		return fmt.Errorf("too few fields")
+	}
+
+	// prefix,asn
+	if _, network, err := net.ParseCIDR(fields[0]); err == nil {
+		asn, err := parseASN(fields[1])
+		if err != nil {
+			return err
+		}
+		if asn == 0 {
+			return nil
+		}
+		bits, _ := network.Mask.Size()
+		table.insertCIDR(network.IP, bits, asn)
+		return nil
+	}
+
+	start := net.ParseIP(fields[0])
+	if start == nil || len(fields) < 3 {
+		return fmt.Errorf("invalid row")
+	}
+	asn, err := parseASN(fields[2])
+	if err != nil {
+		return err
+	}
+	if asn == 0 {
+		return nil
+	}
+
+	// start,end,asn
+	if end := net.ParseIP(fields[1]); end != nil {
+		return table.insertRange(start, end, asn)
+	}
+
+	// prefix,length,asn (pfx2as)
+	bits, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid prefix length %q", fields[1])
+	}
+	max := 128
+	if start.To4() != nil {
+		max = 32
+	}
+	if bits < 0 || bits > max {
+		return fmt.Errorf("invalid prefix length %d", bits)
+	}
+	table.insertCIDR(start, bits, asn)
+	return nil
+}
+
+// MRT (RFC 6396) record types and subtypes used by RIB dumps
+const (
+	mrtHeaderSize = 12
+
+	mrtTableDump   = 12
+	mrtTableDumpV2 = 13
+
+	mrtTableDumpIPv4 = 1
+	mrtTableDumpIPv6 = 2
+
+	mrtRIBIPv4Unicast = 2
+	mrtRIBIPv6Unicast = 4
+
+	bgpAttrASPath  = 2
+	bgpAttrAS4Path = 17
+	bgpASSet       = 1
+	bgpASSequence  = 2
+	bgpASTrans     = 23456
+)
+
+// isMRTHeader reports whether header starts a RIB dump record
+func isMRTHeader(header []byte) bool {
+	recordType := binary.BigEndian.Uint16(header[4:6])
+	length := binary.BigEndian.Uint32(header[8:12])
+	return (recordType == mrtTableDump || recordType == mrtTableDumpV2) && length < 1<<24
+}
+
+// parseMRT reads the prefixes and origin ASes of a RIB dump. Records other
+// than unicast RIB entries are skipped.
+func parseMRT(src io.Reader) (*prefixTable, error) {
+	table := newPrefixTable()
+	header := make([]byte, mrtHeaderSize)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			if err == io.EOF {
+				return table, nil
+			}
+			return nil, fmt.Errorf("truncated MRT header: %w", err)
+		}
+		recordType := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+		if _, err := io.ReadFull(src, body); err != nil {
+			return nil, fmt.Errorf("truncated MRT record: %w", err)
+		}
+
+		var err error
+		switch {
+		case recordType == mrtTableDumpV2 && (subtype == mrtRIBIPv4Unicast || subtype == mrtRIBIPv6Unicast):
+			err = parseMRTRIB(table, body, subtype == mrtRIBIPv6Unicast)
+		case recordType == mrtTableDump && (subtype == mrtTableDumpIPv4 || subtype == mrtTableDumpIPv6):
+			err = parseMRTTableDump(table, body, subtype == mrtTableDumpIPv6)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseMRTRIB reads a TABLE_DUMP_V2 RIB entry: sequence, prefix, then
+// entries whose attributes carry 4-byte AS_PATHs. The first entry with an
+// origin wins.
+func parseMRTRIB(table *prefixTable, body []byte, ipv6 bool) error {
+	if len(body) < 5 {
+		return fmt.Errorf("short MRT RIB record")
+	}
+	bits := int(body[4])
+	ip, n, err := mrtPrefix(body[5:], bits, ipv6)
+	if err != nil {
+		return err
+	}
+	rest := body[5+n:]
+	if len(rest) < 2 {
+		return fmt.Errorf("short MRT RIB record")
+	}
+	count := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+
+	for i := 0; i < count; i++ {
+		if len(rest) < 8 {
+			return fmt.Errorf("short MRT RIB entry")
+		}
+		attrLen := int(binary.BigEndian.Uint16(rest[6:8]))
+		if len(rest) < 8+attrLen {
+			return fmt.Errorf("short MRT RIB entry attributes")
+		}
+		if asn, ok := bgpOriginAS(rest[8:8+attrLen], 4); ok {
+			table.insertCIDR(ip, bits, asn)
+			return nil
+		}
+		rest = rest[8+attrLen:]
+	}
+	return nil
+}
+
+// parseMRTTableDump reads a legacy TABLE_DUMP entry, whose AS_PATH has
+// 2-byte ASes
+func parseMRTTableDump(table *prefixTable, body []byte, ipv6 bool) error {
+	addrLen := net.IPv4len
+	if ipv6 {
+		addrLen = net.IPv6len
+	}
+	// view, sequence, prefix, length, status, originated, peer IP, peer AS, attribute length
+	fixed := 4 + addrLen + 2 + 4 + addrLen + 2 + 2
+	if len(body) < fixed {
+		return fmt.Errorf("short MRT TABLE_DUMP record")
+	}
+	ip := net.IP(append([]byte{}, body[4:4+addrLen]...))
+	bits := int(body[4+addrLen])
+	attrLen := int(binary.BigEndian.Uint16(body[fixed-2 : fixed]))
+	if len(body) < fixed+attrLen {
+		return fmt.Errorf("short MRT TABLE_DUMP attributes")
+	}
+	if asn, ok := bgpOriginAS(body[fixed:fixed+attrLen], 2); ok {
+		table.insertCIDR(ip, bits, asn)
+	}
+	return nil
+}
+
+// mrtPrefix reads a prefix of bits stored in the fewest bytes, returning it
+// and the bytes read
+func mrtPrefix(data []byte, bits int, ipv6 bool) (net.IP, int, error) {
+	size := net.IPv4len
+	if ipv6 {
+		size = net.IPv6len
+	}
+	n := (bits + 7) / 8
+	if bits > size*8 || len(data) < n {
+		return nil, 0, fmt.Errorf("invalid MRT prefix length %d", bits)
+	}
+	ip := make(net.IP, size)
+	copy(ip, data[:n])
+	return ip, n, nil
+}
+
+// bgpOriginAS returns the origin AS in BGP path attributes: the last AS of
+// an AS_SEQUENCE, or the lowest of a trailing AS_SET. A 2-byte AS_PATH
+// ending in AS_TRANS defers to AS4_PATH.
+func bgpOriginAS(attrs []byte, asSize int) (uint32, bool) {
+	var path, path4 []byte
+	for len(attrs) >= 3 {
+		flags, code := attrs[0], attrs[1]
+		hdr, length := 3, int(attrs[2])
+		if flags&0x10 != 0 { // Extended length
+			if len(attrs) < 4 {
+				return 0, false
+			}
+			hdr, length = 4, int(binary.BigEndian.Uint16(attrs[2:4]))
+		}
+		if len(attrs) < hdr+length {
+			return 0, false
+		}
+		switch code {
+		case bgpAttrASPath:
+			path = attrs[hdr : hdr+length]
+		case bgpAttrAS4Path:
+			path4 = attrs[hdr : hdr+length]
+		}
+		attrs = attrs[hdr+length:]
+	}
+
+	asn, ok := lastPathAS(path, asSize)
+	if ok && asn == bgpASTrans && path4 != nil {
+		return lastPathAS(path4, 4)
+	}
+	return asn, ok
+}
+
+func lastPathAS(path []byte, asSize int) (uint32, bool) {
+	var origin uint32
+	found := false
+	for len(path) >= 2 {
+		segType, count := path[0], int(path[1])
+		if len(path) < 2+count*asSize {
+			return 0, false
+		}
+		ases := make([]uint32, count)
+		for i := range ases {
+			at := path[2+i*asSize:]
+			if asSize == 2 {
+				ases[i] = uint32(binary.BigEndian.Uint16(at))
+			} else {
+				ases[i] = binary.BigEndian.Uint32(at)
+			}
+		}
+		switch {
+		case segType == bgpASSequence && count > 0:
+			origin, found = ases[count-1], true
+		case segType == bgpASSet && count > 0:
+			sort.Slice(ases, func(i, j int) bool { return ases[i] < ases[j] })
+			origin, found = ases[0], true
+		}
+		path = path[2+count*asSize:]
+	}
+	return origin, found
+}
@@ -0,0 +1,308 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// MRT and BGP fixtures are built here rather than checked in, so each test
+// shows the routes it resolves
+
+func bgpAttr(code byte, value []byte) []byte {
+	if len(value) > 255 {
+		attr := []byte{0x50, code, 0, 0}
+		binary.BigEndian.PutUint16(attr[2:], uint16(len(value)))
+		return append(attr, value...)
+	}
+	return append([]byte{0x40, code, byte(len(value))}, value...)
+}
+
+// asPathSegment encodes a segment of ASes of asSize bytes each
+func asPathSegment(segType byte, asSize int, ases ...uint32) []byte {
+	out := []byte{segType, byte(len(ases))}
+	for _, as := range ases {
+		if asSize == 2 {
+			out = binary.BigEndian.AppendUint16(out, uint16(as))
+		} else {
+			out = binary.BigEndian.AppendUint32(out, as)
+		}
+	}
+	return out
+}
+
+func mrtRecord(recordType, subtype uint16, body []byte) []byte {
+	header := make([]byte, mrtHeaderSize)
+	binary.BigEndian.PutUint16(header[4:6], recordType)
+	binary.BigEndian.PutUint16(header[6:8], subtype)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(body)))
+	return append(header, body...)
+}
+
+// mrtRIB encodes a TABLE_DUMP_V2 RIB entry of cidr with one entry per set
+// of attributes
+func mrtRIB(t *testing.T, cidr string, attrs ...[]byte) []byte {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Bad fixture prefix %s: %v", cidr, err)
+	}
+	bits, _ := network.Mask.Size()
+	addr := ip.To16()
+	subtype := uint16(mrtRIBIPv6Unicast)
+	if ip4 := ip.To4(); ip4 != nil {
+		addr, subtype = ip4, mrtRIBIPv4Unicast
+	}
+
+	body := []byte{0, 0, 0, 1, byte(bits)}
+	body = append(body, addr[:(bits+7)/8]...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	for _, a := range attrs {
+		body = append(body, 0, 0, 0, 0, 0, 0) // Peer index, originated time
+		body = binary.BigEndian.AppendUint16(body, uint16(len(a)))
+		body = append(body, a...)
+	}
+	return mrtRecord(mrtTableDumpV2, subtype, body)
+}
+
+// mrtTableDumpEntry encodes a legacy TABLE_DUMP entry of cidr
+func mrtTableDumpEntry(t *testing.T, cidr string, attrs []byte) []byte {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Bad fixture prefix %s: %v", cidr, err)
+	}
+	bits, _ := network.Mask.Size()
+	addr := ip.To16()
+	subtype := uint16(mrtTableDumpIPv6)
+	if ip4 := ip.To4(); ip4 != nil {
+		addr, subtype = ip4, mrtTableDumpIPv4
+	}
+
+	body := []byte{0, 0, 0, 1} // View, sequence
+	body = append(body, addr...)
+	body = append(body, byte(bits), 1, 0, 0, 0, 0) // Length, status, originated time
+	body = append(body, make([]byte, len(addr))...)
+	body = append(body, 0, 0) // Peer AS
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+	return mrtRecord(mrtTableDump, subtype, body)
+}
+
+type asnLookup struct {
+	ip  string
+	asn uint32 // 0 if the address should not resolve
+}
+
+func checkLookups(t *testing.T, name string, table *prefixTable, lookups []asnLookup) {
+	t.Helper()
+	for _, l := range lookups {
+		asn, ok := table.lookup(net.ParseIP(l.ip))
+		if ok != (l.asn != 0) || asn != l.asn {
+			t.Errorf("%s: lookup of %s expected AS%d, got AS%d (%v)", name, l.ip, l.asn, asn, ok)
+		}
+	}
+}
+
+// TestParseASNText tests the CSV, pfx2as and range datasets, with IPv4 and
+// IPv6 rows and the rows that are skipped
+func TestParseASNText(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		size    int
+		lookups []asnLookup
+	}{
+		{"prefix CSV", "prefix,asn\n# comment\n1.0.0.0/16,100\n1.0.4.0/24,AS200\n2001:db8::/32,\"300\"\n",
+			3, []asnLookup{{"1.0.3.9", 100}, {"1.0.4.9", 200}, {"1.1.0.1", 0}, {"2001:db8:ffff::1", 300}, {"2001:db9::1", 0}}},
+		{"pfx2as", "1.0.0.0\t24\t13335\n2001:db8::\t48\t13335_209242\n8.8.8.0\t24\t{15169,396982}\n",
+			3, []asnLookup{{"1.0.0.200", 13335}, {"2001:db8:0:1::", 13335}, {"2001:db8:1::", 0}, {"8.8.8.8", 15169}}},
+		{"ranges", "1.0.0.1,1.0.0.6,400,US,ISP\n2001:db8::,2001:db8::ffff,500,ZZ,Net\n",
+			5, []asnLookup{{"1.0.0.0", 0}, {"1.0.0.1", 400}, {"1.0.0.6", 400}, {"1.0.0.7", 0}, {"2001:db8::abcd", 500}, {"2001:db8::1:0", 0}}},
+		{"unrouted and unparsable rows", "1.0.0.0/24,0\n2.0.0.0,2.0.0.255,0,None\nnot-an-ip,1\n3.0.0.0\t33\t600\n4.0.0.0,bad\n5.0.0.0/24\n",
+			0, []asnLookup{{"1.0.0.1", 0}, {"2.0.0.1", 0}, {"3.0.0.1", 0}}},
+		{"reversed range", "1.0.0.9,1.0.0.1,700\n", 0, []asnLookup{{"1.0.0.5", 0}}},
+	}
+	for _, tc := range cases {
+		table, err := parseASNText(strings.NewReader(tc.data))
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tc.name, err)
+		}
+		if table.size() != tc.size {
+			t.Errorf("%s: expected %d prefixes, got %d", tc.name, tc.size, table.size())
+		}
+		checkLookups(t, tc.name, table, tc.lookups)
+	}
+}
+
+// TestPrefixTableInsertRange tests that ranges are covered by the fewest
+// aligned prefixes and nothing past their ends
+func TestPrefixTableInsertRange(t *testing.T) {
+	cases := []struct {
+		name       string
+		start, end string
+		prefixes   int
+		lookups    []asnLookup
+	}{
+		{"single address", "10.0.0.7", "10.0.0.7", 1, []asnLookup{{"10.0.0.7", 1}, {"10.0.0.6", 0}, {"10.0.0.8", 0}}},
+		{"aligned /24", "10.0.1.0", "10.0.1.255", 1, []asnLookup{{"10.0.1.0", 1}, {"10.0.1.255", 1}, {"10.0.2.0", 0}}},
+		{"unaligned", "10.0.0.1", "10.0.0.6", 4, []asnLookup{{"10.0.0.0", 0}, {"10.0.0.1", 1}, {"10.0.0.4", 1}, {"10.0.0.6", 1}, {"10.0.0.7", 0}}},
+		{"whole IPv4 space", "0.0.0.0", "255.255.255.255", 1, []asnLookup{{"0.0.0.0", 1}, {"203.0.113.9", 1}, {"2001:db8::1", 0}}},
+		{"IPv6", "2001:db8::1", "2001:db8::2", 2, []asnLookup{{"2001:db8::", 0}, {"2001:db8::1", 1}, {"2001:db8::2", 1}, {"2001:db8::3", 0}}},
+		{"whole IPv6 space", "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", 1, []asnLookup{{"::1", 1}, {"2001:db8::1", 1}}},
+	}
+	for _, tc := range cases {
+		table := newPrefixTable()
+		if err := table.insertRange(net.ParseIP(tc.start), net.ParseIP(tc.end), 1); err != nil {
+			t.Fatalf("%s: insertRange failed: %v", tc.name, err)
+		}
+		if table.size() != tc.prefixes {
+			t.Errorf("%s: expected %d prefixes, got %d", tc.name, tc.prefixes, table.size())
+		}
+		checkLookups(t, tc.name, table, tc.lookups)
+	}
+
+	table := newPrefixTable()
+	if err := table.insertRange(net.ParseIP("10.0.0.1"), net.ParseIP("::1"), 1); err == nil {
+		t.Errorf("Range mixing address families should be refused")
+	}
+	if err := table.insertRange(net.ParseIP("10.0.0.9"), net.ParseIP("10.0.0.1"), 1); err == nil {
+		t.Errorf("Reversed range should be refused")
+	}
+}
+
+// TestBGPOriginAS tests that the origin is the last AS of the path, the
+// lowest of a trailing AS_SET, or taken from AS4_PATH behind AS_TRANS
+func TestBGPOriginAS(t *testing.T) {
+	origin := bgpAttr(1, []byte{0}) // ORIGIN IGP, ahead of the path
+	cases := []struct {
+		name   string
+		attrs  []byte
+		asSize int
+		asn    uint32
+		ok     bool
+	}{
+		{"4-byte sequence", append(origin, bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 4, 3356, 13335, 4200000001))...), 4, 4200000001, true},
+		{"2-byte sequence", bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 2, 701, 15169)), 2, 15169, true},
+		{"trailing AS_SET", bgpAttr(bgpAttrASPath, append(asPathSegment(bgpASSequence, 4, 3356), asPathSegment(bgpASSet, 4, 65002, 65001, 65003)...)), 4, 65001, true},
+		{"AS_SET then sequence", bgpAttr(bgpAttrASPath, append(asPathSegment(bgpASSet, 4, 65002, 65001), asPathSegment(bgpASSequence, 4, 174)...)), 4, 174, true},
+		{"AS_TRANS with AS4_PATH",
+			append(bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 2, 701, bgpASTrans)), bgpAttr(bgpAttrAS4Path, asPathSegment(bgpASSequence, 4, 701, 4200000002))...),
+			2, 4200000002, true},
+		{"AS_TRANS without AS4_PATH", bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 2, 701, bgpASTrans)), 2, bgpASTrans, true},
+		{"AS4_PATH ignored without AS_TRANS",
+			append(bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 2, 701)), bgpAttr(bgpAttrAS4Path, asPathSegment(bgpASSequence, 4, 4200000002))...),
+			2, 701, true},
+		{"extended length truncated segment", bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 4, make([]uint32, 70)...)[:2+4*69]), 4, 0, false},
+		{"extended length path", bgpAttr(bgpAttrASPath, append(asPathSegment(bgpASSequence, 4, make([]uint32, 69)...), asPathSegment(bgpASSequence, 4, 64512)...)), 4, 64512, true},
+		{"no AS_PATH", origin, 4, 0, false},
+		{"empty path", bgpAttr(bgpAttrASPath, nil), 4, 0, false},
+		{"truncated attribute", bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 4, 13335))[:5], 4, 0, false},
+		{"truncated extended header", []byte{0x50, bgpAttrASPath, 0}, 4, 0, false},
+		{"truncated segment", bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 4, 3356, 13335)[:8]), 4, 0, false},
+	}
+	for _, tc := range cases {
+		asn, ok := bgpOriginAS(tc.attrs, tc.asSize)
+		if ok != tc.ok || asn != tc.asn {
+			t.Errorf("%s: expected AS%d (%v), got AS%d (%v)", tc.name, tc.asn, tc.ok, asn, ok)
+		}
+	}
+}
+
+// TestParseMRT tests that TABLE_DUMP_V2 and legacy TABLE_DUMP dumps of both
+// families resolve their origins, and that truncated dumps are refused
+func TestParseMRT(t *testing.T) {
+	path4 := func(ases ...uint32) []byte { return bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 4, ases...)) }
+	path2 := func(ases ...uint32) []byte { return bgpAttr(bgpAttrASPath, asPathSegment(bgpASSequence, 2, ases...)) }
+
+	var dump []byte
+	dump = append(dump, mrtRecord(mrtTableDumpV2, 1, []byte("peer index table"))...) // Skipped
+	dump = append(dump, mrtRIB(t, "1.0.0.0/24", path4(3356, 13335))...)
+	dump = append(dump, mrtRIB(t, "1.0.0.128/25", bgpAttr(1, []byte{0}), path4(174, 4200000001))...) // First entry has no path
+	dump = append(dump, mrtRIB(t, "2001:db8::/33", path4(6939, 64500))...)
+	dump = append(dump, mrtRIB(t, "0.0.0.0/0")...) // No entries
+	dump = append(dump, mrtTableDumpEntry(t, "8.8.8.0/24", path2(701, 15169))...)
+	dump = append(dump, mrtTableDumpEntry(t, "2001:4860::/32", path2(701, 15169))...)
+
+	if !isMRTHeader(dump[:mrtHeaderSize]) {
+		t.Fatalf("Dump should be recognized by its first header")
+	}
+	table, err := parseMRT(bytes.NewReader(dump))
+	if err != nil {
+		t.Fatalf("parseMRT failed: %v", err)
+	}
+	if table.size() != 5 {
+		t.Errorf("Expected 5 prefixes, got %d", table.size())
+	}
+	checkLookups(t, "MRT", table, []asnLookup{
+		{"1.0.0.1", 13335}, {"1.0.0.200", 4200000001}, {"1.0.1.1", 0},
+		{"2001:db8:7fff::1", 64500}, {"2001:db8:8000::1", 0},
+		{"8.8.8.8", 15169}, {"2001:4860::8888", 15169},
+	})
+
+	rib := mrtRIB(t, "1.0.0.0/24", path4(13335))
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated header", rib[:mrtHeaderSize-2]},
+		{"truncated record", rib[:len(rib)-1]},
+		{"short RIB record", mrtRecord(mrtTableDumpV2, mrtRIBIPv4Unicast, []byte{0, 0, 0, 1})},
+		{"prefix past the record", mrtRecord(mrtTableDumpV2, mrtRIBIPv4Unicast, []byte{0, 0, 0, 1, 24, 1, 0})},
+		{"prefix too long", mrtRecord(mrtTableDumpV2, mrtRIBIPv4Unicast, []byte{0, 0, 0, 1, 33, 1, 0, 0, 0, 0, 0, 0})},
+		{"entry count past the record", mrtRecord(mrtTableDumpV2, mrtRIBIPv4Unicast, []byte{0, 0, 0, 1, 8, 1, 0, 1})},
+		{"attributes past the entry", mrtRecord(mrtTableDumpV2, mrtRIBIPv4Unicast, []byte{0, 0, 0, 1, 8, 1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 9, 0x40})},
+		{"short TABLE_DUMP", mrtRecord(mrtTableDump, mrtTableDumpIPv4, make([]byte, 10))},
+	}
+	for _, tc := range cases {
+		if _, err := parseMRT(bytes.NewReader(tc.data)); err == nil {
+			t.Errorf("%s: truncated dump should be refused", tc.name)
+		}
+	}
+}
+
+// TestPinnedASNResolver tests that a pinned resolver only loads, and reloads,
+// the dataset whose hash it was given
+func TestPinnedASNResolver(t *testing.T) {
+	dataset := []byte("1.0.0.0/24,13335\n8.8.8.0/24,15169\n")
+	sum := sha256.Sum256(dataset)
+	hash := hex.EncodeToString(sum[:])
+
+	path := filepath.Join(t.TempDir(), "asn.csv")
+	if err := os.WriteFile(path, dataset, 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+
+	if _, err := NewPinnedASNResolver(path, strings.Repeat("0", 64)); err == nil {
+		t.Errorf("Pinned resolver should refuse a dataset of another hash")
+	}
+	resolver, err := NewPinnedASNResolver(path, hash)
+	if err != nil {
+		t.Fatalf("NewPinnedASNResolver failed: %v", err)
+	}
+	if resolver.Hash() != hash {
+		t.Errorf("Expected hash %s, got %s", hash, resolver.Hash())
+	}
+
+	// An updated file is refused and the pinned dataset stays in use
+	os.WriteFile(path, []byte("1.0.0.0/24,64500\n"), 0o600)
+	if err := resolver.Reload(); err == nil {
+		t.Errorf("Reload of another dataset should fail")
+	}
+	if got := resolver.NetworkGroup("1.0.0.1"); got != "AS13335" {
+		t.Errorf("Expected the pinned dataset to group 1.0.0.1 as AS13335, got %s", got)
+	}
+
+	// An unpinned resolver follows the file
+	unpinned, err := NewASNResolver(path)
+	if err != nil {
+		t.Fatalf("NewASNResolver failed: %v", err)
+	}
+	if got := unpinned.NetworkGroup("1.0.0.1"); got != "AS64500" {
+		t.Errorf("Expected 1.0.0.1 grouped as AS64500, got %s", got)
+	}
+}
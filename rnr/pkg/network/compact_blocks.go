@@ -0,0 +1,411 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Compact block relay: blocks are announced as their header and a short ID
+// per transaction instead of the full transaction bodies, which peers
+// mostly hold already, as mempool sync gossips every transaction. A
+// receiver rebuilds the block from its mempool and fetches the transactions
+// it lacks from the peer that sent the announcement, over the transaction
+// protocol in pages of maxBlockTxRequests. Only a rebuilt block whose hash matches the
+// announced one is relayed, so every relaying peer can serve the
+// transactions of the blocks it relays.
+
+const TopicCompactBlocks = "/rnr/cblocks/1"
+
+const (
+	shortIDBytes       = 6    // As in BIP 152: collisions within a mempool stay unlikely
+	compactBlockCache  = 64   // Recent blocks kept to serve their transactions
+	maxBlockTxRequests = 4096 // Transactions per request; larger blocks are fetched in pages
+)
+
+// CompactBlock announces a block without its transaction bodies
+type CompactBlock struct {
+	Header      *core.BlockHeader `json:"header"`
+	ProposerID  string            `json:"proposer_id"`
+	PoHSequence []byte            `json:"poh_sequence"`
+	Signature   []byte            `json:"signature"`
+	VRFProof    []byte            `json:"vrf_proof"`
+	LastCommit  []*core.CommitSig `json:"last_commit,omitempty"`
+	BlockHash   []byte            `json:"block_hash"`
+	ShortIDs    []uint64          `json:"short_ids"`          // Of the block's transactions, in order
+	Shredded    bool              `json:"shredded,omitempty"` // The block is also on its way as shreds
+}
+
+// MempoolHandler returns the transactions in the mempool
+type MempoolHandler func() []*core.Transaction
+
+// txRequest asks for a transaction by ID, or for transactions of a recent
+// block by their short IDs
+type txRequest struct {
+	TxID      string   `json:"tx_id,omitempty"`
+	BlockHash []byte   `json:"block_hash,omitempty"`
+	ShortIDs  []uint64 `json:"short_ids,omitempty"`
+}
+
+// ShortTxID is the short ID of a transaction in the block with blockHash.
+// Salting with the block hash keeps collisions from being precomputed.
+func ShortTxID(blockHash []byte, txID string) uint64 {
+	hash := sha256.Sum256(append(append([]byte{}, blockHash...), txID...))
+	var buf [8]byte
+	copy(buf[8-shortIDBytes:], hash[:shortIDBytes])
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// NewCompactBlock returns the compact announcement of block
+func NewCompactBlock(block *core.Block) (*CompactBlock, error) {
+	hash, err := block.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash block: %w", err)
+	}
+	cb := &CompactBlock{
+		Header:      block.Header,
+		ProposerID:  block.ProposerID,
+		PoHSequence: block.PoHSequence,
+		Signature:   block.Signature,
+		VRFProof:    block.VRFProof,
+		LastCommit:  block.LastCommit,
+		BlockHash:   hash,
+		ShortIDs:    make([]uint64, len(block.Transactions)),
+	}
+	for i, tx := range block.Transactions {
+		cb.ShortIDs[i] = ShortTxID(hash, tx.ID)
+	}
+	return cb, nil
+}
+
+// block returns the announced block with the given transactions
+func (cb *CompactBlock) block(txs []*core.Transaction) *core.Block {
+	return &core.Block{
+		Header:       cb.Header,
+		Transactions: txs,
+		ProposerID:   cb.ProposerID,
+		PoHSequence:  cb.PoHSequence,
+		Signature:    cb.Signature,
+		VRFProof:     cb.VRFProof,
+		LastCommit:   cb.LastCommit,
+	}
+}
+
+// compactBlocks keeps recently relayed blocks, by hash, with their
+// transactions by short ID
+type compactBlocks struct {
+	mu        sync.Mutex
+	blocks    map[string]*core.Block
+	txs       map[string]map[uint64]*core.Transaction
+	delivered map[string]bool // Blocks passed to the block handler or proposed here
+	order     []string
+}
+
+func newCompactBlocks() *compactBlocks {
+	return &compactBlocks{
+		blocks:    make(map[string]*core.Block),
+		txs:       make(map[string]map[uint64]*core.Transaction),
+		delivered: make(map[string]bool),
+	}
+}
+
+func (c *compactBlocks) add(hash []byte, block *core.Block) {
+	key := hex.EncodeToString(hash)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocks[key]; ok {
+		return
+	}
+	txs := make(map[uint64]*core.Transaction, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		txs[ShortTxID(hash, tx.ID)] = tx
+	}
+	c.blocks[key] = block
+	c.txs[key] = txs
+	c.order = append(c.order, key)
+	if len(c.order) > compactBlockCache {
+		delete(c.blocks, c.order[0])
+		delete(c.txs, c.order[0])
+		delete(c.delivered, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// markDelivered reports whether the block was not delivered yet, and marks
+// it delivered
+func (c *compactBlocks) markDelivered(hash []byte) bool {
+	key := hex.EncodeToString(hash)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.delivered[key] {
+		return false
+	}
+	c.delivered[key] = true
+	return true
+}
+
+func (c *compactBlocks) get(hash []byte) *core.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blocks[hex.EncodeToString(hash)]
+}
+
+// transactions returns the transactions of a cached block by short ID, or
+// nil if the block is not cached or lacks one of them
+func (c *compactBlocks) transactions(hash []byte, shortIDs []uint64) []*core.Transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byID, ok := c.txs[hex.EncodeToString(hash)]
+	if !ok {
+		return nil
+	}
+	txs := make([]*core.Transaction, len(shortIDs))
+	for i, id := range shortIDs {
+		if txs[i], ok = byID[id]; !ok {
+			return nil
+		}
+	}
+	return txs
+}
+
+// SetMempoolHandler sets the source of transactions compact blocks are
+// rebuilt from
+func (p *P2PNetwork) SetMempoolHandler(handler MempoolHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mempoolHandler = handler
+}
+
+// RequestBlockTransactions requests transactions of a block the peer
+// relayed, by their short IDs, in pages the peer serves
+func (p *P2PNetwork) RequestBlockTransactions(pid peer.ID, blockHash []byte, shortIDs []uint64) ([]*core.Transaction, error) {
+	txs := make([]*core.Transaction, 0, len(shortIDs))
+	for start := 0; start < len(shortIDs); start += maxBlockTxRequests {
+		end := start + maxBlockTxRequests
+		if end > len(shortIDs) {
+			end = len(shortIDs)
+		}
+		page, err := p.requestBlockTransactionPage(pid, blockHash, shortIDs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, page...)
+	}
+	return txs, nil
+}
+
+// requestBlockTransactionPage requests up to maxBlockTxRequests transactions
+// of a block in one request
+func (p *P2PNetwork) requestBlockTransactionPage(pid peer.ID, blockHash []byte, shortIDs []uint64) ([]*core.Transaction, error) {
+	stream, err := p.Host.NewStream(p.ctx, pid, protocol.ID(TransactionProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(statusTimeout))
+
+	if err := p.writeMessage(stream, core.MessageTypeTxRequest, &txRequest{BlockHash: blockHash, ShortIDs: shortIDs}); err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+	stream.CloseWrite()
+
+	var txs []*core.Transaction
+	if _, err := ReadMessageOf(stream, core.MessageTypeBlockTxs, &txs); err != nil {
+		return nil, fmt.Errorf("failed to read transactions: %w", err)
+	}
+	if len(txs) != len(shortIDs) {
+		return nil, fmt.Errorf("requested %d transactions, received %d", len(shortIDs), len(txs))
+	}
+	for i, tx := range txs {
+		if tx == nil || ShortTxID(blockHash, tx.ID) != shortIDs[i] {
+			return nil, fmt.Errorf("received a transaction not requested")
+		}
+	}
+	return txs, nil
+}
+
+// serveBlockTransactions answers a request for transactions of a relayed
+// block
+func (p *P2PNetwork) serveBlockTransactions(w io.Writer, req *txRequest) {
+	if len(req.ShortIDs) > maxBlockTxRequests {
+		WriteError(w, "too many transactions requested")
+		return
+	}
+	txs := p.compactBlocks.transactions(req.BlockHash, req.ShortIDs)
+	if txs == nil {
+		WriteError(w, "block not found")
+		return
+	}
+	p.writeMessage(w, core.MessageTypeBlockTxs, txs)
+}
+
+// BroadcastBlock announces a block as a compact block, and with shred
+// relay on sends a large block as shreds too
+func (p *P2PNetwork) BroadcastBlock(block *core.Block) error {
+	cb, err := NewCompactBlock(block)
+	if err != nil {
+		return err
+	}
+	p.compactBlocks.add(cb.BlockHash, block)
+	p.compactBlocks.markDelivered(cb.BlockHash)
+
+	p.mu.RLock()
+	shredRelay := p.shredRelay
+	p.mu.RUnlock()
+	if shredRelay {
+		if cb.Shredded, err = p.broadcastShreds(cb.BlockHash, block); err != nil {
+			log.Printf("⚠️  Failed to send block as shreds: %v", err)
+		}
+	}
+
+	data, err := json.Marshal(cb)
+	if err != nil {
+		return fmt.Errorf("failed to marshal compact block: %w", err)
+	}
+	return p.pubsub.Publish(TopicCompactBlocks, data)
+}
+
+// deliverBlock passes a relayed block to the block handler, once
+func (p *P2PNetwork) deliverBlock(hash []byte, block *core.Block) {
+	if p.blockHandler == nil || !p.compactBlocks.markDelivered(hash) {
+		return
+	}
+	if err := p.blockHandler(block); err != nil {
+		log.Printf("⚠️  Block handler error: %v", err)
+	}
+}
+
+func decodeCompactBlock(data []byte) (*CompactBlock, bool) {
+	var cb CompactBlock
+	if err := json.Unmarshal(data, &cb); err != nil || cb.Header == nil || cb.ProposerID == "" || len(cb.BlockHash) != sha256.Size {
+		return nil, false
+	}
+	return &cb, true
+}
+
+// validateCompactBlockMessage rebuilds the announced block and checks it, so
+// it can be relayed
+func (p *P2PNetwork) validateCompactBlockMessage(from peer.ID, data []byte) ValidationResult {
+	cb, ok := decodeCompactBlock(data)
+	if !ok {
+		return ValidationReject
+	}
+	if p.compactBlocks.get(cb.BlockHash) != nil {
+		return ValidationAccept // Our own, or rebuilt from an earlier announcement
+	}
+
+	// Shreds under way rebuild the block without fetching from the peer
+	if p.shreds.wait(cb.BlockHash, cb.Shredded, shredRebuildWait) != nil {
+		return ValidationAccept
+	}
+
+	block, err := p.reconstructBlock(from, cb)
+	if err != nil {
+		log.Printf("⚠️  Failed to rebuild block #%d from %s: %v", cb.Header.Height, shortPeerID(from), err)
+		return ValidationIgnore
+	}
+	if block == nil {
+		return ValidationReject
+	}
+	// The proposer signs the whole block, so it is checked once rebuilt
+	if result := p.checkBlock(block); result != ValidationAccept {
+		return result
+	}
+	p.compactBlocks.add(cb.BlockHash, block)
+	return ValidationAccept
+}
+
+// reconstructBlock rebuilds an announced block from the mempool and the
+// transactions fetched from the announcing peer. It returns nil if the
+// peer's own transactions do not make up the announced block.
+func (p *P2PNetwork) reconstructBlock(from peer.ID, cb *CompactBlock) (*core.Block, error) {
+	p.mu.RLock()
+	mempool := p.mempoolHandler
+	p.mu.RUnlock()
+
+	// Transactions whose short IDs collide within the mempool are fetched
+	candidates := make(map[uint64]*core.Transaction, len(cb.ShortIDs))
+	if mempool != nil {
+		wanted := make(map[uint64]bool, len(cb.ShortIDs))
+		for _, id := range cb.ShortIDs {
+			wanted[id] = true
+		}
+		collided := make(map[uint64]bool)
+		for _, tx := range mempool() {
+			id := ShortTxID(cb.BlockHash, tx.ID)
+			if !wanted[id] || collided[id] {
+				continue
+			}
+			if _, dup := candidates[id]; dup {
+				delete(candidates, id)
+				collided[id] = true
+				continue
+			}
+			candidates[id] = tx
+		}
+	}
+
+	txs := make([]*core.Transaction, len(cb.ShortIDs))
+	var missing []uint64
+	var missingIdx []int
+	for i, id := range cb.ShortIDs {
+		if tx, ok := candidates[id]; ok {
+			txs[i] = tx
+		} else {
+			missing = append(missing, id)
+			missingIdx = append(missingIdx, i)
+		}
+	}
+	if len(missing) > 0 {
+		fetched, err := p.RequestBlockTransactions(from, cb.BlockHash, missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %d missing transactions: %w", len(missing), err)
+		}
+		for i, tx := range fetched {
+			txs[missingIdx[i]] = tx
+		}
+	}
+
+	block := cb.block(txs)
+	if hash, err := block.Hash(); err == nil && bytes.Equal(hash, cb.BlockHash) {
+		log.Printf("📦 Rebuilt block #%d: %d transactions, %d fetched", cb.Header.Height, len(txs), len(missing))
+		return block, nil
+	}
+	if len(missing) == len(cb.ShortIDs) {
+		return nil, nil
+	}
+
+	// A short ID matched another mempool transaction: take them all from
+	// the peer, which must hold exactly the announced block
+	fetched, err := p.RequestBlockTransactions(from, cb.BlockHash, cb.ShortIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transactions: %w", err)
+	}
+	block = cb.block(fetched)
+	if hash, err := block.Hash(); err != nil || !bytes.Equal(hash, cb.BlockHash) {
+		return nil, nil
+	}
+	return block, nil
+}
+
+func (p *P2PNetwork) handleCompactBlockMessage(_ peer.ID, data []byte) {
+	cb, ok := decodeCompactBlock(data)
+	if !ok {
+		return
+	}
+	if block := p.compactBlocks.get(cb.BlockHash); block != nil {
+		p.deliverBlock(cb.BlockHash, block)
+	}
+}
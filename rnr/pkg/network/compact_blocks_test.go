@@ -0,0 +1,152 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// testBlock returns a block at height with txs transactions
+func testBlock(height uint64, txs int) *core.Block {
+	block := &core.Block{
+		Header:     &core.BlockHeader{Height: height, Timestamp: time.Unix(1700000000, 0), PrevBlockHash: []byte("parent")},
+		ProposerID: "validator-1",
+		Signature:  []byte("signature"),
+	}
+	for i := 0; i < txs; i++ {
+		block.Transactions = append(block.Transactions, &core.Transaction{
+			ID:     fmt.Sprintf("tx-%d-%d", height, i),
+			From:   "alice",
+			To:     "bob",
+			Amount: big.NewInt(int64(i + 1)),
+			Fee:    big.NewInt(1),
+			Nonce:  uint64(i),
+		})
+	}
+	return block
+}
+
+// TestCompactBlock tests that short IDs are salted by the block and that an
+// announcement carries the block without its transactions
+func TestCompactBlock(t *testing.T) {
+	if ShortTxID([]byte("block-1"), "tx") == ShortTxID([]byte("block-2"), "tx") {
+		t.Errorf("Short IDs should differ between blocks")
+	}
+	if id := ShortTxID([]byte("block-1"), "tx"); id >= 1<<(8*shortIDBytes) {
+		t.Errorf("Short ID %x longer than %d bytes", id, shortIDBytes)
+	}
+
+	block := testBlock(5, 3)
+	cb, err := NewCompactBlock(block)
+	if err != nil {
+		t.Fatalf("NewCompactBlock failed: %v", err)
+	}
+	hash, _ := block.Hash()
+	if !bytes.Equal(cb.BlockHash, hash) || len(cb.ShortIDs) != 3 || cb.ShortIDs[1] != ShortTxID(hash, "tx-5-1") {
+		t.Errorf("Unexpected compact block %+v", cb)
+	}
+	rebuilt, _ := cb.block(block.Transactions).Hash()
+	if !bytes.Equal(rebuilt, hash) {
+		t.Errorf("Announcement with its transactions should rebuild the block")
+	}
+}
+
+// TestCompactBlocksCache tests that relayed blocks serve their transactions
+// by short ID, are delivered once, and are dropped oldest first
+func TestCompactBlocksCache(t *testing.T) {
+	c := newCompactBlocks()
+	hashes := make([][]byte, compactBlockCache+1)
+	for i := range hashes {
+		block := testBlock(uint64(i), 2)
+		hashes[i], _ = block.Hash()
+		c.add(hashes[i], block)
+	}
+	if c.get(hashes[0]) != nil {
+		t.Errorf("Oldest block should be dropped past %d blocks", compactBlockCache)
+	}
+	last := hashes[compactBlockCache]
+	ids := []uint64{ShortTxID(last, "tx-64-1"), ShortTxID(last, "tx-64-0")}
+	if txs := c.transactions(last, ids); len(txs) != 2 || txs[0].ID != "tx-64-1" {
+		t.Errorf("Expected the transactions in the requested order, got %v", txs)
+	}
+	if c.transactions(last, append(ids, 42)) != nil || c.transactions(hashes[0], ids) != nil {
+		t.Errorf("Requests for unknown blocks or transactions should get nothing")
+	}
+	if !c.markDelivered(last) || c.markDelivered(last) {
+		t.Errorf("Block should be delivered exactly once")
+	}
+}
+
+// TestReconstructBlock tests that announced blocks are rebuilt from the
+// mempool, with the missing transactions fetched from the announcing peer,
+// and that a peer serving other transactions gets the block rejected
+func TestReconstructBlock(t *testing.T) {
+	block := testBlock(7, 4)
+	cb, err := NewCompactBlock(block)
+	if err != nil {
+		t.Fatalf("NewCompactBlock failed: %v", err)
+	}
+
+	// Every transaction in the mempool: nothing to fetch, and no peer needed
+	p := newOfflineNetwork(t)
+	p.SetMempoolHandler(func() []*core.Transaction { return block.Transactions })
+	if rebuilt, err := p.reconstructBlock(testPeerID(t), cb); err != nil || rebuilt == nil {
+		t.Fatalf("Block should be rebuilt from the mempool alone, got %v", err)
+	}
+
+	a := newTestNode(t, P2PConfig{}, "rnr-1")
+	b := newTestNode(t, P2PConfig{}, "rnr-1")
+	if err := connectNodes(a, b); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if !waitFor(func() bool { return a.GetPeerStatus(b.Host.ID().String()) != nil }) {
+		t.Fatalf("Status handshake did not complete")
+	}
+	if _, err := a.reconstructBlock(b.Host.ID(), cb); err == nil {
+		t.Errorf("Rebuilding should fail while the peer cannot serve the block")
+	}
+
+	b.compactBlocks.add(cb.BlockHash, block)
+	a.SetMempoolHandler(func() []*core.Transaction {
+		return []*core.Transaction{block.Transactions[2], testBlock(8, 1).Transactions[0], block.Transactions[0]}
+	})
+	rebuilt, err := a.reconstructBlock(b.Host.ID(), cb)
+	if err != nil || rebuilt == nil {
+		t.Fatalf("Block should be rebuilt with the missing transactions fetched, got %v", err)
+	}
+	if hash, _ := rebuilt.Hash(); !bytes.Equal(hash, cb.BlockHash) {
+		t.Errorf("Rebuilt block does not match the announcement")
+	}
+
+	// A block missing more transactions than one request carries is paged
+	large := testBlock(9, maxBlockTxRequests+10)
+	largeCB, err := NewCompactBlock(large)
+	if err != nil {
+		t.Fatalf("NewCompactBlock failed: %v", err)
+	}
+	b.compactBlocks.add(largeCB.BlockHash, large)
+	a.SetMempoolHandler(nil)
+	if rebuilt, err := a.reconstructBlock(b.Host.ID(), largeCB); err != nil || rebuilt == nil || len(rebuilt.Transactions) != len(large.Transactions) {
+		t.Fatalf("Large block should be rebuilt from paged requests, got %v", err)
+	}
+
+	// A peer holding other transactions under the announced IDs
+	altered := testBlock(7, 4)
+	altered.Transactions[1].Amount = big.NewInt(1000)
+	other := newTestNode(t, P2PConfig{}, "rnr-1")
+	if err := connectNodes(a, other); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if !waitFor(func() bool { return a.GetPeerStatus(other.Host.ID().String()) != nil }) {
+		t.Fatalf("Status handshake did not complete")
+	}
+	other.compactBlocks.add(cb.BlockHash, altered)
+	a.SetMempoolHandler(nil)
+	if rebuilt, err := a.reconstructBlock(other.Host.ID(), cb); err != nil || rebuilt != nil {
+		t.Errorf("Block made of other transactions should be rejected, got %v, %v", rebuilt, err)
+	}
+}
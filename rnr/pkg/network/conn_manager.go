@@ -0,0 +1,451 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Connection management: inbound and outbound peers take separate slots, so
+// a flood of inbound connections cannot crowd out the peers this node chose
+// to dial, and public addresses are capped per /24, /16 and origin AS, so an
+// attacker needs addresses in many networks to eclipse the node. Some slots
+// of each direction are kept for validators and persistent peers, which are
+// also exempt from the diversity caps. When a direction or network is over
+// its limit, the lowest-scoring unprotected peers are disconnected first.
+// Unconditional peers take no slot and are never disconnected.
+
+const connTrimInterval = 30 * time.Second
+
+// ConnLimits are the peer slots of a node. Zero fields take the defaults.
+type ConnLimits struct {
+	MaxInbound     int
+	MaxOutbound    int
+	ProtectedSlots int // Slots of each direction only validators and persistent peers may take
+	MaxPerSubnet24 int
+	MaxPerSubnet16 int
+	MaxPerASN      int // Counted only when an ASN dataset is loaded
+}
+
+// DefaultConnLimits returns the default peer slots
+func DefaultConnLimits() ConnLimits {
+	return ConnLimits{
+		MaxInbound:     40,
+		MaxOutbound:    16,
+		ProtectedSlots: 8,
+		MaxPerSubnet24: 3,
+		MaxPerSubnet16: 8,
+		MaxPerASN:      10,
+	}
+}
+
+func (l ConnLimits) withDefaults() ConnLimits {
+	d := DefaultConnLimits()
+	if l.MaxInbound <= 0 {
+		l.MaxInbound = d.MaxInbound
+	}
+	if l.MaxOutbound <= 0 {
+		l.MaxOutbound = d.MaxOutbound
+	}
+	if l.ProtectedSlots <= 0 {
+		l.ProtectedSlots = d.ProtectedSlots
+	}
+	if l.MaxPerSubnet24 <= 0 {
+		l.MaxPerSubnet24 = d.MaxPerSubnet24
+	}
+	if l.MaxPerSubnet16 <= 0 {
+		l.MaxPerSubnet16 = d.MaxPerSubnet16
+	}
+	if l.MaxPerASN <= 0 {
+		l.MaxPerASN = d.MaxPerASN
+	}
+	return l
+}
+
+// ConnStats reports the slots in use
+type ConnStats struct {
+	Inbound        int    `json:"inbound"`
+	Outbound       int    `json:"outbound"`
+	Protected      int    `json:"protected"`
+	MaxInbound     int    `json:"max_inbound"`
+	MaxOutbound    int    `json:"max_outbound"`
+	ProtectedSlots int    `json:"protected_slots"`
+	Rejected       uint64 `json:"rejected"`
+	Pruned         uint64 `json:"pruned"`
+}
+
+// connPeer is a connected peer as the manager sees it
+type connPeer struct {
+	id        peer.ID
+	direction network.Direction
+	groups    []string // Networks counted against a cap, empty for local addresses
+	opened    time.Time
+	protected bool
+	score     float64
+}
+
+type connManager struct {
+	limits     ConnLimits
+	peerConfig *peerConfig
+	persistent map[peer.ID]bool
+	scores     *PeerScores
+
+	mu          sync.RWMutex
+	network     network.Network
+	asn         *ASNResolver
+	isValidator func(peer.ID) bool
+	rejected    uint64
+	pruned      uint64
+	trimCh      chan struct{}
+}
+
+func newConnManager(limits ConnLimits, peerConfig *peerConfig, scores *PeerScores) *connManager {
+	cm := &connManager{
+		limits:     limits.withDefaults(),
+		peerConfig: peerConfig,
+		persistent: make(map[peer.ID]bool),
+		scores:     scores,
+		trimCh:     make(chan struct{}, 1),
+	}
+	for _, info := range peerConfig.persistent {
+		cm.persistent[info.ID] = true
+	}
+	return cm
+}
+
+// isProtected reports whether pid may take a protected slot
+func (cm *connManager) isProtected(pid peer.ID) bool {
+	if cm.persistent[pid] {
+		return true
+	}
+	cm.mu.RLock()
+	isValidator := cm.isValidator
+	cm.mu.RUnlock()
+	return isValidator != nil && isValidator(pid)
+}
+
+// directionName returns "inbound" or "outbound"
+func directionName(direction network.Direction) string {
+	return strings.ToLower(direction.String())
+}
+
+func (cm *connManager) limit(direction network.Direction) int {
+	if direction == network.DirInbound {
+		return cm.limits.MaxInbound
+	}
+	return cm.limits.MaxOutbound
+}
+
+// groupCap returns the number of peers allowed in a network group
+func (cm *connManager) groupCap(group string) int {
+	switch group[:3] {
+	case "/24":
+		return cm.limits.MaxPerSubnet24
+	case "/16":
+		return cm.limits.MaxPerSubnet16
+	default:
+		return cm.limits.MaxPerASN
+	}
+}
+
+// networkGroups returns the groups a peer at addr counts against. Loopback,
+// private and link-local addresses are not capped, so local networks and
+// test setups are not limited.
+func (cm *connManager) networkGroups(addr multiaddr.Multiaddr) []string {
+	if addr == nil {
+		return nil
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return nil
+	}
+	var groups []string
+	if ip4 := ip.To4(); ip4 != nil {
+		groups = append(groups,
+			"/24 "+ip4.Mask(net.CIDRMask(24, 32)).String(),
+			"/16 "+ip4.Mask(net.CIDRMask(16, 32)).String())
+	} else {
+		// An IPv6 /48 is a site and a /32 an allocation, as /24 and /16 are for IPv4
+		groups = append(groups,
+			"/24 "+ip.Mask(net.CIDRMask(48, 128)).String(),
+			"/16 "+ip.Mask(net.CIDRMask(32, 128)).String())
+	}
+	cm.mu.RLock()
+	resolver := cm.asn
+	cm.mu.RUnlock()
+	if asn, ok := resolver.Lookup(ip); ok {
+		groups = append(groups, fmt.Sprintf("AS%d", asn))
+	}
+	return groups
+}
+
+// connectedPeers returns the peers holding slots, that is all connected
+// peers but unconditional ones and except
+func (cm *connManager) connectedPeers(except peer.ID) []*connPeer {
+	cm.mu.RLock()
+	n := cm.network
+	cm.mu.RUnlock()
+	if n == nil {
+		return nil
+	}
+	var peers []*connPeer
+	for _, pid := range n.Peers() {
+		if pid == except || cm.peerConfig.unconditional[pid] {
+			continue
+		}
+		conns := n.ConnsToPeer(pid)
+		if len(conns) == 0 {
+			continue
+		}
+		first := conns[0]
+		for _, conn := range conns[1:] {
+			if conn.Stat().Opened.Before(first.Stat().Opened) {
+				first = conn
+			}
+		}
+		peers = append(peers, &connPeer{
+			id:        pid,
+			direction: first.Stat().Direction,
+			groups:    cm.networkGroups(first.RemoteMultiaddr()),
+			opened:    first.Stat().Opened,
+			protected: cm.isProtected(pid),
+			score:     cm.scores.Score(pid),
+		})
+	}
+	return peers
+}
+
+// admit reports whether a new connection to pid in direction, from or to
+// addr, may take a slot. A protected peer finding its direction full is
+// let in if an unprotected peer can be pruned for it.
+func (cm *connManager) admit(direction network.Direction, pid peer.ID, addr multiaddr.Multiaddr) bool {
+	if cm.peerConfig.unconditional[pid] {
+		return true
+	}
+	cm.mu.RLock()
+	n := cm.network
+	cm.mu.RUnlock()
+	if n == nil || n.Connectedness(pid) == network.Connected {
+		return true // Not started yet, or a further connection of a peer holding a slot
+	}
+
+	protected := cm.isProtected(pid)
+	count, prunable := 0, false
+	groupCounts := make(map[string]int)
+	for _, cp := range cm.connectedPeers(pid) {
+		for _, group := range cp.groups {
+			groupCounts[group]++
+		}
+		if cp.direction != direction {
+			continue
+		}
+		count++
+		if !cp.protected {
+			prunable = true
+		}
+	}
+
+	if err := cm.checkSlots(direction, protected, count, prunable); err != nil {
+		return cm.reject(pid, err)
+	}
+	if protected {
+		return true
+	}
+	for _, group := range cm.networkGroups(addr) {
+		if groupCounts[group] >= cm.groupCap(group) {
+			return cm.reject(pid, fmt.Errorf("%s has %d peers already", group, groupCounts[group]))
+		}
+	}
+	return true
+}
+
+// admitDial reports whether dialing pid may take an outbound slot, before
+// its address is known
+func (cm *connManager) admitDial(pid peer.ID) bool {
+	if cm.peerConfig.unconditional[pid] {
+		return true
+	}
+	cm.mu.RLock()
+	n := cm.network
+	cm.mu.RUnlock()
+	if n == nil || n.Connectedness(pid) == network.Connected {
+		return true
+	}
+	count, prunable := 0, false
+	for _, cp := range cm.connectedPeers(pid) {
+		if cp.direction == network.DirOutbound {
+			count++
+			prunable = prunable || !cp.protected
+		}
+	}
+	return cm.checkSlots(network.DirOutbound, cm.isProtected(pid), count, prunable) == nil
+}
+
+// checkSlots checks that a peer finds a free slot among count taken in
+// direction. Unprotected peers may not take the protected slots.
+func (cm *connManager) checkSlots(direction network.Direction, protected bool, count int, prunable bool) error {
+	limit := cm.limit(direction)
+	if protected {
+		if count >= limit && !prunable {
+			return fmt.Errorf("all %d %s slots are held by protected peers", limit, directionName(direction))
+		}
+		return nil
+	}
+	if open := limit - cm.limits.ProtectedSlots; count >= open {
+		return fmt.Errorf("%d of %d unprotected %s slots taken", count, open, directionName(direction))
+	}
+	return nil
+}
+
+func (cm *connManager) reject(pid peer.ID, err error) bool {
+	cm.mu.Lock()
+	cm.rejected++
+	cm.mu.Unlock()
+	log.Printf("🚧 Refused connection of peer %s: %v", shortPeerID(pid), err)
+	return false
+}
+
+// trim returns the peers to disconnect to bring every direction and
+// network group within its limit: unprotected peers, lowest score first
+// and, at equal scores, the most recently connected first
+func (cm *connManager) trim() []*connPeer {
+	peers := cm.connectedPeers("")
+	counts := make(map[network.Direction]int)
+	groupCounts := make(map[string]int)
+	var candidates []*connPeer
+	for _, cp := range peers {
+		counts[cp.direction]++
+		for _, group := range cp.groups {
+			groupCounts[group]++
+		}
+		if !cp.protected {
+			candidates = append(candidates, cp)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].opened.After(candidates[j].opened)
+	})
+
+	var prune []*connPeer
+	for _, cp := range candidates {
+		over := counts[cp.direction] > cm.limit(cp.direction)
+		for _, group := range cp.groups {
+			over = over || groupCounts[group] > cm.groupCap(group)
+		}
+		if !over {
+			continue
+		}
+		prune = append(prune, cp)
+		counts[cp.direction]--
+		for _, group := range cp.groups {
+			groupCounts[group]--
+		}
+	}
+	return prune
+}
+
+// requestTrim schedules a trim
+func (cm *connManager) requestTrim() {
+	select {
+	case cm.trimCh <- struct{}{}:
+	default:
+	}
+}
+
+func (cm *connManager) stats() ConnStats {
+	stats := ConnStats{
+		MaxInbound:     cm.limits.MaxInbound,
+		MaxOutbound:    cm.limits.MaxOutbound,
+		ProtectedSlots: cm.limits.ProtectedSlots,
+	}
+	for _, cp := range cm.connectedPeers("") {
+		if cp.direction == network.DirInbound {
+			stats.Inbound++
+		} else {
+			stats.Outbound++
+		}
+		if cp.protected {
+			stats.Protected++
+		}
+	}
+	cm.mu.RLock()
+	stats.Rejected, stats.Pruned = cm.rejected, cm.pruned
+	cm.mu.RUnlock()
+	return stats
+}
+
+// startConnManager attaches the connection manager to the host and prunes
+// peers over the limits as they connect and periodically
+func (p *P2PNetwork) startConnManager() {
+	cm := p.connManager
+	cm.mu.Lock()
+	cm.network = p.Host.Network()
+	cm.isValidator = func(pid peer.ID) bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.peerValidators[pid] != ""
+	}
+	cm.mu.Unlock()
+
+	p.Host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(network.Network, network.Conn) {
+			cm.requestTrim()
+		},
+	})
+
+	go func() {
+		ticker := time.NewTicker(connTrimInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cm.trimCh:
+			case <-ticker.C:
+			case <-p.ctx.Done():
+				return
+			}
+			for _, cp := range cm.trim() {
+				log.Printf("✂️  Pruned %s peer %s (score %.1f) to stay within connection limits", directionName(cp.direction), shortPeerID(cp.id), cp.score)
+				p.Host.Network().ClosePeer(cp.id)
+				cm.mu.Lock()
+				cm.pruned++
+				cm.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// GetConnStats returns the peer slots in use and the limits
+func (p *P2PNetwork) GetConnStats() ConnStats {
+	return p.connManager.stats()
+}
+
+// PeerDirection returns "inbound" or "outbound" for a connected peer
+func (p *P2PNetwork) PeerDirection(peerID string) string {
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return ""
+	}
+	conns := p.Host.Network().ConnsToPeer(pid)
+	if len(conns) == 0 {
+		return ""
+	}
+	return directionName(conns[0].Stat().Direction)
+}
+
+// IsProtectedPeer reports whether pid is a validator or persistent peer,
+// which the connection manager keeps slots for and never prunes
+func (p *P2PNetwork) IsProtectedPeer(pid peer.ID) bool {
+	return p.connManager.isProtected(pid)
+}
@@ -0,0 +1,283 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// fakeConnNetwork is the part of a libp2p network the connection manager
+// reads: who is connected, in which direction and from where
+type fakeConnNetwork struct {
+	network.Network
+	conns map[peer.ID]network.Conn
+	order []peer.ID
+}
+
+type fakeConn struct {
+	network.Conn
+	stat network.ConnStats
+	addr multiaddr.Multiaddr
+}
+
+func (c *fakeConn) Stat() network.ConnStats              { return c.stat }
+func (c *fakeConn) RemoteMultiaddr() multiaddr.Multiaddr { return c.addr }
+func (n *fakeConnNetwork) Peers() []peer.ID              { return n.order }
+func (n *fakeConnNetwork) ConnsToPeer(pid peer.ID) []network.Conn {
+	if conn, ok := n.conns[pid]; ok {
+		return []network.Conn{conn}
+	}
+	return nil
+}
+func (n *fakeConnNetwork) Connectedness(pid peer.ID) network.Connectedness {
+	if _, ok := n.conns[pid]; ok {
+		return network.Connected
+	}
+	return network.NotConnected
+}
+
+func (n *fakeConnNetwork) disconnect(pid peer.ID) {
+	delete(n.conns, pid)
+	for i, connected := range n.order {
+		if connected == pid {
+			n.order = append(n.order[:i], n.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (n *fakeConnNetwork) connect(pid peer.ID, direction network.Direction, addr multiaddr.Multiaddr, opened time.Time) {
+	n.conns[pid] = &fakeConn{stat: network.ConnStats{Stats: network.Stats{Direction: direction, Opened: opened}}, addr: addr}
+	n.order = append(n.order, pid)
+}
+
+func tcpAddr(ip string) multiaddr.Multiaddr {
+	family := "ip4"
+	if strings.Contains(ip, ":") {
+		family = "ip6"
+	}
+	return multiaddr.StringCast(fmt.Sprintf("/%s/%s/tcp/4001", family, ip))
+}
+
+// newTestConnManager returns a manager over a fake network, with validators
+// as its protected peers
+func newTestConnManager(limits ConnLimits, validators map[peer.ID]bool, unconditional ...peer.ID) (*connManager, *fakeConnNetwork) {
+	config := &peerConfig{unconditional: make(map[peer.ID]bool)}
+	for _, pid := range unconditional {
+		config.unconditional[pid] = true
+	}
+	scores, _ := NewPeerScores("")
+	cm := newConnManager(limits, config, scores)
+	n := &fakeConnNetwork{conns: make(map[peer.ID]network.Conn)}
+	cm.network = n
+	cm.isValidator = func(pid peer.ID) bool { return validators[pid] }
+	return cm, n
+}
+
+// TestConnManagerSlots tests that unprotected peers are kept out of the
+// protected slots, and that protected peers are let in while an unprotected
+// one can make room
+func TestConnManagerSlots(t *testing.T) {
+	validators := make(map[peer.ID]bool)
+	unconditional := testPeerID(t)
+	limits := ConnLimits{MaxInbound: 4, MaxOutbound: 2, ProtectedSlots: 2, MaxPerSubnet24: 100, MaxPerSubnet16: 100}
+	cm, n := newTestConnManager(limits, validators, unconditional)
+
+	admit := func(protected bool, host int) (peer.ID, bool) {
+		pid := testPeerID(t)
+		validators[pid] = protected
+		addr := tcpAddr(fmt.Sprintf("203.0.%d.1", host))
+		ok := cm.admit(network.DirInbound, pid, addr)
+		if ok {
+			n.connect(pid, network.DirInbound, addr, time.Now())
+		}
+		return pid, ok
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := admit(false, i); !ok {
+			t.Fatalf("Unprotected peer %d should take an open slot", i)
+		}
+	}
+	if _, ok := admit(false, 2); ok {
+		t.Errorf("Unprotected peer should not take a protected slot")
+	}
+	for i := 3; i < 5; i++ {
+		if _, ok := admit(true, i); !ok {
+			t.Fatalf("Validator should take a protected slot")
+		}
+	}
+	// Every inbound slot is taken, but an unprotected peer can be pruned
+	if _, ok := admit(true, 5); !ok {
+		t.Errorf("Validator should be let in while an unprotected peer can make room")
+	}
+	pruned := cm.trim()
+	if len(pruned) != 1 || pruned[0].protected || pruned[0].direction != network.DirInbound {
+		t.Fatalf("Expected one unprotected inbound peer pruned, got %d", len(pruned))
+	}
+	n.disconnect(pruned[0].id)
+	if _, ok := admit(true, 6); !ok {
+		t.Errorf("Validator should be let in while the last unprotected peer can make room")
+	}
+	for _, cp := range cm.trim() {
+		n.disconnect(cp.id)
+	}
+	if _, ok := admit(true, 7); ok {
+		t.Errorf("Validator should be refused once protected peers hold every slot")
+	}
+
+	// Unconditional peers take no slot, and a connected peer's further
+	// connections are not counted again
+	if !cm.admit(network.DirInbound, unconditional, tcpAddr("203.0.8.1")) {
+		t.Errorf("Unconditional peer should always be let in")
+	}
+	if !cm.admit(network.DirInbound, n.order[0], tcpAddr("203.0.9.1")) {
+		t.Errorf("Further connection of a connected peer should be let in")
+	}
+
+	// Outbound slots are separate; with every one protected, unprotected
+	// peers are not dialed at all
+	outbound := ConnLimits{MaxInbound: 4, MaxOutbound: 2, ProtectedSlots: 2}
+	cm, n = newTestConnManager(outbound, validators)
+	if cm.admitDial(testPeerID(t)) {
+		t.Errorf("Unprotected peer should not be dialed into a protected slot")
+	}
+	for i := 0; i < 2; i++ {
+		pid := testPeerID(t)
+		validators[pid] = true
+		if !cm.admitDial(pid) {
+			t.Fatalf("Validator should be dialed")
+		}
+		n.connect(pid, network.DirOutbound, tcpAddr(fmt.Sprintf("198.51.%d.1", i)), time.Now())
+	}
+	pid := testPeerID(t)
+	validators[pid] = true
+	if cm.admitDial(pid) {
+		t.Errorf("Validator should not be dialed once protected peers hold every outbound slot")
+	}
+	if stats := cm.stats(); stats.Outbound != 2 || stats.Protected != 2 || stats.Rejected != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+// TestConnManagerNetworkCaps tests the per-/24, per-/16 and per-AS caps,
+// which protected peers and local addresses are exempt from
+func TestConnManagerNetworkCaps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.csv")
+	os.WriteFile(path, []byte("198.51.0.0/16,64500\n198.52.0.0/16,64500\n"), 0o600)
+	resolver, err := NewASNResolver(path)
+	if err != nil {
+		t.Fatalf("NewASNResolver failed: %v", err)
+	}
+
+	validators := make(map[peer.ID]bool)
+	limits := ConnLimits{MaxInbound: 100, ProtectedSlots: 1, MaxPerSubnet24: 2, MaxPerSubnet16: 3, MaxPerASN: 2}
+	cm, n := newTestConnManager(limits, validators)
+	cm.asn = resolver
+
+	cases := []struct {
+		ip        string
+		protected bool
+		admitted  bool
+	}{
+		{"203.0.113.1", false, true},
+		{"203.0.113.2", false, true},
+		{"203.0.113.3", false, false}, // /24 full
+		{"203.0.113.4", true, true},   // Validators are exempt
+		{"203.0.114.1", false, false}, // /16 full, with the validator
+		{"198.51.100.1", false, true},
+		{"198.52.100.1", false, true},
+		{"198.51.200.1", false, false}, // AS64500 full across its /16s
+		{"10.0.0.1", false, true},      // Private addresses are not capped
+		{"10.0.0.2", false, true},
+		{"10.0.0.3", false, true},
+		{"2001:db8:1::1", false, true},
+		{"2001:db8:1::2", false, true},
+		{"2001:db8:1::3", false, false}, // An IPv6 /48 counts as a /24
+		{"2001:db8:2::1", false, true},
+	}
+	for _, tc := range cases {
+		pid := testPeerID(t)
+		validators[pid] = tc.protected
+		addr := tcpAddr(tc.ip)
+		if admitted := cm.admit(network.DirInbound, pid, addr); admitted != tc.admitted {
+			t.Errorf("%s: expected admitted=%v, got %v", tc.ip, tc.admitted, admitted)
+		}
+		if tc.admitted {
+			n.connect(pid, network.DirInbound, addr, time.Now())
+		}
+	}
+	// The validator counts towards the caps it is exempt from: its /24 is
+	// brought back within the cap at the expense of an unprotected peer
+	pruned := cm.trim()
+	if len(pruned) != 1 || pruned[0].protected || pruned[0].groups[0] != "/24 203.0.113.0" {
+		t.Errorf("Expected one unprotected peer of the validator's /24 pruned, got %d peers", len(pruned))
+	}
+}
+
+// TestConnManagerTrim tests that peers over a limit are pruned lowest score
+// first, the most recent first at equal scores, and protected peers never
+func TestConnManagerTrim(t *testing.T) {
+	validators := make(map[peer.ID]bool)
+	limits := ConnLimits{MaxInbound: 3, MaxOutbound: 10, ProtectedSlots: 1, MaxPerSubnet24: 2, MaxPerSubnet16: 10}
+	cm, n := newTestConnManager(limits, validators)
+
+	start := time.Now().Add(-time.Hour)
+	peers := make(map[string]peer.ID)
+	for i, tc := range []struct {
+		name      string
+		score     PeerEvent
+		protected bool
+		opened    time.Duration
+	}{
+		{"bad", PeerEventInvalidMessage, false, 0},
+		{"old", "", false, 0},
+		{"new", "", false, time.Minute},
+		{"validator", PeerEventProtocolViolation, true, 2 * time.Minute},
+		{"good", PeerEventBlocksServed, false, 3 * time.Minute},
+	} {
+		pid := testPeerID(t)
+		peers[tc.name] = pid
+		validators[pid] = tc.protected
+		if tc.score != "" {
+			cm.scores.Record(pid, tc.score, 1, "")
+		}
+		n.connect(pid, network.DirInbound, tcpAddr(fmt.Sprintf("203.0.%d.1", i)), start.Add(tc.opened))
+	}
+
+	pruned := cm.trim()
+	if len(pruned) != 2 || pruned[0].id != peers["bad"] || pruned[1].id != peers["new"] {
+		names := make([]string, len(pruned))
+		for i, cp := range pruned {
+			for name, pid := range peers {
+				if pid == cp.id {
+					names[i] = name
+				}
+			}
+		}
+		t.Fatalf("Expected bad then new pruned, got %v", names)
+	}
+
+	// A /24 over its cap is trimmed even with the direction within its limit
+	cm, n = newTestConnManager(limits, validators)
+	var lowest peer.ID
+	for i := 0; i < 3; i++ {
+		pid := testPeerID(t)
+		if i == 1 {
+			cm.scores.Record(pid, PeerEventRateLimit, 1, "")
+			lowest = pid
+		}
+		n.connect(pid, network.DirOutbound, tcpAddr(fmt.Sprintf("198.51.100.%d", i+1)), start)
+	}
+	pruned = cm.trim()
+	if len(pruned) != 1 || pruned[0].id != lowest {
+		t.Errorf("Expected the lowest scoring peer of the /24 pruned, got %d peers", len(pruned))
+	}
+}
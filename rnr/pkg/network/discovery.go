@@ -27,11 +27,15 @@ type PeerDiscovery struct {
 	dht        *dht.IpfsDHT
 	mdns       mdns.Service
 	routingDis *drouting.RoutingDiscovery
+	bootstrap  []string
 	peers      map[peer.ID]bool
 	mu         sync.RWMutex
 }
 
-func NewPeerDiscovery(ctx context.Context, h host.Host) (*PeerDiscovery, error) {
+// NewPeerDiscovery finds peers over mDNS and the DHT, which it joins through
+// bootstrapPeers. Private networks pass their own bootstrap peers, as the
+// public BootstrapPeers cannot pass their handshake.
+func NewPeerDiscovery(ctx context.Context, h host.Host, bootstrapPeers []string) (*PeerDiscovery, error) {
 	kdht, err := dht.New(ctx, h, dht.Mode(dht.ModeAutoServer))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create DHT: %w", err)
@@ -48,6 +52,7 @@ func NewPeerDiscovery(ctx context.Context, h host.Host) (*PeerDiscovery, error)
 		ctx:        ctx,
 		dht:        kdht,
 		routingDis: routingDiscovery,
+		bootstrap:  bootstrapPeers,
 		peers:      make(map[peer.ID]bool),
 	}
 
@@ -85,7 +90,7 @@ func (pd *PeerDiscovery) HandlePeerFound(pi peer.AddrInfo) {
 }
 
 func (pd *PeerDiscovery) connectToBootstrap() {
-	for _, peerAddr := range BootstrapPeers {
+	for _, peerAddr := range pd.bootstrap {
 		maddr, err := multiaddr.NewMultiaddr(peerAddr)
 		if err != nil {
 			continue
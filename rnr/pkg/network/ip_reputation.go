@@ -1,6 +1,7 @@
 package network
 
 import (
+        "fmt"
         "net"
         "sync"
         "time"
@@ -36,6 +37,7 @@ type IPReputation struct {
         IsBlacklisted     bool
         BlacklistReason   string
         BlacklistUntil    time.Time
+        NetworkGroup      string // Sybil group counted for this IP: origin AS or subnet
 }
 
 type IPReputationSystem struct {
@@ -50,8 +52,10 @@ type IPReputationSystem struct {
         
         // Sybil detection
         maxNodesPerSubnet     int
+        maxNodesPerASN        int
         subnetMask            int // /24 by default
-        subnetNodeCount       map[string]int // subnet -> count
+        subnetNodeCount       map[string]int // network group (subnet or "AS<n>") -> count
+        asnResolver           *ASNResolver   // Groups IPs by origin AS when a dataset is loaded
 }
 
 func NewIPReputationSystem() *IPReputationSystem {
@@ -62,6 +66,7 @@ func NewIPReputationSystem() *IPReputationSystem {
                 blacklistThreshold: 20,   // Below 20/100 = blacklist
                 blacklistDuration:  24 * time.Hour,
                 maxNodesPerSubnet:  3,    // Max 3 nodes per /24 subnet (Sybil resistance)
+                maxNodesPerASN:     8,    // Max 8 nodes per origin AS when an ASN dataset is loaded
                 subnetMask:        24,
                 subnetNodeCount:   make(map[string]int),
         }
@@ -78,9 +83,9 @@ func (irs *IPReputationSystem) CheckIPAllowed(ip net.IP) (bool, string) {
         
         if !exists {
                 // New IP - check Sybil resistance
-                subnet := irs.getSubnet(ip)
-                if irs.subnetNodeCount[subnet] >= irs.maxNodesPerSubnet {
-                        return false, "Sybil attack detected: too many nodes from same subnet"
+                group, limit := irs.getNetworkGroup(ip)
+                if irs.subnetNodeCount[group] >= limit {
+                        return false, "Sybil attack detected: too many nodes from same network (" + group + ")"
                 }
                 return true, ""
         }
@@ -187,14 +192,31 @@ func (irs *IPReputationSystem) getOrCreateReputation(ip net.IP) *IPReputation {
                 }
                 irs.reputations[ipStr] = rep
                 
-                // Track subnet count (Sybil resistance)
-                subnet := irs.getSubnet(ip)
-                irs.subnetNodeCount[subnet]++
+                // Track network group count (Sybil resistance)
+                rep.NetworkGroup, _ = irs.getNetworkGroup(ip)
+                irs.subnetNodeCount[rep.NetworkGroup]++
         }
         
         return rep
 }
 
+// SetASNResolver groups IPs by origin AS instead of subnet where resolver
+// knows the AS. IPs already tracked keep the group they were counted in.
+func (irs *IPReputationSystem) SetASNResolver(resolver *ASNResolver) {
+        irs.mu.Lock()
+        defer irs.mu.Unlock()
+        irs.asnResolver = resolver
+}
+
+// getNetworkGroup returns the Sybil group of ip and the nodes allowed in it:
+// its origin AS if known, otherwise its subnet
+func (irs *IPReputationSystem) getNetworkGroup(ip net.IP) (string, int) {
+        if asn, ok := irs.asnResolver.Lookup(ip); ok {
+                return fmt.Sprintf("AS%d", asn), irs.maxNodesPerASN
+        }
+        return irs.getSubnet(ip), irs.maxNodesPerSubnet
+}
+
 // getSubnet extracts subnet for Sybil detection
 func (irs *IPReputationSystem) getSubnet(ip net.IP) string {
         // For IPv4, use /24 by default
@@ -269,10 +291,10 @@ func (irs *IPReputationSystem) CleanupOldReputations() {
                 
                 // Remove old clean IPs
                 if rep.Score >= 90 && rep.LastSeen.Before(cutoff) {
-                        subnet := irs.getSubnet(rep.IP)
-                        irs.subnetNodeCount[subnet]--
-                        if irs.subnetNodeCount[subnet] <= 0 {
-                                delete(irs.subnetNodeCount, subnet)
+                        group := rep.NetworkGroup
+                        irs.subnetNodeCount[group]--
+                        if irs.subnetNodeCount[group] <= 0 {
+                                delete(irs.subnetNodeCount, group)
                         }
                         delete(irs.reputations, ipStr)
                 }
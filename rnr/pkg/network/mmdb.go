@@ -0,0 +1,319 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// Minimal MaxMind DB reader, enough to resolve ASNs from datasets such as
+// GeoLite2-ASN. The format is a binary search tree over address bits whose
+// leaves point into a data section of typed values. See
+// https://maxmind.github.io/MaxMind-DB/ for the specification.
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	mmdbDataSeparator = 16
+	mmdbMaxDepth      = 64 // Nested maps, arrays and pointers; datasets use a handful
+)
+
+// mmdbReader resolves addresses in a MaxMind DB held in memory
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint32
+	recordSize uint32
+	ipVersion  uint32
+	data       []byte // Data section
+	ipv4Start  uint32 // Node of ::/96 in an IPv6 tree
+}
+
+// openMMDB parses the metadata of the database in buf
+func openMMDB(buf []byte) (*mmdbReader, error) {
+	at := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if at < 0 {
+		return nil, fmt.Errorf("not a MaxMind DB: metadata marker missing")
+	}
+	meta, _, err := mmdbDecode(buf[at+len(mmdbMetadataMarker):], 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %w", err)
+	}
+	fields, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata")
+	}
+
+	r := &mmdbReader{buf: buf}
+	for key, dst := range map[string]*uint32{
+		"node_count":  &r.nodeCount,
+		"record_size": &r.recordSize,
+		"ip_version":  &r.ipVersion,
+	} {
+		v, ok := fields[key].(uint64)
+		if !ok || v > math.MaxUint32 {
+			return nil, fmt.Errorf("MaxMind DB metadata lacks %s", key)
+		}
+		*dst = uint32(v)
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported MaxMind DB record size %d", r.recordSize)
+	}
+
+	treeSize := int(r.recordSize) * 2 / 8 * int(r.nodeCount)
+	if treeSize+mmdbDataSeparator > at {
+		return nil, fmt.Errorf("MaxMind DB search tree exceeds the file")
+	}
+	r.data = buf[treeSize+mmdbDataSeparator : at]
+
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (r *mmdbReader) record(node uint32, bit byte) uint32 {
+	switch r.recordSize {
+	case 24:
+		off := int(node)*6 + int(bit)*3
+		b := r.buf[off : off+3]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		off := int(node) * 7
+		b := r.buf[off : off+7]
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		off := int(node)*8 + int(bit)*4
+		return binary.BigEndian.Uint32(r.buf[off : off+4])
+	}
+}
+
+// find returns the data record of ip, or nil if the tree has none
+func (r *mmdbReader) find(ip net.IP) (interface{}, error) {
+	node := uint32(0)
+	addr := ip.To4()
+	if addr != nil {
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		addr = ip.To16()
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := (addr[i/8] >> (7 - uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+
+	offset := int(node-r.nodeCount) - mmdbDataSeparator
+	if offset < 0 || offset >= len(r.data) {
+		return nil, fmt.Errorf("MaxMind DB record points outside the data section")
+	}
+	value, _, err := mmdbDecode(r.data, offset, 0)
+	return value, err
+}
+
+func (r *mmdbReader) lookup(ip net.IP) (uint32, bool) {
+	value, err := r.find(ip)
+	if err != nil || value == nil {
+		return 0, false
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	switch asn := fields["autonomous_system_number"].(type) {
+	case uint64:
+		return uint32(asn), asn > 0 && asn <= math.MaxUint32
+	}
+	// Datasets such as ipinfo's store "asn": "AS13335"
+	if asn, ok := fields["asn"].(string); ok {
+		if n, err := parseASN(strings.TrimSpace(asn)); err == nil && n > 0 {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func (r *mmdbReader) size() int {
+	return int(r.nodeCount)
+}
+
+// MaxMind DB data types
+const (
+	mmdbExtended = 0
+	mmdbPointer  = 1
+	mmdbString   = 2
+	mmdbDouble   = 3
+	mmdbBytes    = 4
+	mmdbUint16   = 5
+	mmdbUint32   = 6
+	mmdbMap      = 7
+	mmdbInt32    = 8
+	mmdbUint64   = 9
+	mmdbUint128  = 10
+	mmdbArray    = 11
+	mmdbBool     = 14
+	mmdbFloat    = 15
+)
+
+// mmdbDecode decodes the value at offset in data, in which pointers are
+// offsets too. Returns the value and the offset after it. Unsigned integers
+// decode as uint64, except uint128 as *big.Int. depth is how deeply the
+// value is nested, so a pointer cycle ends in an error.
+func mmdbDecode(data []byte, offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("data nested deeper than %d", mmdbMaxDepth)
+	}
+	if offset >= len(data) {
+		return nil, 0, fmt.Errorf("offset %d past end of data", offset)
+	}
+	ctrl := data[offset]
+	offset++
+	typ := int(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		ss := int(ctrl>>3) & 0x3
+		if offset+ss+1 > len(data) {
+			return nil, 0, fmt.Errorf("truncated pointer")
+		}
+		var target int
+		switch ss {
+		case 0:
+			target = int(ctrl&0x7)<<8 | int(data[offset])
+		case 1:
+			target = (int(ctrl&0x7)<<16 | int(data[offset])<<8 | int(data[offset+1])) + 2048
+		case 2:
+			target = (int(ctrl&0x7)<<24 | int(data[offset])<<16 | int(data[offset+1])<<8 | int(data[offset+2])) + 526336
+		default:
+			target = int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		}
+		if target < len(data) && int(data[target]>>5) == mmdbPointer {
+			return nil, 0, fmt.Errorf("pointer to a pointer")
+		}
+		value, _, err := mmdbDecode(data, target, depth+1)
+		return value, offset + ss + 1, err
+	}
+
+	if typ == mmdbExtended {
+		if offset >= len(data) {
+			return nil, 0, fmt.Errorf("truncated extended type")
+		}
+		typ = 7 + int(data[offset])
+		offset++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(data) {
+			return nil, 0, fmt.Errorf("truncated size")
+		}
+		extra := 0
+		for _, b := range data[offset : offset+n] {
+			extra = extra<<8 | int(b)
+		}
+		size = []int{29, 285, 65821}[n-1] + extra
+		offset += n
+	}
+	if (typ == mmdbMap || typ == mmdbArray) && size > len(data)-offset {
+		return nil, 0, fmt.Errorf("%d entries overrun data", size)
+	}
+
+	switch typ {
+	case mmdbMap:
+		fields := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, next, err := mmdbDecode(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			value, next, err := mmdbDecode(data, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			fields[name] = value
+			offset = next
+		}
+		return fields, offset, nil
+	case mmdbArray:
+		items := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, next, err := mmdbDecode(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, value)
+			offset = next
+		}
+		return items, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(data) {
+		return nil, 0, fmt.Errorf("value of type %d overruns data", typ)
+	}
+	raw := data[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case mmdbString:
+		return string(raw), offset, nil
+	case mmdbBytes:
+		return append([]byte{}, raw...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(raw)), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid integer size %d", size)
+		}
+		var v uint64
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, offset, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid int32 size %d", size)
+		}
+		var v uint32
+		for _, b := range raw {
+			v = v<<8 | uint32(b)
+		}
+		if size == 4 {
+			return int64(int32(v)), offset, nil
+		}
+		return int64(v), offset, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(raw), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported MaxMind DB data type %d", typ)
+}
@@ -0,0 +1,252 @@
+package network
+
+import (
+	"compress/gzip"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// MaxMind DB fixtures are built here rather than checked in, so each test
+// shows the records it resolves
+
+func mmdbStr(s string) []byte {
+	return append([]byte{mmdbString<<5 | byte(len(s))}, s...)
+}
+
+func mmdbUint(typ byte, size int, v uint32) []byte {
+	out := []byte{typ<<5 | byte(size)}
+	for i := size - 1; i >= 0; i-- {
+		out = append(out, byte(v>>(8*uint(i))))
+	}
+	return out
+}
+
+func mmdbPtr(offset int) []byte {
+	return []byte{mmdbPointer<<5 | byte(offset>>8&0x7), byte(offset)}
+}
+
+// mmdbMapOf encodes a map of the key and value pairs in kv
+func mmdbMapOf(kv ...[]byte) []byte {
+	out := []byte{mmdbMap<<5 | byte(len(kv)/2)}
+	for _, b := range kv {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func mmdbASN(asn uint32) []byte {
+	return mmdbMapOf(mmdbStr("autonomous_system_number"), mmdbUint(mmdbUint32, 4, asn))
+}
+
+type mmdbEntry struct {
+	cidr string
+	data []byte // Data record, appended to the data section in order
+}
+
+// mmdbMetadata encodes the metadata map of a database
+func mmdbMetadata(nodeCount, recordSize, ipVersion uint32) []byte {
+	return mmdbMapOf(
+		mmdbStr("node_count"), mmdbUint(mmdbUint32, 4, nodeCount),
+		mmdbStr("record_size"), mmdbUint(mmdbUint16, 2, recordSize),
+		mmdbStr("ip_version"), mmdbUint(mmdbUint16, 2, ipVersion),
+	)
+}
+
+// buildMMDB writes a database resolving the entries' prefixes, which must
+// not overlap. In an IPv6 tree IPv4 prefixes go under ::/96.
+func buildMMDB(t *testing.T, ipVersion, recordSize int, entries []mmdbEntry) []byte {
+	const empty, dataRef = -1, 1 << 30
+	nodes := [][2]int{{empty, empty}}
+	var data []byte
+	for _, e := range entries {
+		ip, network, err := net.ParseCIDR(e.cidr)
+		if err != nil {
+			t.Fatalf("Bad fixture prefix %s: %v", e.cidr, err)
+		}
+		bits, _ := network.Mask.Size()
+		addr := ip.To16()
+		if ip4 := ip.To4(); ip4 != nil {
+			if ipVersion == 4 {
+				addr = ip4
+			} else {
+				addr = append(make(net.IP, 12), ip4...)
+				bits += 96
+			}
+		}
+		bit := func(i int) byte { return addr[i/8] >> (7 - uint(i%8)) & 1 }
+
+		node := 0
+		for i := 0; i < bits-1; i++ {
+			if nodes[node][bit(i)] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit(i)] = len(nodes) - 1
+			}
+			node = nodes[node][bit(i)]
+		}
+		nodes[node][bit(bits-1)] = dataRef + len(data)
+		data = append(data, e.data...)
+	}
+
+	count := len(nodes)
+	resolve := func(record int) uint32 {
+		switch {
+		case record == empty:
+			return uint32(count)
+		case record >= dataRef:
+			return uint32(count + mmdbDataSeparator + record - dataRef)
+		}
+		return uint32(record)
+	}
+	var buf []byte
+	for _, n := range nodes {
+		left, right := resolve(n[0]), resolve(n[1])
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left), byte(left>>24&0xf)<<4|byte(right>>24&0xf),
+				byte(right>>16), byte(right>>8), byte(right))
+		default:
+			buf = append(buf, mmdbUint(0, 4, left)[1:]...)
+			buf = append(buf, mmdbUint(0, 4, right)[1:]...)
+		}
+	}
+	buf = append(buf, make([]byte, mmdbDataSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, mmdbMetadataMarker...)
+	return append(buf, mmdbMetadata(uint32(count), uint32(recordSize), uint32(ipVersion))...)
+}
+
+// TestMMDBLookup tests that IPv4 and IPv6 databases of every record size
+// resolve the ASNs of the prefixes they hold, and nothing else
+func TestMMDBLookup(t *testing.T) {
+	v4 := []mmdbEntry{
+		{"1.0.0.0/24", mmdbASN(13335)},
+		{"8.8.8.0/24", mmdbMapOf(mmdbStr("asn"), mmdbStr("AS15169"))}, // ipinfo style
+		{"100.64.0.0/10", mmdbASN(0)},                                 // Unrouted
+	}
+	v6 := append(append([]mmdbEntry{}, v4...),
+		mmdbEntry{"2001:db8::/32", mmdbASN(64500)},
+		// The key is a pointer to the first record's, at offset 1
+		mmdbEntry{"2001:db9::/48", mmdbMapOf(mmdbPtr(1), mmdbUint(mmdbUint32, 4, 64501))},
+	)
+	lookups := map[string]uint32{
+		"1.0.0.1":       13335,
+		"1.0.0.255":     13335,
+		"1.0.1.1":       0,
+		"8.8.8.8":       15169,
+		"100.64.1.1":    0,
+		"9.9.9.9":       0,
+		"2001:db8::1":   64500,
+		"2001:db9::1":   64501,
+		"2001:db9:1::1": 0,
+		"2001:dba::1":   0,
+	}
+
+	cases := []struct {
+		ipVersion, recordSize int
+		entries               []mmdbEntry
+	}{
+		{4, 24, v4}, {4, 32, v4}, {6, 24, v6}, {6, 28, v6}, {6, 32, v6},
+	}
+	for _, tc := range cases {
+		db, err := openMMDB(buildMMDB(t, tc.ipVersion, tc.recordSize, tc.entries))
+		if err != nil {
+			t.Fatalf("IPv%d/%d: openMMDB failed: %v", tc.ipVersion, tc.recordSize, err)
+		}
+		for addr, expected := range lookups {
+			if tc.ipVersion == 4 && strings.Contains(addr, ":") {
+				expected = 0
+			}
+			asn, ok := db.lookup(net.ParseIP(addr))
+			if ok != (expected != 0) || asn != expected {
+				t.Errorf("IPv%d/%d: %s resolved to %d (%v), expected %d", tc.ipVersion, tc.recordSize, addr, asn, ok, expected)
+			}
+		}
+	}
+
+	// Through the resolver, as a file and compressed
+	dir := t.TempDir()
+	path := filepath.Join(dir, "asn.mmdb.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write(buildMMDB(t, 6, 28, v6))
+	gz.Close()
+	f.Close()
+
+	resolver, err := NewASNResolver(path)
+	if err != nil {
+		t.Fatalf("NewASNResolver failed: %v", err)
+	}
+	for addr, group := range map[string]string{
+		"1.0.0.1":     "AS13335",
+		"2001:db8::1": "AS64500",
+		"9.9.9.9":     "9.9.9.0/24",
+	} {
+		if got := resolver.NetworkGroup(addr); got != group {
+			t.Errorf("%s grouped as %s, expected %s", addr, got, group)
+		}
+	}
+}
+
+// TestMMDBMalformed tests that malformed databases are refused, and that
+// cyclic data fails its lookups instead of recursing without end
+func TestMMDBMalformed(t *testing.T) {
+	withMetadata := func(metadata []byte) []byte {
+		return append(append(make([]byte, 32), mmdbMetadataMarker...), metadata...)
+	}
+	cases := map[string][]byte{
+		"no metadata marker":  []byte("not a database"),
+		"metadata not a map":  withMetadata(mmdbStr("metadata")),
+		"metadata incomplete": withMetadata(mmdbMapOf(mmdbStr("node_count"), mmdbUint(mmdbUint32, 4, 1))),
+		"record size":         withMetadata(mmdbMetadata(1, 20, 4)),
+		"tree past the file":  withMetadata(mmdbMetadata(1000, 24, 4)),
+		"truncated":           withMetadata(mmdbMetadata(1, 24, 4)[:10]),
+		// A map whose value points back at the map
+		"metadata cycle": withMetadata(mmdbMapOf(mmdbStr("node_count"), mmdbPtr(0))),
+		// A pointer at offset 0 to one at offset 2, which points back
+		"pointer to pointer": withMetadata(append(mmdbPtr(2), mmdbPtr(0)...)),
+		// A map of 65821+ entries in a few bytes
+		"oversized map": withMetadata([]byte{mmdbMap<<5 | 31, 0, 0, 0}),
+	}
+	for name, buf := range cases {
+		if _, err := openMMDB(buf); err == nil {
+			t.Errorf("%s: openMMDB should fail", name)
+		}
+	}
+
+	// Deep nesting within the limit decodes
+	deep := mmdbASN(13335)
+	for i := 0; i < mmdbMaxDepth/2-1; i++ {
+		deep = mmdbMapOf(mmdbStr("n"), deep)
+	}
+	if _, _, err := mmdbDecode(deep, 0, 0); err != nil {
+		t.Errorf("Nesting within the limit should decode: %v", err)
+	}
+
+	cyclic := buildMMDB(t, 4, 24, []mmdbEntry{
+		{"1.0.0.0/24", mmdbMapOf(mmdbStr("autonomous_system_number"), mmdbPtr(0))},
+	})
+	db, err := openMMDB(cyclic)
+	if err != nil {
+		t.Fatalf("openMMDB failed: %v", err)
+	}
+	if _, err := db.find(net.ParseIP("1.0.0.1")); err == nil || !strings.Contains(err.Error(), "nested deeper") {
+		t.Errorf("Expected a cyclic record to fail, got %v", err)
+	}
+	if _, ok := db.lookup(net.ParseIP("1.0.0.1")); ok {
+		t.Errorf("Cyclic record should not resolve")
+	}
+
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	os.WriteFile(path, cases["metadata cycle"], 0o600)
+	if _, err := NewASNResolver(path); err == nil {
+		t.Errorf("Resolver should refuse a malformed database")
+	}
+}
@@ -0,0 +1,474 @@
+package network
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// Network emulation: a NetemLink shapes the traffic of the connections it
+// wraps like a real validator uplink would, so PoB tests, speed tests and
+// consensus tests measure slow or lossy validators instead of loopback.
+// Wrapping happens at one endpoint: its writes leave at the upload rate and
+// arrive after the sampled one-way latency, its reads are paced at the
+// download rate. Every connection wrapped by the same link shares its
+// bandwidth and its seeded random source, so a run is reproducible.
+//
+// Datagrams (UDP) that are lost are dropped and may be reordered by jitter.
+// Streams (TCP, libp2p) never lose or reorder data; a lost write arrives
+// RetransmitDelay late instead, as it would after a retransmission.
+
+// LatencyDistribution is how one-way delays spread around the base latency
+type LatencyDistribution int
+
+const (
+	LatencyConstant LatencyDistribution = iota // Always Latency
+	LatencyUniform                             // Uniform in Latency ± Jitter
+	LatencyNormal                              // Normal with mean Latency, deviation Jitter
+	LatencyPareto                              // Latency plus a heavy tail with mean Jitter
+)
+
+// defaultRetransmitDelay is Linux's minimum TCP retransmission timeout
+const defaultRetransmitDelay = 200 * time.Millisecond
+
+// NetemProfile describes an emulated link. Bandwidths are in bytes per
+// second, 0 meaning unlimited; PacketLoss is in percent.
+type NetemProfile struct {
+	UploadBandwidth   float64
+	DownloadBandwidth float64
+	Latency           time.Duration // One-way delay added to writes
+	Jitter            time.Duration
+	Distribution      LatencyDistribution
+	PacketLoss        float64
+	RetransmitDelay   time.Duration // Delay of a lost stream write, default 200ms
+	Seed              int64
+}
+
+// ParseNetemProfile parses a profile such as
+// "upload=5MB,download=20MB,latency=80ms,jitter=10ms,dist=normal,loss=0.5,seed=1".
+// Bandwidths take B, KB or MB per second suffixes.
+func ParseNetemProfile(spec string) (NetemProfile, error) {
+	var profile NetemProfile
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return profile, fmt.Errorf("invalid network emulation setting %q", field)
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case "upload":
+			profile.UploadBandwidth, err = parseBandwidth(value)
+		case "download":
+			profile.DownloadBandwidth, err = parseBandwidth(value)
+		case "latency":
+			profile.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			profile.Jitter, err = time.ParseDuration(value)
+		case "retransmit":
+			profile.RetransmitDelay, err = time.ParseDuration(value)
+		case "loss":
+			profile.PacketLoss, err = strconv.ParseFloat(value, 64)
+			if err == nil && (profile.PacketLoss < 0 || profile.PacketLoss > 100) {
+				err = fmt.Errorf("loss must be a percentage")
+			}
+		case "seed":
+			profile.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "dist":
+			profile.Distribution, err = parseLatencyDistribution(value)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return profile, fmt.Errorf("invalid network emulation setting %q: %w", field, err)
+		}
+	}
+	return profile, nil
+}
+
+func parseBandwidth(value string) (float64, error) {
+	upper := strings.ToUpper(value)
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(upper, "MB"):
+		scale, upper = 1024*1024, strings.TrimSuffix(upper, "MB")
+	case strings.HasSuffix(upper, "KB"):
+		scale, upper = 1024, strings.TrimSuffix(upper, "KB")
+	case strings.HasSuffix(upper, "B"):
+		upper = strings.TrimSuffix(upper, "B")
+	}
+	v, err := strconv.ParseFloat(upper, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", value)
+	}
+	return v * scale, nil
+}
+
+func parseLatencyDistribution(value string) (LatencyDistribution, error) {
+	switch strings.ToLower(value) {
+	case "constant":
+		return LatencyConstant, nil
+	case "uniform":
+		return LatencyUniform, nil
+	case "normal":
+		return LatencyNormal, nil
+	case "pareto":
+		return LatencyPareto, nil
+	}
+	return 0, fmt.Errorf("unknown latency distribution %q", value)
+}
+
+// NetemLink is an emulated link shared by the connections it wraps
+type NetemLink struct {
+	profile    NetemProfile
+	rng        *rand.Rand
+	uploadFree time.Time // When the uplink finishes sending what is queued
+	downFree   time.Time
+	mu         sync.Mutex
+}
+
+// NewNetemLink creates a link with profile
+func NewNetemLink(profile NetemProfile) *NetemLink {
+	if profile.RetransmitDelay <= 0 {
+		profile.RetransmitDelay = defaultRetransmitDelay
+	}
+	return &NetemLink{
+		profile: profile,
+		rng:     rand.New(rand.NewSource(profile.Seed)),
+	}
+}
+
+// Profile returns the link's profile
+func (l *NetemLink) Profile() NetemProfile {
+	return l.profile
+}
+
+// send reserves the uplink for n bytes. Returns when they are on the wire
+// and when they arrive, or lost if a datagram of them is dropped.
+func (l *NetemLink) send(n int) (sent, arrival time.Time, lost bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sent = reserve(&l.uploadFree, n, l.profile.UploadBandwidth)
+	lost = l.profile.PacketLoss > 0 && l.rng.Float64()*100 < l.profile.PacketLoss
+	return sent, sent.Add(l.delay()), lost
+}
+
+// receive reserves the downlink for n bytes and returns when they are in
+func (l *NetemLink) receive(n int) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return reserve(&l.downFree, n, l.profile.DownloadBandwidth)
+}
+
+// reserve queues n bytes on a link of bandwidth that is busy until free
+func reserve(free *time.Time, n int, bandwidth float64) time.Time {
+	now := time.Now()
+	if bandwidth <= 0 {
+		return now
+	}
+	start := *free
+	if start.Before(now) {
+		start = now
+	}
+	*free = start.Add(time.Duration(float64(n) / bandwidth * float64(time.Second)))
+	return *free
+}
+
+// delay samples a one-way delay. Caller holds l.mu.
+func (l *NetemLink) delay() time.Duration {
+	base := float64(l.profile.Latency)
+	jitter := float64(l.profile.Jitter)
+	var d float64
+	switch l.profile.Distribution {
+	case LatencyUniform:
+		d = base + (l.rng.Float64()*2-1)*jitter
+	case LatencyNormal:
+		d = base + l.rng.NormFloat64()*jitter
+	case LatencyPareto:
+		// With shape 3 the excess over scale averages scale/2
+		const shape = 3.0
+		scale := 2 * jitter
+		d = base + scale*math.Pow(1-l.rng.Float64(), -1/shape) - scale
+	default:
+		d = base
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+func sleepUntil(t time.Time) {
+	if d := time.Until(t); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Conn wraps conn. UDP connections get datagram semantics, others stream
+// semantics.
+func (l *NetemLink) Conn(conn net.Conn) net.Conn {
+	if strings.HasPrefix(conn.LocalAddr().Network(), "udp") {
+		return &netemDatagramConn{Conn: conn, link: l}
+	}
+	return &netemConn{Conn: conn, link: l, out: newNetemStreamWriter(l, conn)}
+}
+
+// PacketConn wraps an unconnected datagram socket
+func (l *NetemLink) PacketConn(pc net.PacketConn) net.PacketConn {
+	return &netemPacketConn{PacketConn: pc, link: l}
+}
+
+// Listener wraps every connection ln accepts
+func (l *NetemLink) Listener(ln net.Listener) net.Listener {
+	return &netemListener{Listener: ln, link: l}
+}
+
+// Stream wraps a libp2p stream
+func (l *NetemLink) Stream(s network.Stream) network.Stream {
+	return &netemStream{Stream: s, link: l, out: newNetemStreamWriter(l, s)}
+}
+
+// netemStreamWriter delivers a stream's writes in order once they arrive
+type netemStreamWriter struct {
+	link        *NetemLink
+	w           io.Writer
+	queue       chan netemSegment
+	done        chan struct{}
+	lastArrival time.Time
+	closed      bool
+	mu          sync.Mutex // Guards lastArrival, closed and sends on queue
+	err         error
+	errMu       sync.Mutex
+}
+
+type netemSegment struct {
+	data    []byte
+	arrival time.Time
+}
+
+func newNetemStreamWriter(link *NetemLink, w io.Writer) *netemStreamWriter {
+	sw := &netemStreamWriter{
+		link:  link,
+		w:     w,
+		queue: make(chan netemSegment, 1024),
+		done:  make(chan struct{}),
+	}
+	go sw.deliver()
+	return sw
+}
+
+func (sw *netemStreamWriter) failure() error {
+	sw.errMu.Lock()
+	defer sw.errMu.Unlock()
+	return sw.err
+}
+
+func (sw *netemStreamWriter) fail(err error) {
+	sw.errMu.Lock()
+	defer sw.errMu.Unlock()
+	if sw.err == nil {
+		sw.err = err
+	}
+}
+
+func (sw *netemStreamWriter) deliver() {
+	defer close(sw.done)
+	for seg := range sw.queue {
+		if sw.failure() != nil {
+			continue
+		}
+		sleepUntil(seg.arrival)
+		if sw.failure() != nil {
+			continue
+		}
+		if _, err := sw.w.Write(seg.data); err != nil {
+			sw.fail(err)
+		}
+	}
+}
+
+// Write queues b and returns once the uplink has sent it
+func (sw *netemStreamWriter) Write(b []byte) (int, error) {
+	sw.mu.Lock()
+	if sw.closed {
+		sw.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if err := sw.failure(); err != nil {
+		sw.mu.Unlock()
+		return 0, err
+	}
+	sent, arrival, lost := sw.link.send(len(b))
+	if lost {
+		arrival = arrival.Add(sw.link.profile.RetransmitDelay)
+	}
+	// Streams deliver in order
+	if arrival.Before(sw.lastArrival) {
+		arrival = sw.lastArrival
+	}
+	sw.lastArrival = arrival
+	sw.queue <- netemSegment{data: append([]byte(nil), b...), arrival: arrival}
+	sw.mu.Unlock()
+
+	sleepUntil(sent)
+	return len(b), nil
+}
+
+func (sw *netemStreamWriter) close() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if !sw.closed {
+		sw.closed = true
+		close(sw.queue)
+	}
+}
+
+// flush waits until everything written has been delivered
+func (sw *netemStreamWriter) flush() {
+	sw.close()
+	<-sw.done
+}
+
+// abort drops what has not been delivered yet
+func (sw *netemStreamWriter) abort() {
+	sw.fail(net.ErrClosed)
+	sw.close()
+}
+
+// netemConn is a stream connection on an emulated link
+type netemConn struct {
+	net.Conn
+	link *NetemLink
+	out  *netemStreamWriter
+}
+
+func (c *netemConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		sleepUntil(c.link.receive(n))
+	}
+	return n, err
+}
+
+func (c *netemConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+// Close delivers what is still in flight, then closes the connection
+func (c *netemConn) Close() error {
+	c.out.flush()
+	return c.Conn.Close()
+}
+
+// netemDatagramConn is a connected datagram socket on an emulated link
+type netemDatagramConn struct {
+	net.Conn
+	link *NetemLink
+}
+
+func (c *netemDatagramConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		sleepUntil(c.link.receive(n))
+	}
+	return n, err
+}
+
+func (c *netemDatagramConn) Write(b []byte) (int, error) {
+	sent, arrival, lost := c.link.send(len(b))
+	if !lost {
+		packet := append([]byte(nil), b...)
+		time.AfterFunc(time.Until(arrival), func() { c.Conn.Write(packet) })
+	}
+	sleepUntil(sent)
+	return len(b), nil
+}
+
+// netemPacketConn is an unconnected datagram socket on an emulated link
+type netemPacketConn struct {
+	net.PacketConn
+	link *NetemLink
+}
+
+func (c *netemPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if n > 0 {
+		sleepUntil(c.link.receive(n))
+	}
+	return n, addr, err
+}
+
+func (c *netemPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	sent, arrival, lost := c.link.send(len(b))
+	if !lost {
+		packet := append([]byte(nil), b...)
+		time.AfterFunc(time.Until(arrival), func() { c.PacketConn.WriteTo(packet, addr) })
+	}
+	sleepUntil(sent)
+	return len(b), nil
+}
+
+type netemListener struct {
+	net.Listener
+	link *NetemLink
+}
+
+func (ln *netemListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return ln.link.Conn(conn), nil
+}
+
+// netemStream is a libp2p stream on an emulated link
+type netemStream struct {
+	network.Stream
+	link *NetemLink
+	out  *netemStreamWriter
+}
+
+func (s *netemStream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	if n > 0 {
+		sleepUntil(s.link.receive(n))
+	}
+	return n, err
+}
+
+func (s *netemStream) Write(b []byte) (int, error) {
+	return s.out.Write(b)
+}
+
+func (s *netemStream) CloseWrite() error {
+	s.out.flush()
+	return s.Stream.CloseWrite()
+}
+
+func (s *netemStream) Close() error {
+	s.out.flush()
+	return s.Stream.Close()
+}
+
+func (s *netemStream) Reset() error {
+	s.out.abort()
+	return s.Stream.Reset()
+}
+
+func (s *netemStream) ResetWithError(code network.StreamErrorCode) error {
+	s.out.abort()
+	return s.Stream.ResetWithError(code)
+}
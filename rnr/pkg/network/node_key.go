@@ -0,0 +1,95 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// The node key is the libp2p identity: its peer ID is part of every
+// multiaddr other nodes use to reach this one, so it is kept in the data
+// directory and reused across restarts.
+
+const NodeKeyFile = "node_key.json"
+
+// Key types of newly generated node keys
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+)
+
+type nodeKeyJSON struct {
+	PrivKey []byte `json:"priv_key"` // libp2p protobuf encoding, which carries the key type
+}
+
+// LoadOrCreateNodeKey loads the node key at path, or generates one of
+// keyType and saves it there. The type of an existing key is kept.
+func LoadOrCreateNodeKey(path, keyType string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var stored nodeKeyJSON
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("failed to parse node key %s: %w", path, err)
+		}
+		privKey, err := crypto.UnmarshalPrivateKey(stored.PrivKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode node key %s: %w", path, err)
+		}
+		return privKey, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read node key: %w", err)
+	}
+
+	privKey, err := GenerateNodeKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := crypto.MarshalPrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode node key: %w", err)
+	}
+	data, err = json.MarshalIndent(&nodeKeyJSON{PrivKey: encoded}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode node key: %w", err)
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save node key: %w", err)
+	}
+	return privKey, nil
+}
+
+// GenerateNodeKey generates a node key of keyType, Ed25519 if empty
+func GenerateNodeKey(keyType string) (crypto.PrivKey, error) {
+	var privKey crypto.PrivKey
+	var err error
+	switch keyType {
+	case "", KeyTypeEd25519:
+		privKey, _, err = crypto.GenerateEd25519Key(rand.Reader)
+	case KeyTypeRSA:
+		privKey, _, err = crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown node key type %q", keyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	return privKey, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so a crash never leaves a truncated file behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
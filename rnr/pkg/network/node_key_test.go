@@ -0,0 +1,66 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TestNodeKeyPersists tests that the node key, and with it the peer ID,
+// survives restarts and keeps the type it was created with
+func TestNodeKeyPersists(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		keyType string
+		want    pb.KeyType
+	}{
+		{"", pb.KeyType_Ed25519},
+		{KeyTypeEd25519, pb.KeyType_Ed25519},
+		{KeyTypeRSA, pb.KeyType_RSA},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, "keys", tc.keyType+NodeKeyFile)
+		key, err := LoadOrCreateNodeKey(path, tc.keyType)
+		if err != nil {
+			t.Fatalf("%q: LoadOrCreateNodeKey failed: %v", tc.keyType, err)
+		}
+		if key.Type() != tc.want {
+			t.Errorf("%q: expected a %v key, got %v", tc.keyType, tc.want, key.Type())
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("%q: node key should be saved readable by the owner only", tc.keyType)
+		}
+
+		// Asked for another type, the existing key is kept
+		reloaded, err := LoadOrCreateNodeKey(path, KeyTypeRSA)
+		if err != nil {
+			t.Fatalf("%q: reload failed: %v", tc.keyType, err)
+		}
+		id, _ := peer.IDFromPrivateKey(key)
+		reloadedID, _ := peer.IDFromPrivateKey(reloaded)
+		if id != reloadedID {
+			t.Errorf("%q: peer ID changed across restarts: %s, then %s", tc.keyType, id, reloadedID)
+		}
+	}
+
+	if _, err := LoadOrCreateNodeKey(filepath.Join(dir, "dsa.json"), "dsa"); err == nil {
+		t.Errorf("Unknown key type should be refused")
+	}
+	corrupt := filepath.Join(dir, "corrupt.json")
+	os.WriteFile(corrupt, []byte(`{"priv_key":"AAAA"}`), 0600)
+	if _, err := LoadOrCreateNodeKey(corrupt, ""); err == nil {
+		t.Errorf("Corrupt node key should be refused, not replaced")
+	}
+}
+
+func testPeerID(t *testing.T) peer.ID {
+	key, err := GenerateNodeKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pid, _ := peer.IDFromPrivateKey(key)
+	return pid
+}
@@ -2,13 +2,10 @@ package network
 
 import (
         "context"
-        "crypto/rand"
         "encoding/json"
         "fmt"
-        "io"
         "log"
         "sync"
-        "time"
 
         "github.com/libp2p/go-libp2p"
         "github.com/libp2p/go-libp2p/core/crypto"
@@ -28,6 +25,7 @@ const (
         TransactionProtocol = "/rnr/tx/1.0.0"
         SpeedTestReqProtocol = "/rnr/pob-speed/req/1.0.0"
         SpeedTestStreamProtocol = "/rnr/pob-speed/stream/1.0.0"
+        SpeedTestChallengeProtocol = "/rnr/pob-speed/challenge/1.0.0"
 )
 
 type P2PNetwork struct {
@@ -43,6 +41,37 @@ type P2PNetwork struct {
         authManager     *P2PAuthManager      // SECURITY: Peer authentication
         rateLimiter     *RateLimiter         // SECURITY: Rate limiting per peer
         ipReputation    *IPReputationSystem  // SECURITY: IP reputation tracking (1% missing security)
+        speedTestHandler SpeedTestChallengeHandler // Answers PoB challenges when this node is a candidate
+        probeResponder   *UDPProbeResponder       // Echoes testers' UDP probes when this node is a candidate
+        emulation        *NetemLink               // Emulated link for speed test streams (testing)
+        pubsub           *PubSub                  // Topic-based propagation of blocks, votes, transactions, VRF proofs and evidence
+        blockValidator   BlockValidator
+        voteValidator    VoteValidator
+        txValidator      TransactionValidator
+        vrfProofValidator VRFProofValidator
+        vrfProofHandler  VRFProofHandler
+        evidenceHandler  EvidenceHandler
+        evidenceValidator EvidenceValidator
+        statusProvider    StatusProvider               // This node's chain and head for handshakes
+        peerStatusHandler PeerStatusHandler
+        peerStatus        map[peer.ID]*StatusMessage   // Statuses of accepted peers
+        blockRangeHandler BlockRangeHandler            // Serves sync requests
+        addressBook       *AddressBook                 // Known peers, kept across restarts
+        scores            *PeerScores                  // Unified peer score, kept across restarts
+        gater             *connectionGater
+        localValidatorID  string                       // Validator this node publishes identity records for
+        recordSigner      ValidatorRecordSigner
+        recordVerifier    ValidatorRecordVerifier
+        validatorRecords  map[string]*ValidatorRecord  // Validator ID -> identity record
+        peerValidators    map[peer.ID]string           // Peer -> validator ID of its record
+        peerConfig        *peerConfig                  // Static, persistent, unconditional and private peers
+        connManager       *connManager                 // Inbound and outbound slots and diversity caps
+        mempoolHandler    MempoolHandler               // Transactions compact blocks are rebuilt from
+        compactBlocks     *compactBlocks               // Recently relayed blocks, to serve their transactions
+        shredRelay        bool                         // Send large proposed blocks as shreds
+        shreds            *shredSets                   // Blocks being rebuilt from shreds
+        wireCompression   bool                         // Snappy compress messages sent
+        privateNetwork    bool                         // Connections are protected by a swarm key
 }
 
 type BlockHandler func(*core.Block) error
@@ -50,35 +79,44 @@ type VoteHandler func(blockHash []byte, validatorID string, signature []byte) er
 type TransactionHandler func(*core.Transaction) error
 type TxLookupHandler func(txID string) (*core.Transaction, error) // Lookup transaction by ID
 
+// Message is a message read off a stream, see ReadMessage
 type Message struct {
         Type    int
         Payload []byte
 }
 
-func NewP2PNetwork(port int) (*P2PNetwork, error) {
-        ctx, cancel := context.WithCancel(context.Background())
-
-        privKey, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
+func NewP2PNetwork(config P2PConfig) (*P2PNetwork, error) {
+        peerConfig, err := parsePeerConfig(&config)
         if err != nil {
-                cancel()
-                return nil, fmt.Errorf("failed to generate key pair: %w", err)
+                return nil, err
+        }
+        addressBook, err := NewAddressBook(nodeFilePath(config.DataDir, AddressBookFile))
+        if err != nil {
+                return nil, err
+        }
+        scores, err := NewPeerScores(nodeFilePath(config.DataDir, PeerScoreFile))
+        if err != nil {
+                return nil, err
         }
 
-        listenAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port))
+        // The node key is kept in the data directory so the peer ID, and
+        // with it this node's multiaddrs, survive restarts
+        var privKey crypto.PrivKey
+        if config.DataDir != "" {
+                privKey, err = LoadOrCreateNodeKey(nodeFilePath(config.DataDir, NodeKeyFile), config.KeyType)
+        } else {
+                privKey, err = GenerateNodeKey(config.KeyType)
+        }
         if err != nil {
-                cancel()
-                return nil, fmt.Errorf("failed to create listen address: %w", err)
+                return nil, err
         }
 
-        h, err := libp2p.New(
-                libp2p.ListenAddrs(listenAddr),
-                libp2p.Identity(privKey),
-                libp2p.NATPortMap(),
-                libp2p.EnableNATService(),
-        )
+        ctx, cancel := context.WithCancel(context.Background())
+
+        listenAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", config.Port))
         if err != nil {
                 cancel()
-                return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+                return nil, fmt.Errorf("failed to create listen address: %w", err)
         }
 
         // SECURITY: Initialize all security systems (including 1% missing - IP reputation & Byzantine detection)
@@ -90,22 +128,84 @@ func NewP2PNetwork(port int) (*P2PNetwork, error) {
         )
         ipReputation := NewIPReputationSystem()
 
+        // SECURITY: Banned peers and blacklisted IPs are refused at connection time
+        gater := &connectionGater{
+                scores:       scores,
+                rateLimiter:  rateLimiter,
+                ipReputation: ipReputation,
+                peerConfig:   peerConfig,
+                connManager:  newConnManager(config.Limits, peerConfig, scores),
+        }
+
+        options := []libp2p.Option{
+                libp2p.ListenAddrs(listenAddr),
+                libp2p.Identity(privKey),
+                libp2p.NATPortMap(),
+                libp2p.EnableNATService(),
+                libp2p.ConnectionGater(gater),
+        }
+        if config.SwarmKeyFile != "" {
+                psk, err := LoadSwarmKey(config.SwarmKeyFile)
+                if err != nil {
+                        cancel()
+                        return nil, err
+                }
+                options = append(options, libp2p.PrivateNetwork(psk))
+                log.Printf("🔒 Private network mode, swarm key fingerprint %s", SwarmKeyFingerprint(psk))
+        }
+
+        h, err := libp2p.New(options...)
+        if err != nil {
+                cancel()
+                return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+        }
+
         p2p := &P2PNetwork{
                 Host:         h,
                 ctx:          ctx,
                 cancel:       cancel,
                 peers:        make(map[peer.ID]bool),
+                peerStatus:   make(map[peer.ID]*StatusMessage),
+                validatorRecords: make(map[string]*ValidatorRecord),
+                peerValidators:   make(map[peer.ID]string),
+                compactBlocks:    newCompactBlocks(),
+                shreds:           newShredSets(),
                 authManager:  authManager,
                 rateLimiter:  rateLimiter,
                 ipReputation: ipReputation,
-        }
+                addressBook:  addressBook,
+                scores:       scores,
+                gater:        gater,
+                peerConfig:   peerConfig,
+                connManager:  gater.connManager,
+                privateNetwork: config.SwarmKeyFile != "",
+        }
+
+        // SECURITY: Rate limit violations count against the peer score
+        rateLimiter.SetViolationHandler(func(pid peer.ID, err error) {
+                if !p2p.IsUnconditionalPeer(pid) {
+                        p2p.reportPeer(pid, PeerEventRateLimit, 1, err.Error())
+                }
+        })
 
         h.SetStreamHandler(protocol.ID(BlockProtocol), p2p.handleBlockStream)
         h.SetStreamHandler(protocol.ID(VoteProtocol), p2p.handleVoteStream)
         h.SetStreamHandler(protocol.ID(TransactionProtocol), p2p.handleTransactionStream)
 
+        h.SetStreamHandler(protocol.ID(StatusProtocol), p2p.handleStatusStream)
+        h.SetStreamHandler(protocol.ID(SyncProtocol), p2p.handleSyncStream)
+        h.SetStreamHandler(protocol.ID(ValidatorRecordProtocol), p2p.handleValidatorRecordStream)
+        h.SetStreamHandler(protocol.ID(ShredProtocol), p2p.handleShredStream)
+
         // SECURITY: Setup authentication protocol
         p2p.SetupAuthProtocol()
+        p2p.trackConnections()
+        p2p.startConnManager()
+
+        // Messages are published over pubsub; the stream handlers above stay
+        // for transaction requests and for peers that have not upgraded yet
+        p2p.setupPubSub()
+        p2p.startPeerDialer()
 
         // SECURITY: Start background cleanup routines
         authManager.StartCleanupRoutine()
@@ -138,6 +238,14 @@ func (p *P2PNetwork) SetTxLookupHandler(handler TxLookupHandler) {
         p.txLookupHandler = handler
 }
 
+// SetASNResolver makes peer diversity limits count peers per origin AS
+func (p *P2PNetwork) SetASNResolver(resolver *ASNResolver) {
+        p.ipReputation.SetASNResolver(resolver)
+        p.connManager.mu.Lock()
+        p.connManager.asn = resolver
+        p.connManager.mu.Unlock()
+}
+
 // GetPeers returns list of connected peer IDs
 func (p *P2PNetwork) GetPeers() []string {
         p.mu.RLock()
@@ -157,52 +265,23 @@ func (p *P2PNetwork) RequestTransaction(peerID string, txID string) (*core.Trans
                 return nil, fmt.Errorf("invalid peer ID: %w", err)
         }
 
-        reqData, err := json.Marshal(map[string]string{"tx_id": txID})
-        if err != nil {
-                return nil, fmt.Errorf("failed to marshal request: %w", err)
-        }
-
-        msg := &Message{
-                Type:    core.MessageTypeTxRequest,
-                Payload: reqData,
-        }
-
         stream, err := p.Host.NewStream(p.ctx, pid, protocol.ID(TransactionProtocol))
         if err != nil {
                 return nil, fmt.Errorf("failed to create stream: %w", err)
         }
         defer stream.Close()
 
-        msgData, err := json.Marshal(msg)
-        if err != nil {
-                return nil, fmt.Errorf("failed to marshal message: %w", err)
-        }
-
-        if _, err := stream.Write(msgData); err != nil {
+        if err := p.writeMessage(stream, core.MessageTypeTxRequest, &txRequest{TxID: txID}); err != nil {
                 return nil, fmt.Errorf("failed to write request: %w", err)
         }
 
         // Close write side to signal request complete (prevents deadlock)
         stream.CloseWrite()
 
-        // Read response
-        respData, err := io.ReadAll(stream)
-        if err != nil {
-                return nil, fmt.Errorf("failed to read response: %w", err)
-        }
-
-        // Check for error response
-        var errResp map[string]string
-        if err := json.Unmarshal(respData, &errResp); err == nil {
-                if errMsg, hasErr := errResp["error"]; hasErr {
-                        return nil, fmt.Errorf("peer error: %s", errMsg)
-                }
-        }
-
-        // Parse transaction
+        // Read response; an error frame is returned as the error
         var tx core.Transaction
-        if err := json.Unmarshal(respData, &tx); err != nil {
-                return nil, fmt.Errorf("failed to unmarshal transaction: %w", err)
+        if _, err := ReadMessageOf(stream, core.MessageTypeTxResponse, &tx); err != nil {
+                return nil, fmt.Errorf("failed to read transaction: %w", err)
         }
 
         // Validate non-empty transaction
@@ -224,7 +303,7 @@ func (p *P2PNetwork) ConnectToPeer(peerAddr string) error {
                 return fmt.Errorf("failed to get peer info: %w", err)
         }
 
-        if err := p.Host.Connect(p.ctx, *peerInfo); err != nil {
+        if err := p.dial(*peerInfo); err != nil {
                 return fmt.Errorf("failed to connect to peer: %w", err)
         }
 
@@ -234,49 +313,38 @@ func (p *P2PNetwork) ConnectToPeer(peerAddr string) error {
 
         log.Printf("✅ Connected to peer: %s", peerInfo.ID.String())
 
-        // SECURITY: Auto-authenticate newly connected peer via proper protocol
-        go func() {
-                time.Sleep(500 * time.Millisecond) // Brief delay to ensure connection stable
-                if err := p.AuthenticatePeerViaProtocol(peerInfo.ID); err != nil {
-                        log.Printf("⚠️  Failed to authenticate peer %s: %v", peerInfo.ID.String()[:8], err)
-                }
-        }()
-
+        // SECURITY: The peer is authenticated once connected, see trackConnections
         return nil
 }
 
-func (p *P2PNetwork) BroadcastBlock(block *core.Block) error {
-        blockData, err := json.Marshal(block)
+func (p *P2PNetwork) BroadcastVote(blockHash []byte, validatorID string, signature []byte) error {
+        payload, err := json.Marshal(&voteMessage{
+                BlockHash:   blockHash,
+                ValidatorID: validatorID,
+                Signature:   signature,
+        })
         if err != nil {
-                return fmt.Errorf("failed to marshal block: %w", err)
-        }
-
-        msg := &Message{
-                Type:    core.MessageTypeBlock,
-                Payload: blockData,
+                return fmt.Errorf("failed to marshal vote: %w", err)
         }
 
-        return p.broadcast(BlockProtocol, msg)
+        return p.pubsub.Publish(TopicVotes, payload)
 }
 
-func (p *P2PNetwork) BroadcastVote(blockHash []byte, validatorID string, signature []byte) error {
-        voteData := map[string]interface{}{
-                "block_hash":   blockHash,
-                "validator_id": validatorID,
-                "signature":    signature,
+// SendVote gossips a vote and also sends it straight to the validator that
+// collects it, usually the block's proposer, if its record is known
+func (p *P2PNetwork) SendVote(toValidatorID string, blockHash []byte, validatorID string, signature []byte) error {
+        if err := p.BroadcastVote(blockHash, validatorID, signature); err != nil {
+                return err
         }
-
-        payload, err := json.Marshal(voteData)
-        if err != nil {
-                return fmt.Errorf("failed to marshal vote: %w", err)
+        payload, _ := json.Marshal(&voteMessage{
+                BlockHash:   blockHash,
+                ValidatorID: validatorID,
+                Signature:   signature,
+        })
+        if err := p.SendToValidator(toValidatorID, TopicVotes, payload); err != nil {
+                log.Printf("⚠️  Vote for %x gossiped only: %v", shortHash(blockHash), err)
         }
-
-        msg := &Message{
-                Type:    core.MessageTypeVote,
-                Payload: payload,
-        }
-
-        return p.broadcast(VoteProtocol, msg)
+        return nil
 }
 
 func (p *P2PNetwork) BroadcastTransaction(tx *core.Transaction) error {
@@ -285,43 +353,7 @@ func (p *P2PNetwork) BroadcastTransaction(tx *core.Transaction) error {
                 return fmt.Errorf("failed to marshal transaction: %w", err)
         }
 
-        msg := &Message{
-                Type:    core.MessageTypeTransaction,
-                Payload: txData,
-        }
-
-        return p.broadcast(TransactionProtocol, msg)
-}
-
-func (p *P2PNetwork) broadcast(protocolID string, msg *Message) error {
-        p.mu.RLock()
-        peerIDs := make([]peer.ID, 0, len(p.peers))
-        for peerID := range p.peers {
-                peerIDs = append(peerIDs, peerID)
-        }
-        p.mu.RUnlock()
-
-        msgData, err := json.Marshal(msg)
-        if err != nil {
-                return fmt.Errorf("failed to marshal message: %w", err)
-        }
-
-        for _, peerID := range peerIDs {
-                go func(pid peer.ID) {
-                        stream, err := p.Host.NewStream(p.ctx, pid, protocol.ID(protocolID))
-                        if err != nil {
-                                log.Printf("⚠️  Failed to open stream to %s: %v", pid.String(), err)
-                                return
-                        }
-                        defer stream.Close()
-
-                        if _, err := stream.Write(msgData); err != nil {
-                                log.Printf("⚠️  Failed to send message to %s: %v", pid.String(), err)
-                        }
-                }(peerID)
-        }
-
-        return nil
+        return p.pubsub.Publish(TopicTransactions, txData)
 }
 
 func (p *P2PNetwork) handleBlockStream(stream network.Stream) {
@@ -337,32 +369,26 @@ func (p *P2PNetwork) handleBlockStream(stream network.Stream) {
 
         // SECURITY: Check rate limit
         allowed, err := p.rateLimiter.AllowRequest(remotePeer)
-        if !allowed {
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Rate limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        data, err := io.ReadAll(stream)
+        msg, size, err := ReadMessage(stream, core.MessageTypeBlock)
         if err != nil {
                 log.Printf("⚠️  Failed to read block stream: %v", err)
                 return
         }
 
         // SECURITY: Check bandwidth limit
-        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(len(data)))
-        if !allowed {
+        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(size))
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Bandwidth limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        var msg Message
-        if err := json.Unmarshal(data, &msg); err != nil {
-                log.Printf("⚠️  Failed to unmarshal block message: %v", err)
-                return
-        }
-
         var block core.Block
-        if err := json.Unmarshal(msg.Payload, &block); err != nil {
+        if err := msg.Decode(core.MessageTypeBlock, &block); err != nil {
                 log.Printf("⚠️  Failed to unmarshal block: %v", err)
                 return
         }
@@ -387,42 +413,37 @@ func (p *P2PNetwork) handleVoteStream(stream network.Stream) {
 
         // SECURITY: Check rate limit
         allowed, err := p.rateLimiter.AllowRequest(remotePeer)
-        if !allowed {
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Rate limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        data, err := io.ReadAll(stream)
+        msg, size, err := ReadMessage(stream, core.MessageTypeVote)
         if err != nil {
                 log.Printf("⚠️  Failed to read vote stream: %v", err)
                 return
         }
 
         // SECURITY: Check bandwidth limit
-        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(len(data)))
-        if !allowed {
+        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(size))
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Bandwidth limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        var msg Message
-        if err := json.Unmarshal(data, &msg); err != nil {
-                log.Printf("⚠️  Failed to unmarshal vote message: %v", err)
+        if msg.Type != core.MessageTypeVote {
+                log.Printf("⚠️  Unexpected vote message type: %d", msg.Type)
                 return
         }
 
-        var voteData map[string]interface{}
-        if err := json.Unmarshal(msg.Payload, &voteData); err != nil {
-                log.Printf("⚠️  Failed to unmarshal vote data: %v", err)
+        vote, ok := decodeVote(msg.Payload)
+        if !ok {
+                log.Printf("⚠️  Failed to unmarshal vote data")
                 return
         }
 
-        blockHash := voteData["block_hash"].([]byte)
-        validatorID := voteData["validator_id"].(string)
-        signature := voteData["signature"].([]byte)
-
         if p.voteHandler != nil {
-                if err := p.voteHandler(blockHash, validatorID, signature); err != nil {
+                if err := p.voteHandler(vote.BlockHash, vote.ValidatorID, vote.Signature); err != nil {
                         log.Printf("⚠️  Vote handler error: %v", err)
                 }
         }
@@ -441,34 +462,28 @@ func (p *P2PNetwork) handleTransactionStream(stream network.Stream) {
 
         // SECURITY: Check rate limit
         allowed, err := p.rateLimiter.AllowRequest(remotePeer)
-        if !allowed {
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Rate limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        data, err := io.ReadAll(stream)
+        msg, size, err := ReadMessage(stream, core.MessageTypeTransaction, core.MessageTypeTxRequest)
         if err != nil {
                 log.Printf("⚠️  Failed to read transaction stream: %v", err)
                 return
         }
 
         // SECURITY: Check bandwidth limit
-        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(len(data)))
-        if !allowed {
+        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(size))
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Bandwidth limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        var msg Message
-        if err := json.Unmarshal(data, &msg); err != nil {
-                log.Printf("⚠️  Failed to unmarshal transaction message: %v", err)
-                return
-        }
-
         switch msg.Type {
-        case core.MessageTypeTransaction, 1: // Transaction announcement (support both types for compatibility)
+        case core.MessageTypeTransaction: // Transaction announcement
                 var tx core.Transaction
-                if err := json.Unmarshal(msg.Payload, &tx); err != nil {
+                if err := msg.Decode(core.MessageTypeTransaction, &tx); err != nil {
                         log.Printf("⚠️  Failed to unmarshal transaction: %v", err)
                         return
                 }
@@ -480,13 +495,22 @@ func (p *P2PNetwork) handleTransactionStream(stream network.Stream) {
                 }
 
         case core.MessageTypeTxRequest: // Transaction request
-                var req map[string]string
-                if err := json.Unmarshal(msg.Payload, &req); err != nil {
+                var req txRequest
+                if err := msg.Decode(core.MessageTypeTxRequest, &req); err != nil {
                         log.Printf("⚠️  Failed to unmarshal tx request: %v", err)
                         return
                 }
 
-                txID := req["tx_id"]
+                // Transactions of a relayed compact block
+                if len(req.BlockHash) > 0 {
+                        p.serveBlockTransactions(stream, &req)
+                        return
+                }
+
+                txID := req.TxID
+                if len(txID) < 12 {
+                        return
+                }
                 log.Printf("📥 Received transaction request for %s from peer %s", txID[:12], remotePeer.String()[:8])
 
                 // Lookup transaction and respond
@@ -495,29 +519,18 @@ func (p *P2PNetwork) handleTransactionStream(stream network.Stream) {
                         if err != nil {
                                 log.Printf("⚠️  Failed to lookup transaction %s: %v", txID[:12], err)
                                 // Send error response
-                                errResp := map[string]string{"error": "transaction not found"}
-                                errData, _ := json.Marshal(errResp)
-                                stream.Write(errData)
+                                WriteError(stream, "transaction not found")
                                 return
                         }
 
-                        // Send raw transaction as response (RequestTransaction expects raw tx JSON)
-                        txData, err := json.Marshal(tx)
-                        if err != nil {
-                                log.Printf("⚠️  Failed to marshal transaction: %v", err)
-                                return
-                        }
-
-                        if _, err := stream.Write(txData); err != nil {
+                        if err := p.writeMessage(stream, core.MessageTypeTxResponse, tx); err != nil {
                                 log.Printf("⚠️  Failed to write transaction response: %v", err)
                         } else {
                                 log.Printf("📤 Sent transaction %s to peer %s", txID[:12], remotePeer.String()[:8])
                         }
                 } else {
                         // No lookup handler configured
-                        errResp := map[string]string{"error": "lookup not configured"}
-                        errData, _ := json.Marshal(errResp)
-                        stream.Write(errData)
+                        WriteError(stream, "lookup not configured")
                 }
 
         default:
@@ -533,5 +546,11 @@ func (p *P2PNetwork) GetPeerCount() int {
 
 func (p *P2PNetwork) Close() error {
         p.cancel()
+        if err := p.addressBook.Save(); err != nil {
+                log.Printf("⚠️  %v", err)
+        }
+        if err := p.scores.Save(); err != nil {
+                log.Printf("⚠️  %v", err)
+        }
         return p.Host.Close()
 }
@@ -132,6 +132,16 @@ func (am *P2PAuthManager) IsAuthenticated(peerID peer.ID) bool {
 	return true
 }
 
+// NeedsReauthentication reports whether a peer is unauthenticated or past
+// half its authentication TTL, so it can be renewed before it lapses
+func (am *P2PAuthManager) NeedsReauthentication(peerID peer.ID) bool {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	authTime, exists := am.authenticated[peerID]
+	return !exists || time.Since(authTime) > am.challengeTTL/2
+}
+
 // RevokeAuthentication removes authentication for a peer
 // Used when peer misbehaves or disconnects
 func (am *P2PAuthManager) RevokeAuthentication(peerID peer.ID) {
@@ -1,7 +1,6 @@
 package network
 
 import (
-        "encoding/json"
         "fmt"
         "io"
         "log"
@@ -10,6 +9,8 @@ import (
         "github.com/libp2p/go-libp2p/core/network"
         "github.com/libp2p/go-libp2p/core/peer"
         "github.com/libp2p/go-libp2p/core/protocol"
+
+        "rnr-blockchain/pkg/core"
 )
 
 const (
@@ -48,11 +49,8 @@ func (p *P2PNetwork) handleAuthStream(stream network.Stream) {
 
         remotePeer := stream.Conn().RemotePeer()
         
-        // CRITICAL FIX: Use decoder to read without waiting for stream close
-        decoder := json.NewDecoder(stream)
-        
         var msg AuthMessage
-        if err := decoder.Decode(&msg); err != nil {
+        if _, err := ReadMessageOf(stream, core.MessageTypeAuth, &msg); err != nil {
                 if err != io.EOF {
                         log.Printf("⚠️  Failed to decode auth message from %s: %v", remotePeer.String()[:8], err)
                 }
@@ -72,8 +70,8 @@ func (p *P2PNetwork) handleAuthStream(stream network.Stream) {
 
 // handleAuthChallenge handles incoming authentication challenge
 func (p *P2PNetwork) handleAuthChallenge(stream network.Stream, remotePeer peer.ID, msg *AuthMessage) {
-        // Solve the challenge
-        solution := p.authManager.SolveChallenge(remotePeer, msg.Nonce)
+        // Solve the challenge; the solution binds our own peer ID
+        solution := p.authManager.SolveChallenge(p.Host.ID(), msg.Nonce)
         
         // Send response
         response := &AuthMessage{
@@ -84,9 +82,7 @@ func (p *P2PNetwork) handleAuthChallenge(stream network.Stream, remotePeer peer.
                 Timestamp: time.Now().Unix(),
         }
         
-        // CRITICAL FIX: Use encoder to write message properly
-        encoder := json.NewEncoder(stream)
-        if err := encoder.Encode(response); err != nil {
+        if err := p.writeMessage(stream, core.MessageTypeAuth, response); err != nil {
                 log.Printf("⚠️  Failed to encode auth response: %v", err)
                 return
         }
@@ -117,19 +113,18 @@ func (p *P2PNetwork) handleAuthResponse(stream network.Stream, remotePeer peer.I
         if err != nil {
                 result.Error = err.Error()
                 log.Printf("❌ Authentication failed for %s: %v", remotePeer.String()[:8], err)
+                p.reportPeer(remotePeer, PeerEventAuthFailure, 1, err.Error())
         } else {
                 log.Printf("✅ Peer %s authenticated successfully via stream handler", remotePeer.String()[:8])
         }
         
-        // CRITICAL FIX: Use encoder to write result properly
-        encoder := json.NewEncoder(stream)
-        encoder.Encode(result)
+        p.writeMessage(stream, core.MessageTypeAuth, result)
 }
 
 // AuthenticatePeerViaProtocol performs real authentication challenge-response
 func (p *P2PNetwork) AuthenticatePeerViaProtocol(peerID peer.ID) error {
-        // Check if already authenticated
-        if p.authManager.IsAuthenticated(peerID) {
+        // Check if already authenticated and not due for renewal
+        if !p.authManager.NeedsReauthentication(peerID) {
                 return nil
         }
 
@@ -149,7 +144,7 @@ func (p *P2PNetwork) AuthenticatePeerViaProtocol(peerID peer.ID) error {
         // Set deadlines for the entire handshake
         stream.SetDeadline(time.Now().Add(10 * time.Second))
 
-        // Send challenge using encoder
+        // Send challenge
         challengeMsg := &AuthMessage{
                 Type:      AuthTypeChallenge,
                 PeerID:    p.Host.ID().String(),
@@ -157,16 +152,12 @@ func (p *P2PNetwork) AuthenticatePeerViaProtocol(peerID peer.ID) error {
                 Timestamp: challenge.Timestamp.Unix(),
         }
 
-        encoder := json.NewEncoder(stream)
-        if err := encoder.Encode(challengeMsg); err != nil {
+        if err := p.writeMessage(stream, core.MessageTypeAuth, challengeMsg); err != nil {
                 return fmt.Errorf("failed to encode challenge: %w", err)
         }
 
-        // CRITICAL FIX: Use decoder to read response without waiting for stream close
-        decoder := json.NewDecoder(stream)
-        
         var response AuthMessage
-        if err := decoder.Decode(&response); err != nil {
+        if _, err := ReadMessageOf(stream, core.MessageTypeAuth, &response); err != nil {
                 return fmt.Errorf("failed to decode response: %w", err)
         }
 
@@ -183,6 +174,7 @@ func (p *P2PNetwork) AuthenticatePeerViaProtocol(peerID peer.ID) error {
         }
 
         if err := p.authManager.VerifyResponse(challengeResponse); err != nil {
+                p.reportPeer(peerID, PeerEventAuthFailure, 1, err.Error())
                 return fmt.Errorf("authentication verification failed: %w", err)
         }
 
@@ -0,0 +1,218 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Peer configuration:
+//   - static peers are dialed once at startup
+//   - persistent peers are kept connected, redialed with backoff when lost
+//   - unconditional peers are never banned or rate limited
+//   - private peers are never recorded in the address book
+//
+// After the configured peers, the best peers of the address book are dialed.
+
+const (
+	redialInterval       = 10 * time.Second
+	minRedialBackoff     = 5 * time.Second
+	maxRedialBackoff     = 5 * time.Minute
+	addressBookDialPeers = 8 // Address book peers dialed at startup
+	dialTimeout          = 15 * time.Second
+)
+
+// P2PConfig configures a P2PNetwork
+type P2PConfig struct {
+	Port int
+
+	// DataDir holds the node key and address book. Empty generates a new
+	// key on every start and keeps the address book in memory.
+	DataDir string
+	KeyType string // Type of a newly generated node key, KeyTypeEd25519 if empty
+
+	// SwarmKeyFile makes this node part of the private network of that
+	// swarm key. Empty joins the public network.
+	SwarmKeyFile string
+
+	StaticPeers          []string // Multiaddrs with /p2p/ peer IDs
+	PersistentPeers      []string // Multiaddrs with /p2p/ peer IDs
+	UnconditionalPeerIDs []string
+	PrivatePeerIDs       []string
+
+	Limits ConnLimits // Peer slots, DefaultConnLimits for zero fields
+}
+
+// peerConfig is the parsed peer part of a P2PConfig
+type peerConfig struct {
+	static        []peer.AddrInfo
+	persistent    []peer.AddrInfo
+	unconditional map[peer.ID]bool
+	private       map[peer.ID]bool
+}
+
+func parsePeerConfig(config *P2PConfig) (*peerConfig, error) {
+	pc := &peerConfig{
+		unconditional: make(map[peer.ID]bool),
+		private:       make(map[peer.ID]bool),
+	}
+	var err error
+	if pc.static, err = parsePeerAddrs(config.StaticPeers); err != nil {
+		return nil, fmt.Errorf("invalid static peer: %w", err)
+	}
+	if pc.persistent, err = parsePeerAddrs(config.PersistentPeers); err != nil {
+		return nil, fmt.Errorf("invalid persistent peer: %w", err)
+	}
+	for _, s := range config.UnconditionalPeerIDs {
+		pid, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unconditional peer ID %q: %w", s, err)
+		}
+		pc.unconditional[pid] = true
+	}
+	for _, s := range config.PrivatePeerIDs {
+		pid, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private peer ID %q: %w", s, err)
+		}
+		pc.private[pid] = true
+	}
+	return pc, nil
+}
+
+// parsePeerAddrs parses multiaddrs, merging addresses of the same peer
+func parsePeerAddrs(addrs []string) ([]peer.AddrInfo, error) {
+	maddrs := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, s := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", s, err)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	return peer.AddrInfosFromP2pAddrs(maddrs...)
+}
+
+func nodeFilePath(dataDir, name string) string {
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, name)
+}
+
+// IsUnconditionalPeer reports whether pid is exempt from bans and limits
+func (p *P2PNetwork) IsUnconditionalPeer(pid peer.ID) bool {
+	return p.peerConfig.unconditional[pid]
+}
+
+// IsPrivatePeer reports whether pid is kept out of the address book
+func (p *P2PNetwork) IsPrivatePeer(pid peer.ID) bool {
+	return p.peerConfig.private[pid]
+}
+
+// KnownPeers returns the address book entries, best first
+func (p *P2PNetwork) KnownPeers() []KnownPeer {
+	return p.addressBook.Peers()
+}
+
+// recordPeer adds a peer whose status was accepted to the address book
+func (p *P2PNetwork) recordPeer(pid peer.ID) {
+	if p.IsPrivatePeer(pid) {
+		return
+	}
+	p.addressBook.RecordSuccess(pid, p.Host.Peerstore().Addrs(pid))
+}
+
+// dial connects to a peer, recording a failure in the address book
+func (p *P2PNetwork) dial(info peer.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(p.ctx, dialTimeout)
+	defer cancel()
+	if err := p.Host.Connect(ctx, info); err != nil {
+		p.addressBook.RecordFailure(info.ID)
+		return err
+	}
+	return nil
+}
+
+// startPeerDialer dials the configured and known peers, keeps persistent
+// peers connected and flushes the address book and peer scores
+func (p *P2PNetwork) startPeerDialer() {
+	go func() {
+		dialed := make(map[peer.ID]bool)
+		for _, info := range append(append([]peer.AddrInfo{}, p.peerConfig.persistent...), p.peerConfig.static...) {
+			if dialed[info.ID] {
+				continue
+			}
+			dialed[info.ID] = true
+			if err := p.dial(info); err != nil {
+				log.Printf("⚠️  Failed to connect to configured peer %s: %v", shortPeerID(info.ID), err)
+			}
+		}
+		known := 0
+		for _, info := range p.addressBook.BestPeers(addressBookDialPeers + len(dialed)) {
+			if dialed[info.ID] || info.ID == p.Host.ID() {
+				continue
+			}
+			if known == addressBookDialPeers {
+				break
+			}
+			known++
+			if err := p.dial(info); err == nil {
+				log.Printf("📒 Reconnected to known peer %s", shortPeerID(info.ID))
+			}
+		}
+	}()
+
+	go func() {
+		redial := time.NewTicker(redialInterval)
+		defer redial.Stop()
+		flush := time.NewTicker(addressBookFlush)
+		defer flush.Stop()
+
+		backoff := make(map[peer.ID]time.Duration)
+		nextDial := make(map[peer.ID]time.Time)
+		for {
+			select {
+			case now := <-redial.C:
+				for _, info := range p.peerConfig.persistent {
+					if p.Host.Network().Connectedness(info.ID) == network.Connected {
+						delete(backoff, info.ID)
+						delete(nextDial, info.ID)
+						continue
+					}
+					if now.Before(nextDial[info.ID]) {
+						continue
+					}
+					if err := p.dial(info); err != nil {
+						wait := backoff[info.ID] * 2
+						if wait < minRedialBackoff {
+							wait = minRedialBackoff
+						} else if wait > maxRedialBackoff {
+							wait = maxRedialBackoff
+						}
+						backoff[info.ID] = wait
+						nextDial[info.ID] = now.Add(wait)
+						log.Printf("⚠️  Persistent peer %s unreachable, retrying in %s: %v", shortPeerID(info.ID), wait, err)
+						continue
+					}
+					log.Printf("🔁 Reconnected to persistent peer %s", shortPeerID(info.ID))
+				}
+			case <-flush.C:
+				if err := p.addressBook.Save(); err != nil {
+					log.Printf("⚠️  %v", err)
+				}
+				if err := p.scores.Save(); err != nil {
+					log.Printf("⚠️  %v", err)
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
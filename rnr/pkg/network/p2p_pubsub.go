@@ -0,0 +1,384 @@
+package network
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Blocks, votes, transactions, VRF proofs and evidence propagate over pubsub
+// topics. Each topic's validator decodes the message and asks the node's
+// validator hook before the message is relayed; its handler then passes it
+// to the node's message handler.
+
+// Validator hooks judge gossiped messages before they are relayed
+type BlockValidator func(*core.Block) ValidationResult
+type VoteValidator func(blockHash []byte, validatorID string, signature []byte) ValidationResult
+type TransactionValidator func(*core.Transaction) ValidationResult
+type VRFProofValidator func(*VRFProofMessage) ValidationResult
+
+// EvidenceHandler receives gossiped misbehavior evidence, JSON encoded
+type EvidenceHandler func(data []byte) error
+
+// EvidenceValidator judges gossiped evidence, JSON encoded, before it is
+// relayed
+type EvidenceValidator func(data []byte) ValidationResult
+
+// voteMessage is a gossiped vote
+type voteMessage struct {
+	BlockHash   []byte `json:"block_hash"`
+	ValidatorID string `json:"validator_id"`
+	Signature   []byte `json:"signature"`
+}
+
+// Topic weights in the peer score: invalid blocks weigh most, invalid
+// transactions least, as they can be honestly stale
+var topicWeights = map[string]float64{
+	TopicBlocks:           1.0,
+	TopicCompactBlocks:    1.0,
+	TopicVotes:            0.5,
+	TopicVRFProofs:        0.5,
+	TopicEvidence:         0.5,
+	TopicTransactions:     0.25,
+	TopicValidatorRecords: 0.5,
+}
+
+// setupPubSub starts the router and joins the node's topics
+func (p *P2PNetwork) setupPubSub() {
+	p.pubsub = NewPubSub(p.ctx, p.Host)
+	p.pubsub.SetAcceptFilter(p.acceptPubSub)
+
+	p.pubsub.SetAppScore(p.scores.Score)
+
+	join := func(topic string, validator TopicValidator, handler TopicHandler) {
+		p.pubsub.Join(topic, DefaultTopicScoreParams(topicWeights[topic]), handler)
+		p.pubsub.SetValidator(topic, p.scoredValidator(topic, validator))
+	}
+	join(TopicBlocks, p.validateBlockMessage, p.handleBlockMessage)
+	join(TopicCompactBlocks, p.validateCompactBlockMessage, p.handleCompactBlockMessage)
+	join(TopicVotes, p.validateVoteMessage, p.handleVoteMessage)
+	join(TopicTransactions, p.validateTransactionMessage, p.handleTransactionMessage)
+	join(TopicVRFProofs, p.validateVRFProofMessage, p.handleVRFProofMessage)
+	join(TopicEvidence, p.validateEvidenceMessage, p.handleEvidenceMessage)
+	join(TopicValidatorRecords, p.validateValidatorRecordMessage, p.handleValidatorRecordMessage)
+}
+
+// scoredValidator counts the results of validator in the peer score: valid
+// messages are useful contributions, rejected ones invalid messages
+func (p *P2PNetwork) scoredValidator(topic string, validator TopicValidator) TopicValidator {
+	weight := topicWeights[topic]
+	return func(from peer.ID, data []byte) ValidationResult {
+		result := validator(from, data)
+		if from == p.Host.ID() {
+			return result
+		}
+		switch result {
+		case ValidationAccept:
+			p.reportPeer(from, PeerEventValidMessage, weight, "")
+		case ValidationReject:
+			p.reportPeer(from, PeerEventInvalidMessage, weight, "rejected on "+topic)
+		}
+		return result
+	}
+}
+
+// trackConnections keeps the peer list in step with the host's connections
+// and handshakes with every peer, inbound or outbound. Accepted peers are
+// authenticated, and their authentications renewed before they lapse.
+func (p *P2PNetwork) trackConnections() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, pid := range p.Host.Network().Peers() {
+					if !p.hasStatus(pid) || !p.authManager.NeedsReauthentication(pid) {
+						continue
+					}
+					if err := p.AuthenticatePeerViaProtocol(pid); err != nil {
+						log.Printf("⚠️  Failed to renew authentication of peer %s: %v", shortPeerID(pid), err)
+					}
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	p.Host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			pid := conn.RemotePeer()
+			p.mu.Lock()
+			known := p.peers[pid]
+			p.peers[pid] = true
+			p.mu.Unlock()
+			if known {
+				return
+			}
+
+			go func() {
+				time.Sleep(500 * time.Millisecond) // Brief delay to ensure connection stable
+				p.handshake(pid)
+			}()
+		},
+		DisconnectedF: func(n network.Network, conn network.Conn) {
+			pid := conn.RemotePeer()
+			if n.Connectedness(pid) == network.Connected {
+				return
+			}
+			p.mu.Lock()
+			delete(p.peers, pid)
+			delete(p.peerStatus, pid)
+			p.mu.Unlock()
+		},
+	})
+}
+
+// acceptPubSub admits gossiped messages only from authenticated peers within
+// their bandwidth limit, which unconditional peers are exempt from
+func (p *P2PNetwork) acceptPubSub(from peer.ID, size int) bool {
+	if !p.authManager.IsAuthenticated(from) {
+		return false
+	}
+	if p.IsUnconditionalPeer(from) {
+		return true
+	}
+	if p.rateLimiter.GetPeerLimit(from) == nil {
+		p.rateLimiter.AllowRequest(from) // Starts tracking the peer
+	}
+	if allowed, err := p.rateLimiter.AllowBytes(from, int64(size)); !allowed {
+		log.Printf("⚠️  Bandwidth limit exceeded for peer %s: %v", shortPeerID(from), err)
+		return false
+	}
+	return true
+}
+
+// SetBlockValidator sets the hook gossiped blocks must pass to be relayed
+func (p *P2PNetwork) SetBlockValidator(validator BlockValidator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blockValidator = validator
+}
+
+// SetVoteValidator sets the hook gossiped votes must pass to be relayed
+func (p *P2PNetwork) SetVoteValidator(validator VoteValidator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.voteValidator = validator
+}
+
+// SetTransactionValidator sets the hook gossiped transactions must pass to
+// be relayed
+func (p *P2PNetwork) SetTransactionValidator(validator TransactionValidator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.txValidator = validator
+}
+
+// SetVRFProofValidator sets the hook gossiped VRF proofs must pass to be
+// relayed
+func (p *P2PNetwork) SetVRFProofValidator(validator VRFProofValidator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.vrfProofValidator = validator
+}
+
+// SetEvidenceValidator sets the hook gossiped evidence must pass to be
+// relayed
+func (p *P2PNetwork) SetEvidenceValidator(validator EvidenceValidator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evidenceValidator = validator
+}
+
+// SetEvidenceHandler sets the handler of gossiped evidence
+func (p *P2PNetwork) SetEvidenceHandler(handler EvidenceHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evidenceHandler = handler
+}
+
+// BroadcastEvidence gossips JSON encoded misbehavior evidence
+func (p *P2PNetwork) BroadcastEvidence(evidence []byte) error {
+	return p.pubsub.Publish(TopicEvidence, evidence)
+}
+
+// PubSubStats returns the pubsub router's statistics
+func (p *P2PNetwork) PubSubStats() map[string]interface{} {
+	return p.pubsub.GetStats()
+}
+
+// PeerScore returns a peer's pubsub score
+func (p *P2PNetwork) PeerScore(pid peer.ID) float64 {
+	return p.pubsub.PeerScore(pid)
+}
+
+func decodeBlock(data []byte) (*core.Block, bool) {
+	var block core.Block
+	if err := json.Unmarshal(data, &block); err != nil || block.Header == nil || block.ProposerID == "" {
+		return nil, false
+	}
+	return &block, true
+}
+
+func (p *P2PNetwork) validateBlockMessage(_ peer.ID, data []byte) ValidationResult {
+	block, ok := decodeBlock(data)
+	if !ok {
+		return ValidationReject
+	}
+	return p.checkBlock(block)
+}
+
+// checkBlock asks the block validator hook about a complete block
+func (p *P2PNetwork) checkBlock(block *core.Block) ValidationResult {
+	p.mu.RLock()
+	validator := p.blockValidator
+	p.mu.RUnlock()
+	if validator != nil {
+		return validator(block)
+	}
+	return ValidationAccept
+}
+
+func (p *P2PNetwork) handleBlockMessage(_ peer.ID, data []byte) {
+	block, ok := decodeBlock(data)
+	if !ok || p.blockHandler == nil {
+		return
+	}
+	if err := p.blockHandler(block); err != nil {
+		log.Printf("⚠️  Block handler error: %v", err)
+	}
+}
+
+func decodeVote(data []byte) (*voteMessage, bool) {
+	var vote voteMessage
+	if err := json.Unmarshal(data, &vote); err != nil || len(vote.BlockHash) == 0 || vote.ValidatorID == "" || len(vote.Signature) == 0 {
+		return nil, false
+	}
+	return &vote, true
+}
+
+func (p *P2PNetwork) validateVoteMessage(_ peer.ID, data []byte) ValidationResult {
+	vote, ok := decodeVote(data)
+	if !ok {
+		return ValidationReject
+	}
+	p.mu.RLock()
+	validator := p.voteValidator
+	p.mu.RUnlock()
+	if validator != nil {
+		return validator(vote.BlockHash, vote.ValidatorID, vote.Signature)
+	}
+	return ValidationAccept
+}
+
+func (p *P2PNetwork) handleVoteMessage(_ peer.ID, data []byte) {
+	vote, ok := decodeVote(data)
+	if !ok || p.voteHandler == nil {
+		return
+	}
+	if err := p.voteHandler(vote.BlockHash, vote.ValidatorID, vote.Signature); err != nil {
+		log.Printf("⚠️  Vote handler error: %v", err)
+	}
+}
+
+func decodeTransaction(data []byte) (*core.Transaction, bool) {
+	var tx core.Transaction
+	if err := json.Unmarshal(data, &tx); err != nil || tx.ID == "" {
+		return nil, false
+	}
+	return &tx, true
+}
+
+func (p *P2PNetwork) validateTransactionMessage(_ peer.ID, data []byte) ValidationResult {
+	tx, ok := decodeTransaction(data)
+	if !ok {
+		return ValidationReject
+	}
+	p.mu.RLock()
+	validator := p.txValidator
+	p.mu.RUnlock()
+	if validator != nil {
+		return validator(tx)
+	}
+	return ValidationAccept
+}
+
+func (p *P2PNetwork) handleTransactionMessage(_ peer.ID, data []byte) {
+	tx, ok := decodeTransaction(data)
+	if !ok || p.txHandler == nil {
+		return
+	}
+	if err := p.txHandler(tx); err != nil {
+		log.Printf("⚠️  Transaction handler error: %v", err)
+	}
+}
+
+func decodeVRFProof(data []byte) (*VRFProofMessage, bool) {
+	var msg VRFProofMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.ProposerID == "" || len(msg.VRFProof) == 0 {
+		return nil, false
+	}
+	return &msg, true
+}
+
+func (p *P2PNetwork) validateVRFProofMessage(_ peer.ID, data []byte) ValidationResult {
+	msg, ok := decodeVRFProof(data)
+	if !ok {
+		return ValidationReject
+	}
+	p.mu.RLock()
+	validator := p.vrfProofValidator
+	p.mu.RUnlock()
+	if validator != nil {
+		return validator(msg)
+	}
+	return ValidationAccept
+}
+
+func (p *P2PNetwork) handleVRFProofMessage(_ peer.ID, data []byte) {
+	msg, ok := decodeVRFProof(data)
+	if !ok {
+		return
+	}
+	p.mu.RLock()
+	handler := p.vrfProofHandler
+	p.mu.RUnlock()
+	if handler == nil {
+		return
+	}
+	if err := handler(msg); err != nil {
+		log.Printf("⚠️  VRF proof handler error: %v", err)
+	}
+}
+
+func (p *P2PNetwork) validateEvidenceMessage(_ peer.ID, data []byte) ValidationResult {
+	if !json.Valid(data) {
+		return ValidationReject
+	}
+	p.mu.RLock()
+	validator := p.evidenceValidator
+	p.mu.RUnlock()
+	if validator != nil {
+		return validator(data)
+	}
+	return ValidationAccept
+}
+
+func (p *P2PNetwork) handleEvidenceMessage(_ peer.ID, data []byte) {
+	p.mu.RLock()
+	handler := p.evidenceHandler
+	p.mu.RUnlock()
+	if handler == nil {
+		return
+	}
+	if err := handler(data); err != nil {
+		log.Printf("⚠️  Evidence handler error: %v", err)
+	}
+}
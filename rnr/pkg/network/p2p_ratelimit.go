@@ -17,6 +17,8 @@ type RateLimiter struct {
 	maxRequestsPerMinute int
 	maxBytesPerSecond    int64
 	burstSize            int
+
+	onViolation func(peerID peer.ID, err error) // Called with the limiter locked
 }
 
 // PeerLimit tracks rate limit state for a single peer
@@ -42,6 +44,22 @@ func NewRateLimiter(maxRequestsPerMinute, burstSize int, maxBytesPerSecond int64
 	}
 }
 
+// SetViolationHandler sets a handler called on every request or bandwidth
+// limit violation. It must not call back into the limiter.
+func (rl *RateLimiter) SetViolationHandler(handler func(peerID peer.ID, err error)) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.onViolation = handler
+}
+
+// violation reports err to the violation handler and returns it
+func (rl *RateLimiter) violation(peerID peer.ID, err error) error {
+	if rl.onViolation != nil {
+		rl.onViolation(peerID, err)
+	}
+	return err
+}
+
 // AllowRequest checks if a request from peer is allowed
 // SECURITY: Token bucket algorithm prevents request spam
 func (rl *RateLimiter) AllowRequest(peerID peer.ID) (bool, error) {
@@ -94,10 +112,10 @@ func (rl *RateLimiter) AllowRequest(peerID peer.ID) (bool, error) {
 		if limit.ViolationCount >= 5 {
 			limit.IsBanned = true
 			limit.BanExpiry = now.Add(10 * time.Minute)
-			return false, fmt.Errorf("peer %s banned for excessive rate limit violations", peerID.String()[:8])
+			return false, rl.violation(peerID, fmt.Errorf("peer %s banned for excessive rate limit violations", peerID.String()[:8]))
 		}
 
-		return false, fmt.Errorf("rate limit exceeded for peer %s", peerID.String()[:8])
+		return false, rl.violation(peerID, fmt.Errorf("rate limit exceeded for peer %s", peerID.String()[:8]))
 	}
 
 	// Consume one token
@@ -133,10 +151,10 @@ func (rl *RateLimiter) AllowBytes(peerID peer.ID, bytes int64) (bool, error) {
 		if limit.ViolationCount >= 3 {
 			limit.IsBanned = true
 			limit.BanExpiry = now.Add(5 * time.Minute)
-			return false, fmt.Errorf("peer %s banned for bandwidth abuse", peerID.String()[:8])
+			return false, rl.violation(peerID, fmt.Errorf("peer %s banned for bandwidth abuse", peerID.String()[:8]))
 		}
 
-		return false, fmt.Errorf("bandwidth limit exceeded for peer %s", peerID.String()[:8])
+		return false, rl.violation(peerID, fmt.Errorf("bandwidth limit exceeded for peer %s", peerID.String()[:8]))
 	}
 
 	// Allow bytes
@@ -181,6 +199,15 @@ func (rl *RateLimiter) BanPeer(peerID peer.ID, duration time.Duration) {
 	limit.BanExpiry = time.Now().Add(duration)
 }
 
+// IsBanned reports whether peer is currently banned
+func (rl *RateLimiter) IsBanned(peerID peer.ID) bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	limit, exists := rl.limits[peerID]
+	return exists && limit.IsBanned && time.Now().Before(limit.BanExpiry)
+}
+
 // CleanupOldLimits removes rate limit data for peers not seen recently
 func (rl *RateLimiter) CleanupOldLimits() {
 	rl.mu.Lock()
@@ -0,0 +1,164 @@
+package network
+
+import (
+	"log"
+	"math"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// The peer score gates connections and is added to the pubsub score, so a
+// peer that misbehaves on any protocol loses its gossip privileges before
+// it is banned. Penalties are also counted against the peer's IP, which the
+// IP reputation system blacklists independently of peer IDs.
+
+// peerEventSeverities are the IP reputation severities (1-10) of penalized
+// events at weight 1. Only Byzantine behavior reaches the severity at which
+// an IP is blacklisted at once.
+var peerEventSeverities = map[PeerEvent]float64{
+	PeerEventProtocolViolation: 3,
+	PeerEventInvalidMessage:    2,
+	PeerEventRateLimit:         1,
+	PeerEventAuthFailure:       4,
+	PeerEventWrongChain:        3,
+	PeerEventByzantine:         8,
+}
+
+// connectionGater refuses connections of banned peers and blacklisted IPs,
+// and those the connection manager has no slot for. Unconditional peers are
+// always let through.
+type connectionGater struct {
+	scores       *PeerScores
+	rateLimiter  *RateLimiter
+	ipReputation *IPReputationSystem
+	peerConfig   *peerConfig
+	connManager  *connManager
+}
+
+func (g *connectionGater) allowPeer(pid peer.ID) bool {
+	if g.peerConfig.unconditional[pid] {
+		return true
+	}
+	return !g.scores.IsBanned(pid) && !g.rateLimiter.IsBanned(pid)
+}
+
+func (g *connectionGater) allowAddr(addr multiaddr.Multiaddr) bool {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return true // Relayed and DNS addresses carry no IP to judge
+	}
+	return g.ipReputation.GetReputationStatus(ip) != ReputationBlacklisted
+}
+
+func (g *connectionGater) InterceptPeerDial(pid peer.ID) bool {
+	return g.allowPeer(pid) && g.connManager.admitDial(pid)
+}
+
+func (g *connectionGater) InterceptAddrDial(pid peer.ID, addr multiaddr.Multiaddr) bool {
+	return g.peerConfig.unconditional[pid] || g.allowAddr(addr)
+}
+
+func (g *connectionGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return g.allowAddr(addrs.RemoteMultiaddr())
+}
+
+func (g *connectionGater) InterceptSecured(direction network.Direction, pid peer.ID, addrs network.ConnMultiaddrs) bool {
+	return g.allowPeer(pid) && g.connManager.admit(direction, pid, addrs.RemoteMultiaddr())
+}
+
+func (g *connectionGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// isBanned reports whether pid is banned by its score or the rate limiter
+func (p *P2PNetwork) isBanned(pid peer.ID) bool {
+	return !p.gater.allowPeer(pid)
+}
+
+// reportPeer scores an event of pid at weight times its usual score
+// change, and disconnects the peer if that got it banned
+func (p *P2PNetwork) reportPeer(pid peer.ID, event PeerEvent, weight float64, detail string) {
+	delta, banned := p.scores.Record(pid, event, weight, detail)
+	if ip := p.peerIP(pid); ip != nil {
+		if delta < 0 {
+			severity := int(math.Ceil(peerEventSeverities[event] * weight))
+			if severity < 1 {
+				severity = 1
+			}
+			p.ipReputation.RecordMisbehavior(ip, string(event), severity, detail)
+		} else if event == PeerEventBlocksServed {
+			p.ipReputation.RecordSuccess(ip, string(event))
+		}
+	}
+	if banned && !p.IsUnconditionalPeer(pid) {
+		log.Printf("🚫 Banned peer %s after %s: %s", shortPeerID(pid), event, detail)
+		p.Host.Network().ClosePeer(pid)
+	}
+}
+
+// banPeer bans pid for duration whatever its score, and disconnects it
+func (p *P2PNetwork) banPeer(pid peer.ID, duration time.Duration, reason string) {
+	if p.IsUnconditionalPeer(pid) {
+		return
+	}
+	p.scores.Ban(pid, duration, reason)
+	p.Host.Network().ClosePeer(pid)
+}
+
+// peerIP returns the IP of a connection to pid, or nil
+func (p *P2PNetwork) peerIP(pid peer.ID) net.IP {
+	for _, conn := range p.Host.Network().ConnsToPeer(pid) {
+		if ip, err := manet.ToIP(conn.RemoteMultiaddr()); err == nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// ReportValidatorMisbehavior penalizes the peer of a validator whose
+// misbehavior this node detected itself. severity is 1-10, as in Byzantine
+// evidence; 10 bans the peer at once.
+func (p *P2PNetwork) ReportValidatorMisbehavior(validatorID string, severity int, detail string) {
+	pid, err := p.GetPeerIDByValidatorID(validatorID)
+	if err != nil {
+		return
+	}
+	p.reportPeer(pid, PeerEventByzantine, float64(severity)/10, detail)
+}
+
+// GetPeerScore returns a peer's score and history, or nil if it has none
+func (p *P2PNetwork) GetPeerScore(peerID string) *PeerScoreRecord {
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return nil
+	}
+	return p.scores.Get(pid)
+}
+
+// GetPeerScores returns the scores of all scored peers, lowest first
+func (p *P2PNetwork) GetPeerScores() []*PeerScoreRecord {
+	return p.scores.All()
+}
+
+// GetBannedPeerScores returns the records of banned peers, whose Ban
+// explains the ban
+func (p *P2PNetwork) GetBannedPeerScores() []*PeerScoreRecord {
+	return p.scores.Banned()
+}
+
+// UnbanPeer lifts a peer's ban
+func (p *P2PNetwork) UnbanPeer(peerID string) error {
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return err
+	}
+	p.scores.Unban(pid)
+	p.rateLimiter.ResetPeer(pid)
+	return nil
+}
@@ -0,0 +1,207 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Status handshake: right after connecting, both peers exchange their chain
+// ID, genesis hash, protocol version, head and finalized checkpoint. A peer
+// on another chain or speaking an incompatible version is disconnected and
+// banned for a while. Only peers whose status was accepted are
+// authenticated and heard on pubsub; their head is passed to the peer
+// status handler, which starts sync when the peer is ahead.
+
+const (
+	StatusProtocol = "/rnr/status/1.0.0"
+
+	NetworkProtocolVersion    uint32 = 2 // Version this node speaks; 2 frames all messages
+	MinNetworkProtocolVersion uint32 = 2 // Oldest version it accepts
+)
+
+const (
+	statusTimeout  = 10 * time.Second
+	statusMaxBytes = 4096
+)
+
+// StatusMessage describes a node's chain and head
+type StatusMessage struct {
+	ChainID         string `json:"chain_id"`
+	GenesisHash     []byte `json:"genesis_hash"`
+	ProtocolVersion uint32 `json:"protocol_version"`
+	HeadHeight      uint64 `json:"head_height"`
+	HeadHash        []byte `json:"head_hash"`
+	FinalizedHeight uint64 `json:"finalized_height"`
+	FinalizedHash   []byte `json:"finalized_hash"`
+}
+
+// StatusProvider returns this node's current status. ProtocolVersion is
+// filled in by the network.
+type StatusProvider func() *StatusMessage
+
+// PeerStatusHandler receives the status of a newly accepted peer
+type PeerStatusHandler func(peerID string, status *StatusMessage)
+
+// SetStatusProvider sets the source of this node's status. Peers that
+// connect before it is set are disconnected and may retry.
+func (p *P2PNetwork) SetStatusProvider(provider StatusProvider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statusProvider = provider
+}
+
+// SetPeerStatusHandler sets the handler of accepted peer statuses
+func (p *P2PNetwork) SetPeerStatusHandler(handler PeerStatusHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peerStatusHandler = handler
+}
+
+// GetPeerStatus returns the status a peer sent in its handshake, or nil
+func (p *P2PNetwork) GetPeerStatus(peerID string) *StatusMessage {
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.peerStatus[pid]
+}
+
+func (p *P2PNetwork) hasStatus(pid peer.ID) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.peerStatus[pid] != nil
+}
+
+func (p *P2PNetwork) localStatus() (*StatusMessage, error) {
+	p.mu.RLock()
+	provider := p.statusProvider
+	p.mu.RUnlock()
+	if provider == nil {
+		return nil, fmt.Errorf("local status not available yet")
+	}
+	status := provider()
+	status.ProtocolVersion = NetworkProtocolVersion
+	return status, nil
+}
+
+// checkStatus returns why remote cannot be a peer of local, or nil
+func checkStatus(local, remote *StatusMessage) error {
+	if remote.ProtocolVersion < MinNetworkProtocolVersion {
+		return fmt.Errorf("protocol version %d older than %d", remote.ProtocolVersion, MinNetworkProtocolVersion)
+	}
+	if remote.ChainID != local.ChainID {
+		return fmt.Errorf("chain ID %q, expected %q", remote.ChainID, local.ChainID)
+	}
+	if !bytes.Equal(remote.GenesisHash, local.GenesisHash) {
+		return fmt.Errorf("genesis %x, expected %x", shortHash(remote.GenesisHash), shortHash(local.GenesisHash))
+	}
+	return nil
+}
+
+func shortHash(hash []byte) []byte {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+// handshake sends this node's status to pid and checks the one it answers
+func (p *P2PNetwork) handshake(pid peer.ID) {
+	if p.isBanned(pid) {
+		p.Host.Network().ClosePeer(pid)
+		return
+	}
+
+	local, err := p.localStatus()
+	if err != nil {
+		log.Printf("⚠️  Handshake with %s deferred: %v", shortPeerID(pid), err)
+		p.Host.Network().ClosePeer(pid)
+		return
+	}
+
+	// A peer pruned or gone since it connected is not redialed
+	stream, err := p.Host.NewStream(network.WithNoDial(p.ctx, "status handshake"), pid, protocol.ID(StatusProtocol))
+	if err != nil {
+		log.Printf("⚠️  Failed to open status stream to %s: %v", shortPeerID(pid), err)
+		return
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(statusTimeout))
+
+	if err := p.writeMessage(stream, core.MessageTypeStatus, local); err != nil {
+		log.Printf("⚠️  Failed to send status to %s: %v", shortPeerID(pid), err)
+		return
+	}
+	var remote StatusMessage
+	if _, err := ReadMessageOf(stream, core.MessageTypeStatus, &remote); err != nil {
+		log.Printf("⚠️  Failed to read status of %s: %v", shortPeerID(pid), err)
+		return
+	}
+	p.acceptStatus(stream.Conn(), local, &remote)
+}
+
+// handleStatusStream answers a peer's handshake
+func (p *P2PNetwork) handleStatusStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(statusTimeout))
+
+	var remote StatusMessage
+	if _, err := ReadMessageOf(stream, core.MessageTypeStatus, &remote); err != nil {
+		log.Printf("⚠️  Failed to read status of %s: %v", shortPeerID(stream.Conn().RemotePeer()), err)
+		return
+	}
+	local, err := p.localStatus()
+	if err != nil {
+		stream.Reset()
+		return
+	}
+	if err := p.writeMessage(stream, core.MessageTypeStatus, local); err != nil {
+		return
+	}
+	p.acceptStatus(stream.Conn(), local, &remote)
+}
+
+// acceptStatus records the status of the peer of conn, or disconnects and
+// bans it if it is on another chain. Unconditional peers are disconnected
+// but not banned.
+func (p *P2PNetwork) acceptStatus(conn network.Conn, local, remote *StatusMessage) {
+	pid := conn.RemotePeer()
+	if err := checkStatus(local, remote); err != nil {
+		log.Printf("🚫 Disconnecting peer %s: %v", shortPeerID(pid), err)
+		p.addressBook.Remove(pid)
+		p.reportPeer(pid, PeerEventWrongChain, 1, err.Error())
+		p.Host.Network().ClosePeer(pid)
+		return
+	}
+
+	p.mu.Lock()
+	known := p.peerStatus[pid] != nil
+	p.peerStatus[pid] = remote
+	handler := p.peerStatusHandler
+	p.mu.Unlock()
+	if known {
+		return
+	}
+	p.recordPeer(pid)
+	go p.sendValidatorRecord(pid)
+
+	log.Printf("🤝 Peer %s on %s at height %d (finalized %d)", shortPeerID(pid), remote.ChainID, remote.HeadHeight, remote.FinalizedHeight)
+	go func() {
+		if err := p.AuthenticatePeerViaProtocol(pid); err != nil {
+			log.Printf("⚠️  Failed to authenticate peer %s: %v", shortPeerID(pid), err)
+		}
+	}()
+	if handler != nil {
+		handler(pid.String(), remote)
+	}
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// newTestNode starts a node on a random local port, reporting a head on
+// chainID
+func newTestNode(t *testing.T, config P2PConfig, chainID string) *P2PNetwork {
+	if config.DataDir == "" {
+		config.DataDir = t.TempDir()
+	}
+	p, err := NewP2PNetwork(config)
+	if err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	p.SetStatusProvider(func() *StatusMessage {
+		return &StatusMessage{ChainID: chainID, GenesisHash: []byte("genesis-" + chainID), HeadHeight: 10}
+	})
+	return p
+}
+
+// connectNodes dials b from a over loopback
+func connectNodes(a, b *P2PNetwork) error {
+	for _, addr := range b.Host.Addrs() {
+		if ip, err := manet.ToIP(addr); err == nil && ip.IsLoopback() {
+			return a.ConnectToPeer(fmt.Sprintf("%s/p2p/%s", addr, b.Host.ID()))
+		}
+	}
+	return fmt.Errorf("node has no loopback address")
+}
+
+// waitFor polls cond until it holds or a few seconds pass
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return cond()
+}
+
+// TestCheckStatus tests that peers on another chain, genesis or too old a
+// protocol version are refused
+func TestCheckStatus(t *testing.T) {
+	local := &StatusMessage{ChainID: "rnr-1", GenesisHash: []byte("genesis"), ProtocolVersion: NetworkProtocolVersion}
+	cases := []struct {
+		name   string
+		remote StatusMessage
+		err    string
+	}{
+		{"same chain", StatusMessage{ChainID: "rnr-1", GenesisHash: []byte("genesis"), ProtocolVersion: NetworkProtocolVersion, HeadHeight: 99}, ""},
+		{"other chain", StatusMessage{ChainID: "rnr-2", GenesisHash: []byte("genesis"), ProtocolVersion: NetworkProtocolVersion}, "chain ID"},
+		{"other genesis", StatusMessage{ChainID: "rnr-1", GenesisHash: []byte("fork"), ProtocolVersion: NetworkProtocolVersion}, "genesis"},
+		{"old version", StatusMessage{ChainID: "rnr-1", GenesisHash: []byte("genesis"), ProtocolVersion: MinNetworkProtocolVersion - 1}, "protocol version"},
+		{"newer version", StatusMessage{ChainID: "rnr-1", GenesisHash: []byte("genesis"), ProtocolVersion: NetworkProtocolVersion + 1}, ""},
+	}
+	for _, tc := range cases {
+		err := checkStatus(local, &tc.remote)
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: expected the peer accepted, got %v", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+// TestStatusHandshake tests that connected nodes on the same chain learn
+// each other's head, and that a node on another chain is disconnected and
+// banned
+func TestStatusHandshake(t *testing.T) {
+	a := newTestNode(t, P2PConfig{}, "rnr-1")
+	b := newTestNode(t, P2PConfig{}, "rnr-1")
+	other := newTestNode(t, P2PConfig{}, "rnr-2")
+
+	accepted := make(chan string, 1)
+	a.SetPeerStatusHandler(func(peerID string, status *StatusMessage) {
+		accepted <- peerID
+	})
+	if err := connectNodes(a, b); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	select {
+	case peerID := <-accepted:
+		if peerID != b.Host.ID().String() {
+			t.Errorf("Handler got status of %s, expected %s", peerID, b.Host.ID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Status handshake did not complete")
+	}
+	status := a.GetPeerStatus(b.Host.ID().String())
+	if status == nil || status.HeadHeight != 10 || status.ProtocolVersion != NetworkProtocolVersion {
+		t.Errorf("Expected the peer's status recorded, got %+v", status)
+	}
+	if !waitFor(func() bool { return b.GetPeerStatus(a.Host.ID().String()) != nil }) {
+		t.Errorf("Answering node should record the dialer's status too")
+	}
+
+	// Both nodes start a handshake; whichever checks the other's status
+	// first disconnects, and the other may not get to read an answer
+	connectNodes(a, other)
+	banned := func() bool { return a.scores.IsBanned(other.Host.ID()) || other.scores.IsBanned(a.Host.ID()) }
+	if !waitFor(banned) {
+		t.Fatalf("Node on another chain should be banned")
+	}
+	if !waitFor(func() bool { return len(a.Host.Network().ConnsToPeer(other.Host.ID())) == 0 }) {
+		t.Errorf("Node on another chain should be disconnected")
+	}
+	if other.GetPeerStatus(a.Host.ID().String()) != nil || a.GetPeerStatus(other.Host.ID().String()) != nil {
+		t.Errorf("Nodes on different chains should not record each other as peers")
+	}
+}
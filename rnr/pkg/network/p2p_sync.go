@@ -0,0 +1,149 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Block sync: a node behind a peer's head, as learned in the status
+// handshake, requests the missing blocks from it in ranges. The peer answers
+// with the first blocks of a range that fit in maxSyncResponseBytes, and
+// the node requests the rest next.
+
+const SyncProtocol = "/rnr/sync/1.0.0"
+
+const (
+	MaxSyncBatch         = 100      // Blocks per sync request at most
+	maxSyncResponseBytes = 32 << 20 // Blocks past this are left out of a response, unless it is the first
+	syncTimeout          = 30 * time.Second
+)
+
+// BlockRangeHandler returns the blocks of heights start to end
+type BlockRangeHandler func(start, end uint64) ([]*core.Block, error)
+
+type blockRangeRequest struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// SetBlockRangeHandler sets the handler serving peers' sync requests
+func (p *P2PNetwork) SetBlockRangeHandler(handler BlockRangeHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blockRangeHandler = handler
+}
+
+// RequestBlocks requests the blocks of heights start to end from a peer
+func (p *P2PNetwork) RequestBlocks(peerID string, start, end uint64) ([]*core.Block, error) {
+	if end < start || end-start >= MaxSyncBatch {
+		return nil, fmt.Errorf("invalid block range %d-%d", start, end)
+	}
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	stream, err := p.Host.NewStream(p.ctx, pid, protocol.ID(SyncProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(syncTimeout))
+
+	if err := p.writeMessage(stream, core.MessageTypeSyncRequest, &blockRangeRequest{Start: start, End: end}); err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+	stream.CloseWrite()
+
+	var blocks []*core.Block
+	if _, err := ReadMessageOf(stream, core.MessageTypeSyncResponse, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to read blocks: %w", err)
+	}
+	for i, block := range blocks {
+		if block == nil || block.Header == nil || block.Header.Height != start+uint64(i) {
+			p.reportPeer(pid, PeerEventProtocolViolation, 1, "sync response out of the requested range")
+			return nil, fmt.Errorf("peer sent blocks out of the requested range")
+		}
+	}
+	if len(blocks) > 0 {
+		p.reportPeer(pid, PeerEventBlocksServed, 1, "")
+	}
+	return blocks, nil
+}
+
+// handleSyncStream serves a sync request of a peer whose status was accepted
+func (p *P2PNetwork) handleSyncStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(syncTimeout))
+
+	remotePeer := stream.Conn().RemotePeer()
+	if !p.hasStatus(remotePeer) {
+		stream.Reset()
+		return
+	}
+	if allowed, err := p.rateLimiter.AllowRequest(remotePeer); !allowed && !p.IsUnconditionalPeer(remotePeer) {
+		log.Printf("⚠️  Rate limit exceeded for peer %s: %v", shortPeerID(remotePeer), err)
+		stream.Reset()
+		return
+	}
+
+	var req blockRangeRequest
+	if _, err := ReadMessageOf(stream, core.MessageTypeSyncRequest, &req); err != nil {
+		stream.Reset()
+		return
+	}
+	if req.End < req.Start || req.End-req.Start >= MaxSyncBatch {
+		p.reportPeer(remotePeer, PeerEventProtocolViolation, 1, "invalid sync range")
+		stream.Reset()
+		return
+	}
+
+	p.mu.RLock()
+	handler := p.blockRangeHandler
+	p.mu.RUnlock()
+	blocks := []*core.Block{}
+	if handler != nil {
+		var err error
+		if blocks, err = handler(req.Start, req.End); err != nil {
+			log.Printf("⚠️  Failed to serve blocks %d-%d: %v", req.Start, req.End, err)
+			WriteError(stream, "blocks unavailable")
+			return
+		}
+	}
+	response, err := syncResponse(blocks)
+	if err != nil {
+		log.Printf("⚠️  Failed to encode blocks %d-%d: %v", req.Start, req.End, err)
+		WriteError(stream, "blocks unavailable")
+		return
+	}
+	if err := p.writeMessage(stream, core.MessageTypeSyncResponse, response); err != nil {
+		log.Printf("⚠️  Failed to send blocks to %s: %v", shortPeerID(remotePeer), err)
+	}
+}
+
+// syncResponse encodes the first blocks that fit in maxSyncResponseBytes,
+// and the first block whatever its size so the requester makes progress
+func syncResponse(blocks []*core.Block) ([]json.RawMessage, error) {
+	response := make([]json.RawMessage, 0, len(blocks))
+	total := 0
+	for _, block := range blocks {
+		data, err := json.Marshal(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(response) > 0 && total+len(data) > maxSyncResponseBytes {
+			break
+		}
+		response = append(response, data)
+		total += len(data)
+	}
+	return response, nil
+}
@@ -0,0 +1,347 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Peer scoring: protocol violations, invalid messages, rate limit hits,
+// failed authentications and Byzantine behavior lower a peer's score, and
+// useful contributions raise it. The score decays towards zero, so old
+// offenses are forgiven, and is saved with the node's data so a restart does
+// not clear it. A peer whose score falls to the ban threshold is banned; the
+// events that led there are kept to explain the ban.
+
+const PeerScoreFile = "peerscores.json"
+
+// PeerEvent is a kind of scored peer behavior
+type PeerEvent string
+
+const (
+	PeerEventProtocolViolation PeerEvent = "protocol_violation" // Malformed or out of protocol messages
+	PeerEventInvalidMessage    PeerEvent = "invalid_message"    // Gossiped messages that failed validation
+	PeerEventRateLimit         PeerEvent = "rate_limit"         // Request or bandwidth limit exceeded
+	PeerEventAuthFailure       PeerEvent = "auth_failure"       // Wrong challenge solution
+	PeerEventWrongChain        PeerEvent = "wrong_chain"        // Another chain or incompatible version
+	PeerEventByzantine         PeerEvent = "byzantine"          // Consensus misbehavior of the peer's validator
+	PeerEventValidMessage      PeerEvent = "valid_message"      // Gossiped messages that passed validation
+	PeerEventBlocksServed      PeerEvent = "blocks_served"      // Sync requests answered
+)
+
+// peerEventScores are the score changes of events at weight 1
+var peerEventScores = map[PeerEvent]float64{
+	PeerEventProtocolViolation: -25,
+	PeerEventInvalidMessage:    -10,
+	PeerEventRateLimit:         -5,
+	PeerEventAuthFailure:       -20,
+	PeerEventWrongChain:        -100,
+	PeerEventByzantine:         -100,
+	PeerEventValidMessage:      0.2,
+	PeerEventBlocksServed:      1,
+}
+
+const (
+	maxPeerScore       = 20.0   // Good behavior cannot bank more credit than this
+	banPeerScore       = -100.0 // At or below: the peer is banned
+	peerScoreHalfLife  = time.Hour
+	peerBanDuration    = time.Hour // Doubles with each ban of the same peer
+	maxPeerBanDuration = 7 * 24 * time.Hour
+	peerScoreEvents    = 32                 // Recent events kept per peer
+	peerScoreRetention = 7 * 24 * time.Hour // Idle, neutral peers are dropped after this
+	maxScoredPeers     = 5000
+)
+
+// PeerScoreEvent is a scored event in a peer's history
+type PeerScoreEvent struct {
+	Time   time.Time `json:"time"`
+	Event  PeerEvent `json:"event"`
+	Delta  float64   `json:"delta"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// PeerBan records why and until when a peer is banned
+type PeerBan struct {
+	Since  time.Time        `json:"since"`
+	Until  time.Time        `json:"until"`
+	Reason string           `json:"reason"`
+	Events []PeerScoreEvent `json:"events"` // The peer's events up to the ban
+}
+
+// PeerScoreRecord is a peer's score and history
+type PeerScoreRecord struct {
+	PeerID  string           `json:"peer_id"`
+	Score   float64          `json:"score"`
+	Updated time.Time        `json:"updated"`
+	Events  []PeerScoreEvent `json:"events"`
+	Bans    int              `json:"bans"`
+	Ban     *PeerBan         `json:"ban,omitempty"` // Latest ban, possibly expired
+}
+
+// Banned reports whether the record's latest ban is still in force
+func (r *PeerScoreRecord) Banned(now time.Time) bool {
+	return r.Ban != nil && now.Before(r.Ban.Until)
+}
+
+// PeerScores keeps the scores of peers, saved as JSON
+type PeerScores struct {
+	path  string
+	mu    sync.Mutex
+	peers map[peer.ID]*PeerScoreRecord
+	dirty bool
+}
+
+// NewPeerScores loads the peer scores at path, decayed by the time since
+// they were saved. With an empty path the scores are only kept in memory.
+func NewPeerScores(path string) (*PeerScores, error) {
+	ps := &PeerScores{
+		path:  path,
+		peers: make(map[peer.ID]*PeerScoreRecord),
+	}
+	if path == "" {
+		return ps, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer scores: %w", err)
+	}
+	var records []*PeerScoreRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse peer scores %s: %w", path, err)
+	}
+	now := time.Now()
+	for _, record := range records {
+		pid, err := peer.Decode(record.PeerID)
+		if err != nil {
+			continue
+		}
+		decayPeerScore(record, now)
+		ps.peers[pid] = record
+	}
+	return ps, nil
+}
+
+// Record scores an event of pid at weight times its usual score change.
+// It returns the change and whether the event got the peer banned.
+func (ps *PeerScores) Record(pid peer.ID, event PeerEvent, weight float64, detail string) (float64, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now()
+	record := ps.record(pid, now)
+	delta := peerEventScores[event] * weight
+	record.Score = math.Min(record.Score+delta, maxPeerScore)
+	record.Events = append(record.Events, PeerScoreEvent{Time: now, Event: event, Delta: delta, Detail: detail})
+	if len(record.Events) > peerScoreEvents {
+		record.Events = record.Events[len(record.Events)-peerScoreEvents:]
+	}
+	ps.dirty = true
+
+	if record.Score > banPeerScore || record.Banned(now) {
+		return delta, false
+	}
+	reason := fmt.Sprintf("score %.0f after %s", record.Score, event)
+	if detail != "" {
+		reason += ": " + detail
+	}
+	ps.ban(record, now, 0, reason)
+	return delta, true
+}
+
+// Ban bans pid for duration, or for its next escalating ban duration if
+// duration is 0, whatever its score
+func (ps *PeerScores) Ban(pid peer.ID, duration time.Duration, reason string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	now := time.Now()
+	ps.ban(ps.record(pid, now), now, duration, reason)
+	ps.dirty = true
+}
+
+// Unban lifts pid's ban, keeping it in the peer's history
+func (ps *PeerScores) Unban(pid peer.ID) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if record, ok := ps.peers[pid]; ok && record.Ban != nil {
+		record.Ban.Until = time.Now()
+		ps.dirty = true
+	}
+}
+
+// Score returns pid's current score, 0 for unknown peers
+func (ps *PeerScores) Score(pid peer.ID) float64 {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	record, ok := ps.peers[pid]
+	if !ok {
+		return 0
+	}
+	decayPeerScore(record, time.Now())
+	return record.Score
+}
+
+// IsBanned reports whether pid is banned
+func (ps *PeerScores) IsBanned(pid peer.ID) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	record, ok := ps.peers[pid]
+	return ok && record.Banned(time.Now())
+}
+
+// Get returns a copy of pid's record, or nil
+func (ps *PeerScores) Get(pid peer.ID) *PeerScoreRecord {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	record, ok := ps.peers[pid]
+	if !ok {
+		return nil
+	}
+	decayPeerScore(record, time.Now())
+	return copyPeerScoreRecord(record)
+}
+
+// All returns copies of all records, lowest score first
+func (ps *PeerScores) All() []*PeerScoreRecord {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	now := time.Now()
+	records := make([]*PeerScoreRecord, 0, len(ps.peers))
+	for _, record := range ps.peers {
+		decayPeerScore(record, now)
+		records = append(records, copyPeerScoreRecord(record))
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Score < records[j].Score })
+	return records
+}
+
+// Banned returns copies of the records of banned peers
+func (ps *PeerScores) Banned() []*PeerScoreRecord {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	now := time.Now()
+	var records []*PeerScoreRecord
+	for _, record := range ps.peers {
+		if record.Banned(now) {
+			decayPeerScore(record, now)
+			records = append(records, copyPeerScoreRecord(record))
+		}
+	}
+	return records
+}
+
+// Save drops idle records and writes the scores if they changed
+func (ps *PeerScores) Save() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.prune(time.Now())
+	if ps.path == "" || !ps.dirty {
+		return nil
+	}
+	records := make([]*PeerScoreRecord, 0, len(ps.peers))
+	for _, record := range ps.peers {
+		records = append(records, record)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode peer scores: %w", err)
+	}
+	if err := writeFileAtomic(ps.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save peer scores: %w", err)
+	}
+	ps.dirty = false
+	return nil
+}
+
+func (ps *PeerScores) record(pid peer.ID, now time.Time) *PeerScoreRecord {
+	record, ok := ps.peers[pid]
+	if !ok {
+		record = &PeerScoreRecord{PeerID: pid.String(), Updated: now}
+		ps.peers[pid] = record
+	}
+	decayPeerScore(record, now)
+	return record
+}
+
+func (ps *PeerScores) ban(record *PeerScoreRecord, now time.Time, duration time.Duration, reason string) {
+	if duration == 0 {
+		duration = peerBanDuration << uint(record.Bans)
+		if duration > maxPeerBanDuration || duration <= 0 {
+			duration = maxPeerBanDuration
+		}
+	}
+	record.Bans++
+	record.Ban = &PeerBan{
+		Since:  now,
+		Until:  now.Add(duration),
+		Reason: reason,
+		Events: append([]PeerScoreEvent(nil), record.Events...),
+	}
+}
+
+// prune drops records of peers that are neither banned nor scored and were
+// idle for peerScoreRetention, then the most neutral records above
+// maxScoredPeers
+func (ps *PeerScores) prune(now time.Time) {
+	for pid, record := range ps.peers {
+		decayPeerScore(record, now)
+		idle := len(record.Events) == 0 || now.Sub(record.Events[len(record.Events)-1].Time) > peerScoreRetention
+		if idle && !record.Banned(now) && math.Abs(record.Score) < 1 {
+			delete(ps.peers, pid)
+			ps.dirty = true
+		}
+	}
+	if len(ps.peers) <= maxScoredPeers {
+		return
+	}
+
+	type scored struct {
+		pid   peer.ID
+		score float64
+	}
+	var candidates []scored
+	for pid, record := range ps.peers {
+		if !record.Banned(now) {
+			candidates = append(candidates, scored{pid, math.Abs(record.Score)})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+	for _, c := range candidates {
+		if len(ps.peers) <= maxScoredPeers {
+			break
+		}
+		delete(ps.peers, c.pid)
+	}
+	ps.dirty = true
+}
+
+// decayPeerScore halves the score every peerScoreHalfLife since the
+// record was updated
+func decayPeerScore(record *PeerScoreRecord, now time.Time) {
+	elapsed := now.Sub(record.Updated)
+	if elapsed <= 0 {
+		return
+	}
+	record.Score *= math.Pow(0.5, float64(elapsed)/float64(peerScoreHalfLife))
+	record.Updated = now
+}
+
+func copyPeerScoreRecord(record *PeerScoreRecord) *PeerScoreRecord {
+	copied := *record
+	copied.Events = append([]PeerScoreEvent(nil), record.Events...)
+	if record.Ban != nil {
+		ban := *record.Ban
+		copied.Ban = &ban
+	}
+	return &copied
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPeerScores tests that events move a peer's score, that reaching the
+// threshold bans it for escalating durations, and that scores and bans
+// survive a restart while decaying
+func TestPeerScores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), PeerScoreFile)
+	ps, err := NewPeerScores(path)
+	if err != nil {
+		t.Fatalf("NewPeerScores failed: %v", err)
+	}
+
+	good, bad, idle := testPeerID(t), testPeerID(t), testPeerID(t)
+	for i := 0; i < 50; i++ {
+		ps.Record(good, PeerEventBlocksServed, 1, "")
+	}
+	if score := ps.Score(good); math.Abs(score-maxPeerScore) > 0.01 {
+		t.Errorf("Good behavior should be capped at %.0f, got %.2f", maxPeerScore, score)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, banned := ps.Record(bad, PeerEventProtocolViolation, 1, "malformed"); banned {
+			t.Fatalf("Peer banned after %d violations, above the threshold", i+1)
+		}
+	}
+	delta, banned := ps.Record(bad, PeerEventInvalidMessage, 3, "invalid blocks")
+	if delta != -30 || !banned || !ps.IsBanned(bad) {
+		t.Fatalf("Invalid messages at weight 3 should take the peer past the threshold and ban it")
+	}
+	record := ps.Get(bad)
+	if record.Bans != 1 || len(record.Ban.Events) != 4 || record.Ban.Until.Sub(record.Ban.Since) != peerBanDuration {
+		t.Errorf("Expected a first ban of %v explained by 4 events, got %+v", peerBanDuration, record.Ban)
+	}
+	if _, banned := ps.Record(bad, PeerEventProtocolViolation, 1, ""); banned {
+		t.Errorf("A banned peer should not be banned again while the ban holds")
+	}
+
+	ps.Unban(bad)
+	if ps.IsBanned(bad) {
+		t.Errorf("Unbanned peer should not be banned")
+	}
+	ps.Ban(bad, 0, "again")
+	if record := ps.Get(bad); record.Bans != 2 || record.Ban.Until.Sub(record.Ban.Since) != 2*peerBanDuration {
+		t.Errorf("Second ban should last twice as long, got %+v", record.Ban)
+	}
+
+	// An idle, neutral peer is dropped when saved
+	ps.Record(idle, PeerEventValidMessage, 1, "")
+	ps.mu.Lock()
+	ps.peers[idle].Events[0].Time = time.Now().Add(-peerScoreRetention - time.Hour)
+	ps.mu.Unlock()
+
+	// A half-life later, the scores are halved
+	ps.mu.Lock()
+	for _, record := range ps.peers {
+		record.Updated = record.Updated.Add(-peerScoreHalfLife)
+	}
+	ps.mu.Unlock()
+	if err := ps.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reloaded, err := NewPeerScores(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if score := reloaded.Score(good); math.Abs(score-maxPeerScore/2) > 0.1 {
+		t.Errorf("Expected the good peer's score halved to %.0f, got %.2f", maxPeerScore/2, score)
+	}
+	if !reloaded.IsBanned(bad) {
+		t.Errorf("Ban should survive a restart")
+	}
+	if reloaded.Get(idle) != nil {
+		t.Errorf("Idle neutral peer should have been dropped")
+	}
+	if all := reloaded.All(); len(all) != 2 || all[0].PeerID != bad.String() {
+		t.Errorf("Expected the banned peer listed first of 2, got %d records", len(all))
+	}
+	if banned := reloaded.Banned(); len(banned) != 1 || banned[0].PeerID != bad.String() {
+		t.Errorf("Expected only the bad peer banned, got %d", len(banned))
+	}
+}
@@ -0,0 +1,899 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// GossipSub-style publish/subscribe. Every pair of peers keeps one
+// long-lived stream in each direction. For every topic a node joins, it
+// keeps a mesh of meshD peers (between meshDlo and meshDhi) and relays
+// messages to its mesh only, so the load per node stays flat as the network
+// grows. Each heartbeat it tells a few peers outside the mesh which messages
+// it has seen (IHAVE), and they fetch the ones they missed (IWANT).
+//
+// Messages are identified by a hash of topic and content, so a message is
+// relayed at most once however many peers send it. A message is relayed
+// only once its topic validator accepts it. Peers are scored per topic:
+// time in the mesh and first deliveries raise the score, invalid messages
+// lower it. Low scoring peers are pruned from meshes, excluded from gossip
+// and eventually ignored.
+
+const PubSubProtocol = "/rnr/pubsub/1.0.0"
+
+// Topics
+const (
+	TopicBlocks       = "/rnr/blocks/1"
+	TopicVotes        = "/rnr/votes/1"
+	TopicTransactions = "/rnr/tx/1"
+	TopicVRFProofs    = "/rnr/vrf/1"
+	TopicEvidence     = "/rnr/evidence/1"
+)
+
+// Mesh and gossip parameters, as in GossipSub v1.1
+const (
+	meshD             = 6
+	meshDlo           = 4
+	meshDhi           = 12
+	gossipDlazy       = 6
+	heartbeatInterval = time.Second
+	mcacheHistory     = 5 // Heartbeats messages are kept for IWANT
+	mcacheGossip      = 3 // Heartbeats messages are advertised in IHAVE
+	maxIHaveIDs       = 5000
+	seenTTL           = 2 * time.Minute
+	pruneBackoff      = time.Minute // A pruned peer is not grafted again before
+	scoreRetention    = 10 * time.Minute
+	maxRPCSize        = 16 << 20
+	peerQueueSize     = 512
+)
+
+// Peer score thresholds
+const (
+	gossipThreshold   = -10.0 // Below: no gossip to or from the peer
+	publishThreshold  = -50.0 // Below: own messages are not sent to the peer
+	graylistThreshold = -80.0 // Below: the peer's RPCs are ignored
+)
+
+// ValidationResult is a topic validator's verdict on a message
+type ValidationResult int
+
+const (
+	ValidationAccept ValidationResult = iota // Deliver and relay
+	ValidationIgnore                         // Drop without penalty, e.g. stale
+	ValidationReject                         // Drop and penalize the sender
+)
+
+// TopicValidator judges a message received from a peer before it is relayed
+type TopicValidator func(from peer.ID, data []byte) ValidationResult
+
+// TopicHandler receives the accepted messages of a topic
+type TopicHandler func(from peer.ID, data []byte)
+
+// TopicScoreParams weigh a peer's behavior in one topic
+type TopicScoreParams struct {
+	Weight               float64       // Topic's share of the peer score
+	MeshTimeWeight       float64       // Per quantum spent in the mesh
+	MeshTimeQuantum      time.Duration //
+	MeshTimeCap          float64       // Quanta counted at most
+	FirstDeliveryWeight  float64       // Per message first delivered by the peer
+	FirstDeliveryCap     float64       // First deliveries counted at most
+	InvalidMessageWeight float64       // Per invalid message, squared; negative
+	Decay                float64       // Counter multiplier per heartbeat
+}
+
+// DefaultTopicScoreParams returns the score parameters of a topic of weight
+func DefaultTopicScoreParams(weight float64) TopicScoreParams {
+	return TopicScoreParams{
+		Weight:               weight,
+		MeshTimeWeight:       0.01,
+		MeshTimeQuantum:      time.Second,
+		MeshTimeCap:          300,
+		FirstDeliveryWeight:  1,
+		FirstDeliveryCap:     20,
+		InvalidMessageWeight: -10,
+		Decay:                0.99,
+	}
+}
+
+// PubSubMessage is a message published to a topic
+type PubSubMessage struct {
+	Topic string `json:"topic"`
+	Data  []byte `json:"data"`
+}
+
+// ID identifies the message by topic and content
+func (m *PubSubMessage) ID() string {
+	h := sha256.New()
+	h.Write([]byte(m.Topic))
+	h.Write([]byte{0})
+	h.Write(m.Data)
+	return hex.EncodeToString(h.Sum(nil)[:20])
+}
+
+// pubsubRPC is one frame on a pubsub stream
+type pubsubRPC struct {
+	Subscriptions []pubsubSubscription `json:"subscriptions,omitempty"`
+	Messages      []*PubSubMessage     `json:"messages,omitempty"`
+	Control       *pubsubControl       `json:"control,omitempty"`
+}
+
+type pubsubSubscription struct {
+	Topic     string `json:"topic"`
+	Subscribe bool   `json:"subscribe"`
+}
+
+type pubsubControl struct {
+	IHave []pubsubIHave `json:"ihave,omitempty"`
+	IWant []string      `json:"iwant,omitempty"` // Message IDs
+	Graft []string      `json:"graft,omitempty"` // Topics
+	Prune []string      `json:"prune,omitempty"` // Topics
+}
+
+type pubsubIHave struct {
+	Topic      string   `json:"topic"`
+	MessageIDs []string `json:"message_ids"`
+}
+
+// pubsubTopic is a topic this node joined
+type pubsubTopic struct {
+	params    TopicScoreParams
+	validator TopicValidator
+	handler   TopicHandler
+	mesh      map[peer.ID]bool
+}
+
+// topicStats is a peer's behavior in one topic
+type topicStats struct {
+	inMesh            bool
+	meshSince         time.Time
+	firstDeliveries   float64
+	invalidDeliveries float64
+}
+
+type pubsubPeer struct {
+	id     peer.ID
+	queue  chan *pubsubRPC
+	done   chan struct{}
+	topics map[string]bool // Topics the peer subscribes to
+	stats  map[string]*topicStats
+}
+
+type retainedScore struct {
+	stats map[string]*topicStats
+	until time.Time
+}
+
+// PubSub is a GossipSub-style router over libp2p streams
+type PubSub struct {
+	host     host.Host
+	ctx      context.Context
+	topics   map[string]*pubsubTopic
+	peers    map[peer.ID]*pubsubPeer
+	retained map[peer.ID]*retainedScore
+	backoff  map[string]map[peer.ID]time.Time
+	seen     map[string]time.Time
+	mcache   *messageCache
+	accept   func(from peer.ID, size int) bool
+	appScore func(peer.ID) float64
+	compress bool // Snappy compress RPCs sent
+	mu       sync.Mutex
+}
+
+// NewPubSub starts a router on h. It follows h's connections and stops
+// with ctx.
+func NewPubSub(ctx context.Context, h host.Host) *PubSub {
+	ps := &PubSub{
+		host:     h,
+		ctx:      ctx,
+		topics:   make(map[string]*pubsubTopic),
+		peers:    make(map[peer.ID]*pubsubPeer),
+		retained: make(map[peer.ID]*retainedScore),
+		backoff:  make(map[string]map[peer.ID]time.Time),
+		seen:     make(map[string]time.Time),
+		mcache:   newMessageCache(),
+	}
+
+	h.SetStreamHandler(protocol.ID(PubSubProtocol), ps.handleStream)
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			ps.addPeer(conn.RemotePeer())
+		},
+		DisconnectedF: func(n network.Network, conn network.Conn) {
+			if n.Connectedness(conn.RemotePeer()) != network.Connected {
+				ps.removePeer(conn.RemotePeer())
+			}
+		},
+	})
+	for _, pid := range h.Network().Peers() {
+		ps.addPeer(pid)
+	}
+
+	go ps.heartbeatLoop()
+	return ps
+}
+
+// SetAcceptFilter makes the router drop the messages of an RPC of size
+// bytes from a peer unless filter allows them. Subscriptions and control
+// messages are always processed.
+func (ps *PubSub) SetAcceptFilter(filter func(from peer.ID, size int) bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.accept = filter
+}
+
+// SetAppScore adds score, the node's own judgment of a peer, to the
+// peer's topic scores. It is called with the router locked.
+func (ps *PubSub) SetAppScore(score func(peer.ID) float64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.appScore = score
+}
+
+// SetCompression sets whether RPCs are sent snappy compressed
+func (ps *PubSub) SetCompression(enabled bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.compress = enabled
+}
+
+// Join subscribes to topic. handler receives the messages the topic's
+// validator accepts.
+func (ps *PubSub) Join(topic string, params TopicScoreParams, handler TopicHandler) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if t, ok := ps.topics[topic]; ok {
+		t.params = params
+		t.handler = handler
+		return
+	}
+	ps.topics[topic] = &pubsubTopic{params: params, handler: handler, mesh: make(map[peer.ID]bool)}
+
+	announce := &pubsubRPC{Subscriptions: []pubsubSubscription{{Topic: topic, Subscribe: true}}}
+	for _, p := range ps.peers {
+		ps.send(p, announce)
+	}
+}
+
+// SetValidator sets the validator of a joined topic
+func (ps *PubSub) SetValidator(topic string, validator TopicValidator) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	t, ok := ps.topics[topic]
+	if !ok {
+		return fmt.Errorf("topic %s not joined", topic)
+	}
+	t.validator = validator
+	return nil
+}
+
+// Publish sends data to every peer subscribed to topic and scoring above
+// the publish threshold. Flooding own messages keeps them from being lost in
+// an eclipsed mesh; relays only go to the mesh. Own messages skip the topic
+// validator: the node checked them when producing them, and the validator
+// may need locks the publisher holds.
+func (ps *PubSub) Publish(topic string, data []byte) error {
+	msg := &PubSubMessage{Topic: topic, Data: data}
+	id := msg.ID()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, seen := ps.seen[id]; seen {
+		return nil
+	}
+	ps.seen[id] = time.Now()
+	ps.mcache.put(id, msg)
+
+	rpc := &pubsubRPC{Messages: []*PubSubMessage{msg}}
+	for _, p := range ps.peers {
+		if p.topics[topic] && ps.score(p) >= publishThreshold {
+			ps.send(p, rpc)
+		}
+	}
+	return nil
+}
+
+// SendTo sends a message of topic straight to pid, which validates, relays
+// and delivers it as if gossiped. Unlike Publish it sends even a message
+// already seen, so it can follow a Publish of the same message.
+func (ps *PubSub) SendTo(pid peer.ID, topic string, data []byte) error {
+	msg := &PubSubMessage{Topic: topic, Data: data}
+	id := msg.ID()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	p, ok := ps.peers[pid]
+	if !ok {
+		return fmt.Errorf("no pubsub stream to %s", shortPeerID(pid))
+	}
+	ps.seen[id] = time.Now()
+	ps.mcache.put(id, msg)
+	ps.send(p, &pubsubRPC{Messages: []*PubSubMessage{msg}})
+	return nil
+}
+
+// PeerScore returns the score of a connected peer
+func (ps *PubSub) PeerScore(pid peer.ID) float64 {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if p, ok := ps.peers[pid]; ok {
+		return ps.score(p)
+	}
+	return 0
+}
+
+// MeshPeers returns the mesh of topic
+func (ps *PubSub) MeshPeers(topic string) []peer.ID {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	t, ok := ps.topics[topic]
+	if !ok {
+		return nil
+	}
+	peers := make([]peer.ID, 0, len(t.mesh))
+	for pid := range t.mesh {
+		peers = append(peers, pid)
+	}
+	return peers
+}
+
+// GetStats returns router statistics
+func (ps *PubSub) GetStats() map[string]interface{} {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	meshes := make(map[string]int, len(ps.topics))
+	for name, t := range ps.topics {
+		meshes[name] = len(t.mesh)
+	}
+	return map[string]interface{}{
+		"peers":         len(ps.peers),
+		"seen_messages": len(ps.seen),
+		"cached":        len(ps.mcache.msgs),
+		"mesh_sizes":    meshes,
+	}
+}
+
+func (ps *PubSub) addPeer(pid peer.ID) {
+	if pid == ps.host.ID() {
+		return
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.peers[pid]; ok {
+		return
+	}
+	p := &pubsubPeer{
+		id:     pid,
+		queue:  make(chan *pubsubRPC, peerQueueSize),
+		done:   make(chan struct{}),
+		topics: make(map[string]bool),
+		stats:  make(map[string]*topicStats),
+	}
+	if r, ok := ps.retained[pid]; ok {
+		p.stats = r.stats
+		delete(ps.retained, pid)
+	}
+	ps.peers[pid] = p
+
+	if len(ps.topics) > 0 {
+		hello := &pubsubRPC{}
+		for name := range ps.topics {
+			hello.Subscriptions = append(hello.Subscriptions, pubsubSubscription{Topic: name, Subscribe: true})
+		}
+		ps.send(p, hello)
+	}
+	go ps.writeLoop(p)
+}
+
+func (ps *PubSub) removePeer(pid peer.ID) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	p, ok := ps.peers[pid]
+	if !ok {
+		return
+	}
+	delete(ps.peers, pid)
+	close(p.done)
+	for _, t := range ps.topics {
+		delete(t.mesh, pid)
+	}
+	for _, s := range p.stats {
+		s.inMesh = false
+	}
+
+	// A misbehaving peer cannot reset its score by reconnecting
+	if ps.score(p) < 0 {
+		ps.retained[pid] = &retainedScore{stats: p.stats, until: time.Now().Add(scoreRetention)}
+	}
+}
+
+// send queues rpc for p, dropping it if p is not keeping up. Caller holds ps.mu.
+func (ps *PubSub) send(p *pubsubPeer, rpc *pubsubRPC) {
+	select {
+	case p.queue <- rpc:
+	default:
+		log.Printf("⚠️  Pubsub queue to %s full, dropping RPC", shortPeerID(p.id))
+	}
+}
+
+// writeLoop opens p's stream and writes its queued RPCs. A peer that was
+// disconnected meanwhile is not redialed.
+func (ps *PubSub) writeLoop(p *pubsubPeer) {
+	ctx := network.WithNoDial(ps.ctx, "pubsub stream")
+	stream, err := ps.host.NewStream(ctx, p.id, protocol.ID(PubSubProtocol))
+	if err != nil {
+		log.Printf("⚠️  Failed to open pubsub stream to %s: %v", shortPeerID(p.id), err)
+		ps.removePeer(p.id)
+		return
+	}
+	defer stream.Close()
+
+	w := bufio.NewWriter(stream)
+	for {
+		select {
+		case rpc := <-p.queue:
+			ps.mu.Lock()
+			compress := ps.compress
+			ps.mu.Unlock()
+			if err := writeRPC(w, rpc, compress); err != nil {
+				log.Printf("⚠️  Failed to send pubsub RPC to %s: %v", shortPeerID(p.id), err)
+				stream.Reset()
+				ps.removePeer(p.id)
+				return
+			}
+		case <-p.done:
+			return
+		case <-ps.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleStream reads the RPCs of a peer's stream
+func (ps *PubSub) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	from := stream.Conn().RemotePeer()
+	ps.addPeer(from)
+
+	r := bufio.NewReader(stream)
+	for {
+		rpc, size, err := readRPC(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("⚠️  Failed to read pubsub RPC from %s: %v", shortPeerID(from), err)
+				stream.Reset()
+			}
+			return
+		}
+		ps.handleRPC(from, rpc, size)
+	}
+}
+
+func (ps *PubSub) handleRPC(from peer.ID, rpc *pubsubRPC, size int) {
+	ps.mu.Lock()
+	p, ok := ps.peers[from]
+	if !ok || ps.score(p) < graylistThreshold {
+		ps.mu.Unlock()
+		return
+	}
+	for _, sub := range rpc.Subscriptions {
+		if sub.Subscribe {
+			p.topics[sub.Topic] = true
+		} else {
+			delete(p.topics, sub.Topic)
+			if t, ok := ps.topics[sub.Topic]; ok && t.mesh[from] {
+				ps.leaveMesh(t, sub.Topic, p)
+			}
+		}
+	}
+	accept := ps.accept
+	ps.mu.Unlock()
+
+	if len(rpc.Messages) > 0 && (accept == nil || accept(from, size)) {
+		for _, msg := range rpc.Messages {
+			ps.handleMessage(from, msg)
+		}
+	}
+	if rpc.Control != nil {
+		ps.handleControl(from, rpc.Control)
+	}
+}
+
+// handleMessage validates a message, relays it to the mesh and delivers it
+func (ps *PubSub) handleMessage(from peer.ID, msg *PubSubMessage) {
+	id := msg.ID()
+
+	ps.mu.Lock()
+	t, joined := ps.topics[msg.Topic]
+	if _, seen := ps.seen[id]; seen || !joined {
+		ps.mu.Unlock()
+		return
+	}
+	ps.seen[id] = time.Now()
+	validator := t.validator
+	ps.mu.Unlock()
+
+	result := ValidationAccept
+	if validator != nil {
+		result = validator(from, msg.Data)
+	}
+
+	ps.mu.Lock()
+	p, connected := ps.peers[from]
+	switch result {
+	case ValidationReject:
+		if connected {
+			ps.stats(p, msg.Topic).invalidDeliveries++
+		}
+		log.Printf("⚠️  Rejected %s message %s from %s", msg.Topic, id[:12], shortPeerID(from))
+		ps.mu.Unlock()
+		return
+	case ValidationIgnore:
+		ps.mu.Unlock()
+		return
+	}
+
+	if connected {
+		s := ps.stats(p, msg.Topic)
+		s.firstDeliveries = math.Min(s.firstDeliveries+1, t.params.FirstDeliveryCap)
+	}
+	ps.mcache.put(id, msg)
+
+	rpc := &pubsubRPC{Messages: []*PubSubMessage{msg}}
+	for pid := range t.mesh {
+		if pid != from {
+			ps.send(ps.peers[pid], rpc)
+		}
+	}
+	handler := t.handler
+	ps.mu.Unlock()
+
+	if handler != nil {
+		handler(from, msg.Data)
+	}
+}
+
+func (ps *PubSub) handleControl(from peer.ID, ctrl *pubsubControl) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	p, ok := ps.peers[from]
+	if !ok {
+		return
+	}
+	reply := &pubsubControl{}
+	var messages []*PubSubMessage
+
+	if ps.score(p) >= gossipThreshold {
+		for _, ihave := range ctrl.IHave {
+			if _, joined := ps.topics[ihave.Topic]; !joined {
+				continue
+			}
+			for _, id := range ihave.MessageIDs {
+				if _, seen := ps.seen[id]; !seen && len(reply.IWant) < maxIHaveIDs {
+					reply.IWant = append(reply.IWant, id)
+				}
+			}
+		}
+		for _, id := range ctrl.IWant {
+			if msg, ok := ps.mcache.get(id); ok && p.topics[msg.Topic] {
+				messages = append(messages, msg)
+			}
+		}
+	}
+
+	for _, topic := range ctrl.Graft {
+		t, joined := ps.topics[topic]
+		if !joined {
+			reply.Prune = append(reply.Prune, topic)
+			continue
+		}
+		if t.mesh[from] {
+			continue
+		}
+		if ps.score(p) < 0 || len(t.mesh) >= meshDhi || ps.backedOff(topic, from) {
+			reply.Prune = append(reply.Prune, topic)
+			continue
+		}
+		ps.joinMesh(t, topic, p)
+	}
+	for _, topic := range ctrl.Prune {
+		if t, ok := ps.topics[topic]; ok && t.mesh[from] {
+			ps.leaveMesh(t, topic, p)
+		}
+	}
+
+	if len(reply.IWant) > 0 || len(reply.Prune) > 0 || len(messages) > 0 {
+		rpc := &pubsubRPC{Messages: messages}
+		if len(reply.IWant) > 0 || len(reply.Prune) > 0 {
+			rpc.Control = reply
+		}
+		ps.send(p, rpc)
+	}
+}
+
+// joinMesh adds p to the mesh of topic. Caller holds ps.mu.
+func (ps *PubSub) joinMesh(t *pubsubTopic, topic string, p *pubsubPeer) {
+	t.mesh[p.id] = true
+	s := ps.stats(p, topic)
+	s.inMesh = true
+	s.meshSince = time.Now()
+}
+
+// leaveMesh removes p from the mesh of topic and backs off from grafting it
+// again. Caller holds ps.mu.
+func (ps *PubSub) leaveMesh(t *pubsubTopic, topic string, p *pubsubPeer) {
+	delete(t.mesh, p.id)
+	ps.stats(p, topic).inMesh = false
+	if ps.backoff[topic] == nil {
+		ps.backoff[topic] = make(map[peer.ID]time.Time)
+	}
+	ps.backoff[topic][p.id] = time.Now().Add(pruneBackoff)
+}
+
+func (ps *PubSub) backedOff(topic string, pid peer.ID) bool {
+	until, ok := ps.backoff[topic][pid]
+	return ok && time.Now().Before(until)
+}
+
+func (ps *PubSub) stats(p *pubsubPeer, topic string) *topicStats {
+	s, ok := p.stats[topic]
+	if !ok {
+		s = &topicStats{}
+		p.stats[topic] = s
+	}
+	return s
+}
+
+// score sums p's weighted topic scores and its app score. Caller holds ps.mu.
+func (ps *PubSub) score(p *pubsubPeer) float64 {
+	total := 0.0
+	for name, s := range p.stats {
+		t, ok := ps.topics[name]
+		if !ok {
+			continue
+		}
+		params := t.params
+		topicScore := 0.0
+		if s.inMesh && params.MeshTimeQuantum > 0 {
+			quanta := math.Min(float64(time.Since(s.meshSince))/float64(params.MeshTimeQuantum), params.MeshTimeCap)
+			topicScore += quanta * params.MeshTimeWeight
+		}
+		topicScore += s.firstDeliveries * params.FirstDeliveryWeight
+		topicScore += s.invalidDeliveries * s.invalidDeliveries * params.InvalidMessageWeight
+		total += topicScore * params.Weight
+	}
+	if ps.appScore != nil {
+		total += ps.appScore(p.id)
+	}
+	return total
+}
+
+func (ps *PubSub) heartbeatLoop() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ps.heartbeat()
+		case <-ps.ctx.Done():
+			return
+		}
+	}
+}
+
+// heartbeat maintains the meshes, emits gossip and ages scores and caches
+func (ps *PubSub) heartbeat() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	controls := make(map[peer.ID]*pubsubControl)
+	control := func(pid peer.ID) *pubsubControl {
+		if controls[pid] == nil {
+			controls[pid] = &pubsubControl{}
+		}
+		return controls[pid]
+	}
+
+	for name, t := range ps.topics {
+		for pid := range t.mesh {
+			p := ps.peers[pid]
+			if !p.topics[name] || ps.score(p) < 0 {
+				ps.leaveMesh(t, name, p)
+				control(pid).Prune = append(control(pid).Prune, name)
+			}
+		}
+
+		if len(t.mesh) < meshDlo {
+			candidates := ps.topicPeers(name, func(p *pubsubPeer) bool {
+				return !t.mesh[p.id] && !ps.backedOff(name, p.id) && ps.score(p) >= 0
+			})
+			for _, p := range candidates {
+				if len(t.mesh) >= meshD {
+					break
+				}
+				ps.joinMesh(t, name, p)
+				control(p.id).Graft = append(control(p.id).Graft, name)
+			}
+		}
+
+		if len(t.mesh) > meshDhi {
+			members := make([]*pubsubPeer, 0, len(t.mesh))
+			for pid := range t.mesh {
+				members = append(members, ps.peers[pid])
+			}
+			sort.Slice(members, func(i, j int) bool { return ps.score(members[i]) > ps.score(members[j]) })
+			for _, p := range members[meshD:] {
+				ps.leaveMesh(t, name, p)
+				control(p.id).Prune = append(control(p.id).Prune, name)
+			}
+		}
+
+		if ids := ps.mcache.gossipIDs(name); len(ids) > 0 {
+			targets := ps.topicPeers(name, func(p *pubsubPeer) bool {
+				return !t.mesh[p.id] && ps.score(p) >= gossipThreshold
+			})
+			if len(targets) > gossipDlazy {
+				targets = targets[:gossipDlazy]
+			}
+			for _, p := range targets {
+				control(p.id).IHave = append(control(p.id).IHave, pubsubIHave{Topic: name, MessageIDs: ids})
+			}
+		}
+	}
+
+	for pid, ctrl := range controls {
+		ps.send(ps.peers[pid], &pubsubRPC{Control: ctrl})
+	}
+
+	ps.mcache.shift()
+	now := time.Now()
+	for id, at := range ps.seen {
+		if now.Sub(at) > seenTTL {
+			delete(ps.seen, id)
+		}
+	}
+	for topic, peers := range ps.backoff {
+		for pid, until := range peers {
+			if now.After(until) {
+				delete(peers, pid)
+			}
+		}
+		if len(peers) == 0 {
+			delete(ps.backoff, topic)
+		}
+	}
+	for pid, r := range ps.retained {
+		if now.After(r.until) {
+			delete(ps.retained, pid)
+		}
+	}
+	for _, p := range ps.peers {
+		for name, s := range p.stats {
+			t, ok := ps.topics[name]
+			if !ok {
+				continue
+			}
+			s.firstDeliveries *= t.params.Decay
+			s.invalidDeliveries *= t.params.Decay
+			if s.invalidDeliveries < 0.01 {
+				s.invalidDeliveries = 0
+			}
+		}
+	}
+}
+
+// topicPeers returns the peers subscribed to topic that pass filter, in
+// random order. Caller holds ps.mu.
+func (ps *PubSub) topicPeers(topic string, filter func(*pubsubPeer) bool) []*pubsubPeer {
+	peers := make([]*pubsubPeer, 0)
+	for _, p := range ps.peers {
+		if p.topics[topic] && filter(p) {
+			peers = append(peers, p)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	return peers
+}
+
+// messageCache keeps recent messages for IWANT, in heartbeat windows
+type messageCache struct {
+	msgs    map[string]*PubSubMessage
+	history [][]string // Message IDs per window, newest first
+}
+
+func newMessageCache() *messageCache {
+	return &messageCache{
+		msgs:    make(map[string]*PubSubMessage),
+		history: make([][]string, mcacheHistory),
+	}
+}
+
+func (mc *messageCache) put(id string, msg *PubSubMessage) {
+	if _, ok := mc.msgs[id]; ok {
+		return
+	}
+	mc.msgs[id] = msg
+	mc.history[0] = append(mc.history[0], id)
+}
+
+func (mc *messageCache) get(id string) (*PubSubMessage, bool) {
+	msg, ok := mc.msgs[id]
+	return msg, ok
+}
+
+// gossipIDs returns the IDs of topic's messages of the recent windows
+func (mc *messageCache) gossipIDs(topic string) []string {
+	ids := make([]string, 0)
+	for _, window := range mc.history[:mcacheGossip] {
+		for _, id := range window {
+			if msg, ok := mc.msgs[id]; ok && msg.Topic == topic && len(ids) < maxIHaveIDs {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// shift drops the oldest window and starts a new one
+func (mc *messageCache) shift() {
+	for _, id := range mc.history[len(mc.history)-1] {
+		delete(mc.msgs, id)
+	}
+	copy(mc.history[1:], mc.history[:len(mc.history)-1])
+	mc.history[0] = nil
+}
+
+// writeRPC writes rpc as a wire frame
+func writeRPC(w *bufio.Writer, rpc *pubsubRPC, compress bool) error {
+	if err := WriteMessage(w, core.MessageTypePubSubRPC, rpc, compress); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// readRPC reads a frame written by writeRPC and returns its size
+func readRPC(r *bufio.Reader) (*pubsubRPC, int, error) {
+	var rpc pubsubRPC
+	size, err := ReadMessageOf(r, core.MessageTypePubSubRPC, &rpc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &rpc, size, nil
+}
+
+func shortPeerID(pid peer.ID) string {
+	s := pid.String()
+	if len(s) > 8 {
+		return s[:8]
+	}
+	return s
+}
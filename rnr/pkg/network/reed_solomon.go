@@ -0,0 +1,232 @@
+package network
+
+import (
+	"fmt"
+)
+
+// Systematic Reed-Solomon erasure code over GF(2^8). The first dataShards
+// shards are the data itself; the parityShards after them are computed so
+// that any dataShards of the total suffice to recover the data. The encoding
+// matrix is a Vandermonde matrix multiplied by the inverse of its top square,
+// which keeps every square submatrix invertible.
+
+const maxTotalShards = 256 // Distinct evaluation points in GF(2^8)
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	// Field generated by x^8 + x^4 + x^3 + x^2 + 1 with generator 2
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m gfMatrix) mul(o gfMatrix) gfMatrix {
+	out := newGFMatrix(len(m), len(o[0]))
+	for i := range m {
+		for j := range o[0] {
+			var v byte
+			for k := range o {
+				v ^= gfMul(m[i][k], o[k][j])
+			}
+			out[i][j] = v
+		}
+	}
+	return out
+}
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newGFMatrix(n, 2*n)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if inv := gfInv(work[col][col]); inv != 1 {
+			for j := range work[col] {
+				work[col][j] = gfMul(work[col][j], inv)
+			}
+		}
+		for row := 0; row < n; row++ {
+			if row == col || work[row][col] == 0 {
+				continue
+			}
+			factor := work[row][col]
+			for j := range work[row] {
+				work[row][j] ^= gfMul(factor, work[col][j])
+			}
+		}
+	}
+	inv := newGFMatrix(n, n)
+	for i := range inv {
+		copy(inv[i], work[i][n:])
+	}
+	return inv, nil
+}
+
+// ReedSolomon encodes and reconstructs shards of equal size
+type ReedSolomon struct {
+	dataShards   int
+	parityShards int
+	matrix       gfMatrix // (dataShards+parityShards) x dataShards, identity on top
+}
+
+// NewReedSolomon returns a code of dataShards data and parityShards parity
+// shards
+func NewReedSolomon(dataShards, parityShards int) (*ReedSolomon, error) {
+	if dataShards < 1 || parityShards < 0 || dataShards+parityShards > maxTotalShards {
+		return nil, fmt.Errorf("invalid shard counts %d+%d", dataShards, parityShards)
+	}
+	total := dataShards + parityShards
+	vandermonde := newGFMatrix(total, dataShards)
+	for r := 0; r < total; r++ {
+		for c := 0; c < dataShards; c++ {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+	topInv, err := gfMatrix(vandermonde[:dataShards]).invert()
+	if err != nil {
+		return nil, err
+	}
+	return &ReedSolomon{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       vandermonde.mul(topInv),
+	}, nil
+}
+
+// Encode computes the parity shards of shards, whose first dataShards
+// entries hold the data
+func (rs *ReedSolomon) Encode(shards [][]byte) error {
+	if len(shards) != rs.dataShards+rs.parityShards {
+		return fmt.Errorf("expected %d shards, got %d", rs.dataShards+rs.parityShards, len(shards))
+	}
+	size := len(shards[0])
+	for i := 0; i < rs.dataShards; i++ {
+		if len(shards[i]) != size {
+			return fmt.Errorf("shards differ in size")
+		}
+	}
+	for i := rs.dataShards; i < len(shards); i++ {
+		shards[i] = rs.combine(rs.matrix[i], shards[:rs.dataShards], size)
+	}
+	return nil
+}
+
+// Reconstruct fills in the missing (nil) data shards from any dataShards
+// shards present. Parity shards are not restored.
+func (rs *ReedSolomon) Reconstruct(shards [][]byte) error {
+	if len(shards) != rs.dataShards+rs.parityShards {
+		return fmt.Errorf("expected %d shards, got %d", rs.dataShards+rs.parityShards, len(shards))
+	}
+	missing := false
+	for i := 0; i < rs.dataShards; i++ {
+		if shards[i] == nil {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	var rows []int
+	size := -1
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			return fmt.Errorf("shards differ in size")
+		}
+		size = len(shard)
+		rows = append(rows, i)
+		if len(rows) == rs.dataShards {
+			break
+		}
+	}
+	if len(rows) < rs.dataShards {
+		return fmt.Errorf("too few shards: %d of %d needed", len(rows), rs.dataShards)
+	}
+
+	sub := newGFMatrix(rs.dataShards, rs.dataShards)
+	present := make([][]byte, rs.dataShards)
+	for i, row := range rows {
+		copy(sub[i], rs.matrix[row])
+		present[i] = shards[row]
+	}
+	decode, err := sub.invert()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < rs.dataShards; i++ {
+		if shards[i] == nil {
+			shards[i] = rs.combine(decode[i], present, size)
+		}
+	}
+	return nil
+}
+
+// combine returns the linear combination of shards with coefficients
+func (rs *ReedSolomon) combine(coefficients []byte, shards [][]byte, size int) []byte {
+	out := make([]byte, size)
+	for k, c := range coefficients {
+		if c == 0 {
+			continue
+		}
+		for j, b := range shards[k] {
+			out[j] ^= gfMul(c, b)
+		}
+	}
+	return out
+}
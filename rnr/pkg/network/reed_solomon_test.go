@@ -0,0 +1,65 @@
+package network
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestReedSolomonReconstruct tests that the data is recovered with any
+// parityShards shards lost, and that losing one more fails
+func TestReedSolomonReconstruct(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cases := []struct{ data, parity int }{
+		{1, 1}, {2, 1}, {3, 3}, {4, 2}, {10, 10}, {17, 5}, {maxDataShreds, maxDataShreds},
+	}
+	for _, tc := range cases {
+		rs, err := NewReedSolomon(tc.data, tc.parity)
+		if err != nil {
+			t.Fatalf("%d+%d: NewReedSolomon failed: %v", tc.data, tc.parity, err)
+		}
+		original := make([][]byte, tc.data+tc.parity)
+		for i := 0; i < tc.data; i++ {
+			original[i] = make([]byte, 37)
+			rng.Read(original[i])
+		}
+		if err := rs.Encode(original); err != nil {
+			t.Fatalf("%d+%d: Encode failed: %v", tc.data, tc.parity, err)
+		}
+
+		for trial := 0; trial < 20; trial++ {
+			// Trial 0 loses every data shard it can, the others a random pick
+			lost := rng.Perm(len(original))[:rng.Intn(tc.parity+1)]
+			if trial == 0 {
+				lost = rng.Perm(tc.data)
+				if len(lost) > tc.parity {
+					lost = lost[:tc.parity]
+				}
+			}
+			shards := append([][]byte(nil), original...)
+			for _, i := range lost {
+				shards[i] = nil
+			}
+			if err := rs.Reconstruct(shards); err != nil {
+				t.Fatalf("%d+%d: Reconstruct without %v failed: %v", tc.data, tc.parity, lost, err)
+			}
+			for i := 0; i < tc.data; i++ {
+				if !bytes.Equal(shards[i], original[i]) {
+					t.Fatalf("%d+%d: data shard %d wrong after losing %v", tc.data, tc.parity, i, lost)
+				}
+			}
+		}
+
+		shards := append([][]byte(nil), original...)
+		for _, i := range rng.Perm(len(shards))[:tc.parity+1] {
+			shards[i] = nil
+		}
+		if err := rs.Reconstruct(shards); err == nil {
+			t.Errorf("%d+%d: Reconstruct with %d shards lost should fail", tc.data, tc.parity, tc.parity+1)
+		}
+	}
+
+	if _, err := NewReedSolomon(200, 100); err == nil {
+		t.Errorf("More shards than GF(2^8) has points should be refused")
+	}
+}
@@ -0,0 +1,599 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Shred relay: a large block is split into Reed-Solomon shreds, half of
+// them parity, and every shred travels down its own tree of the validators'
+// peers, shuffled per shred. The proposer sends each shred only to the
+// root of its tree and every validator forwards the shreds it receives to
+// its children, so the proposer uploads about twice the block once rather
+// than the block to every peer. Any node holding half of the shreds rebuilds
+// the block. Shreds carry a Merkle proof under a root the proposing node
+// signs, so each is checked before it is forwarded.
+//
+// Trees are built from the peers of validator identity records. Nodes whose
+// record sets differ route some shreds differently, which the parity
+// absorbs. The compact announcement still goes out on gossip, reaching
+// nodes outside the trees.
+
+const ShredProtocol = "/rnr/shred/1.0.0"
+
+const (
+	shredSize          = 4096     // Bytes of block per shred, before growing for huge blocks
+	shredMinBlockBytes = 64 << 10 // Smaller blocks are only announced compactly
+	maxDataShreds      = 128      // With as many parity shreds, the most GF(2^8) allows
+	shredFanout        = 8        // Children per node in a shred's tree
+	maxShredBlockBytes = maxRPCSize
+	maxPendingShreds   = 16 // Blocks being rebuilt at once
+	maxShredRoots      = 4  // Shred sets signed for one block, the proposer's among them
+	shredSetTTL        = time.Minute
+	shredRebuildWait   = 2 * time.Second // How long a compact announcement waits for shreds under way
+)
+
+// Shred is a piece of an erasure coded block
+type Shred struct {
+	BlockHash    []byte   `json:"block_hash"`
+	Origin       string   `json:"origin"` // Peer ID of the proposing node, the root of every tree
+	DataShreds   int      `json:"data_shreds"`
+	ParityShreds int      `json:"parity_shreds"`
+	BlockSize    int      `json:"block_size"` // Bytes of encoded block before padding
+	MerkleRoot   []byte   `json:"merkle_root"`
+	Signature    []byte   `json:"signature"` // Origin's node key signature of the shred set
+	Index        int      `json:"index"`
+	Data         []byte   `json:"data"`
+	Proof        [][]byte `json:"proof"` // Merkle path of Data
+}
+
+// signingHash is the hash the origin signs for the whole shred set
+func (s *Shred) signingHash() []byte {
+	h := sha256.New()
+	h.Write([]byte("rnr-shreds:"))
+	h.Write(s.BlockHash)
+	h.Write(s.MerkleRoot)
+	var buf [12]byte
+	binary.BigEndian.PutUint32(buf[0:], uint32(s.DataShreds))
+	binary.BigEndian.PutUint32(buf[4:], uint32(s.ParityShreds))
+	binary.BigEndian.PutUint32(buf[8:], uint32(s.BlockSize))
+	h.Write(buf[:])
+	return h.Sum(nil)
+}
+
+func shredLeaf(index int, data []byte) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(index))
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(buf[:])
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func shredNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// shredMerkleTree returns the levels of the Merkle tree over leaves, leaves
+// first. An odd node is paired with itself.
+func shredMerkleTree(leaves [][]byte) [][][]byte {
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, (len(level)+1)/2)
+		for i := range next {
+			right := level[len(level)-1]
+			if 2*i+1 < len(level) {
+				right = level[2*i+1]
+			}
+			next[i] = shredNode(level[2*i], right)
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+func shredMerkleProof(levels [][][]byte, index int) [][]byte {
+	var proof [][]byte
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = len(level) - 1
+		}
+		proof = append(proof, level[sibling])
+		index /= 2
+	}
+	return proof
+}
+
+// verifyProof checks the shred's data against its Merkle root
+func (s *Shred) verifyProof() bool {
+	node := shredLeaf(s.Index, s.Data)
+	index := s.Index
+	for _, sibling := range s.Proof {
+		if index%2 == 0 {
+			node = shredNode(node, sibling)
+		} else {
+			node = shredNode(sibling, node)
+		}
+		index /= 2
+	}
+	return index == 0 && bytes.Equal(node, s.MerkleRoot)
+}
+
+// shredBlock erasure codes an encoded block into signed shreds
+func (p *P2PNetwork) shredBlock(blockHash, encoded []byte) ([]*Shred, error) {
+	dataShreds := (len(encoded) + shredSize - 1) / shredSize
+	if dataShreds > maxDataShreds {
+		dataShreds = maxDataShreds
+	}
+	size := (len(encoded) + dataShreds - 1) / dataShreds
+	rs, err := NewReedSolomon(dataShreds, dataShreds)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([][]byte, 2*dataShreds)
+	for i := 0; i < dataShreds; i++ {
+		shards[i] = make([]byte, size)
+		if start := i * size; start < len(encoded) {
+			copy(shards[i], encoded[start:])
+		}
+	}
+	if err := rs.Encode(shards); err != nil {
+		return nil, err
+	}
+
+	leaves := make([][]byte, len(shards))
+	for i, shard := range shards {
+		leaves[i] = shredLeaf(i, shard)
+	}
+	levels := shredMerkleTree(leaves)
+	template := Shred{
+		BlockHash:    blockHash,
+		Origin:       p.Host.ID().String(),
+		DataShreds:   dataShreds,
+		ParityShreds: dataShreds,
+		BlockSize:    len(encoded),
+		MerkleRoot:   levels[len(levels)-1][0],
+	}
+	template.Signature, err = p.Host.Peerstore().PrivKey(p.Host.ID()).Sign(template.signingHash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign shreds: %w", err)
+	}
+
+	shreds := make([]*Shred, len(shards))
+	for i, shard := range shards {
+		shred := template
+		shred.Index = i
+		shred.Data = shard
+		shred.Proof = shredMerkleProof(levels, i)
+		shreds[i] = &shred
+	}
+	return shreds, nil
+}
+
+// shredSet collects the shreds of a block until it can be rebuilt. A set
+// is created by its first shred, or ahead of it by the block's compact
+// announcement. Shreds from different origins or under different roots are
+// kept apart, so a forged set neither mixes with nor locks out the
+// proposer's.
+type shredSet struct {
+	roots   map[string]*shredRoot // By origin and hex Merkle root
+	created time.Time
+	done    chan struct{} // Closed once the block is rebuilt or given up
+	block   *core.Block
+}
+
+// shredRoot is the shreds of a block under one origin's signed Merkle root
+type shredRoot struct {
+	header Shred // Set-wide fields, from the first verified shred
+	shards [][]byte
+	count  int
+}
+
+// shredSets tracks the blocks being rebuilt from shreds
+type shredSets struct {
+	mu   sync.Mutex
+	sets map[string]*shredSet
+}
+
+func newShredSets() *shredSets {
+	return &shredSets{sets: make(map[string]*shredSet)}
+}
+
+// wait blocks until the block with hash is rebuilt from shreds, or
+// timeout, and returns it or nil. If expected, the shreds may not have
+// started arriving yet.
+func (s *shredSets) wait(hash []byte, expected bool, timeout time.Duration) *core.Block {
+	key := hex.EncodeToString(hash)
+	s.mu.Lock()
+	s.prune()
+	set := s.sets[key]
+	if set == nil && expected && len(s.sets) < maxPendingShreds {
+		set = newShredSet()
+		s.sets[key] = set
+	}
+	s.mu.Unlock()
+	if set == nil {
+		return nil
+	}
+	select {
+	case <-set.done:
+	case <-time.After(timeout):
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return set.block
+}
+
+// SetShredRelay turns on sending large blocks this node proposes as shreds.
+// Shreds from other nodes are always received and forwarded.
+func (p *P2PNetwork) SetShredRelay(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.shredRelay = enabled
+}
+
+// shredTreePeers returns the peers of the current validator records other
+// than origin, in a stable order
+func (p *P2PNetwork) shredTreePeers(origin peer.ID) []peer.ID {
+	p.mu.RLock()
+	seen := make(map[peer.ID]bool, len(p.validatorRecords))
+	for _, record := range p.validatorRecords {
+		if time.Since(time.Unix(0, record.Timestamp)) > validatorRecordTTL {
+			continue
+		}
+		if pid, err := peer.Decode(record.PeerID); err == nil && pid != origin {
+			seen[pid] = true
+		}
+	}
+	p.mu.RUnlock()
+
+	peers := make([]peer.ID, 0, len(seen))
+	for pid := range seen {
+		peers = append(peers, pid)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	return peers
+}
+
+// shredTree shuffles peers into the tree of one shred. The peer at
+// position i has the children at positions fanout*i+1 to fanout*(i+1).
+func shredTree(peers []peer.ID, blockHash []byte, index int) []peer.ID {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(index))
+	seed := sha256.Sum256(append(append([]byte{}, blockHash...), buf[:]...))
+	tree := append([]peer.ID(nil), peers...)
+	rng := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:8]))))
+	rng.Shuffle(len(tree), func(i, j int) { tree[i], tree[j] = tree[j], tree[i] })
+	return tree
+}
+
+// shredTargets returns the peers this node sends a shred to: the tree's
+// root if it is the origin, else its children in the tree
+func (p *P2PNetwork) shredTargets(shred *Shred, origin peer.ID, peers []peer.ID) []peer.ID {
+	tree := shredTree(peers, shred.BlockHash, shred.Index)
+	if len(tree) == 0 {
+		return nil
+	}
+	if origin == p.Host.ID() {
+		return tree[:1]
+	}
+	position := -1
+	for i, pid := range tree {
+		if pid == p.Host.ID() {
+			position = i
+			break
+		}
+	}
+	if position < 0 {
+		return nil
+	}
+	first := shredFanout*position + 1
+	if first >= len(tree) {
+		return nil
+	}
+	last := first + shredFanout
+	if last > len(tree) {
+		last = len(tree)
+	}
+	return tree[first:last]
+}
+
+// broadcastShreds sends the shreds of a large block down their trees. It
+// returns whether the block was large enough and had peers to go to.
+func (p *P2PNetwork) broadcastShreds(blockHash []byte, block *core.Block) (bool, error) {
+	encoded, err := json.Marshal(block)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal block: %w", err)
+	}
+	if len(encoded) < shredMinBlockBytes || len(encoded) > maxShredBlockBytes {
+		return false, nil
+	}
+	peers := p.shredTreePeers(p.Host.ID())
+	if len(peers) == 0 {
+		return false, nil
+	}
+	shreds, err := p.shredBlock(blockHash, encoded)
+	if err != nil {
+		return false, err
+	}
+	p.sendShreds(p.Host.ID(), shreds, peers)
+	log.Printf("🧩 Sent block #%d as %d shreds to %d validator peers", block.Header.Height, len(shreds), len(peers))
+	return true, nil
+}
+
+// sendShreds sends every shred to its targets, one stream per peer
+func (p *P2PNetwork) sendShreds(origin peer.ID, shreds []*Shred, peers []peer.ID) {
+	batches := make(map[peer.ID][]*Shred)
+	for _, shred := range shreds {
+		for _, pid := range p.shredTargets(shred, origin, peers) {
+			batches[pid] = append(batches[pid], shred)
+		}
+	}
+	for pid, batch := range batches {
+		pid, batch := pid, batch
+		go func() {
+			if err := p.sendShredBatch(pid, batch); err != nil {
+				log.Printf("⚠️  Failed to send %d shreds to %s: %v", len(batch), shortPeerID(pid), err)
+			}
+		}()
+	}
+}
+
+func (p *P2PNetwork) sendShredBatch(pid peer.ID, shreds []*Shred) error {
+	ctx, cancel := context.WithTimeout(p.ctx, dialTimeout)
+	defer cancel()
+	stream, err := p.Host.NewStream(ctx, pid, protocol.ID(ShredProtocol))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(statusTimeout))
+	return p.writeMessage(stream, core.MessageTypeShreds, shreds)
+}
+
+// handleShredStream receives a batch of shreds, forwards the valid ones to
+// this node's children in their trees and rebuilds the block once enough
+// have arrived
+func (p *P2PNetwork) handleShredStream(stream network.Stream) {
+	defer stream.Close()
+	from := stream.Conn().RemotePeer()
+	stream.SetDeadline(time.Now().Add(statusTimeout))
+
+	msg, size, err := ReadMessage(stream, core.MessageTypeShreds)
+	if err != nil {
+		return
+	}
+	if !p.IsUnconditionalPeer(from) {
+		if p.rateLimiter.GetPeerLimit(from) == nil {
+			p.rateLimiter.AllowRequest(from) // Starts tracking the peer
+		}
+		if allowed, _ := p.rateLimiter.AllowBytes(from, int64(size)); !allowed {
+			return
+		}
+	}
+	var shreds []*Shred
+	if err := msg.Decode(core.MessageTypeShreds, &shreds); err != nil {
+		p.reportPeer(from, PeerEventProtocolViolation, 1, "malformed shreds")
+		return
+	}
+
+	var forward []*Shred
+	var origin peer.ID
+	for _, shred := range shreds {
+		if shred == nil {
+			continue
+		}
+		fresh, err := p.addShred(shred)
+		if err != nil {
+			p.reportPeer(from, PeerEventInvalidMessage, topicWeights[TopicBlocks], err.Error())
+			return
+		}
+		if fresh {
+			origin, _ = peer.Decode(shred.Origin)
+			forward = append(forward, shred)
+		}
+	}
+	if len(forward) > 0 {
+		p.sendShreds(origin, forward, p.shredTreePeers(origin))
+	}
+}
+
+// checkShred verifies a shred's parameters, proof and origin signature
+func (p *P2PNetwork) checkShred(shred *Shred, origin peer.ID) error {
+	if len(shred.BlockHash) != sha256.Size || shred.DataShreds < 1 || shred.DataShreds > maxDataShreds ||
+		shred.ParityShreds != shred.DataShreds || shred.Index < 0 || shred.Index >= 2*shred.DataShreds ||
+		shred.BlockSize < 1 || shred.BlockSize > maxShredBlockBytes ||
+		len(shred.Data) != (shred.BlockSize+shred.DataShreds-1)/shred.DataShreds {
+		return fmt.Errorf("malformed shred")
+	}
+	if !shred.verifyProof() {
+		return fmt.Errorf("invalid shred proof")
+	}
+	if ok, err := p.Host.Peerstore().PubKey(origin).Verify(shred.signingHash(), shred.Signature); err != nil || !ok {
+		return fmt.Errorf("invalid shred signature")
+	}
+	return nil
+}
+
+// addShred adds a shred to its block's set. It returns whether the shred is
+// new and was verified, and an error if it is invalid.
+func (p *P2PNetwork) addShred(shred *Shred) (bool, error) {
+	if p.compactBlocks.get(shred.BlockHash) != nil {
+		return false, nil // Already rebuilt or proposed here
+	}
+	key := hex.EncodeToString(shred.BlockHash)
+	rootKey := shred.Origin + "/" + hex.EncodeToString(shred.MerkleRoot)
+
+	p.shreds.mu.Lock()
+	set := p.shreds.sets[key]
+	held := set != nil && (set.block != nil || set.roots[rootKey].holds(shred.Index))
+	p.shreds.mu.Unlock()
+	if held {
+		return false, nil
+	}
+
+	origin, err := peer.Decode(shred.Origin)
+	if err != nil {
+		return false, fmt.Errorf("invalid shred origin")
+	}
+	if p.Host.Peerstore().PubKey(origin) == nil {
+		return false, nil // Cannot be checked until the origin's key is known
+	}
+	if !p.isShredOrigin(origin) {
+		return false, nil // Only validators' nodes propose; the proposer is checked once rebuilt
+	}
+	if err := p.checkShred(shred, origin); err != nil {
+		return false, err
+	}
+
+	p.shreds.mu.Lock()
+	defer p.shreds.mu.Unlock()
+	p.shreds.prune()
+	set = p.shreds.sets[key]
+	if set == nil {
+		if len(p.shreds.sets) >= maxPendingShreds {
+			return false, nil
+		}
+		set = newShredSet()
+		p.shreds.sets[key] = set
+	}
+	if set.block != nil {
+		return false, nil
+	}
+	root := set.roots[rootKey]
+	if root == nil {
+		if len(set.roots) >= maxShredRoots {
+			return false, nil
+		}
+		root = &shredRoot{header: *shred, shards: make([][]byte, 2*shred.DataShreds)}
+		root.header.Data, root.header.Proof = nil, nil
+		set.roots[rootKey] = root
+	}
+	if root.shards[shred.Index] != nil {
+		return false, nil
+	}
+	root.shards[shred.Index] = shred.Data
+	root.count++
+	if root.count == root.header.DataShreds {
+		go p.rebuildFromShreds(set, rootKey)
+	}
+	return true, nil
+}
+
+// holds reports whether the shred at index is already in r
+func (r *shredRoot) holds(index int) bool {
+	return r != nil && index >= 0 && index < len(r.shards) && r.shards[index] != nil
+}
+
+// isShredOrigin reports whether shreds signed by origin are collected: the
+// node must be bound to a validator. Until a record verifier is set, records
+// are not in use and any node may.
+func (p *P2PNetwork) isShredOrigin(origin peer.ID) bool {
+	p.mu.RLock()
+	verifier := p.recordVerifier
+	p.mu.RUnlock()
+	return verifier == nil || p.GetValidatorIDByPeerID(origin) != ""
+}
+
+// rebuildFromShreds decodes a block once half of the shreds under one root
+// arrived. If the block cannot be rebuilt, or its proposer is not the node
+// that signed the shreds, the root's shreds are dropped and another set may
+// still complete.
+func (p *P2PNetwork) rebuildFromShreds(set *shredSet, rootKey string) {
+	p.shreds.mu.Lock()
+	root := set.roots[rootKey]
+	shards := append([][]byte(nil), root.shards...)
+	header := root.header
+	p.shreds.mu.Unlock()
+
+	block, err := decodeShreds(&header, shards)
+	if err == nil {
+		if origin, _ := peer.Decode(header.Origin); !p.isValidatorPeer(block.ProposerID, origin) {
+			err = fmt.Errorf("shreds signed by %s, not the proposer's node", shortPeerID(origin))
+		} else if result := p.checkBlock(block); result != ValidationAccept {
+			err = fmt.Errorf("block #%d did not pass validation", block.Header.Height)
+		}
+	}
+
+	p.shreds.mu.Lock()
+	if err != nil {
+		delete(set.roots, rootKey)
+		p.shreds.mu.Unlock()
+		log.Printf("⚠️  Failed to rebuild block %x from shreds: %v", shortHash(header.BlockHash), err)
+		return
+	}
+	select {
+	case <-set.done: // Rebuilt from another root, or given up
+		p.shreds.mu.Unlock()
+		return
+	default:
+	}
+	set.block = block
+	close(set.done)
+	p.shreds.mu.Unlock()
+
+	p.compactBlocks.add(header.BlockHash, block)
+	log.Printf("🧩 Rebuilt block #%d from %d shreds", block.Header.Height, header.DataShreds)
+	p.deliverBlock(header.BlockHash, block)
+}
+
+func decodeShreds(header *Shred, shards [][]byte) (*core.Block, error) {
+	rs, err := NewReedSolomon(header.DataShreds, header.ParityShreds)
+	if err != nil {
+		return nil, err
+	}
+	if err := rs.Reconstruct(shards); err != nil {
+		return nil, err
+	}
+	encoded := bytes.Join(shards[:header.DataShreds], nil)[:header.BlockSize]
+	block, ok := decodeBlock(encoded)
+	if !ok {
+		return nil, fmt.Errorf("invalid block encoding")
+	}
+	if hash, err := block.Hash(); err != nil || !bytes.Equal(hash, header.BlockHash) {
+		return nil, fmt.Errorf("block does not match its hash")
+	}
+	return block, nil
+}
+
+func newShredSet() *shredSet {
+	return &shredSet{roots: make(map[string]*shredRoot), created: time.Now(), done: make(chan struct{})}
+}
+
+// prune drops sets that did not complete in time. The caller holds s.mu.
+func (s *shredSets) prune() {
+	for key, set := range s.sets {
+		if time.Since(set.created) <= shredSetTTL {
+			continue
+		}
+		if set.block == nil {
+			close(set.done)
+		}
+		delete(s.sets, key)
+	}
+}
@@ -0,0 +1,130 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// bindValidator makes p take pid as validatorID's node
+func bindValidator(p *P2PNetwork, validatorID string, pid peer.ID) {
+	p.recordVerifier = func(*ValidatorRecord) error { return nil }
+	p.validatorRecords[validatorID] = &ValidatorRecord{ValidatorID: validatorID, PeerID: pid.String(), Timestamp: time.Now().UnixNano()}
+	p.peerValidators[pid] = validatorID
+}
+
+// TestShredMerkleProofs tests that every shred's proof verifies under the
+// root, for trees of any size, and that altered shreds do not
+func TestShredMerkleProofs(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 6, 7, 8, 9, 13, 2 * maxDataShreds} {
+		leaves := make([][]byte, n)
+		data := make([][]byte, n)
+		for i := range leaves {
+			data[i] = []byte{byte(i), byte(i >> 8)}
+			leaves[i] = shredLeaf(i, data[i])
+		}
+		levels := shredMerkleTree(leaves)
+		root := levels[len(levels)-1][0]
+
+		for i := 0; i < n; i++ {
+			shred := &Shred{Index: i, Data: data[i], Proof: shredMerkleProof(levels, i), MerkleRoot: root}
+			if !shred.verifyProof() {
+				t.Fatalf("%d leaves: proof of shred %d does not verify", n, i)
+			}
+			altered := *shred
+			altered.Data = []byte("altered")
+			if altered.verifyProof() {
+				t.Errorf("%d leaves: altered shred %d verifies", n, i)
+			}
+			moved := *shred
+			moved.Index = i + 1
+			if moved.verifyProof() {
+				t.Errorf("%d leaves: shred %d verifies at index %d", n, i, i+1)
+			}
+		}
+	}
+}
+
+// TestShredsRebuildDespiteForgedSets tests that shreds signed by a node other
+// than the proposer's, or that do not rebuild the block, do not keep the
+// proposer's shreds from rebuilding it
+func TestShredsRebuildDespiteForgedSets(t *testing.T) {
+	receiver := newOfflineNetwork(t)
+	proposer := newOfflineNetwork(t)
+	forger := newOfflineNetwork(t)
+	stranger := newOfflineNetwork(t)
+	for _, n := range []*P2PNetwork{proposer, forger, stranger} {
+		receiver.Host.Peerstore().AddPubKey(n.Host.ID(), n.Host.Peerstore().PubKey(n.Host.ID()))
+	}
+	bindValidator(receiver, "v1", proposer.Host.ID())
+	bindValidator(receiver, "v2", forger.Host.ID())
+
+	rng := rand.New(rand.NewSource(1))
+	block := &core.Block{Header: &core.BlockHeader{Height: 7}, ProposerID: "v1", Signature: make([]byte, 5*shredSize)}
+	rng.Read(block.Signature)
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatalf("Failed to hash block: %v", err)
+	}
+	encoded, _ := json.Marshal(block)
+	garbage := make([]byte, len(encoded))
+	rng.Read(garbage)
+
+	shred := func(n *P2PNetwork, encoded []byte) []*Shred {
+		shreds, err := n.shredBlock(hash, encoded)
+		if err != nil {
+			t.Fatalf("Failed to shred block: %v", err)
+		}
+		return shreds
+	}
+	// add adds any half of the shreds, as they would arrive with the rest lost
+	add := func(shreds []*Shred, expectFresh bool) {
+		for _, i := range rng.Perm(len(shreds))[:shreds[0].DataShreds] {
+			fresh, err := receiver.addShred(shreds[i])
+			if err != nil || fresh != expectFresh {
+				t.Fatalf("Shred %d: expected fresh=%v, got %v (%v)", i, expectFresh, fresh, err)
+			}
+		}
+	}
+
+	// A node bound to no validator cannot start a set
+	add(shred(stranger, encoded), false)
+	// Another validator's node: signs the block itself, or shreds garbage
+	// under the block's hash. Both sets complete and are dropped.
+	add(shred(forger, encoded), true)
+	add(shred(forger, garbage), true)
+	time.Sleep(100 * time.Millisecond)
+
+	add(shred(proposer, encoded), true)
+	rebuilt := receiver.shreds.wait(hash, false, 2*time.Second)
+	if rebuilt == nil {
+		t.Fatalf("Block should be rebuilt from the proposer's shreds")
+	}
+	if rebuiltHash, _ := rebuilt.Hash(); !bytes.Equal(rebuiltHash, hash) {
+		t.Errorf("Rebuilt block does not match its hash")
+	}
+
+	receiver.shreds.mu.Lock()
+	roots := len(receiver.shreds.sets[hex.EncodeToString(hash)].roots)
+	receiver.shreds.mu.Unlock()
+	if roots != 1 {
+		t.Errorf("Expected only the proposer's shreds kept, got %d sets", roots)
+	}
+
+	// Tampered shreds are refused outright
+	other := newOfflineNetwork(t)
+	other.Host.Peerstore().AddPubKey(proposer.Host.ID(), proposer.Host.Peerstore().PubKey(proposer.Host.ID()))
+	tampered := *shred(proposer, encoded)[0]
+	tampered.Data = append([]byte(nil), tampered.Data...)
+	tampered.Data[0] ^= 1
+	if _, err := other.addShred(&tampered); err == nil {
+		t.Errorf("Shred that does not match its proof should be refused")
+	}
+}
@@ -2,16 +2,21 @@ package network
 
 import (
 	"bufio"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"rnr-blockchain/pkg/core"
 )
 
 // SpeedTestRequest is sent by a tester to initiate speed test
@@ -32,6 +37,7 @@ type SpeedTestResponse struct {
 	Accepted        bool
 	PayloadReady    bool
 	ResponseHash    []byte
+	ProbePort       int // UDP probe responder port, 0 if unavailable
 	Timestamp       time.Time
 }
 
@@ -50,8 +56,10 @@ type SpeedTestResult struct {
 	TesterID           string
 	CandidateID        string
 	UploadBandwidth    float64
-	Latency            float64
+	Latency            float64 // Median UDP probe RTT, or the challenge round trip without probes
 	Jitter             float64
+	PacketLoss         float64 // % of UDP probes lost, or of payload chunks without probes
+	ResponseHash       []byte  // Candidate's answer to the challenge nonce
 	ChunkTimestamps    []time.Time
 	ReceivedChunks     int
 	VerifiedHashes     []string
@@ -64,15 +72,17 @@ type SpeedTestResult struct {
 func (p *P2PNetwork) SetupSpeedTestHandlers() {
 	p.Host.SetStreamHandler(protocol.ID(SpeedTestReqProtocol), p.handleSpeedTestRequest)
 	p.Host.SetStreamHandler(protocol.ID(SpeedTestStreamProtocol), p.handleSpeedTestStream)
+	p.Host.SetStreamHandler(protocol.ID(SpeedTestChallengeProtocol), p.handleSpeedTestChallenge)
 	fmt.Println("✅ P2P Speed Test protocol handlers registered")
 }
 
 // handleSpeedTestRequest handles incoming speed test requests from testers
 func (p *P2PNetwork) handleSpeedTestRequest(stream network.Stream) {
+	stream = p.emulateStream(stream)
 	defer stream.Close()
 
 	var request SpeedTestRequest
-	if err := json.NewDecoder(stream).Decode(&request); err != nil {
+	if _, err := ReadMessageOf(stream, core.MessageTypePoBTestRequest, &request); err != nil {
 		fmt.Printf("❌ Failed to decode speed test request: %v\n", err)
 		return
 	}
@@ -90,7 +100,7 @@ func (p *P2PNetwork) handleSpeedTestRequest(stream network.Stream) {
 	}
 
 	// Send response
-	if err := json.NewEncoder(stream).Encode(&response); err != nil {
+	if err := p.writeMessage(stream, core.MessageTypePoBTestResponse, &response); err != nil {
 		fmt.Printf("❌ Failed to send speed test response: %v\n", err)
 		return
 	}
@@ -100,6 +110,7 @@ func (p *P2PNetwork) handleSpeedTestRequest(stream network.Stream) {
 
 // handleSpeedTestStream handles the actual speed test data stream
 func (p *P2PNetwork) handleSpeedTestStream(stream network.Stream) {
+	stream = p.emulateStream(stream)
 	defer stream.Close()
 
 	startTime := time.Now()
@@ -111,7 +122,7 @@ func (p *P2PNetwork) handleSpeedTestStream(stream network.Stream) {
 
 	for {
 		var chunk SpeedTestChunk
-		if err := json.NewDecoder(reader).Decode(&chunk); err != nil {
+		if _, err := ReadMessageOf(reader, core.MessageTypeSpeedTestChunk, &chunk); err != nil {
 			if err == io.EOF {
 				break
 			}
@@ -137,7 +148,7 @@ func (p *P2PNetwork) handleSpeedTestStream(stream network.Stream) {
 			"chunk_index": chunk.ChunkIndex,
 			"ack":         true,
 		}
-		if err := json.NewEncoder(stream).Encode(&ack); err != nil {
+		if err := p.writeMessage(stream, core.MessageTypeSpeedTestAck, &ack); err != nil {
 			fmt.Printf("❌ Failed to send ACK: %v\n", err)
 			break
 		}
@@ -158,16 +169,17 @@ func (p *P2PNetwork) RequestSpeedTest(peerID peer.ID, request *SpeedTestRequest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open stream: %w", err)
 	}
+	stream = p.emulateStream(stream)
 	defer stream.Close()
 
 	// Send request
-	if err := json.NewEncoder(stream).Encode(request); err != nil {
+	if err := p.writeMessage(stream, core.MessageTypePoBTestRequest, request); err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
 	// Wait for response
 	var response SpeedTestResponse
-	if err := json.NewDecoder(stream).Decode(&response); err != nil {
+	if _, err := ReadMessageOf(stream, core.MessageTypePoBTestResponse, &response); err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
@@ -182,6 +194,7 @@ func (p *P2PNetwork) ConductSpeedTest(peerID peer.ID, sessionID string, payload
 	if err != nil {
 		return nil, fmt.Errorf("failed to open stream: %w", err)
 	}
+	stream = p.emulateStream(stream)
 	defer stream.Close()
 
 	writer := bufio.NewWriter(stream)
@@ -212,7 +225,7 @@ func (p *P2PNetwork) ConductSpeedTest(peerID peer.ID, sessionID string, payload
 		chunkTimestamps[i] = chunk.Timestamp
 
 		// Send chunk
-		if err := json.NewEncoder(writer).Encode(&chunk); err != nil {
+		if err := p.writeMessage(writer, core.MessageTypeSpeedTestChunk, &chunk); err != nil {
 			return nil, fmt.Errorf("failed to send chunk %d: %w", i, err)
 		}
 		
@@ -222,7 +235,7 @@ func (p *P2PNetwork) ConductSpeedTest(peerID peer.ID, sessionID string, payload
 
 		// Wait for ACK
 		var ack map[string]interface{}
-		if err := json.NewDecoder(reader).Decode(&ack); err != nil {
+		if _, err := ReadMessageOf(reader, core.MessageTypeSpeedTestAck, &ack); err != nil {
 			return nil, fmt.Errorf("failed to receive ACK for chunk %d: %w", i, err)
 		}
 	}
@@ -244,14 +257,235 @@ func (p *P2PNetwork) ConductSpeedTest(peerID peer.ID, sessionID string, payload
 	return result, nil
 }
 
-// GetPeerIDByValidatorID converts validator ID to peer ID
-// This is a placeholder - actual implementation would maintain a mapping
-func (p *P2PNetwork) GetPeerIDByValidatorID(validatorID string) (peer.ID, error) {
-	// TODO: Implement proper validator ID to peer ID mapping
-	// For now, try to decode the validator ID as a peer ID
-	peerID, err := peer.Decode(validatorID)
+// SpeedTestChallengeHandler answers a tester's PoB challenge on the candidate
+// side. It returns the challenge response and a function producing the payload
+// to upload, or an error to reject the request.
+type SpeedTestChallengeHandler func(request *SpeedTestRequest) (responseHash []byte, payload func() ([]byte, error), err error)
+
+// SetSpeedTestChallengeHandler sets the handler for incoming PoB challenges
+func (p *P2PNetwork) SetSpeedTestChallengeHandler(handler SpeedTestChallengeHandler) {
+	p.speedTestHandler = handler
+}
+
+// SetUDPProbeResponder offers testers UDP probing of this node through
+// responder, which must be listening
+func (p *P2PNetwork) SetUDPProbeResponder(responder *UDPProbeResponder) {
+	p.probeResponder = responder
+}
+
+// SetNetworkEmulation runs this node's speed test streams over link, so
+// speed tests between local nodes see the link's bandwidth, latency and loss
+func (p *P2PNetwork) SetNetworkEmulation(link *NetemLink) {
+	p.emulation = link
+}
+
+func (p *P2PNetwork) emulateStream(stream network.Stream) network.Stream {
+	if p.emulation == nil {
+		return stream
+	}
+	return p.emulation.Stream(stream)
+}
+
+// handleSpeedTestChallenge answers a tester's challenge and uploads the
+// requested payload on the same stream
+func (p *P2PNetwork) handleSpeedTestChallenge(stream network.Stream) {
+	stream = p.emulateStream(stream)
+	defer stream.Close()
+
+	var request SpeedTestRequest
+	if _, err := ReadMessageOf(stream, core.MessageTypePoBTestRequest, &request); err != nil {
+		fmt.Printf("❌ Failed to decode speed test challenge: %v\n", err)
+		return
+	}
+
+	response := SpeedTestResponse{
+		SessionID:   request.SessionID,
+		CandidateID: request.CandidateID,
+		Timestamp:   time.Now(),
+	}
+
+	var payloadFunc func() ([]byte, error)
+	if !p.isValidatorPeer(request.TesterID, stream.Conn().RemotePeer()) {
+		fmt.Printf("⚠️  Rejected speed test challenge: %s is not the peer of tester %s\n",
+			shortPeerID(stream.Conn().RemotePeer()), request.TesterID)
+	} else if p.speedTestHandler != nil {
+		responseHash, payload, err := p.speedTestHandler(&request)
+		if err != nil {
+			fmt.Printf("⚠️  Rejected speed test challenge from %s: %v\n", request.TesterID, err)
+		} else {
+			response.Accepted = true
+			response.PayloadReady = true
+			response.ResponseHash = responseHash
+			payloadFunc = payload
+
+			if p.probeResponder != nil && p.probeResponder.Port() > 0 {
+				p.probeResponder.Authorize(DeriveUDPProbeKey(request.SessionID, responseHash), udpProbeKeyTTL)
+				response.ProbePort = p.probeResponder.Port()
+			}
+		}
+	}
+
+	if err := p.writeMessage(stream, core.MessageTypePoBTestResponse, &response); err != nil {
+		fmt.Printf("❌ Failed to send challenge response: %v\n", err)
+		return
+	}
+	if !response.Accepted {
+		return
+	}
+
+	payload, err := payloadFunc()
+	if err != nil {
+		fmt.Printf("❌ Failed to generate speed test payload: %v\n", err)
+		return
+	}
+	if request.ExpectedChunks <= 0 {
+		return
+	}
+	chunkSize := (len(payload) + request.ExpectedChunks - 1) / request.ExpectedChunks
+
+	writer := bufio.NewWriter(stream)
+	for i := 0; i < request.ExpectedChunks; i++ {
+		chunkStart := i * chunkSize
+		chunkEnd := chunkStart + chunkSize
+		if chunkEnd > len(payload) {
+			chunkEnd = len(payload)
+		}
+		data := payload[chunkStart:chunkEnd]
+		hash := sha256.Sum256(data)
+
+		chunk := SpeedTestChunk{
+			SessionID:  request.SessionID,
+			ChunkIndex: i,
+			Data:       data,
+			ChunkHash:  hex.EncodeToString(hash[:]),
+			Timestamp:  time.Now(),
+		}
+		if err := p.writeMessage(writer, core.MessageTypeSpeedTestChunk, &chunk); err != nil {
+			fmt.Printf("❌ Failed to upload chunk %d: %v\n", i, err)
+			return
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		fmt.Printf("❌ Failed to flush speed test payload: %v\n", err)
+		return
+	}
+
+	fmt.Printf("📤 Uploaded speed test payload to %s (session: %s)\n", request.TesterID, request.SessionID)
+}
+
+// ChallengeSpeedTest runs a timed PoB challenge against a candidate. The
+// candidate must answer the challenge within responseTimeout and then upload
+// its payload, whose chunks are checked against expectedHashes; chunks
+// missing or failing verification when transferTimeout expires make the
+// payload unverified. Latency, jitter and packet loss then come from UDP
+// probes of the candidate. A candidate offering no probes is measured on the
+// stream alone (challenge round trip, chunk loss) and flagged as an anomaly.
+func (p *P2PNetwork) ChallengeSpeedTest(peerID peer.ID, request *SpeedTestRequest, expectedHashes []string, responseTimeout, transferTimeout time.Duration) (*SpeedTestResult, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, responseTimeout)
+	defer cancel()
+
+	stream, err := p.Host.NewStream(ctx, peerID, protocol.ID(SpeedTestChallengeProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	stream = p.emulateStream(stream)
+	defer stream.Close()
+
+	startTime := time.Now()
+	stream.SetDeadline(startTime.Add(responseTimeout))
+
+	if err := p.writeMessage(stream, core.MessageTypePoBTestRequest, request); err != nil {
+		return nil, fmt.Errorf("failed to send challenge: %w", err)
+	}
+
+	reader := bufio.NewReader(stream)
+	var response SpeedTestResponse
+	if _, err := ReadMessageOf(reader, core.MessageTypePoBTestResponse, &response); err != nil {
+		return nil, fmt.Errorf("no challenge response: %w", err)
+	}
+	latency := time.Since(startTime)
+
+	if !response.Accepted {
+		return nil, fmt.Errorf("candidate rejected the challenge")
+	}
+
+	result := &SpeedTestResult{
+		SessionID:    request.SessionID,
+		TesterID:     request.TesterID,
+		CandidateID:  request.CandidateID,
+		Latency:      float64(latency.Microseconds()) / 1000,
+		ResponseHash: response.ResponseHash,
+		Anomalies:    []string{},
+	}
+
+	transferStart := time.Now()
+	stream.SetDeadline(transferStart.Add(transferTimeout))
+
+	verified := make(map[int]bool, len(expectedHashes))
+	verifiedBytes := 0
+	for result.ReceivedChunks < len(expectedHashes) {
+		var chunk SpeedTestChunk
+		if _, err := ReadMessageOf(reader, core.MessageTypeSpeedTestChunk, &chunk); err != nil {
+			if err != io.EOF {
+				result.Anomalies = append(result.Anomalies, fmt.Sprintf("transfer interrupted: %v", err))
+			}
+			break
+		}
+		result.ChunkTimestamps = append(result.ChunkTimestamps, time.Now())
+		result.ReceivedChunks++
+
+		hash := sha256.Sum256(chunk.Data)
+		actualHash := hex.EncodeToString(hash[:])
+		index := chunk.ChunkIndex
+		if index < 0 || index >= len(expectedHashes) || verified[index] || expectedHashes[index] != actualHash {
+			result.Anomalies = append(result.Anomalies, fmt.Sprintf("chunk %d failed verification", index))
+			continue
+		}
+
+		verified[index] = true
+		verifiedBytes += len(chunk.Data)
+		result.VerifiedHashes = append(result.VerifiedHashes, actualHash)
+	}
+
+	// Only verified bytes count towards bandwidth
+	if elapsed := time.Since(transferStart); elapsed > 0 {
+		result.UploadBandwidth = float64(verifiedBytes) / (1024 * 1024) / elapsed.Seconds()
+	}
+	lost := len(expectedHashes) - len(verified)
+	if len(expectedHashes) > 0 {
+		result.PacketLoss = float64(lost) * 100 / float64(len(expectedHashes))
+	}
+	result.PayloadVerified = lost == 0
+
+	// Probe after the transfer so the RTTs are not inflated by it
+	if probe, err := p.probeCandidate(stream, request.SessionID, &response); err != nil {
+		result.Anomalies = append(result.Anomalies, fmt.Sprintf("no UDP probe: %v", err))
+	} else {
+		result.Latency = probe.MedianRTT
+		result.Jitter = probe.Jitter
+		result.PacketLoss = probe.PacketLoss
+	}
+	result.Timestamp = time.Now()
+
+	return result, nil
+}
+
+// probeCandidate runs UDP probes against the responder the candidate offered
+// in response, at the IP address of stream's connection
+func (p *P2PNetwork) probeCandidate(stream network.Stream, sessionID string, response *SpeedTestResponse) (*UDPProbeResult, error) {
+	if response.ProbePort <= 0 {
+		return nil, fmt.Errorf("candidate offered no probe port")
+	}
+
+	remote := stream.Conn().RemoteMultiaddr()
+	ip, err := remote.ValueForProtocol(ma.P_IP4)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode validator ID as peer ID: %w", err)
+		if ip, err = remote.ValueForProtocol(ma.P_IP6); err != nil {
+			return nil, fmt.Errorf("no IP address in %s", remote)
+		}
 	}
-	return peerID, nil
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(response.ProbePort))
+	key := DeriveUDPProbeKey(sessionID, response.ResponseHash)
+	return ProbeUDP(addr, key, DefaultUDPProbeConfig())
 }
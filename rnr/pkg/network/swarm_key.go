@@ -0,0 +1,78 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// Private networks: nodes given a swarm key, a 32-byte pre-shared key, run
+// every connection through libp2p's pnet protector, which encrypts it with
+// the key before the transport handshake. A node without the key, or with
+// another one, fails the handshake and never reaches any protocol. The key
+// file is in the usual libp2p format, so other libp2p tools accept it.
+
+const SwarmKeyFile = "swarm.key"
+
+const swarmKeyHeader = "/key/swarm/psk/1.0.0/\n/base16/\n"
+
+// GenerateSwarmKey returns a new random swarm key, encoded as a key file
+func GenerateSwarmKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate swarm key: %w", err)
+	}
+	return []byte(swarmKeyHeader + hex.EncodeToString(key) + "\n"), nil
+}
+
+// WriteSwarmKey generates a swarm key and saves it at path, refusing to
+// replace an existing key
+func WriteSwarmKey(path string) (pnet.PSK, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("swarm key %s already exists", path)
+	}
+	data, err := GenerateSwarmKey()
+	if err != nil {
+		return nil, err
+	}
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save swarm key: %w", err)
+	}
+	return psk, nil
+}
+
+// LoadSwarmKey reads the swarm key at path
+func LoadSwarmKey(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swarm key: %w", err)
+	}
+	defer f.Close()
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode swarm key %s: %w", path, err)
+	}
+	return psk, nil
+}
+
+// SwarmKeyFingerprint identifies a swarm key without revealing it, so
+// operators can check that their nodes share the same key
+func SwarmKeyFingerprint(psk pnet.PSK) string {
+	sum := sha256.Sum256(psk)
+	return hex.EncodeToString(sum[:8])
+}
+
+// IsPrivateNetwork reports whether this node only talks to nodes holding
+// its swarm key
+func (p *P2PNetwork) IsPrivateNetwork() bool {
+	return p.privateNetwork
+}
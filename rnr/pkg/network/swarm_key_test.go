@@ -0,0 +1,89 @@
+package network
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSwarmKey tests that swarm keys are saved in the libp2p key file
+// format, load back the same, and are never replaced
+func TestSwarmKey(t *testing.T) {
+	dir := t.TempDir()
+	data, err := GenerateSwarmKey()
+	if err != nil {
+		t.Fatalf("GenerateSwarmKey failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(swarmKeyHeader)) || len(data) != len(swarmKeyHeader)+65 {
+		t.Errorf("Unexpected swarm key file %q", data)
+	}
+	if other, _ := GenerateSwarmKey(); bytes.Equal(data, other) {
+		t.Errorf("Generated swarm keys should differ")
+	}
+
+	path := filepath.Join(dir, SwarmKeyFile)
+	psk, err := WriteSwarmKey(path)
+	if err != nil {
+		t.Fatalf("WriteSwarmKey failed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Swarm key should be saved readable by the owner only")
+	}
+	loaded, err := LoadSwarmKey(path)
+	if err != nil {
+		t.Fatalf("LoadSwarmKey failed: %v", err)
+	}
+	if !bytes.Equal(psk, loaded) || SwarmKeyFingerprint(psk) != SwarmKeyFingerprint(loaded) {
+		t.Errorf("Loaded swarm key differs from the one written")
+	}
+	if _, err := WriteSwarmKey(path); err == nil {
+		t.Errorf("Existing swarm key should not be replaced")
+	}
+	if again, _ := LoadSwarmKey(path); !bytes.Equal(again, psk) {
+		t.Errorf("Refused write should leave the swarm key as it was")
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.key")
+	os.WriteFile(corrupt, []byte(swarmKeyHeader+"not hex\n"), 0600)
+	if _, err := LoadSwarmKey(corrupt); err == nil {
+		t.Errorf("Corrupt swarm key should be refused")
+	}
+	if _, err := LoadSwarmKey(filepath.Join(dir, "missing.key")); err == nil {
+		t.Errorf("Missing swarm key should be an error")
+	}
+}
+
+// TestPrivateNetwork tests that nodes sharing a swarm key connect, and that
+// nodes with another key or none cannot
+func TestPrivateNetwork(t *testing.T) {
+	dir := t.TempDir()
+	key, other := filepath.Join(dir, "a.key"), filepath.Join(dir, "b.key")
+	for _, path := range []string{key, other} {
+		if _, err := WriteSwarmKey(path); err != nil {
+			t.Fatalf("WriteSwarmKey failed: %v", err)
+		}
+	}
+
+	a := newTestNode(t, P2PConfig{SwarmKeyFile: key}, "rnr-1")
+	b := newTestNode(t, P2PConfig{SwarmKeyFile: key}, "rnr-1")
+	stranger := newTestNode(t, P2PConfig{SwarmKeyFile: other}, "rnr-1")
+	public := newTestNode(t, P2PConfig{}, "rnr-1")
+	if !a.IsPrivateNetwork() || public.IsPrivateNetwork() {
+		t.Errorf("Only nodes given a swarm key should be private")
+	}
+
+	if err := connectNodes(a, b); err != nil {
+		t.Errorf("Nodes sharing a swarm key should connect: %v", err)
+	}
+	if err := connectNodes(a, stranger); err == nil {
+		t.Errorf("Node with another swarm key should not connect")
+	}
+	if err := connectNodes(public, a); err == nil {
+		t.Errorf("Node without a swarm key should not connect")
+	}
+
+	if _, err := NewP2PNetwork(P2PConfig{DataDir: t.TempDir(), SwarmKeyFile: filepath.Join(dir, "missing.key")}); err == nil {
+		t.Errorf("Node should not start with a missing swarm key")
+	}
+}
@@ -0,0 +1,377 @@
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UDP probes measure latency, jitter and packet loss without TCP hiding
+// losses behind retransmits. The tester sends sequenced probes to the
+// candidate's responder, which echoes each one back. Both directions carry a
+// MAC under a per-session key, so the responder only answers testers it has
+// authorized and the tester only counts echoes from the candidate. The
+// candidate holds the key too, so each probe also carries a random nonce only
+// the tester knows until it is sent: a reply must echo the nonce and send
+// time of its probe, so the candidate cannot answer probes it has not
+// received. Replies are the same size as probes, so the responder cannot be
+// used as an amplifier.
+//
+// Probe layout (udpProbeSize bytes):
+//
+//	[0:4]   magic ("RNRQ" probe, "RNRA" reply)
+//	[4:8]   sequence number
+//	[8:16]  tester send time (unix ns)
+//	[16:24] key ID, first 8 bytes of SHA-256(key)
+//	[24:48] tester nonce, random per probe
+//	[48:64] truncated HMAC-SHA256 of bytes [0:48]
+const (
+	udpProbeSize   = 64
+	udpProbeMACLen = 16
+	udpProbeMACAt  = udpProbeSize - udpProbeMACLen
+	udpProbeNonce  = 24 // Offset of the tester nonce, which runs to udpProbeMACAt
+
+	// udpProbeKeyTTL covers a PoB transfer followed by the probe run
+	udpProbeKeyTTL = 2 * time.Minute
+)
+
+var (
+	udpProbeMagic = [4]byte{'R', 'N', 'R', 'Q'}
+	udpReplyMagic = [4]byte{'R', 'N', 'R', 'A'}
+)
+
+// UDPProbeConfig controls a probe run
+type UDPProbeConfig struct {
+	Count    int           // Probes sent
+	Interval time.Duration // Gap between probes
+	Timeout  time.Duration // Wait for replies after the last probe
+}
+
+// DefaultUDPProbeConfig sends 1000 probes, enough to resolve the 0.1%
+// packet loss target (one lost probe), over about 5 seconds
+func DefaultUDPProbeConfig() UDPProbeConfig {
+	return UDPProbeConfig{
+		Count:    1000,
+		Interval: 5 * time.Millisecond,
+		Timeout:  time.Second,
+	}
+}
+
+// UDPProbeResult is the RTT distribution and loss of a probe run. Times are
+// in milliseconds, loss in percent.
+type UDPProbeResult struct {
+	Sent       int
+	Received   int
+	Duplicates int
+	MinRTT     float64
+	MedianRTT  float64
+	P95RTT     float64
+	MaxRTT     float64
+	Jitter     float64 // Mean difference between consecutive RTTs (RFC 3550)
+	PacketLoss float64
+}
+
+// DeriveUDPProbeKey derives the probe key of a session from a secret both
+// sides hold, such as the candidate's challenge response
+func DeriveUDPProbeKey(sessionID string, secret []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("rnr-pob-udp-probe"))
+	h.Write([]byte(sessionID))
+	h.Write(secret)
+	return h.Sum(nil)
+}
+
+func udpProbeKeyID(key []byte) [8]byte {
+	var id [8]byte
+	sum := sha256.Sum256(key)
+	copy(id[:], sum[:8])
+	return id
+}
+
+func udpProbeMAC(key, packet []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(packet[:udpProbeMACAt])
+	return mac.Sum(nil)[:udpProbeMACLen]
+}
+
+// sealUDPProbe sets magic and MAC on packet
+func sealUDPProbe(packet []byte, magic [4]byte, key []byte) {
+	copy(packet[0:4], magic[:])
+	copy(packet[udpProbeMACAt:], udpProbeMAC(key, packet))
+}
+
+// openUDPProbe checks packet's size, magic and MAC
+func openUDPProbe(packet []byte, magic [4]byte, key []byte) bool {
+	if len(packet) != udpProbeSize || string(packet[0:4]) != string(magic[:]) {
+		return false
+	}
+	return hmac.Equal(packet[udpProbeMACAt:], udpProbeMAC(key, packet))
+}
+
+// UDPProbeResponder echoes authenticated probes. Each authorized key may be
+// used for a limited number of probes before it expires.
+type UDPProbeResponder struct {
+	conn      net.PacketConn
+	keys      map[[8]byte]*udpProbeKey
+	emulation *NetemLink
+	mu        sync.Mutex
+}
+
+type udpProbeKey struct {
+	key       []byte
+	expires   time.Time
+	remaining int
+}
+
+// NewUDPProbeResponder creates a responder; call Listen to start it
+func NewUDPProbeResponder() *UDPProbeResponder {
+	return &UDPProbeResponder{
+		keys: make(map[[8]byte]*udpProbeKey),
+	}
+}
+
+// SetNetworkEmulation makes the responder answer over link. Call before Listen.
+func (r *UDPProbeResponder) SetNetworkEmulation(link *NetemLink) {
+	r.emulation = link
+}
+
+// Listen starts answering probes on addr (e.g. ":8080")
+func (r *UDPProbeResponder) Listen(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("invalid UDP probe address %s: %w", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for UDP probes on %s: %w", addr, err)
+	}
+	r.conn = conn
+	if r.emulation != nil {
+		r.conn = r.emulation.PacketConn(conn)
+	}
+
+	go r.serve()
+	return nil
+}
+
+// Port returns the UDP port the responder listens on, or 0 if not listening
+func (r *UDPProbeResponder) Port() int {
+	if r.conn == nil {
+		return 0
+	}
+	return r.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Authorize lets a tester holding key probe the responder for ttl
+func (r *UDPProbeResponder) Authorize(key []byte, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, k := range r.keys {
+		if now.After(k.expires) {
+			delete(r.keys, id)
+		}
+	}
+	r.keys[udpProbeKeyID(key)] = &udpProbeKey{
+		key:       key,
+		expires:   now.Add(ttl),
+		remaining: 2 * DefaultUDPProbeConfig().Count,
+	}
+}
+
+// Close stops the responder
+func (r *UDPProbeResponder) Close() error {
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+func (r *UDPProbeResponder) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, src, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if reply := r.answer(buf[:n]); reply != nil {
+			r.conn.WriteTo(reply, src)
+		}
+	}
+}
+
+// answer returns the reply to packet, or nil if it is not an authorized probe
+func (r *UDPProbeResponder) answer(packet []byte) []byte {
+	if len(packet) != udpProbeSize {
+		return nil
+	}
+	var id [8]byte
+	copy(id[:], packet[16:24])
+
+	r.mu.Lock()
+	k, ok := r.keys[id]
+	if !ok || time.Now().After(k.expires) || k.remaining <= 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	k.remaining--
+	key := k.key
+	r.mu.Unlock()
+
+	if !openUDPProbe(packet, udpProbeMagic, key) {
+		return nil
+	}
+	reply := make([]byte, udpProbeSize)
+	copy(reply, packet)
+	sealUDPProbe(reply, udpReplyMagic, key)
+	return reply
+}
+
+// ProbeUDP sends config.Count probes to the responder at addr and measures
+// the echoes. Probes unanswered config.Timeout after the last one is sent
+// count as lost; a responder that never answers yields 100% loss.
+func ProbeUDP(addr string, key []byte, config UDPProbeConfig) (*UDPProbeResult, error) {
+	if config.Count <= 0 {
+		return nil, fmt.Errorf("probe count must be positive")
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial UDP probe responder %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	keyID := udpProbeKeyID(key)
+	nonces := make([]byte, config.Count*(udpProbeMACAt-udpProbeNonce))
+	if _, err := rand.Read(nonces); err != nil {
+		return nil, fmt.Errorf("failed to generate probe nonces: %w", err)
+	}
+	nonce := func(seq int) []byte {
+		size := udpProbeMACAt - udpProbeNonce
+		return nonces[seq*size : (seq+1)*size]
+	}
+	sentAt := make([]time.Time, config.Count)
+	rtts := make([]time.Duration, config.Count)
+	received := make([]bool, config.Count)
+	result := &UDPProbeResult{}
+	var mu sync.Mutex
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 2048)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue // e.g. ICMP port unreachable; the probe counts as lost
+			}
+			now := time.Now()
+			if !openUDPProbe(buf[:n], udpReplyMagic, key) {
+				continue
+			}
+			seq := binary.BigEndian.Uint32(buf[4:8])
+			if int(seq) >= config.Count {
+				continue
+			}
+
+			mu.Lock()
+			// Only the echo of a probe actually sent carries its send
+			// time and nonce
+			if sentAt[seq].IsZero() ||
+				binary.BigEndian.Uint64(buf[8:16]) != uint64(sentAt[seq].UnixNano()) ||
+				!bytes.Equal(buf[udpProbeNonce:udpProbeMACAt], nonce(int(seq))) {
+				mu.Unlock()
+				continue
+			}
+			if received[seq] {
+				result.Duplicates++
+			} else {
+				received[seq] = true
+				rtts[seq] = now.Sub(sentAt[seq])
+				result.Received++
+			}
+			all := result.Received == config.Count
+			mu.Unlock()
+			if all {
+				return
+			}
+		}
+	}()
+
+	packet := make([]byte, udpProbeSize)
+	copy(packet[16:24], keyID[:])
+	for seq := 0; seq < config.Count; seq++ {
+		binary.BigEndian.PutUint32(packet[4:8], uint32(seq))
+		copy(packet[udpProbeNonce:udpProbeMACAt], nonce(seq))
+		mu.Lock()
+		sentAt[seq] = time.Now()
+		binary.BigEndian.PutUint64(packet[8:16], uint64(sentAt[seq].UnixNano()))
+		mu.Unlock()
+		sealUDPProbe(packet, udpProbeMagic, key)
+
+		if _, err := conn.Write(packet); err == nil {
+			result.Sent++
+		}
+		if config.Interval > 0 {
+			time.Sleep(config.Interval)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(config.Timeout))
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Probes that failed to send count as lost too
+	result.PacketLoss = float64(config.Count-result.Received) * 100 / float64(config.Count)
+
+	ordered := make([]float64, 0, result.Received)
+	var jitterSum float64
+	for seq := 0; seq < config.Count; seq++ {
+		if !received[seq] {
+			continue
+		}
+		rtt := float64(rtts[seq].Nanoseconds()) / 1e6
+		if len(ordered) > 0 {
+			diff := rtt - ordered[len(ordered)-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			jitterSum += diff
+		}
+		ordered = append(ordered, rtt)
+	}
+	if len(ordered) == 0 {
+		return result, nil
+	}
+	if len(ordered) > 1 {
+		result.Jitter = jitterSum / float64(len(ordered)-1)
+	}
+
+	sort.Float64s(ordered)
+	result.MinRTT = ordered[0]
+	result.MaxRTT = ordered[len(ordered)-1]
+	result.MedianRTT = ordered[len(ordered)/2]
+	if len(ordered)%2 == 0 {
+		result.MedianRTT = (ordered[len(ordered)/2-1] + ordered[len(ordered)/2]) / 2
+	}
+	result.P95RTT = ordered[(len(ordered)*95+99)/100-1]
+
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// forgingResponder answers every probe with a reply the candidate could
+// build from the key alone, altered by forge
+func forgingResponder(t *testing.T, key []byte, forge func(reply []byte)) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, src, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			reply := append([]byte(nil), buf[:n]...)
+			forge(reply)
+			sealUDPProbe(reply, udpReplyMagic, key)
+			conn.WriteTo(reply, src)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// TestUDPProbeRepliesEchoNonceAndSendTime tests that only echoes of the
+// probes actually sent count as received
+func TestUDPProbeRepliesEchoNonceAndSendTime(t *testing.T) {
+	key := DeriveUDPProbeKey("session", []byte("secret"))
+	config := UDPProbeConfig{Count: 20, Interval: time.Millisecond, Timeout: 200 * time.Millisecond}
+
+	responder := NewUDPProbeResponder()
+	if err := responder.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer responder.Close()
+	responder.Authorize(key, time.Minute)
+
+	result, err := ProbeUDP(responder.conn.LocalAddr().String(), key, config)
+	if err != nil {
+		t.Fatalf("ProbeUDP failed: %v", err)
+	}
+	if result.Received != config.Count || result.PacketLoss != 0 {
+		t.Fatalf("Expected every probe echoed, got %d/%d", result.Received, config.Count)
+	}
+
+	cases := map[string]func(reply []byte){
+		"guessed nonce": func(reply []byte) {
+			for i := udpProbeNonce; i < udpProbeMACAt; i++ {
+				reply[i] = 0
+			}
+		},
+		"early send time": func(reply []byte) {
+			reply[15]--
+		},
+	}
+	for name, forge := range cases {
+		result, err := ProbeUDP(forgingResponder(t, key, forge), key, config)
+		if err != nil {
+			t.Fatalf("%s: ProbeUDP failed: %v", name, err)
+		}
+		if result.Received != 0 || result.PacketLoss != 100 {
+			t.Errorf("%s: forged replies should not count, got %d received", name, result.Received)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package network
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Validator identity records bind a validator to the peer it runs on. A
+// record is signed by the validator's ECDSA key, carries a VRF proof under
+// its VRF key and is signed by the node key of the peer it names, so
+// neither a validator nor a peer can claim the other without its key.
+// Validators gossip their records, renew them periodically and hand them
+// to every newly accepted peer; nodes verify them against the keys in state
+// and route messages meant for a validator to its peer.
+
+const (
+	TopicValidatorRecords   = "/rnr/validators/1"
+	ValidatorRecordProtocol = "/rnr/validator-record/1.0.0"
+)
+
+const (
+	validatorRecordTTL      = 30 * time.Minute // Records not renewed within this expire
+	validatorRecordRefresh  = 10 * time.Minute
+	maxValidatorRecordAddrs = 8
+	maxValidatorRecordBytes = 8192
+	maxRecordClockSkew      = 5 * time.Minute
+)
+
+// ValidatorRecord links a validator's keys to its peer ID and addresses
+type ValidatorRecord struct {
+	ValidatorID   string   `json:"validator_id"`
+	PeerID        string   `json:"peer_id"`
+	PeerPublicKey []byte   `json:"peer_public_key"` // libp2p encoding; RSA peer IDs do not embed the key
+	Addrs         []string `json:"addrs"`
+	Timestamp     int64    `json:"timestamp"` // Unix nanoseconds; a newer record replaces an older one
+
+	Signature     []byte `json:"signature"`  // Validator's ECDSA signature of SigningHash
+	VRFOutput     []byte `json:"vrf_output"` // VRF of SigningHash under the validator's VRF key
+	VRFProof      []byte `json:"vrf_proof"`
+	PeerSignature []byte `json:"peer_signature"` // Node key's signature of SigningHash
+}
+
+// SigningHash is the hash the validator and node keys sign
+func (r *ValidatorRecord) SigningHash() []byte {
+	data, _ := json.Marshal(struct {
+		ValidatorID   string
+		PeerID        string
+		PeerPublicKey []byte
+		Addrs         []string
+		Timestamp     int64
+	}{r.ValidatorID, r.PeerID, r.PeerPublicKey, r.Addrs, r.Timestamp})
+	hash := sha256.Sum256(append([]byte("rnr-validator-record:"), data...))
+	return hash[:]
+}
+
+// ValidatorRecordSigner sets a record's Signature, VRFOutput and VRFProof
+// with the local validator's keys
+type ValidatorRecordSigner func(record *ValidatorRecord) error
+
+// ValidatorRecordVerifier checks a record's Signature and VRF proof against
+// the keys its validator has in state
+type ValidatorRecordVerifier func(record *ValidatorRecord) error
+
+// SetValidatorRecordSigner makes this node publish records binding
+// validatorID to its peer, and renew them until the network closes
+func (p *P2PNetwork) SetValidatorRecordSigner(validatorID string, signer ValidatorRecordSigner) {
+	p.mu.Lock()
+	started := p.recordSigner != nil
+	p.localValidatorID = validatorID
+	p.recordSigner = signer
+	p.mu.Unlock()
+	if started {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(validatorRecordRefresh)
+		defer ticker.Stop()
+		for {
+			if err := p.publishValidatorRecord(); err != nil {
+				log.Printf("⚠️  Failed to publish validator record: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// SetValidatorRecordVerifier sets the check of gossiped records against
+// state. Without one, records are neither accepted nor relayed.
+func (p *P2PNetwork) SetValidatorRecordVerifier(verifier ValidatorRecordVerifier) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.recordVerifier = verifier
+}
+
+// sendValidatorRecord hands this node's record to a newly accepted peer,
+// which would otherwise wait for its renewal to learn it
+func (p *P2PNetwork) sendValidatorRecord(pid peer.ID) {
+	p.mu.RLock()
+	record := p.validatorRecords[p.localValidatorID]
+	p.mu.RUnlock()
+	if record == nil || record.PeerID != p.Host.ID().String() {
+		return
+	}
+
+	stream, err := p.Host.NewStream(p.ctx, pid, protocol.ID(ValidatorRecordProtocol))
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(statusTimeout))
+	p.writeMessage(stream, core.MessageTypeValidatorRecord, record)
+}
+
+// handleValidatorRecordStream receives a record a peer hands over, checked
+// like a gossiped one
+func (p *P2PNetwork) handleValidatorRecordStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(statusTimeout))
+
+	msg, _, err := ReadMessage(stream, core.MessageTypeValidatorRecord)
+	if err != nil || msg.Type != core.MessageTypeValidatorRecord {
+		return
+	}
+	data := msg.Payload
+	from := stream.Conn().RemotePeer()
+	switch p.validateValidatorRecordMessage(from, data) {
+	case ValidationAccept:
+		p.handleValidatorRecordMessage(from, data)
+	case ValidationReject:
+		p.reportPeer(from, PeerEventInvalidMessage, topicWeights[TopicValidatorRecords], "invalid validator record")
+	}
+}
+
+// publishValidatorRecord signs and gossips a fresh record of this node
+func (p *P2PNetwork) publishValidatorRecord() error {
+	p.mu.RLock()
+	validatorID, signer := p.localValidatorID, p.recordSigner
+	p.mu.RUnlock()
+
+	pubKey, err := crypto.MarshalPublicKey(p.Host.Peerstore().PubKey(p.Host.ID()))
+	if err != nil {
+		return fmt.Errorf("failed to encode node key: %w", err)
+	}
+	record := &ValidatorRecord{
+		ValidatorID:   validatorID,
+		PeerID:        p.Host.ID().String(),
+		PeerPublicKey: pubKey,
+		Timestamp:     time.Now().UnixNano(),
+	}
+	for _, addr := range p.Host.Addrs() {
+		if len(record.Addrs) == maxValidatorRecordAddrs {
+			break
+		}
+		record.Addrs = append(record.Addrs, addr.String())
+	}
+
+	if err := signer(record); err != nil {
+		return fmt.Errorf("failed to sign validator record: %w", err)
+	}
+	record.PeerSignature, err = p.Host.Peerstore().PrivKey(p.Host.ID()).Sign(record.SigningHash())
+	if err != nil {
+		return fmt.Errorf("failed to sign validator record: %w", err)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode validator record: %w", err)
+	}
+	p.storeValidatorRecord(record, p.Host.ID())
+	return p.pubsub.Publish(TopicValidatorRecords, data)
+}
+
+// verifyPeerSignature checks that the record's peer key matches its peer ID
+// and signed the record
+func verifyPeerSignature(record *ValidatorRecord) (peer.ID, error) {
+	pid, err := peer.Decode(record.PeerID)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer ID: %w", err)
+	}
+	pubKey, err := crypto.UnmarshalPublicKey(record.PeerPublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer key: %w", err)
+	}
+	if !pid.MatchesPublicKey(pubKey) {
+		return "", fmt.Errorf("peer key does not match peer ID")
+	}
+	ok, err := pubKey.Verify(record.SigningHash(), record.PeerSignature)
+	if err != nil || !ok {
+		return "", fmt.Errorf("invalid peer signature")
+	}
+	return pid, nil
+}
+
+func decodeValidatorRecord(data []byte) (*ValidatorRecord, bool) {
+	var record ValidatorRecord
+	if err := json.Unmarshal(data, &record); err != nil || record.ValidatorID == "" || len(record.Addrs) > maxValidatorRecordAddrs {
+		return nil, false
+	}
+	return &record, true
+}
+
+func (p *P2PNetwork) validateValidatorRecordMessage(_ peer.ID, data []byte) ValidationResult {
+	record, ok := decodeValidatorRecord(data)
+	if !ok {
+		return ValidationReject
+	}
+	issued := time.Unix(0, record.Timestamp)
+	if time.Since(issued) > validatorRecordTTL {
+		return ValidationIgnore
+	}
+	if time.Until(issued) > maxRecordClockSkew {
+		return ValidationReject
+	}
+
+	p.mu.RLock()
+	verifier := p.recordVerifier
+	current := p.validatorRecords[record.ValidatorID]
+	p.mu.RUnlock()
+	if current != nil && current.Timestamp >= record.Timestamp {
+		return ValidationIgnore
+	}
+	if verifier == nil {
+		return ValidationIgnore
+	}
+
+	if _, err := verifyPeerSignature(record); err != nil {
+		log.Printf("⚠️  Invalid record of validator %s: %v", record.ValidatorID, err)
+		return ValidationReject
+	}
+	if err := verifier(record); err != nil {
+		log.Printf("⚠️  Invalid record of validator %s: %v", record.ValidatorID, err)
+		return ValidationReject
+	}
+	return ValidationAccept
+}
+
+func (p *P2PNetwork) handleValidatorRecordMessage(_ peer.ID, data []byte) {
+	record, ok := decodeValidatorRecord(data)
+	if !ok {
+		return
+	}
+	pid, err := peer.Decode(record.PeerID)
+	if err != nil {
+		return
+	}
+	p.storeValidatorRecord(record, pid)
+}
+
+// storeValidatorRecord keeps record if it is newer than the one known, and
+// adds its addresses to the peerstore
+func (p *P2PNetwork) storeValidatorRecord(record *ValidatorRecord, pid peer.ID) {
+	p.mu.Lock()
+	if current := p.validatorRecords[record.ValidatorID]; current != nil {
+		if current.Timestamp >= record.Timestamp {
+			p.mu.Unlock()
+			return
+		}
+		if oldPID, err := peer.Decode(current.PeerID); err == nil && oldPID != pid {
+			delete(p.peerValidators, oldPID)
+		}
+	}
+	p.validatorRecords[record.ValidatorID] = record
+	p.peerValidators[pid] = record.ValidatorID
+	p.mu.Unlock()
+
+	if pid == p.Host.ID() {
+		return
+	}
+	if pubKey, err := crypto.UnmarshalPublicKey(record.PeerPublicKey); err == nil {
+		p.Host.Peerstore().AddPubKey(pid, pubKey) // Checks shreds this peer originates
+	}
+	for _, s := range record.Addrs {
+		if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+			p.Host.Peerstore().AddAddr(pid, addr, validatorRecordTTL)
+		}
+	}
+}
+
+// GetValidatorRecord returns the current record of a validator, or nil
+func (p *P2PNetwork) GetValidatorRecord(validatorID string) *ValidatorRecord {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	record := p.validatorRecords[validatorID]
+	if record == nil || time.Since(time.Unix(0, record.Timestamp)) > validatorRecordTTL {
+		return nil
+	}
+	return record
+}
+
+// GetPeerIDByValidatorID returns the peer a validator's current record
+// binds it to
+func (p *P2PNetwork) GetPeerIDByValidatorID(validatorID string) (peer.ID, error) {
+	record := p.GetValidatorRecord(validatorID)
+	if record == nil {
+		return "", fmt.Errorf("no identity record for validator %s", validatorID)
+	}
+	return peer.Decode(record.PeerID)
+}
+
+// GetValidatorIDByPeerID returns the validator a peer is bound to, or ""
+func (p *P2PNetwork) GetValidatorIDByPeerID(pid peer.ID) string {
+	p.mu.RLock()
+	validatorID := p.peerValidators[pid]
+	p.mu.RUnlock()
+	if validatorID == "" || p.GetValidatorRecord(validatorID) == nil {
+		return ""
+	}
+	return validatorID
+}
+
+// isValidatorPeer reports whether pid may speak for validatorID. Until a
+// record verifier is set, records are not in use and any peer may.
+func (p *P2PNetwork) isValidatorPeer(validatorID string, pid peer.ID) bool {
+	p.mu.RLock()
+	verifier := p.recordVerifier
+	p.mu.RUnlock()
+	if verifier == nil {
+		return true
+	}
+	bound, err := p.GetPeerIDByValidatorID(validatorID)
+	return err == nil && bound == pid
+}
+
+// SendToValidator sends a message of topic straight to a validator's peer,
+// connecting to it first if needed. The peer relays it as if gossiped.
+func (p *P2PNetwork) SendToValidator(validatorID, topic string, data []byte) error {
+	pid, err := p.GetPeerIDByValidatorID(validatorID)
+	if err != nil {
+		return err
+	}
+	if pid == p.Host.ID() {
+		return nil
+	}
+	if p.Host.Network().Connectedness(pid) != network.Connected {
+		ctx, cancel := context.WithTimeout(p.ctx, dialTimeout)
+		defer cancel()
+		if err := p.Host.Connect(ctx, p.Host.Peerstore().PeerInfo(pid)); err != nil {
+			return fmt.Errorf("failed to connect to validator %s: %w", validatorID, err)
+		}
+	}
+	return p.pubsub.SendTo(pid, topic, data)
+}
@@ -0,0 +1,148 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newOfflineNetwork returns a network on a host that does not listen, for
+// tests of message handling without peers
+func newOfflineNetwork(t *testing.T) *P2PNetwork {
+	key, err := GenerateNodeKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("Failed to generate node key: %v", err)
+	}
+	h, err := libp2p.New(libp2p.Identity(key), libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatalf("Failed to create host: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return &P2PNetwork{
+		Host:             h,
+		validatorRecords: make(map[string]*ValidatorRecord),
+		peerValidators:   make(map[peer.ID]string),
+		compactBlocks:    newCompactBlocks(),
+		shreds:           newShredSets(),
+	}
+}
+
+// signedTestRecord returns a record binding validatorID to the peer of key,
+// issued at issued and signed by signer
+func signedTestRecord(t *testing.T, validatorID string, key, signer crypto.PrivKey, issued time.Time) []byte {
+	pid, _ := peer.IDFromPrivateKey(key)
+	pubKey, err := crypto.MarshalPublicKey(signer.GetPublic())
+	if err != nil {
+		t.Fatalf("Failed to encode peer key: %v", err)
+	}
+	record := &ValidatorRecord{
+		ValidatorID:   validatorID,
+		PeerID:        pid.String(),
+		PeerPublicKey: pubKey,
+		Addrs:         []string{"/ip4/198.51.100.1/tcp/4001"},
+		Timestamp:     issued.UnixNano(),
+	}
+	if record.PeerSignature, err = signer.Sign(record.SigningHash()); err != nil {
+		t.Fatalf("Failed to sign record: %v", err)
+	}
+	data, _ := json.Marshal(record)
+	return data
+}
+
+// TestValidatorRecords tests that records signed by the peer they name are
+// accepted and bind the validator to it, and that stale, early, forged or
+// unverified ones are not
+func TestValidatorRecords(t *testing.T) {
+	p := newOfflineNetwork(t)
+	keys := make([]crypto.PrivKey, 3)
+	for i := range keys {
+		key, err := GenerateNodeKey(KeyTypeEd25519)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		keys[i] = key
+	}
+	first, _ := peer.IDFromPrivateKey(keys[0])
+	second, _ := peer.IDFromPrivateKey(keys[1])
+	now := time.Now()
+
+	record := signedTestRecord(t, "validator-1", keys[0], keys[0], now.Add(-time.Minute))
+	if result := p.validateValidatorRecordMessage(first, record); result != ValidationIgnore {
+		t.Errorf("Records should be ignored until a verifier is set, got %v", result)
+	}
+	if !p.isValidatorPeer("validator-1", second) {
+		t.Errorf("Any peer should speak for a validator while records are not in use")
+	}
+
+	var verifierErr error
+	p.SetValidatorRecordVerifier(func(*ValidatorRecord) error { return verifierErr })
+	cases := []struct {
+		name   string
+		data   []byte
+		result ValidationResult
+	}{
+		{"malformed", []byte("{"), ValidationReject},
+		{"no validator", signedTestRecord(t, "", keys[0], keys[0], now), ValidationReject},
+		{"stale", signedTestRecord(t, "validator-1", keys[0], keys[0], now.Add(-validatorRecordTTL-time.Minute)), ValidationIgnore},
+		{"future", signedTestRecord(t, "validator-1", keys[0], keys[0], now.Add(2*maxRecordClockSkew)), ValidationReject},
+		{"key of another peer", signedTestRecord(t, "validator-1", keys[0], keys[2], now), ValidationReject},
+		{"valid", record, ValidationAccept},
+	}
+	for _, tc := range cases {
+		if result := p.validateValidatorRecordMessage(first, tc.data); result != tc.result {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.result, result)
+		}
+	}
+
+	// A peer signature under the right key but over another record fails
+	var forged ValidatorRecord
+	json.Unmarshal(record, &forged)
+	forged.Addrs = []string{"/ip4/203.0.113.1/tcp/4001"}
+	forged.Timestamp = now.UnixNano()
+	if _, err := verifyPeerSignature(&forged); err == nil {
+		t.Errorf("Peer signature of another record should not verify")
+	}
+	verifierErr = fmt.Errorf("bad validator signature")
+	if result := p.validateValidatorRecordMessage(first, record); result != ValidationReject {
+		t.Errorf("Record refused by the verifier should be rejected, got %v", result)
+	}
+	verifierErr = nil
+
+	p.handleValidatorRecordMessage(first, record)
+	if p.GetValidatorIDByPeerID(first) != "validator-1" || !p.isValidatorPeer("validator-1", first) || p.isValidatorPeer("validator-1", second) {
+		t.Errorf("Accepted record should bind validator-1 to its peer only")
+	}
+	if addrs := p.Host.Peerstore().Addrs(first); len(addrs) != 1 {
+		t.Errorf("Record's addresses should be added to the peerstore, got %v", addrs)
+	}
+	if result := p.validateValidatorRecordMessage(first, record); result != ValidationIgnore {
+		t.Errorf("Record already known should be ignored, got %v", result)
+	}
+
+	// A newer record moves the validator to another peer
+	moved := signedTestRecord(t, "validator-1", keys[1], keys[1], now)
+	if result := p.validateValidatorRecordMessage(second, moved); result != ValidationAccept {
+		t.Fatalf("Newer record should be accepted, got %v", result)
+	}
+	p.handleValidatorRecordMessage(second, moved)
+	p.handleValidatorRecordMessage(first, record)
+	if pid, err := p.GetPeerIDByValidatorID("validator-1"); err != nil || pid != second {
+		t.Errorf("Expected validator-1 on its new peer, got %s, %v", pid, err)
+	}
+	if p.GetValidatorIDByPeerID(first) != "" {
+		t.Errorf("Previous peer should no longer speak for the validator")
+	}
+
+	// Records not renewed expire
+	p.mu.Lock()
+	p.validatorRecords["validator-1"].Timestamp = now.Add(-validatorRecordTTL - time.Minute).UnixNano()
+	p.mu.Unlock()
+	if p.GetValidatorRecord("validator-1") != nil || p.GetValidatorIDByPeerID(second) != "" || p.isValidatorPeer("validator-1", second) {
+		t.Errorf("Expired record should no longer bind the validator")
+	}
+}
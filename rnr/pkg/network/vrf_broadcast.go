@@ -3,7 +3,6 @@ package network
 import (
         "encoding/json"
         "fmt"
-        "io"
         "log"
         "time"
 
@@ -31,6 +30,11 @@ type VRFProofHandler func(*VRFProofMessage) error
 
 // SetVRFProofHandler registers handler for VRF proof messages
 func (p *P2PNetwork) SetVRFProofHandler(handler VRFProofHandler) {
+        p.mu.Lock()
+        p.vrfProofHandler = handler
+        p.mu.Unlock()
+
+        // Peers that have not upgraded to pubsub still send over streams
         p.Host.SetStreamHandler(protocol.ID(VRFProofProtocol), func(stream network.Stream) {
                 p.handleVRFProofStream(stream, handler)
         })
@@ -38,7 +42,7 @@ func (p *P2PNetwork) SetVRFProofHandler(handler VRFProofHandler) {
 }
 
 // BroadcastVRFProof broadcasts VRF proof to all authenticated peers
-// SECURITY: Only broadcasts to authenticated peers to prevent spam
+// SECURITY: Peers only accept and relay it from authenticated peers
 func (p *P2PNetwork) BroadcastVRFProof(block *core.Block) error {
         if block.VRFProof == nil || len(block.VRFProof) == 0 {
                 return fmt.Errorf("block missing VRF proof")
@@ -57,15 +61,10 @@ func (p *P2PNetwork) BroadcastVRFProof(block *core.Block) error {
                 return fmt.Errorf("failed to marshal VRF proof message: %w", err)
         }
 
-        message := &Message{
-                Type:    4, // MessageTypeVRFProof
-                Payload: payload,
-        }
-
         log.Printf("📡 Broadcasting VRF proof for block #%d from proposer %s", 
                 block.Header.Height, block.ProposerID[:8])
 
-        return p.broadcast(VRFProofProtocol, message)
+        return p.pubsub.Publish(TopicVRFProofs, payload)
 }
 
 // handleVRFProofStream handles incoming VRF proof broadcasts
@@ -82,32 +81,26 @@ func (p *P2PNetwork) handleVRFProofStream(stream network.Stream, handler VRFProo
 
         // SECURITY: Check rate limit
         allowed, err := p.rateLimiter.AllowRequest(remotePeer)
-        if !allowed {
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Rate limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        data, err := io.ReadAll(stream)
+        message, size, err := ReadMessage(stream, core.MessageTypeVRFProof)
         if err != nil {
                 log.Printf("⚠️  Failed to read VRF proof stream: %v", err)
                 return
         }
 
         // SECURITY: Check bandwidth limit
-        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(len(data)))
-        if !allowed {
+        allowed, err = p.rateLimiter.AllowBytes(remotePeer, int64(size))
+        if !allowed && !p.IsUnconditionalPeer(remotePeer) {
                 log.Printf("⚠️  Bandwidth limit exceeded for peer %s: %v", remotePeer.String()[:8], err)
                 return
         }
 
-        var message Message
-        if err := json.Unmarshal(data, &message); err != nil {
-                log.Printf("⚠️  Failed to unmarshal VRF proof message: %v", err)
-                return
-        }
-
         var vrfMsg VRFProofMessage
-        if err := json.Unmarshal(message.Payload, &vrfMsg); err != nil {
+        if err := message.Decode(core.MessageTypeVRFProof, &vrfMsg); err != nil {
                 log.Printf("⚠️  Failed to unmarshal VRF proof payload: %v", err)
                 return
         }
@@ -0,0 +1,223 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// Wire format: every message on a stream is one length-prefixed frame,
+//
+//	version (1 byte) | flags (1 byte) | type (2 bytes) | length (4 bytes) | payload
+//
+// where the type is one of core.MessageType*, the length is that of the
+// payload as sent and the payload is JSON, snappy compressed if flagged.
+// Each type has a hard maximum size, checked before the payload is read and
+// again after it is decompressed. The reader names the types it expects and
+// a frame of another type is refused from its header, so a peer cannot make
+// a node buffer more than the largest message of the types it expects.
+
+const (
+	WireVersion    = 1 // Frame version this node writes
+	MinWireVersion = 1 // Oldest frame version it reads
+
+	wireHeaderSize  = 8
+	wireFlagSnappy  = 0x01
+	wireCompressMin = 512 // Smaller payloads are sent as they are
+
+	// A block's capacity counts its transactions by their estimated size,
+	// which JSON field names and base64 at most double; header and commit
+	// fit in the rest
+	maxBlockMessageSize = 2*core.MaxBlockCapacityBytes + 4<<20
+)
+
+// wireSpec is how messages of a type are framed
+type wireSpec struct {
+	maxSize  int  // Bytes of payload, decompressed
+	compress bool // Whether snappy may be used
+}
+
+var wireSpecs = map[int]wireSpec{
+	core.MessageTypeSyncRequest:        {1 << 10, false},
+	core.MessageTypeSyncResponse:       {maxBlockMessageSize, true}, // maxSyncResponseBytes, or a single larger block
+	core.MessageTypeBlock:              {maxBlockMessageSize, true},
+	core.MessageTypeTransaction:        {128 << 10, true},
+	core.MessageTypeTxRequest:          {128 << 10, true},
+	core.MessageTypeTxResponse:         {4 << 20, true}, // Up to a batch of transactions
+	core.MessageTypePoBTestRequest:     {16 << 10, false},
+	core.MessageTypePoBTestResponse:    {16 << 10, false},
+	core.MessageTypeVote:               {4 << 10, false},
+	core.MessageTypeGovernanceProposal: {64 << 10, true},
+	core.MessageTypeGovernanceVote:     {4 << 10, false},
+	core.MessageTypePing:               {256, false},
+	core.MessageTypePong:               {256, false},
+	core.MessageTypeStatus:             {statusMaxBytes, false},
+	core.MessageTypeAuth:               {4 << 10, false},
+	core.MessageTypeSpeedTestChunk:     {maxRPCSize, false}, // Compression would skew the bandwidth measured
+	core.MessageTypeSpeedTestAck:       {256, false},
+	core.MessageTypeVRFProof:           {16 << 10, false},
+	core.MessageTypeValidatorRecord:    {maxValidatorRecordBytes, false},
+	core.MessageTypeShreds:             {maxRPCSize, false}, // Erasure coded data does not compress
+	core.MessageTypePubSubRPC:          {maxRPCSize, true},
+	core.MessageTypeBlockTxs:           {maxBlockMessageSize, true}, // A page of a block's transactions
+	core.MessageTypeError:              {1 << 10, false},
+	core.MessageTypeTxAnnouncement:     {256, false},
+}
+
+// wireError is the payload of an error frame
+type wireError struct {
+	Error string `json:"error"`
+}
+
+// WriteMessage writes v, JSON encoded, as a frame of msgType. If compress is
+// set and the type allows it, a large payload is snappy compressed when that
+// makes it smaller.
+func WriteMessage(w io.Writer, msgType int, v interface{}, compress bool) error {
+	spec, ok := wireSpecs[msgType]
+	if !ok {
+		return fmt.Errorf("unknown message type %d", msgType)
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message type %d: %w", msgType, err)
+	}
+	if len(payload) > spec.maxSize {
+		return fmt.Errorf("message type %d of %d bytes exceeds limit of %d", msgType, len(payload), spec.maxSize)
+	}
+
+	var flags byte
+	if compress && spec.compress && len(payload) >= wireCompressMin {
+		if compressed := snappy.Encode(nil, payload); len(compressed) < len(payload) {
+			payload = compressed
+			flags |= wireFlagSnappy
+		}
+	}
+
+	frame := make([]byte, wireHeaderSize+len(payload))
+	frame[0] = WireVersion
+	frame[1] = flags
+	binary.BigEndian.PutUint16(frame[2:4], uint16(msgType))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(payload)))
+	copy(frame[wireHeaderSize:], payload)
+	_, err = w.Write(frame)
+	return err
+}
+
+// WriteError writes an error frame
+func WriteError(w io.Writer, message string) error {
+	return WriteMessage(w, core.MessageTypeError, &wireError{Error: message}, false)
+}
+
+// ReadMessage reads a frame of one of the expected types, or an error frame.
+// It returns the message with its payload decompressed, and the frame's size
+// on the wire. A frame of any other type is refused before its payload is
+// read.
+func ReadMessage(r io.Reader, expected ...int) (*Message, int, error) {
+	var header [wireHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	version, flags := header[0], header[1]
+	msgType := int(binary.BigEndian.Uint16(header[2:4]))
+	size := binary.BigEndian.Uint32(header[4:8])
+
+	if version < MinWireVersion || version > WireVersion {
+		return nil, 0, fmt.Errorf("unsupported wire version %d", version)
+	}
+	if flags&^wireFlagSnappy != 0 {
+		return nil, 0, fmt.Errorf("unknown frame flags %#x", flags)
+	}
+	spec, ok := wireSpecs[msgType]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown message type %d", msgType)
+	}
+	if !expectedType(msgType, expected) {
+		return nil, 0, fmt.Errorf("unexpected message type %d, expected %v", msgType, expected)
+	}
+	if int64(size) > int64(spec.maxSize) {
+		return nil, 0, fmt.Errorf("message type %d of %d bytes exceeds limit of %d", msgType, size, spec.maxSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	if flags&wireFlagSnappy != 0 {
+		n, err := snappy.DecodedLen(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid compressed payload: %w", err)
+		}
+		if n > spec.maxSize {
+			return nil, 0, fmt.Errorf("message type %d of %d bytes exceeds limit of %d", msgType, n, spec.maxSize)
+		}
+		if payload, err = snappy.Decode(nil, payload); err != nil {
+			return nil, 0, fmt.Errorf("invalid compressed payload: %w", err)
+		}
+	}
+	return &Message{Type: msgType, Payload: payload}, wireHeaderSize + int(size), nil
+}
+
+// expectedType reports whether a frame of msgType may be read when expecting
+// one of expected. Error frames may always be read.
+func expectedType(msgType int, expected []int) bool {
+	if msgType == core.MessageTypeError {
+		return true
+	}
+	for _, t := range expected {
+		if t == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode decodes the message's payload into v, which must be of msgType.
+// An error frame is returned as an error.
+func (m *Message) Decode(msgType int, v interface{}) error {
+	if m.Type == core.MessageTypeError && msgType != core.MessageTypeError {
+		var e wireError
+		if err := json.Unmarshal(m.Payload, &e); err != nil {
+			return fmt.Errorf("invalid error message: %w", err)
+		}
+		return fmt.Errorf("peer error: %s", e.Error)
+	}
+	if m.Type != msgType {
+		return fmt.Errorf("unexpected message type %d, expected %d", m.Type, msgType)
+	}
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("invalid message type %d: %w", msgType, err)
+	}
+	return nil
+}
+
+// ReadMessageOf reads a frame of msgType into v and returns its size on the
+// wire
+func ReadMessageOf(r io.Reader, msgType int, v interface{}) (int, error) {
+	msg, size, err := ReadMessage(r, msgType)
+	if err != nil {
+		return 0, err
+	}
+	return size, msg.Decode(msgType, v)
+}
+
+// SetWireCompression sets whether this node snappy compresses the messages
+// it sends. Compressed messages are always accepted.
+func (p *P2PNetwork) SetWireCompression(enabled bool) {
+	p.mu.Lock()
+	p.wireCompression = enabled
+	p.mu.Unlock()
+	p.pubsub.SetCompression(enabled)
+}
+
+// writeMessage writes a frame with this node's compression setting
+func (p *P2PNetwork) writeMessage(w io.Writer, msgType int, v interface{}) error {
+	p.mu.RLock()
+	compress := p.wireCompression
+	p.mu.RUnlock()
+	return WriteMessage(w, msgType, v, compress)
+}
@@ -0,0 +1,219 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+
+	"rnr-blockchain/pkg/core"
+)
+
+// wireFrame builds a frame header followed by payload, whatever the header
+// declares
+func wireFrame(version, flags byte, msgType int, size uint32, payload []byte) []byte {
+	frame := make([]byte, wireHeaderSize, wireHeaderSize+len(payload))
+	frame[0] = version
+	frame[1] = flags
+	binary.BigEndian.PutUint16(frame[2:4], uint16(msgType))
+	binary.BigEndian.PutUint32(frame[4:8], size)
+	return append(frame, payload...)
+}
+
+// TestWireRoundTrip tests that messages read back as written, compressed
+// only when the type allows it and it pays off
+func TestWireRoundTrip(t *testing.T) {
+	large := strings.Repeat("a", 4*wireCompressMin)
+	cases := []struct {
+		name       string
+		msgType    int
+		value      string
+		compress   bool
+		compressed bool
+	}{
+		{"small", core.MessageTypeTransaction, "tx", true, false},
+		{"large compressed", core.MessageTypeTransaction, large, true, true},
+		{"large uncompressed", core.MessageTypeTransaction, large, false, false},
+		{"type without compression", core.MessageTypeShreds, large, true, false},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, tc.msgType, tc.value, tc.compress); err != nil {
+			t.Fatalf("%s: WriteMessage failed: %v", tc.name, err)
+		}
+		written := buf.Len()
+		if compressed := buf.Bytes()[1]&wireFlagSnappy != 0; compressed != tc.compressed {
+			t.Errorf("%s: expected compressed=%v, got %v", tc.name, tc.compressed, compressed)
+		}
+
+		msg, size, err := ReadMessage(&buf, tc.msgType)
+		if err != nil {
+			t.Fatalf("%s: ReadMessage failed: %v", tc.name, err)
+		}
+		if size != written {
+			t.Errorf("%s: expected size on the wire %d, got %d", tc.name, written, size)
+		}
+		var value string
+		if err := msg.Decode(tc.msgType, &value); err != nil || value != tc.value {
+			t.Errorf("%s: message did not read back as written (%v)", tc.name, err)
+		}
+	}
+
+	// Several frames on one stream, read by the type each is expected as
+	var buf bytes.Buffer
+	WriteMessage(&buf, core.MessageTypePing, "ping", false)
+	WriteMessage(&buf, core.MessageTypeVote, "vote", false)
+	var ping, vote string
+	if _, err := ReadMessageOf(&buf, core.MessageTypePing, &ping); err != nil || ping != "ping" {
+		t.Errorf("First frame did not read back: %v", err)
+	}
+	if _, err := ReadMessageOf(&buf, core.MessageTypeVote, &vote); err != nil || vote != "vote" {
+		t.Errorf("Second frame did not read back: %v", err)
+	}
+}
+
+// TestWireSizeLimits tests that frames over their type's limit, before or
+// after decompression, or of a type not expected are refused without their
+// payload being read
+func TestWireSizeLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, core.MessageTypePing, strings.Repeat("a", 300), false); err == nil {
+		t.Errorf("Writing a message over its type's limit should fail")
+	}
+
+	// Expands past the transaction limit, but is small on the wire
+	bomb := snappy.Encode(nil, []byte(`"`+strings.Repeat("a", 256<<10)+`"`))
+
+	cases := []struct {
+		name     string
+		frame    []byte
+		expected []int
+		err      string
+	}{
+		{"declared over limit", wireFrame(WireVersion, 0, core.MessageTypePing, 1<<20, nil),
+			[]int{core.MessageTypePing}, "exceeds limit"},
+		{"declared at limit", wireFrame(WireVersion, 0, core.MessageTypePing, 256, nil),
+			[]int{core.MessageTypePing}, "EOF"},
+		{"decompressed over limit", wireFrame(WireVersion, wireFlagSnappy, core.MessageTypeTransaction, uint32(len(bomb)), bomb),
+			[]int{core.MessageTypeTransaction}, "exceeds limit"},
+		{"invalid compression", wireFrame(WireVersion, wireFlagSnappy, core.MessageTypeTransaction, 3, []byte{0xff, 0xff, 0xff}),
+			[]int{core.MessageTypeTransaction}, "invalid compressed payload"},
+		{"type not expected", wireFrame(WireVersion, 0, core.MessageTypeBlock, 1<<20, nil),
+			[]int{core.MessageTypeVote}, "unexpected message type"},
+		{"none expected", wireFrame(WireVersion, 0, core.MessageTypeVote, 10, nil),
+			nil, "unexpected message type"},
+		{"one of several expected", wireFrame(WireVersion, 0, core.MessageTypeTxRequest, 2, []byte("{}")),
+			[]int{core.MessageTypeTransaction, core.MessageTypeTxRequest}, ""},
+	}
+	for _, tc := range cases {
+		_, _, err := ReadMessage(bytes.NewReader(tc.frame), tc.expected...)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: expected the frame read, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+// TestBlockMessageLimits tests that block carrying messages fit the largest
+// block consensus allows, and that sync responses stop past their byte
+// budget but always carry a block
+func TestBlockMessageLimits(t *testing.T) {
+	for _, msgType := range []int{core.MessageTypeBlock, core.MessageTypeSyncResponse, core.MessageTypeBlockTxs} {
+		if limit := wireSpecs[msgType].maxSize; limit < 2*core.MaxBlockCapacityBytes {
+			t.Errorf("Message type %d limit of %d bytes is below a full block", msgType, limit)
+		}
+	}
+
+	withData := func(height uint64, size int) *core.Block {
+		block := testBlock(height, 1)
+		block.Transactions[0].Data = make([]byte, size)
+		return block
+	}
+	cases := []struct {
+		name     string
+		blocks   []*core.Block
+		expected int
+	}{
+		{"within budget", []*core.Block{withData(1, 1<<20), withData(2, 1<<20), withData(3, 1<<20)}, 3},
+		{"past budget", []*core.Block{withData(1, maxSyncResponseBytes/3), withData(2, maxSyncResponseBytes/3), withData(3, maxSyncResponseBytes/3)}, 2},
+		{"first over budget", []*core.Block{withData(1, maxSyncResponseBytes), withData(2, 1)}, 1},
+	}
+	for _, tc := range cases {
+		response, err := syncResponse(tc.blocks)
+		if err != nil || len(response) != tc.expected {
+			t.Errorf("%s: expected %d blocks, got %d (%v)", tc.name, tc.expected, len(response), err)
+		}
+	}
+}
+
+// TestWireHeaderChecks tests that frames of unknown versions, flags or types
+// are refused
+func TestWireHeaderChecks(t *testing.T) {
+	cases := []struct {
+		name  string
+		frame []byte
+		err   string
+	}{
+		{"version too old", wireFrame(MinWireVersion-1, 0, core.MessageTypePing, 2, []byte("{}")), "unsupported wire version"},
+		{"version too new", wireFrame(WireVersion+1, 0, core.MessageTypePing, 2, []byte("{}")), "unsupported wire version"},
+		{"unknown flag", wireFrame(WireVersion, 0x02, core.MessageTypePing, 2, []byte("{}")), "unknown frame flags"},
+		{"unknown flag with snappy", wireFrame(WireVersion, wireFlagSnappy|0x80, core.MessageTypePing, 2, []byte("{}")), "unknown frame flags"},
+		{"unknown type", wireFrame(WireVersion, 0, 999, 2, []byte("{}")), "unknown message type"},
+		{"truncated header", wireFrame(WireVersion, 0, core.MessageTypePing, 2, nil)[:4], "EOF"},
+	}
+	for _, tc := range cases {
+		_, _, err := ReadMessage(bytes.NewReader(tc.frame), core.MessageTypePing)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	if err := WriteMessage(&bytes.Buffer{}, 999, "x", false); err == nil {
+		t.Errorf("Writing an unknown message type should fail")
+	}
+}
+
+// TestWireErrorFrames tests that an error frame is read whatever type is
+// expected and decodes as the peer's error
+func TestWireErrorFrames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteError(&buf, "transaction not found"); err != nil {
+		t.Fatalf("WriteError failed: %v", err)
+	}
+	var tx core.Transaction
+	_, err := ReadMessageOf(&buf, core.MessageTypeTxResponse, &tx)
+	if err == nil || err.Error() != "peer error: transaction not found" {
+		t.Errorf("Expected the peer's error, got %v", err)
+	}
+
+	// Read as an error frame, it decodes like any message
+	WriteError(&buf, "busy")
+	var e wireError
+	if _, err := ReadMessageOf(&buf, core.MessageTypeError, &e); err != nil || e.Error != "busy" {
+		t.Errorf("Expected the error frame decoded, got %+v (%v)", e, err)
+	}
+
+	// Error frames have a limit of their own
+	long, _ := json.Marshal(&wireError{Error: strings.Repeat("x", 2<<10)})
+	frame := wireFrame(WireVersion, 0, core.MessageTypeError, uint32(len(long)), long)
+	if _, _, err := ReadMessage(bytes.NewReader(frame), core.MessageTypeBlock); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("Expected an oversized error frame refused, got %v", err)
+	}
+
+	malformed := wireFrame(WireVersion, 0, core.MessageTypeError, 3, []byte("nope"[:3]))
+	msg, _, err := ReadMessage(bytes.NewReader(malformed), core.MessageTypeBlock)
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if err := msg.Decode(core.MessageTypeBlock, &tx); err == nil || !strings.Contains(err.Error(), "invalid error message") {
+		t.Errorf("Expected a malformed error frame reported, got %v", err)
+	}
+}
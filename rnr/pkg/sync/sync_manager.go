@@ -25,6 +25,7 @@ type SyncManager struct {
 	targetHeight   uint64
 	currentHeight  uint64
 	syncPeer       string
+	processBlock   func(*core.Block) error // Validates and applies synced blocks
 	mu             sync.RWMutex
 	requestTimeout time.Duration
 }
@@ -74,6 +75,10 @@ func (sm *SyncManager) StartSync(peerHeight uint64, peerID string) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	if sm.status == StatusSyncing {
+		return fmt.Errorf("already syncing from %s", sm.syncPeer)
+	}
+
 	currentBlock := sm.blockchain.GetLatestBlock()
 	sm.currentHeight = currentBlock.Header.Height
 
@@ -92,6 +97,25 @@ func (sm *SyncManager) StartSync(peerHeight uint64, peerID string) error {
 	return nil
 }
 
+// AbortSync gives up the current sync, e.g. when its peer fails, so a sync
+// from another peer can start
+func (sm *SyncManager) AbortSync() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.status == StatusSyncing {
+		sm.status = StatusBehind
+	}
+}
+
+// SetBlockProcessor sets the function that validates a synced block and
+// applies it to the chain and the state once it is final. Without one,
+// synced blocks are only checked to extend the chain.
+func (sm *SyncManager) SetBlockProcessor(process func(*core.Block) error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.processBlock = process
+}
+
 func (sm *SyncManager) CreateBlockRequest(batchSize uint64) *BlockRequest {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
@@ -118,12 +142,18 @@ func (sm *SyncManager) ProcessBlockResponse(blocks []*core.Block) error {
 	defer sm.mu.Unlock()
 
 	for _, block := range blocks {
-		if err := sm.blockchain.VerifyBlock(block); err != nil {
-			return fmt.Errorf("invalid block %d: %w", block.Header.Height, err)
-		}
-
-		if err := sm.blockchain.AddBlock(block); err != nil {
-			return fmt.Errorf("failed to add block %d: %w", block.Header.Height, err)
+		if sm.processBlock != nil {
+			if err := sm.processBlock(block); err != nil {
+				return fmt.Errorf("invalid block %d: %w", block.Header.Height, err)
+			}
+		} else {
+			if err := sm.blockchain.VerifyBlock(block); err != nil {
+				return fmt.Errorf("invalid block %d: %w", block.Header.Height, err)
+			}
+
+			if err := sm.blockchain.AddBlock(block); err != nil {
+				return fmt.Errorf("failed to add block %d: %w", block.Header.Height, err)
+			}
 		}
 
 		sm.currentHeight = block.Header.Height